@@ -63,8 +63,8 @@ export const buildTradeActionConfirmation = (
       };
     case 'satisfaction':
       return {
-        title: 'Update trade satisfaction?',
-        message: `Toggle your trade satisfaction rating for trade ${tradeLabel}.`,
+        title: 'Rate trade?',
+        message: `Give trade ${tradeLabel} a thumbs-up. Each trainer can rate a trade once.`,
       };
     default:
       return {
@@ -127,11 +127,11 @@ export const buildTradeAuditDetails = (trade: TradeRow | null): string[] => {
   if (cancelledAt) details.push(`Cancelled: ${cancelledAt}`);
   if (deletedAt) details.push(`Deleted: ${deletedAt}`);
   if (trade.trade_cancelled_by) details.push(`Cancelled by: ${trade.trade_cancelled_by}`);
-  if (typeof trade.user_1_trade_satisfaction === 'boolean') {
-    details.push(`Proposer satisfaction: ${trade.user_1_trade_satisfaction ? 'yes' : 'no'}`);
+  if (typeof trade.user_1_trade_satisfaction === 'number') {
+    details.push(`Proposer rating: ${trade.user_1_trade_satisfaction}/5`);
   }
-  if (typeof trade.user_2_trade_satisfaction === 'boolean') {
-    details.push(`Accepter satisfaction: ${trade.user_2_trade_satisfaction ? 'yes' : 'no'}`);
+  if (typeof trade.user_2_trade_satisfaction === 'number') {
+    details.push(`Accepter rating: ${trade.user_2_trade_satisfaction}/5`);
   }
 
   if (details.length === 0) return ['No audit timestamps yet.'];
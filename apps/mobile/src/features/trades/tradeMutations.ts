@@ -1,3 +1,4 @@
+import type { TradeRatingInput } from '@pokemongonexus/shared-contracts/receiver';
 import type { TradeRecord } from '@pokemongonexus/shared-contracts/trades';
 
 export type TradeRow = TradeRecord & {
@@ -202,34 +203,46 @@ export const completeTrade = (
   };
 };
 
+// A thumbs-up is the top score of a trade rating.
+export const THUMBS_UP_SCORE = 5;
+
+// setTradeSatisfaction gives the current trainer's side a thumbs-up. Ratings
+// are not trade updates: the result carries the rating to send and no
+// changed rows. Each side rates once, so a rated side is left alone.
 export const setTradeSatisfaction = (
   trades: TradeMap,
   tradeId: string,
   currentUsername: string,
-): { next: TradeMap; changed: TradeRow[] } => {
+): { next: TradeMap; changed: TradeRow[]; ratings: TradeRatingInput[] } => {
   const source = trades[tradeId];
-  if (!source) return { next: trades, changed: [] };
+  if (!source) return { next: trades, changed: [], ratings: [] };
 
   const proposer = source.username_proposed ?? '';
   const accepter = source.username_accepting ?? '';
   const isProposer = currentUsername.length > 0 && currentUsername === proposer;
   const isAccepter = currentUsername.length > 0 && currentUsername === accepter;
   if (!isProposer && !isAccepter) {
-    return { next: trades, changed: [] };
+    return { next: trades, changed: [], ratings: [] };
+  }
+
+  const currentRating = isProposer
+    ? source.user_1_trade_satisfaction
+    : source.user_2_trade_satisfaction;
+  if (currentRating != null) {
+    return { next: trades, changed: [], ratings: [] };
   }
 
-  const proposerSatisfaction = Boolean(source.user_1_trade_satisfaction);
-  const accepterSatisfaction = Boolean(source.user_2_trade_satisfaction);
   const updated: TradeRow = {
     ...source,
     ...(isProposer
-      ? { user_1_trade_satisfaction: !proposerSatisfaction }
-      : { user_2_trade_satisfaction: !accepterSatisfaction }),
+      ? { user_1_trade_satisfaction: THUMBS_UP_SCORE }
+      : { user_2_trade_satisfaction: THUMBS_UP_SCORE }),
     last_update: nowMs(),
   };
 
   return {
     next: { ...trades, [tradeId]: updated },
-    changed: [updated],
+    changed: [],
+    ratings: [{ trade_id: tradeId, score: THUMBS_UP_SCORE }],
   };
 };
@@ -1,6 +1,7 @@
 import React, { useCallback, useEffect, useMemo, useRef, useState } from 'react';
 import { Alert, Button, Pressable, ScrollView, StyleSheet, Text, View } from 'react-native';
 import type { NativeStackScreenProps } from '@react-navigation/native-stack';
+import type { TradeRatingInput } from '@pokemongonexus/shared-contracts/receiver';
 import type { PartnerInfo } from '@pokemongonexus/shared-contracts/trades';
 import { useAuth } from '../features/auth/AuthProvider';
 import { useEvents } from '../features/events/EventsProvider';
@@ -30,14 +31,14 @@ import {
   buildTradeStatusDetail,
 } from '../features/trades/tradeLifecycleMessages';
 import type { RootStackParamList } from '../navigation/AppNavigator';
-import { sendTradeUpdate } from '../services/receiverService';
+import { sendTradeRating, sendTradeUpdate } from '../services/receiverService';
 import { revealTradePartnerInfo } from '../services/tradePartnerService';
 import { fetchTradesOverviewForUser } from '../services/tradesService';
 import { commonStyles } from '../ui/commonStyles';
 import { theme } from '../ui/theme';
 
 type TradesScreenProps = NativeStackScreenProps<RootStackParamList, 'Trades'>;
-type TradeMutationResult = { next: TradeMap; changed: TradeRow[] };
+type TradeMutationResult = { next: TradeMap; changed: TradeRow[]; ratings?: TradeRatingInput[] };
 type TradeMutation = (tradeMap: TradeMap) => TradeMutationResult;
 type SyncState = 'idle' | 'success' | 'failed';
 
@@ -58,11 +59,8 @@ const compareStatusLabel = (left: string, right: string): number => {
   return left.localeCompare(right);
 };
 
-const formatSatisfaction = (value: unknown): string => {
-  if (value === true) return 'yes';
-  if (value === false) return 'no';
-  return 'not rated';
-};
+const formatSatisfaction = (value: unknown): string =>
+  typeof value === 'number' ? `${value}/5` : 'not rated';
 
 const formatPartnerValue = (value: unknown): string => {
   if (typeof value !== 'string') return '-';
@@ -204,13 +202,16 @@ export const TradesScreen = ({ navigation }: TradesScreenProps) => {
     };
   }, [selectedTrade, user?.username]);
 
-  const syncMutation = async (rows: TradeRow[]) => {
+  const syncMutation = async (rows: TradeRow[], ratings: TradeRatingInput[] = []) => {
     for (const row of rows) {
       await sendTradeUpdate({
         operation: 'updateTrade',
         tradeData: row,
       });
     }
+    for (const rating of ratings) {
+      await sendTradeRating(rating);
+    }
   };
 
   const runMutation = async (mutate: TradeMutation) => {
@@ -219,12 +220,12 @@ export const TradesScreen = ({ navigation }: TradesScreenProps) => {
     setError(null);
     setSyncError(null);
     const currentMap = toTradeMap(trades);
-    const { next, changed } = mutate(currentMap);
+    const { next, changed, ratings } = mutate(currentMap);
     const nextRows = toTradeRows(next);
     setTrades(nextRows);
     setStatusCounts(buildStatusCounts(nextRows));
     try {
-      await syncMutation(changed);
+      await syncMutation(changed, ratings);
       setSyncState('success');
       setRetryMutation(null);
       await loadTrades();
@@ -423,7 +424,7 @@ export const TradesScreen = ({ navigation }: TradesScreenProps) => {
               }
             />
             <Button
-              title="Toggle Satisfaction"
+              title="Thumbs Up"
               disabled={mutationLoading || !Boolean(actionDecisions?.satisfaction.allowed)}
               onPress={() =>
                 confirmAndRunMutation('satisfaction', (map) =>
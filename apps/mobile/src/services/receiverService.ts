@@ -1,4 +1,8 @@
-import { receiverContract, type ReceiverBatchedUpdatesPayload } from '@pokemongonexus/shared-contracts/receiver';
+import {
+  receiverContract,
+  type ReceiverBatchedUpdatesPayload,
+  type TradeRatingInput,
+} from '@pokemongonexus/shared-contracts/receiver';
 import { runtimeConfig } from '../config/runtimeConfig';
 import { requestJson } from './httpClient';
 
@@ -31,6 +35,16 @@ export const sendTradeUpdate = async (
     tradeUpdates: [tradeUpdate],
   });
 
+export const sendTradeRating = async (
+  rating: TradeRatingInput,
+): Promise<Record<string, unknown>> =>
+  sendBatchedUpdates({
+    location: null,
+    pokemonUpdates: [],
+    tradeUpdates: [],
+    tradeRatings: [rating],
+  });
+
 export const sendPokemonUpdate = async (
   pokemonUpdate: PokemonBatchedUpdate,
 ): Promise<Record<string, unknown>> =>
@@ -1,5 +1,10 @@
 import { buildUrl } from '@pokemongonexus/shared-contracts/common';
-import { searchContract, type SearchQueryParams, type SearchResultRow } from '@pokemongonexus/shared-contracts/search';
+import {
+  searchContract,
+  type SearchQueryParams,
+  type SearchResponse,
+  type SearchResultRow,
+} from '@pokemongonexus/shared-contracts/search';
 import { runtimeConfig } from '../config/runtimeConfig';
 import { getAuthToken } from '../features/auth/authSession';
 import { parseJsonSafe, requestWithPolicy } from './httpClient';
@@ -22,10 +27,14 @@ export const searchPokemon = async (
     },
   });
 
-  const payload = await parseJsonSafe<SearchResultRow[] | Record<string, SearchResultRow>>(response);
+  const payload = await parseJsonSafe<
+    SearchResponse | SearchResultRow[] | Record<string, SearchResultRow>
+  >(response);
   if (!response.ok) {
     throw new Error(`Search failed (${response.status})`);
   }
   if (!payload) return [];
-  return Array.isArray(payload) ? payload : Object.values(payload);
+  if (Array.isArray(payload)) return payload;
+  if (Array.isArray(payload.results)) return payload.results as SearchResultRow[];
+  return Object.values(payload as Record<string, SearchResultRow>);
 };
@@ -29,7 +29,7 @@ describe('tradeLifecycleMessages', () => {
     );
     expect(buildTradeActionConfirmation('satisfaction', baseTrade, 'ash')).toEqual(
       expect.objectContaining({
-        title: 'Update trade satisfaction?',
+        title: 'Rate trade?',
       }),
     );
   });
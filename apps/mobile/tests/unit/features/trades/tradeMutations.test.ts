@@ -4,6 +4,7 @@ import {
   completeTrade,
   reproposeTrade,
   setTradeSatisfaction,
+  THUMBS_UP_SCORE,
   toTradeMap,
   toTradeRows,
   type TradeRow,
@@ -119,29 +120,34 @@ describe('tradeMutations', () => {
     expect(counts).toEqual({ proposed: 1, pending: 2 });
   });
 
-  it('setTradeSatisfaction toggles the correct side for each participant', () => {
+  it('setTradeSatisfaction rates the correct side once for each participant', () => {
     const base = toTradeMap([
       {
         trade_id: 't1',
         trade_status: 'completed',
         username_proposed: 'ash',
         username_accepting: 'misty',
-        user_1_trade_satisfaction: false,
-        user_2_trade_satisfaction: false,
+        user_1_trade_satisfaction: null,
+        user_2_trade_satisfaction: null,
       },
     ]);
 
-    const proposerToggle = setTradeSatisfaction(base, 't1', 'ash');
-    expect(proposerToggle.next.t1.user_1_trade_satisfaction).toBe(true);
-    expect(proposerToggle.next.t1.user_2_trade_satisfaction).toBe(false);
+    const proposerRating = setTradeSatisfaction(base, 't1', 'ash');
+    expect(proposerRating.changed).toEqual([]);
+    expect(proposerRating.ratings).toEqual([{ trade_id: 't1', score: THUMBS_UP_SCORE }]);
+    expect(proposerRating.next.t1.user_1_trade_satisfaction).toBe(THUMBS_UP_SCORE);
+    expect(proposerRating.next.t1.user_2_trade_satisfaction).toBeNull();
 
-    const accepterToggle = setTradeSatisfaction(proposerToggle.next, 't1', 'misty');
-    expect(accepterToggle.next.t1.user_1_trade_satisfaction).toBe(true);
-    expect(accepterToggle.next.t1.user_2_trade_satisfaction).toBe(true);
+    const again = setTradeSatisfaction(proposerRating.next, 't1', 'ash');
+    expect(again.ratings).toEqual([]);
+    expect(again.next).toBe(proposerRating.next);
 
-    const outsider = setTradeSatisfaction(accepterToggle.next, 't1', 'brock');
+    const accepterRating = setTradeSatisfaction(proposerRating.next, 't1', 'misty');
+    expect(accepterRating.ratings).toEqual([{ trade_id: 't1', score: THUMBS_UP_SCORE }]);
+    expect(accepterRating.next.t1.user_2_trade_satisfaction).toBe(THUMBS_UP_SCORE);
+
+    const outsider = setTradeSatisfaction(accepterRating.next, 't1', 'brock');
     expect(outsider.changed).toEqual([]);
-    expect(outsider.next.t1.user_1_trade_satisfaction).toBe(true);
-    expect(outsider.next.t1.user_2_trade_satisfaction).toBe(true);
+    expect(outsider.ratings).toEqual([]);
   });
 });
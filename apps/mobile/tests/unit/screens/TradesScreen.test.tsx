@@ -3,7 +3,7 @@ import { Alert } from 'react-native';
 import { act, fireEvent, render, screen, waitFor } from '@testing-library/react-native';
 import type { AlertButton } from 'react-native';
 import { TradesScreen } from '../../../src/screens/TradesScreen';
-import { sendTradeUpdate } from '../../../src/services/receiverService';
+import { sendTradeRating, sendTradeUpdate } from '../../../src/services/receiverService';
 import { revealTradePartnerInfo } from '../../../src/services/tradePartnerService';
 import { fetchTradesOverviewForUser } from '../../../src/services/tradesService';
 
@@ -19,6 +19,7 @@ jest.mock('../../../src/services/tradesService', () => ({
 
 jest.mock('../../../src/services/receiverService', () => ({
   sendTradeUpdate: jest.fn(),
+  sendTradeRating: jest.fn(),
 }));
 
 jest.mock('../../../src/services/tradePartnerService', () => ({
@@ -28,6 +29,7 @@ jest.mock('../../../src/services/tradePartnerService', () => ({
 const mockedFetchTradesOverviewForUser =
   fetchTradesOverviewForUser as jest.MockedFunction<typeof fetchTradesOverviewForUser>;
 const mockedSendTradeUpdate = sendTradeUpdate as jest.MockedFunction<typeof sendTradeUpdate>;
+const mockedSendTradeRating = sendTradeRating as jest.MockedFunction<typeof sendTradeRating>;
 const mockedRevealTradePartnerInfo =
   revealTradePartnerInfo as jest.MockedFunction<typeof revealTradePartnerInfo>;
 
@@ -192,7 +194,7 @@ describe('TradesScreen', () => {
     expect(screen.getByText('Last sync: success')).toBeTruthy();
   });
 
-  it('toggles satisfaction for completed trade and syncs update', async () => {
+  it('rates a completed trade with a thumbs-up and sends it as a trade rating', async () => {
     mockedFetchTradesOverviewForUser.mockResolvedValue({
       statusCounts: { completed: 1 },
       trades: [
@@ -201,14 +203,14 @@ describe('TradesScreen', () => {
           trade_status: 'completed',
           username_proposed: 'ash',
           username_accepting: 'misty',
-          user_1_trade_satisfaction: false,
-          user_2_trade_satisfaction: false,
+          user_1_trade_satisfaction: null,
+          user_2_trade_satisfaction: null,
           pokemon_instance_id_user_proposed: 'i1',
           pokemon_instance_id_user_accepting: 'i2',
         },
       ],
     });
-    mockedSendTradeUpdate.mockResolvedValue({});
+    mockedSendTradeRating.mockResolvedValue({});
 
     render(<TradesScreen navigation={baseNavigation as never} route={route as never} />);
     fireEvent.press(screen.getByText('Load Trades'));
@@ -218,20 +220,13 @@ describe('TradesScreen', () => {
     });
 
     fireEvent.press(screen.getByText('completed - t1'));
-    fireEvent.press(screen.getByText('Toggle Satisfaction'));
+    fireEvent.press(screen.getByText('Thumbs Up'));
     await confirmLastAlert();
 
     await waitFor(() => {
-      expect(mockedSendTradeUpdate).toHaveBeenCalledWith(
-        expect.objectContaining({
-          operation: 'updateTrade',
-          tradeData: expect.objectContaining({
-            trade_id: 't1',
-            user_1_trade_satisfaction: true,
-          }),
-        }),
-      );
+      expect(mockedSendTradeRating).toHaveBeenCalledWith({ trade_id: 't1', score: 5 });
     });
+    expect(mockedSendTradeUpdate).not.toHaveBeenCalled();
   });
 
   it('reveals partner info for selected pending trade', async () => {
@@ -163,13 +163,24 @@ async function sendBatchedUpdatesToBackend(location) {
     }
 
     const db = await openUpdatesDB();
-    const [pokemonUpdates, tradeUpdates] = await Promise.all([
+    const [pokemonUpdates, tradeEntries] = await Promise.all([
       getAllFromStore(db, 'batchedPokemonUpdates'),
       getAllFromStore(db, 'batchedTradeUpdates'),
     ]);
 
+    // Ratings share the trade store; the receiver takes them as tradeRatings.
+    const tradeUpdates = [];
+    const tradeRatings = [];
+    for (const entry of Array.isArray(tradeEntries) ? tradeEntries : []) {
+      if (entry && entry.operation === 'rateTrade' && entry.rating) {
+        tradeRatings.push(entry.rating);
+      } else {
+        tradeUpdates.push(entry);
+      }
+    }
+
     const hasPokemon = Array.isArray(pokemonUpdates) && pokemonUpdates.length > 0;
-    const hasTrades = Array.isArray(tradeUpdates) && tradeUpdates.length > 0;
+    const hasTrades = tradeUpdates.length > 0 || tradeRatings.length > 0;
 
     if (!hasPokemon && !hasTrades) {
       log('batchedUpdates:none', {});
@@ -181,7 +192,7 @@ async function sendBatchedUpdatesToBackend(location) {
       return;
     }
 
-    const payload = { location: location || null, pokemonUpdates, tradeUpdates };
+    const payload = { location: location || null, pokemonUpdates, tradeUpdates, tradeRatings };
     log('batchedUpdates:POST', { payload });
 
     const targetPath = RECEIVER_BATCHED_UPDATES_PATH.startsWith('/')
@@ -70,6 +70,7 @@ export const EventsProvider: React.FC<EventsProviderProps> = ({ children }) => {
   const deviceIdRef       = useRef<string>(getDeviceId());
   const sseRef            = useRef<EventSource | null>(null);
   const hasInitRef        = useRef(false);
+  const lastEventIdRef    = useRef<string>('');
 
   /* ──────────────────── handlers ──────────────────── */
   const handleIncomingUpdate = useCallback(
@@ -95,6 +96,26 @@ export const EventsProvider: React.FC<EventsProviderProps> = ({ children }) => {
     }
   }, []);
 
+  /* the server could not replay what we missed; pull it instead */
+  const resyncFromServer = useCallback(async () => {
+    if (!user) return;
+    const since = useSessionStore.getState().lastUpdateTimestamp;
+    if (!since) return;
+    try {
+      log.debug('resync requested, fetching missed updates');
+      const updates = await fetchUpdates<IncomingUpdateData>(
+        user.user_id,
+        deviceIdRef.current,
+        since.getTime().toString(),
+      );
+      if (updates?.pokemon || updates?.trade) {
+        handleIncomingUpdate(updates);
+      }
+    } catch (err) {
+      log.error('resync fetchUpdates error', err);
+    }
+  }, [handleIncomingUpdate, user]);
+
   const openSSE = useCallback(() => {
     if (!user) return;
 
@@ -102,6 +123,11 @@ export const EventsProvider: React.FC<EventsProviderProps> = ({ children }) => {
     const queryParams: SseQueryParams = {
       device_id: deviceIdRef.current,
     };
+    // We reopen the stream ourselves, so the browser's Last-Event-ID
+    // header is not sent; pass the resume point explicitly.
+    if (lastEventIdRef.current) {
+      queryParams.last_event_id = lastEventIdRef.current;
+    }
     const url = buildUrl(
       import.meta.env.VITE_EVENTS_API_URL,
       eventsContract.endpoints.sse,
@@ -114,7 +140,12 @@ export const EventsProvider: React.FC<EventsProviderProps> = ({ children }) => {
 
       es.onopen    = () => log.debug('open');
       es.onerror   = (e) => { log.error('error', e); closeSSE(); };
+      es.addEventListener('resync', () => {
+        lastEventIdRef.current = '';
+        void resyncFromServer();
+      });
       es.onmessage = (ev) => {
+        if (ev.lastEventId) lastEventIdRef.current = ev.lastEventId;
         try {
           const parsed = JSON.parse(ev.data) as IncomingUpdateData;
           handleIncomingUpdate(parsed);
@@ -125,7 +156,7 @@ export const EventsProvider: React.FC<EventsProviderProps> = ({ children }) => {
     } catch (err) {
       log.error('failed to establish connection', err);
     }
-  }, [closeSSE, handleIncomingUpdate, user]);
+  }, [closeSSE, handleIncomingUpdate, resyncFromServer, user]);
 
   /* ──────────────────── first‑time init ──────────────────── */
   useEffect(() => {
@@ -184,6 +215,7 @@ export const EventsProvider: React.FC<EventsProviderProps> = ({ children }) => {
     if (!isLoggedIn) {
       closeSSE();
       hasInitRef.current = false;
+      lastEventIdRef.current = '';
     }
   }, [isLoggedIn, closeSSE]);
 
@@ -76,8 +76,9 @@ export default function useTradeFiltering(
     const newlyFiltered: string[] = [];
     const reappeared: string[] = [];
 
+    // Only the list filters are reset; anything else (e.g. `match`) is kept.
     safeKeys(nextLocalTradeFilters).forEach((k) => {
-      nextLocalTradeFilters[k] = false;
+      if (typeof nextLocalTradeFilters[k] === 'boolean') nextLocalTradeFilters[k] = false;
     });
 
     safeArray(selectedExcludeImages).forEach((isSelected, idx) => {
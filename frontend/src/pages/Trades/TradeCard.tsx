@@ -40,8 +40,8 @@ type CanonicalTrade = TradeCardTrade & {
   trade_cancelled_by: string | null;
   trade_deleted_date: string | null;
   trade_proposal_date: string;
-  user_1_trade_satisfaction: boolean;
-  user_2_trade_satisfaction: boolean;
+  user_1_trade_satisfaction: number | null;
+  user_2_trade_satisfaction: number | null;
 };
 
 type AcceptArgs = Parameters<typeof handleAcceptTrade>[0];
@@ -75,6 +75,9 @@ const normalizeBoolean = (value: unknown, fallback = false): boolean =>
 const normalizeNumber = (value: unknown, fallback: number): number =>
   typeof value === 'number' && Number.isFinite(value) ? value : fallback;
 
+const normalizeRating = (value: unknown): number | null =>
+  typeof value === 'number' && Number.isFinite(value) ? value : null;
+
 const DEFAULT_ISO_TIMESTAMP = new Date(0).toISOString();
 
 const toCanonicalTrade = (input: TradeCardTrade): CanonicalTrade => {
@@ -96,8 +99,8 @@ const toCanonicalTrade = (input: TradeCardTrade): CanonicalTrade => {
     trade_cancelled_by: normalizeNullableString(input.trade_cancelled_by),
     trade_deleted_date: normalizeNullableString(input.trade_deleted_date),
     trade_proposal_date: normalizeString(input.trade_proposal_date, DEFAULT_ISO_TIMESTAMP),
-    user_1_trade_satisfaction: normalizeBoolean(input.user_1_trade_satisfaction),
-    user_2_trade_satisfaction: normalizeBoolean(input.user_2_trade_satisfaction),
+    user_1_trade_satisfaction: normalizeRating(input.user_1_trade_satisfaction),
+    user_2_trade_satisfaction: normalizeRating(input.user_2_trade_satisfaction),
   };
 };
 
@@ -3,14 +3,18 @@
 import { putBatchedTradeUpdates } from "../../../db/indexedDB";
 import { createScopedLogger } from '@/utils/logger';
 import type { TradeRecord } from '@shared-contracts/trades';
+import type { TradeRatingInput } from '@shared-contracts/receiver';
 
 const log = createScopedLogger('handleThumbsUpTrade');
 
+// A thumbs-up is the top score of a trade rating.
+export const THUMBS_UP_SCORE = 5;
+
 type Trade = TradeRecord & {
   trade_id: string;
   username_proposed: string;
-  user_1_trade_satisfaction: boolean;
-  user_2_trade_satisfaction: boolean;
+  user_1_trade_satisfaction: number | null;
+  user_2_trade_satisfaction: number | null;
   last_update: number;
 };
 
@@ -31,13 +35,21 @@ export async function handleThumbsUpTrade({
 }: HandleThumbsUpTradeArgs): Promise<void> {
   // Determine if the current user is the proposer (User 1) or accepter (User 2)
   const isCurrentUserProposer = trade.username_proposed === currentUsername;
+  const currentRating = isCurrentUserProposer
+    ? trade.user_1_trade_satisfaction
+    : trade.user_2_trade_satisfaction;
+
+  // Each side rates a trade once; storage rejects a second rating.
+  if (currentRating != null) {
+    log.debug('Trade already rated');
+    return;
+  }
 
-  // Toggle the appropriate satisfaction flag and update last_update
   const updatedTrade: Trade = {
     ...trade,
     ...(isCurrentUserProposer
-      ? { user_1_trade_satisfaction: !trade.user_1_trade_satisfaction }
-      : { user_2_trade_satisfaction: !trade.user_2_trade_satisfaction }
+      ? { user_1_trade_satisfaction: THUMBS_UP_SCORE }
+      : { user_2_trade_satisfaction: THUMBS_UP_SCORE }
     ),
     last_update: Date.now(),
   };
@@ -48,17 +60,17 @@ export async function handleThumbsUpTrade({
   try {
     await setTradeData(updatedTrades);
 
-    // Optionally add batched update (if consistent with other handlers)
-    const batchedUpdateData = {
-      operation: 'updateTrade',
-      tradeData: updatedTrade,
-    };
-
-    await putBatchedTradeUpdates(updatedTrade.trade_id, batchedUpdateData);
+    // Queued next to trade updates under its own key; the service worker
+    // sends it as tradeRatings.
+    const rating: TradeRatingInput = { trade_id: trade.trade_id, score: THUMBS_UP_SCORE };
+    await putBatchedTradeUpdates(`rating:${trade.trade_id}`, {
+      operation: 'rateTrade',
+      rating,
+    });
 
     periodicUpdates();
-    log.debug('Trade satisfaction updated');
+    log.debug('Trade rating queued');
   } catch (error) {
-    log.error('Error updating trade satisfaction', error);
+    log.error('Error rating trade', error);
   }
 }
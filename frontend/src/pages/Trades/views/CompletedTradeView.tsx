@@ -51,9 +51,10 @@ const CompletedTradeView: React.FC<CompletedTradeViewProps> = ({
 
   const currentUsername = getStoredUsername() ?? '';
   const isCurrentUserProposer = trade.username_proposed === currentUsername;
-  const satisfactionStatus = isCurrentUserProposer
+  const currentRating = isCurrentUserProposer
     ? trade.user_1_trade_satisfaction
     : trade.user_2_trade_satisfaction;
+  const satisfactionStatus = currentRating != null;
 
   const leftDetails = partnerDetails;
   const rightDetails = currentUserDetails;
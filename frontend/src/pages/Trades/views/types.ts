@@ -9,8 +9,8 @@ export interface TradeViewTrade {
   username_accepting?: TradeRecord['username_accepting'];
   user_proposed_completion_confirmed?: TradeRecord['user_proposed_completion_confirmed'];
   user_accepting_completion_confirmed?: TradeRecord['user_accepting_completion_confirmed'];
-  user_1_trade_satisfaction?: boolean | number | null;
-  user_2_trade_satisfaction?: boolean | number | null;
+  user_1_trade_satisfaction?: TradeRecord['user_1_trade_satisfaction'];
+  user_2_trade_satisfaction?: TradeRecord['user_2_trade_satisfaction'];
   trade_cancelled_date?: TradeRecord['trade_cancelled_date'];
   trade_cancelled_by?: TradeRecord['trade_cancelled_by'];
   trade_completed_date?: TradeRecord['trade_completed_date'];
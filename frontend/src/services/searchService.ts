@@ -6,6 +6,7 @@ import {
 } from './httpClient';
 import type {
   SearchQueryParams,
+  SearchResponse,
   SearchResultRow,
 } from '@shared-contracts/search';
 import { searchContract } from '@shared-contracts/search';
@@ -24,7 +25,7 @@ export async function searchPokemon(
   );
 
   const payload = await parseJsonSafe<
-    SearchResultRow[] | Record<string, SearchResultRow>
+    SearchResponse | SearchResultRow[] | Record<string, SearchResultRow>
   >(response);
 
   if (!response.ok) {
@@ -35,5 +36,11 @@ export async function searchPokemon(
     return [];
   }
 
-  return Array.isArray(payload) ? payload : Object.values(payload);
+  if (Array.isArray(payload)) {
+    return payload;
+  }
+  if (Array.isArray(payload.results)) {
+    return payload.results as SearchResultRow[];
+  }
+  return Object.values(payload as Record<string, SearchResultRow>);
 }
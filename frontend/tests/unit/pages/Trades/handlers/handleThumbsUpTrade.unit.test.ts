@@ -0,0 +1,62 @@
+import { describe, expect, it, vi, beforeEach } from 'vitest';
+
+import { putBatchedTradeUpdates } from '@/db/indexedDB';
+import { handleThumbsUpTrade, THUMBS_UP_SCORE } from '@/pages/Trades/handlers/handleThumbsUpTrade';
+
+vi.mock('@/db/indexedDB', () => ({
+  putBatchedTradeUpdates: vi.fn(async () => undefined),
+}));
+
+const baseTrade = {
+  trade_id: 'trade-1',
+  trade_status: 'completed',
+  username_proposed: 'ash',
+  username_accepting: 'misty',
+  user_1_trade_satisfaction: null,
+  user_2_trade_satisfaction: null,
+  last_update: 1000,
+};
+
+describe('handleThumbsUpTrade', () => {
+  beforeEach(() => {
+    vi.clearAllMocks();
+  });
+
+  it('queues a trade rating for the current side instead of a trade update', async () => {
+    const setTradeData = vi.fn(async () => undefined);
+    const periodicUpdates = vi.fn();
+
+    await handleThumbsUpTrade({
+      trade: { ...baseTrade },
+      trades: { [baseTrade.trade_id]: { ...baseTrade } },
+      setTradeData,
+      periodicUpdates,
+      currentUsername: 'misty',
+    });
+
+    const saved = setTradeData.mock.calls[0][0][baseTrade.trade_id];
+    expect(saved.user_1_trade_satisfaction).toBeNull();
+    expect(saved.user_2_trade_satisfaction).toBe(THUMBS_UP_SCORE);
+    expect(putBatchedTradeUpdates).toHaveBeenCalledWith('rating:trade-1', {
+      operation: 'rateTrade',
+      rating: { trade_id: 'trade-1', score: THUMBS_UP_SCORE },
+    });
+    expect(periodicUpdates).toHaveBeenCalledTimes(1);
+  });
+
+  it('does nothing when the current side has already rated', async () => {
+    const setTradeData = vi.fn(async () => undefined);
+    const rated = { ...baseTrade, user_1_trade_satisfaction: 4 };
+
+    await handleThumbsUpTrade({
+      trade: rated,
+      trades: { [rated.trade_id]: rated },
+      setTradeData,
+      periodicUpdates: vi.fn(),
+      currentUsername: 'ash',
+    });
+
+    expect(setTradeData).not.toHaveBeenCalled();
+    expect(putBatchedTradeUpdates).not.toHaveBeenCalled();
+  });
+});
@@ -31,8 +31,8 @@ const baseTrade = {
   trade_friendship_level: 'Best',
   trade_dust_cost: 50000,
   trade_completed_date: '2026-02-10T00:00:00.000Z',
-  user_1_trade_satisfaction: false,
-  user_2_trade_satisfaction: false,
+  user_1_trade_satisfaction: null,
+  user_2_trade_satisfaction: null,
   is_lucky_trade: true,
 };
 
@@ -68,10 +68,10 @@ describe('CompletedTradeView', () => {
     expect(handleThumbsUp).toHaveBeenCalledTimes(1);
   });
 
-  it('shows thanks text and active thumbs-up style when already satisfied', () => {
+  it('shows thanks text and active thumbs-up style when already rated', () => {
     render(
       <CompletedTradeView
-        trade={{ ...baseTrade, user_1_trade_satisfaction: true }}
+        trade={{ ...baseTrade, user_1_trade_satisfaction: 5 }}
         currentUserDetails={baseDetails}
         partnerDetails={baseDetails}
         loading={false}
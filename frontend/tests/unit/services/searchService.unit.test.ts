@@ -20,6 +20,27 @@ describe('searchService.searchPokemon', () => {
     expect(result).toEqual([{ pokemon_id: 1 }, { pokemon_id: 2 }]);
   });
 
+  it('returns ordered results from the paged response', async () => {
+    vi.spyOn(global, 'fetch').mockResolvedValueOnce(
+      new Response(
+        JSON.stringify({
+          results: [{ pokemon_id: 2 }, { pokemon_id: 1 }],
+          next_cursor: 'abc',
+          total_count: 40,
+          total_is_estimate: false,
+        }),
+        {
+          status: 200,
+          headers: { 'Content-Type': 'application/json' },
+        },
+      ),
+    );
+
+    const result = await searchPokemon({ ownership: 'caught', sort: 'cp' });
+
+    expect(result).toEqual([{ pokemon_id: 2 }, { pokemon_id: 1 }]);
+  });
+
   it('normalizes object payload into array rows', async () => {
     vi.spyOn(global, 'fetch').mockResolvedValueOnce(
       new Response(
@@ -1,8 +1,15 @@
+import type { TagUpdate } from './receiver';
+import type { InboxNotification, TradeChatMessage } from './users';
+
 export const eventsContract = {
   endpoints: {
     getUpdates: '/getUpdates',
     sse: '/sse',
     sseToken: '/sse-token',
+    ws: '/ws',
+    pushVapidPublicKey: '/push/vapid-public-key',
+    pushSubscription: '/push/subscription',
+    presence: '/presence',
   },
 } as const;
 
@@ -11,10 +18,104 @@ export interface UpdatesQueryParams extends Record<string, string> {
   timestamp: string;
 }
 
+/** Paged sync: pass `limit` (max 2000) and, after the first page, the
+ *  previous response's `next_cursor`; keep going while `has_more`. */
+export interface UpdatesPageQueryParams extends Record<string, string> {
+  device_id: string;
+  limit: string;
+  // Optional `cursor` (opaque) and, on the first page only, `timestamp`.
+}
+
+export interface UpdatesResponse<TPokemon = Record<string, unknown>, TTrade = Record<string, unknown>> {
+  pokemon: Record<string, TPokemon>;
+  trade: Record<string, TTrade>;
+  relatedInstances: Record<string, TPokemon>;
+  /** Null only before storage has the change sequence. */
+  next_cursor: string | null;
+  has_more: boolean;
+}
+
 export interface SseQueryParams extends Record<string, string> {
   device_id: string;
+  // Optional `last_event_id` resumes the stream after that event id.
+  // Optional `types` (e.g. `trade.*,pokemon.deleted` or `*`) switches the
+  // stream to the named events below.
+}
+
+export type TypedEventType =
+  | 'pokemon.upserted'
+  | 'pokemon.deleted'
+  | 'trade.proposed'
+  | 'trade.accepted'
+  | 'trade.completed'
+  | 'trade.cancelled'
+  | 'trade.reminder'
+  | 'profile.updated'
+  | 'tag.updated'
+  | 'trade.message_created'
+  | 'trade.messages_read'
+  | 'notification.created'
+  | 'presence.changed';
+
+export interface TradeEventData {
+  trade_id: string;
+  status: string;
+  trade: Record<string, unknown>;
+  related_instances: Record<string, Record<string, unknown>>;
+  reason?: 'cancelled' | 'denied' | 'deleted' | 'expired' | 'countered';
+}
+
+export interface TypedEventDataMap {
+  'pokemon.upserted': { instance_id: string; instance: Record<string, unknown>; username?: string };
+  'pokemon.deleted': { instance_id: string };
+  'trade.proposed': TradeEventData;
+  'trade.accepted': TradeEventData;
+  'trade.completed': TradeEventData;
+  'trade.cancelled': TradeEventData;
+  'trade.reminder': TradeReminder;
+  'profile.updated': { username: string; changed: Array<'location' | 'pokemon'> };
+  'tag.updated': { tag_id: string; operation: TagUpdate['operation']; tag: Partial<TagUpdate['tagData']> };
+  'trade.message_created': TradeChatMessage;
+  'trade.messages_read': TradeMessagesRead;
+  'notification.created': InboxNotification;
+  'presence.changed': TrainerPresence;
+}
+
+/** Envelope of every typed event, schema version 1 (see
+ *  reader/events/schemas/v1). */
+export interface TypedEvent<T extends TypedEventType = TypedEventType> {
+  v: 1;
+  type: T;
+  key: string;
+  occurred_at: number;
+  data: TypedEventDataMap[T];
+}
+
+/** Payload of the `resync` SSE event: the gap since `last_event_id` is no
+ *  longer buffered (or the client fell too far behind), so the client should
+ *  pull `/getUpdates` instead. */
+export interface SseResyncEvent {
+  reason: 'replay_gap' | 'queue_overflow';
+  last_event_id?: string;
 }
 
+/** `user:<user_id>`, `trade:<trade_id>` or `trainer:<username>`. */
+export type WsChannel = string;
+
+export type WsClientMessage =
+  | { type: 'subscribe' | 'unsubscribe'; channel: WsChannel; ref?: string }
+  | { type: 'ack'; id: string }
+  | { type: 'filter'; types: string[]; ref?: string }
+  | { type: 'batchedUpdates'; data: Record<string, unknown>; ref?: string }
+  | { type: 'ping'; ref?: string };
+
+export type WsServerMessage =
+  | { type: 'welcome'; channels: WsChannel[]; publish: boolean }
+  | { type: 'event'; channel: WsChannel; id?: string; event?: TypedEventType; data: IncomingUpdateEnvelope | TypedEvent }
+  | { type: 'resync'; data: SseResyncEvent }
+  | { type: 'ok'; ref?: string; channel?: WsChannel; trace_id?: string }
+  | { type: 'error'; ref?: string; error: string };
+
 export interface SseTokenQueryParams extends Record<string, string> {
   device_id: string;
 }
@@ -24,6 +125,56 @@ export interface SseTokenResponse {
   expires_in_seconds: number;
 }
 
+/** Heads-up from storage's expiry scheduler; the trade is unchanged. */
+export interface TradeReminder {
+  trade_id: string;
+  trade_status: 'proposed' | 'pending';
+  username_proposed: string;
+  username_accepting: string;
+  /** When the trade expires (proposed) or is auto-cancelled (pending). */
+  expires_at?: string;
+}
+
+/** The reader read every message up to `up_to_message_id` on a trade. */
+export interface TradeMessagesRead {
+  trade_id: string;
+  reader_username: string;
+  up_to_message_id: string;
+  read_at: string;
+}
+
+/** Presence of a trainer who shares it (users.share_presence).
+ *  `last_active_at` is only set with `recent`. */
+export interface TrainerPresence {
+  username: string;
+  status: 'online' | 'recent' | 'offline';
+  last_active_at?: string;
+}
+
+/** GET /presence?usernames=a,b (at most 100). Trainers who do not share
+ *  their presence are left out. */
+export interface PresenceResponse {
+  presence: Record<string, TrainerPresence>;
+}
+
+export interface PushVapidPublicKeyResponse {
+  public_key: string;
+}
+
+/** Body of PUT /push/subscription?device_id=: PushSubscription.toJSON(). */
+export interface PushSubscriptionBody {
+  endpoint: string;
+  keys: { p256dh: string; auth: string };
+}
+
+/** What the service worker receives in its push event. */
+export interface TradePushMessage {
+  title: string;
+  body: string;
+  tag: string;
+  data: { trade_id: string; trade_status: string; url: string };
+}
+
 export interface IncomingUpdateEnvelope<
   TPokemon = Record<string, unknown>,
   TTrade = Record<string, unknown>,
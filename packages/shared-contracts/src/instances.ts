@@ -1,3 +1,23 @@
+/** Conditions on the other instance of a trade match, kept under `match`
+ *  in wanted_filters (of a wanted instance) or trade_filters (of one for
+ *  trade). A key whose field the other instance leaves unset does not pass.
+ *  `backgrounds` lists accepted location cards and, on a wanted instance,
+ *  lifts the rule that both cards are equal. `no_shadow` also rejects
+ *  purified. `max_distance_km` is measured between the two trainers.
+ *  trade_filters may not set the IV, lucky or friendship keys: a wanted
+ *  instance leaves them unset, so storage ignores such a filter. */
+export interface TradeMatchFilter {
+  min_attack_iv?: number;
+  min_defense_iv?: number;
+  min_stamina_iv?: number;
+  min_iv_total?: number;
+  lucky_only?: boolean;
+  no_shadow?: boolean;
+  backgrounds?: Array<string | number>;
+  max_distance_km?: number;
+  min_friendship_level?: number;
+}
+
 export interface PokemonInstance {
   // identity
   instance_id?: string;
@@ -64,6 +84,8 @@ export interface PokemonInstance {
   wanted_tags: string[] | null;
   not_trade_list: Record<string, unknown> | null;
   not_wanted_list: Record<string, unknown> | null;
+  // The client's list filters (name -> on), plus an optional
+  // `match: TradeMatchFilter`.
   trade_filters: Record<string, unknown> | null;
   wanted_filters: Record<string, unknown> | null;
 
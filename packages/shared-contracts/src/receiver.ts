@@ -11,4 +11,67 @@ export interface ReceiverBatchedUpdatesPayload<
   location: unknown | null;
   pokemonUpdates: TPokemonUpdate[];
   tradeUpdates: TTradeUpdate[];
+  tradeRatings?: TradeRatingInput[];
+  tagUpdates?: TagUpdate[];
+  tradeMessages?: TradeMessageSend[];
+  tradeMessageReads?: TradeMessageRead[];
+  tradeMessageReports?: TradeMessageReport[];
+  /** At most 5 per request. */
+  tradeCycleProposals?: TradeCycleProposal[];
+}
+
+/** Rates the other side of a completed trade, once per trade and side.
+ *  Storage mirrors the score to the rater's `user_N_trade_satisfaction`. */
+export interface TradeRatingInput {
+  trade_id: string;
+  /** 1 to 5. */
+  score: number;
+  /** At most 500 characters. */
+  comment?: string;
+}
+
+/** Creates, updates or deletes a custom tag. Update only touches the
+ *  fields present in `tagData`; delete needs only `tag_id`. */
+export interface TagUpdate {
+  operation: 'create' | 'update' | 'delete';
+  tagData: {
+    tag_id: string;
+    parent?: 'caught' | 'trade' | 'wanted';
+    name?: string;
+    /** `#rrggbb`, or null to clear. */
+    color?: string | null;
+    sort?: number;
+    /** Nests the tag under another tag of the same bucket; null un-nests. */
+    parent_tag_id?: string | null;
+  };
+}
+
+/** A chat message to the other side of a proposed or pending trade, at
+ *  most 1000 characters. Resending the same `client_message_id` is a no-op. */
+export interface TradeMessageSend {
+  trade_id: string;
+  client_message_id?: string;
+  body: string;
+}
+
+/** Marks the caller's received messages in a trade read, up to and
+ *  including `up_to_message_id`. */
+export interface TradeMessageRead {
+  trade_id: string;
+  up_to_message_id: string;
+}
+
+/** Proposes a cycle from /tradeCycles: the legs in order, each giving to
+ *  the next leg's giver. Storage creates one trade per leg, with ids
+ *  `<trade_cycle_id>:1` onwards; the caller must be a member. */
+export interface TradeCycleProposal {
+  trade_cycle_id: string;
+  legs: { trade_instance_id: string; wanted_instance_id: string }[];
+}
+
+/** Reports a received message to moderation. */
+export interface TradeMessageReport {
+  message_id: string;
+  reason: 'spam' | 'harassment' | 'scam' | 'other';
+  details?: string;
 }
@@ -4,12 +4,29 @@ export type SearchQueryParamValue = string | number | boolean | null | undefined
 
 export type SearchQueryParams = Record<string, SearchQueryParamValue>;
 
+/** `distance` is in whole km (at least 1) with a location search. The
+ *  trainer's `latitude`/`longitude` are the centre of their geohash
+ *  `location_cell` and only sent when they allow their location. */
 export type SearchResultRow = {
   pokemon_id?: number;
   distance?: number;
+  latitude?: number;
+  longitude?: number;
+  location_cell?: string;
   [key: string]: unknown;
 };
 
+export type SearchSort = 'distance' | 'iv_total' | 'cp' | 'updated' | 'reputation';
+
+/** GET /searchPokemon. `total_count` is only sent with the first page (no
+ *  cursor); it is capped at 1000 and marked as an estimate past that. */
+export interface SearchResponse {
+  results: SearchResultRow[];
+  next_cursor: string | null;
+  total_count: number | null;
+  total_is_estimate: boolean;
+}
+
 export interface PokemonSearchQueryParams extends SearchQueryParams {
   pokemon_id: number;
   shiny: boolean;
@@ -33,12 +50,135 @@ export interface PokemonSearchQueryParams extends SearchQueryParams {
   ownership: OwnershipMode;
   range_km: number;
   limit: number;
+  /** Defaults to `distance` with a location, else `updated`. */
+  sort?: SearchSort;
+  /** `next_cursor` of the previous page. */
+  cursor?: string;
   dynamax: boolean;
   gigantamax: boolean;
 }
 
+export type SearchSize = 'xxs' | 'xs' | 'xl' | 'xxl';
+
+/** List, range and flag filters of /searchPokemon. Lists are
+ *  comma-separated (at most 50 values) and match any value; `pokemon_id`,
+ *  `costume_id` and `fast_move_id` take lists too, and `costume_id` also
+ *  takes `null` entries or `any`. */
+export interface PokemonSearchRangeParams extends SearchQueryParams {
+  charged_move_ids?: string;
+  min_attack_iv?: number;
+  min_defense_iv?: number;
+  min_stamina_iv?: number;
+  min_iv_percent?: number;
+  max_iv_percent?: number;
+  min_cp?: number;
+  max_cp?: number;
+  min_level?: number;
+  max_level?: number;
+  /** Comma-separated `SearchSize`s; needs `pokemon_id`. */
+  size?: string;
+  /** `YYYY-MM-DD`, inclusive. */
+  caught_from?: string;
+  caught_to?: string;
+  lucky?: boolean;
+  purified?: boolean;
+  traded?: boolean;
+}
+
+export type SearchFacetName = 'species' | 'costume' | 'flags' | 'distance' | 'team';
+
+/** Facets mode of /searchPokemon: the same filters, answered with counts.
+ *  Not pageable; `distance` needs a location. */
+export interface SearchFacetsQueryParams extends SearchQueryParams {
+  /** Comma-separated `SearchFacetName`s. */
+  facets: string;
+}
+
+/** `value` is `null` for no costume / no team. */
+export interface SearchFacetCount {
+  value: number | string | null;
+  count: number;
+}
+
+/** Distance bucket in km; `max_km` is `null` for the open-ended last one. */
+export interface SearchFacetDistanceBucket {
+  bucket: string;
+  min_km: number;
+  max_km: number | null;
+  count: number;
+}
+
+/** Only the requested facets are present. Value lists are ordered by count
+ *  and hold at most 50 entries; counts cover every match and may be up to a
+ *  minute old. */
+export interface SearchFacetsResponse {
+  facets: {
+    species?: SearchFacetCount[];
+    costume?: SearchFacetCount[];
+    flags?: { shiny: number; shadow: number; lucky: number };
+    distance?: SearchFacetDistanceBucket[];
+    team?: SearchFacetCount[];
+  };
+  total_count: number;
+}
+
+/** GET /tradeCycles, around the caller's stored location. */
+export interface TradeCycleQueryParams extends SearchQueryParams {
+  /** Default 25, at most 100. */
+  range_km?: number;
+  /** 3 or 4 (default). */
+  max_length?: number;
+  /** Default 10, at most 25. */
+  limit?: number;
+}
+
+/** One hop: `from` gives `trade_instance_id` to `to`, who wants it through
+ *  `wanted_instance_id`. The first leg starts at the caller. */
+export interface TradeCycleLeg {
+  from_user_id: string;
+  from_username: string;
+  to_user_id: string;
+  to_username: string;
+  trade_instance_id: string;
+  wanted_instance_id: string;
+  pokemon_id: number;
+  distance_km: number;
+}
+
+export interface TradeCycleMember {
+  user_id: string;
+  username: string;
+  /** From the caller. */
+  distance_km: number;
+  reputation?: {
+    rating_average: number | null;
+    rating_count: number;
+    trades_completed: number;
+    trades_cancelled: number;
+    completion_rate: number | null;
+    cancellation_rate: number | null;
+  };
+}
+
+/** Ranked by `score`: the lowest member rating (unrated counts as 3) less
+ *  the average hop as a share of `range_km`. */
+export interface TradeCycle {
+  length: number;
+  score: number;
+  total_distance_km: number;
+  legs: TradeCycleLeg[];
+  members: TradeCycleMember[];
+}
+
+export interface TradeCyclesResponse {
+  cycles: TradeCycle[];
+  /** A graph or candidate cap was hit; some cycles may be missing. */
+  truncated: boolean;
+}
+
 export const searchContract = {
   endpoints: {
     searchPokemon: '/searchPokemon',
+    tradeCycles: '/tradeCycles',
   },
 } as const;
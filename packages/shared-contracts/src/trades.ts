@@ -22,9 +22,13 @@ export interface TradeRecord {
   trade_dust_cost?: number | null;
   is_lucky_trade?: boolean | number | null;
   trade_deleted_date?: string | null;
-  user_1_trade_satisfaction?: boolean | null;
-  user_2_trade_satisfaction?: boolean | null;
+  /** The proposer's 1-5 rating of the trade, mirrored from `tradeRatings`. */
+  user_1_trade_satisfaction?: number | null;
+  /** The accepter's 1-5 rating of the trade, mirrored from `tradeRatings`. */
+  user_2_trade_satisfaction?: number | null;
   last_update?: number | string | null;
+  /** Set on the legs of a trade cycle; legs only move the accepting side. */
+  trade_cycle_id?: string | null;
   [key: string]: unknown;
 }
 
@@ -55,6 +55,81 @@ export interface SecondaryUserUpdateRequest {
   pokemonGoName?: string;
 }
 
+export type NotificationType =
+  | 'trade_proposed'
+  | 'trade_accepted'
+  | 'trade_cancelled'
+  | 'trade_completed'
+  | 'trade_rated'
+  | 'most_wanted_match'
+  | 'saved_search_match';
+
+export interface InboxNotification {
+  notification_id: string;
+  notification_type: NotificationType;
+  actor_username: string | null;
+  trade_id: string | null;
+  instance_id: string | null;
+  data: Record<string, unknown>;
+  created_at: string;
+  read_at: string | null;
+}
+
+export interface NotificationsPage {
+  notifications: InboxNotification[];
+  unread_count: number;
+  /** Pass as `before` for the next page; null on the last page. */
+  next_before: string | null;
+}
+
+/** One chat message on a trade. `client_message_id` matches the id the
+ *  sender's client gave the message in `tradeMessages`. */
+export interface TradeChatMessage {
+  message_id: string;
+  trade_id: string;
+  client_message_id: string;
+  sender_username: string;
+  body: string;
+  created_at: string;
+  read_at: string | null;
+}
+
+export interface TradeMessagesPage {
+  messages: TradeChatMessage[];
+  /** The caller's unread messages in this trade. */
+  unread_count: number;
+  /** Pass as `before` for the next page; null on the last page. */
+  next_before: string | null;
+}
+
+/** Body of the read and dismiss endpoints. */
+export type NotificationSelection = { ids: string[] } | { all: true };
+
+export interface NotificationPreferences {
+  preferences: Record<NotificationType, boolean>;
+}
+
+/** A kept /searchPokemon query. `params` holds the query strings it was
+ *  saved with; storage alerts the owner when a new listing matches. */
+export interface SavedSearch {
+  search_id: string;
+  name: string;
+  params: Record<string, string>;
+  muted: boolean;
+  notify_every_minutes: number;
+  last_notified_at: string | null;
+  created_at: string;
+  updated_at: string;
+}
+
+/** Body of the create (name and params required) and update endpoints. */
+export interface SavedSearchRequest {
+  name?: string;
+  params?: Record<string, string | number | boolean | null>;
+  muted?: boolean;
+  notify_every_minutes?: number;
+}
+
 export type ErrorEnvelope = {
   message?: string;
 };
@@ -73,5 +148,19 @@ export const usersContract = {
       `/update-user/${encodeURIComponent(userId)}`,
     userOverview: (userId: string) =>
       `/users/${encodeURIComponent(userId)}/overview`,
+    notifications: (userId: string) =>
+      `/users/${encodeURIComponent(userId)}/notifications`,
+    notificationsRead: (userId: string) =>
+      `/users/${encodeURIComponent(userId)}/notifications/read`,
+    notificationsDismiss: (userId: string) =>
+      `/users/${encodeURIComponent(userId)}/notifications/dismiss`,
+    notificationPreferences: (userId: string) =>
+      `/users/${encodeURIComponent(userId)}/notification-preferences`,
+    savedSearches: (userId: string) =>
+      `/users/${encodeURIComponent(userId)}/saved-searches`,
+    savedSearch: (userId: string, searchId: string) =>
+      `/users/${encodeURIComponent(userId)}/saved-searches/${encodeURIComponent(searchId)}`,
+    tradeMessages: (tradeId: string) =>
+      `/trades/${encodeURIComponent(tradeId)}/messages`,
   },
 } as const;
@@ -0,0 +1,143 @@
+// admin_handler.go
+
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+)
+
+// maxDisconnectBlock caps block_seconds; a revoked token should have
+// expired well before then.
+const maxDisconnectBlock = 24 * time.Hour
+
+// requireAdmin lets through users listed in EVENTS_ADMIN_USER_IDS. It runs
+// after verifyJWT.
+func requireAdmin(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+	for _, id := range config.Events.AdminUserIDs {
+		if userID != "" && id == userID {
+			return c.Next()
+		}
+	}
+	logrus.Warnf("Refused admin request from user %s", userID)
+	return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Admin access required"})
+}
+
+// connectionInfo describes one live connection in the admin listing.
+type connectionInfo struct {
+	UserID      string    `json:"user_id"`
+	DeviceID    string    `json:"device_id"`
+	Transport   string    `json:"transport"`
+	ConnectedAt time.Time `json:"connected_at"`
+	BytesSent   int64     `json:"bytes_sent"`
+	QueueDepth  int       `json:"queue_depth"`
+	Typed       bool      `json:"typed"`
+	Channels    int       `json:"channels"`
+}
+
+/* -------------------------------------------------------------------------- */
+/*  GET /api/admin/connections  (admin)                                       */
+/* -------------------------------------------------------------------------- */
+
+// listConnections returns this replica's live connections, oldest first,
+// optionally narrowed with user_id and device_id. It is per replica: the
+// totals and connections leave out every other replica, so with several
+// replicas call each one directly.
+func listConnections(c *fiber.Ctx) error {
+	userID := strings.TrimSpace(c.Query("user_id"))
+	deviceID := strings.TrimSpace(c.Query("device_id"))
+
+	clientsMutex.Lock()
+	total := len(clients)
+	users := make(map[string]struct{}, len(clients))
+	conns := make([]connectionInfo, 0, len(clients))
+	for _, client := range clients {
+		users[client.UserID] = struct{}{}
+		if (userID != "" && client.UserID != userID) || (deviceID != "" && client.DeviceID != deviceID) {
+			continue
+		}
+		channels := 1
+		if client.Channels != nil {
+			channels = len(client.Channels)
+		}
+		conns = append(conns, connectionInfo{
+			UserID:      client.UserID,
+			DeviceID:    client.DeviceID,
+			Transport:   client.Transport,
+			ConnectedAt: client.ConnectedAt,
+			BytesSent:   client.BytesSent.Load(),
+			QueueDepth:  client.Queue.depth(),
+			Typed:       client.Types != nil,
+			Channels:    channels,
+		})
+	}
+	clientsMutex.Unlock()
+
+	sort.Slice(conns, func(i, j int) bool { return conns[i].ConnectedAt.Before(conns[j].ConnectedAt) })
+	return c.JSON(fiber.Map{
+		"instance_id":     config.Events.InstanceID,
+		"scope":           "instance",
+		"total":           total,
+		"users":           len(users),
+		"max_connections": config.Events.MaxConnections,
+		"max_per_user":    config.Events.MaxConnectionsPerUser,
+		"connections":     conns,
+	})
+}
+
+/* -------------------------------------------------------------------------- */
+/*  DELETE /api/admin/connections?user_id=<id>[&device_id=<id>]  (admin)      */
+/* -------------------------------------------------------------------------- */
+
+// disconnectConnections closes a user's (or one device's) connections on
+// every replica: this one right away, the others on their next
+// connection_blocks poll. block_seconds refuses reconnects for that long,
+// e.g. for the rest of a revoked token's lifetime; omitting it lifts an
+// earlier block. disconnected counts this replica's connections only.
+func disconnectConnections(c *fiber.Ctx) error {
+	userID := strings.TrimSpace(c.Query("user_id"))
+	if userID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Missing user_id"})
+	}
+	deviceID := strings.TrimSpace(c.Query("device_id"))
+
+	var block time.Duration
+	if raw := c.Query("block_seconds"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "block_seconds must be a non-negative integer"})
+		}
+		block = time.Duration(n) * time.Second
+		if block > maxDisconnectBlock {
+			block = maxDisconnectBlock
+		}
+	}
+
+	admin, _ := c.Locals("user_id").(string)
+	row := &connectionBlock{UserID: userID, DeviceID: deviceID, CreatedBy: admin}
+	if block > 0 {
+		until := time.Now().Add(block).UTC()
+		row.BlockedUntil = &until
+	}
+	closed, err := recordConnectionBlock(row)
+	if err != nil {
+		logrus.Errorf("Failed to record admin disconnect of user=%s device=%s: %v", userID, deviceID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to record disconnect"})
+	}
+	logrus.Infof("Admin %s disconnected user=%s device=%s (%d local connections, block %s)", admin, userID, deviceID, closed, block)
+
+	resp := fiber.Map{
+		"instance_id":  config.Events.InstanceID,
+		"disconnected": closed,
+	}
+	if row.BlockedUntil != nil {
+		resp["blocked_until"] = *row.BlockedUntil
+	}
+	return c.JSON(resp)
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+)
+
+func withRegistry(t *testing.T) {
+	t.Helper()
+	origClients, origBlocked, origConfig := clients, blockedConnections, config
+	clients = make(map[string]*Client)
+	blockedConnections = make(map[string]time.Time)
+	t.Cleanup(func() {
+		clients, blockedConnections, config = origClients, origBlocked, origConfig
+	})
+}
+
+func addTestClient(userID, deviceID, transport string) (string, *Client) {
+	client := &Client{UserID: userID, DeviceID: deviceID, Transport: transport, Queue: newOutboundQueue(4, 0, overflowPolicyResync), Connected: true}
+	id := newClientID(userID, deviceID, transport)
+	clientsMutex.Lock()
+	registerClientLocked(id, client)
+	clientsMutex.Unlock()
+	return id, client
+}
+
+func TestAdmitClient_CapsPerUserAndGlobally(t *testing.T) {
+	withRegistry(t)
+	config.Events.MaxConnections = 3
+	config.Events.MaxConnectionsPerUser = 2
+
+	// Two tabs on the same device are two registry entries.
+	addTestClient("u-1", "d-1", transportSSE)
+	addTestClient("u-1", "d-1", transportSSE)
+	if len(clients) != 2 {
+		t.Fatalf("expected both tabs registered, got %d", len(clients))
+	}
+
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+	if err := admitClientLocked("u-1", "d-2", time.Now()); err != errUserConnectionLimit {
+		t.Fatalf("expected the per-user cap, got %v", err)
+	}
+	if err := admitClientLocked("u-2", "d-1", time.Now()); err != nil {
+		t.Fatalf("expected another user to fit, got %v", err)
+	}
+	clients["extra"] = &Client{UserID: "u-3"}
+	if err := admitClientLocked("u-2", "d-1", time.Now()); err != errConnectionsFull {
+		t.Fatalf("expected the global cap, got %v", err)
+	}
+	if admissionStatus(errUserConnectionLimit) != fiber.StatusTooManyRequests || admissionStatus(errConnectionsFull) != fiber.StatusServiceUnavailable {
+		t.Fatalf("unexpected admission statuses")
+	}
+}
+
+func TestForceDisconnect_ClosesDeviceAndBlocksReconnects(t *testing.T) {
+	withRegistry(t)
+
+	_, tab1 := addTestClient("u-1", "d-1", transportSSE)
+	_, ws := addTestClient("u-1", "d-1", transportWS)
+	_, other := addTestClient("u-1", "d-2", transportSSE)
+
+	if n := forceDisconnect("u-1", "d-1", "disconnected by admin", time.Minute); n != 2 {
+		t.Fatalf("expected both d-1 connections closed, got %d", n)
+	}
+	for _, c := range []*Client{tab1, ws} {
+		select {
+		case <-c.Queue.done:
+		default:
+			t.Fatalf("expected the queue to be closed")
+		}
+		if c.Connected || c.CloseReason == "" {
+			t.Fatalf("expected the client marked with a close reason: %+v", c)
+		}
+	}
+	if !other.Connected || len(clients) != 1 {
+		t.Fatalf("expected the other device untouched")
+	}
+
+	clientsMutex.Lock()
+	blocked := admitClientLocked("u-1", "d-1", time.Now())
+	otherDevice := admitClientLocked("u-1", "d-2", time.Now())
+	expired := admitClientLocked("u-1", "d-1", time.Now().Add(2*time.Minute))
+	clientsMutex.Unlock()
+	if blocked != errConnectionBlocked || otherDevice != nil || expired != nil {
+		t.Fatalf("unexpected admission: blocked=%v other=%v expired=%v", blocked, otherDevice, expired)
+	}
+
+	// Without a block, an earlier one is lifted.
+	forceDisconnect("u-1", "", "", 0)
+	forceDisconnect("u-1", "d-1", "", 0)
+	if len(blockedConnections) != 0 || len(clients) != 0 {
+		t.Fatalf("expected no blocks or clients left, got %v / %d", blockedConnections, len(clients))
+	}
+}
+
+func TestAdminConnections_RequiresAdminAndLists(t *testing.T) {
+	withRegistry(t)
+	config.Events.AdminUserIDs = []string{"admin-1"}
+	config.Events.InstanceID = "events-a"
+
+	_, client := addTestClient("u-1", "d-1", transportSSE)
+	client.BytesSent.Add(42)
+	client.Queue.push(sseFrame{ID: 1, Data: []byte(`{}`)})
+	addTestClient("u-2", "d-9", transportWS)
+
+	newApp := func(userID string) *fiber.App {
+		app := fiber.New()
+		app.Use(func(c *fiber.Ctx) error {
+			c.Locals("user_id", userID)
+			return c.Next()
+		})
+		app.Get("/api/admin/connections", requireAdmin, listConnections)
+		app.Delete("/api/admin/connections", requireAdmin, disconnectConnections)
+		return app
+	}
+
+	resp, err := newApp("u-1").Test(httptest.NewRequest(fiber.MethodGet, "/api/admin/connections", nil), -1)
+	if err != nil || resp.StatusCode != fiber.StatusForbidden {
+		t.Fatalf("expected non-admins to be refused, got %v %v", resp.StatusCode, err)
+	}
+
+	admin := newApp("admin-1")
+	resp, err = admin.Test(httptest.NewRequest(fiber.MethodGet, "/api/admin/connections?user_id=u-1", nil), -1)
+	if err != nil || resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("expected listing, got %v %v", resp.StatusCode, err)
+	}
+	var body struct {
+		InstanceID  string           `json:"instance_id"`
+		Total       int              `json:"total"`
+		Users       int              `json:"users"`
+		Connections []connectionInfo `json:"connections"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body.InstanceID != "events-a" || body.Total != 2 || body.Users != 2 || len(body.Connections) != 1 {
+		t.Fatalf("unexpected listing: %+v", body)
+	}
+	if got := body.Connections[0]; got.DeviceID != "d-1" || got.BytesSent != 42 || got.QueueDepth != 1 || got.ConnectedAt.IsZero() {
+		t.Fatalf("unexpected connection: %+v", got)
+	}
+
+	resp, err = admin.Test(httptest.NewRequest(fiber.MethodDelete, "/api/admin/connections", nil), -1)
+	if err != nil || resp.StatusCode != fiber.StatusBadRequest {
+		t.Fatalf("expected user_id to be required, got %v %v", resp.StatusCode, err)
+	}
+
+	withConnectionBlocks(t)
+	gdb, mock, sqlDB := setupMockGormDB(t)
+	defer sqlDB.Close()
+	origDB := db
+	defer func() { db = origDB }()
+	db = gdb
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `connection_blocks`")).
+		WithArgs("u-2", "", sqlmock.AnyArg(), "admin-1", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(7, 1))
+	mock.ExpectCommit()
+
+	resp, err = admin.Test(httptest.NewRequest(fiber.MethodDelete, "/api/admin/connections?user_id=u-2&block_seconds=60", nil), -1)
+	if err != nil || resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("expected disconnect, got %v %v", resp.StatusCode, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet sql expectations: %v", err)
+	}
+	if len(clients) != 1 {
+		t.Fatalf("expected u-2 disconnected, %d clients left", len(clients))
+	}
+	if _, ok := appliedConnectionBlocks[7]; !ok {
+		t.Fatalf("expected the recorded row marked applied, got %v", appliedConnectionBlocks)
+	}
+}
+
+func withConnectionBlocks(t *testing.T) {
+	t.Helper()
+	orig := appliedConnectionBlocks
+	appliedConnectionBlocks = make(map[uint64]time.Time)
+	t.Cleanup(func() { appliedConnectionBlocks = orig })
+}
+
+func TestPollConnectionBlocks_AppliesOtherReplicasDisconnects(t *testing.T) {
+	withRegistry(t)
+	withConnectionBlocks(t)
+	gdb, mock, sqlDB := setupMockGormDB(t)
+	defer sqlDB.Close()
+	origDB := db
+	defer func() { db = origDB }()
+	db = gdb
+
+	_, blocked := addTestClient("u-1", "d-1", transportSSE)
+	_, kicked := addTestClient("u-2", "d-1", transportWS)
+	_, other := addTestClient("u-3", "d-1", transportSSE)
+
+	now := time.Now()
+	until := now.Add(time.Hour)
+	cols := []string{"id", "user_id", "device_id", "blocked_until", "created_by", "created_at"}
+	query := regexp.QuoteMeta("SELECT * FROM `connection_blocks` WHERE created_at >= ? ORDER BY id")
+	mock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows(cols).
+		AddRow(1, "u-1", "", until, "admin-1", now).
+		AddRow(2, "u-2", "d-1", nil, "admin-1", now))
+	if err := pollConnectionBlocks(now, connectionBlockLookback); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if blocked.Connected || kicked.Connected || !other.Connected {
+		t.Fatalf("expected u-1 and u-2 disconnected only")
+	}
+	clientsMutex.Lock()
+	refused := admitClientLocked("u-1", "d-2", now)
+	readmitted := admitClientLocked("u-2", "d-1", now)
+	clientsMutex.Unlock()
+	if refused != errConnectionBlocked || readmitted != nil {
+		t.Fatalf("unexpected admission: u-1=%v u-2=%v", refused, readmitted)
+	}
+
+	// A row seen before is not applied again, so a reconnect survives; a
+	// later row without a block lifts u-1's.
+	_, back := addTestClient("u-2", "d-1", transportWS)
+	mock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows(cols).
+		AddRow(2, "u-2", "d-1", nil, "admin-1", now).
+		AddRow(3, "u-1", "", nil, "admin-1", now))
+	if err := pollConnectionBlocks(now.Add(connectionBlockPollInterval), connectionBlockLookback); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if !back.Connected || len(blockedConnections) != 0 {
+		t.Fatalf("expected the reconnect kept and the block lifted, got %v", blockedConnections)
+	}
+
+	// Rows out of the lookback are forgotten.
+	mock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows(cols))
+	if err := pollConnectionBlocks(now.Add(2*connectionBlockLookback), connectionBlockLookback); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if len(appliedConnectionBlocks) != 0 {
+		t.Fatalf("expected applied ids pruned, got %v", appliedConnectionBlocks)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet sql expectations: %v", err)
+	}
+}
@@ -3,19 +3,216 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	transportSSE = "sse"
+	transportWS  = "ws"
 )
 
 type Client struct {
-	UserID    string
-	DeviceID  string
-	Channel   chan []byte
-	Context   *fiber.Ctx
-	Connected bool
-	closeOnce sync.Once // ensures Channel is closed only once
+	UserID      string
+	DeviceID    string
+	Transport   string         // sse or ws
+	Queue       *outboundQueue // bounded, coalescing send queue
+	Context     *fiber.Ctx
+	Connected   bool
+	ConnectedAt time.Time
+	// BytesSent counts what the writer goroutine has written so far.
+	BytesSent atomic.Int64
+	// CloseReason is set when the server ends the connection on purpose
+	// (admin disconnect). Guarded by clientsMutex.
+	CloseReason string
+
+	// Channels a WebSocket client subscribed to; nil means the user's own
+	// channel only, which is all an SSE stream receives. Guarded by
+	// clientsMutex.
+	Channels map[string]bool
+	// LastAckedID is the newest event id the client acknowledged.
+	LastAckedID uint64
+	// Types selects typed events; nil keeps the legacy merged frame.
+	// Guarded by clientsMutex.
+	Types *eventFilter
 }
 
 var clients = make(map[string]*Client)
 var clientsMutex = &sync.Mutex{}
+
+var connectionSeq atomic.Uint64
+
+// blockedConnections refuses new connections for a user ("<user>") or one
+// device ("<user>:<device>") until the given time. Guarded by clientsMutex.
+var blockedConnections = make(map[string]time.Time)
+
+var (
+	errConnectionsFull     = errors.New("too many connections")
+	errUserConnectionLimit = errors.New("too many connections for this user")
+	errConnectionBlocked   = errors.New("connections are blocked")
+)
+
+// newClientID keys one connection in the registry. Each connection gets its
+// own entry, so several tabs on one device, or SSE next to WebSocket, are
+// all delivered to.
+func newClientID(userID, deviceID, transport string) string {
+	return fmt.Sprintf("%s:%s:%s:%d", userID, deviceID, transport, connectionSeq.Add(1))
+}
+
+// admitClientLocked checks the connection caps (zero is unlimited) and admin
+// blocks before a connection registers. Callers hold clientsMutex.
+func admitClientLocked(userID, deviceID string, now time.Time) error {
+	for _, key := range []string{userID, userID + ":" + deviceID} {
+		until, ok := blockedConnections[key]
+		if !ok {
+			continue
+		}
+		if now.Before(until) {
+			sseRejectedConnectionsTotal.WithLabelValues("blocked").Inc()
+			return errConnectionBlocked
+		}
+		delete(blockedConnections, key)
+	}
+	if max := config.Events.MaxConnections; max > 0 && len(clients) >= max {
+		sseRejectedConnectionsTotal.WithLabelValues("global_limit").Inc()
+		return errConnectionsFull
+	}
+	perUser := 0
+	for _, c := range clients {
+		if c.UserID == userID {
+			perUser++
+		}
+	}
+	if max := config.Events.MaxConnectionsPerUser; max > 0 && perUser >= max {
+		sseRejectedConnectionsTotal.WithLabelValues("user_limit").Inc()
+		return errUserConnectionLimit
+	}
+	return nil
+}
+
+// admissionStatus maps an admitClientLocked error to the HTTP status sent
+// to the client.
+func admissionStatus(err error) int {
+	switch err {
+	case errConnectionBlocked:
+		return fiber.StatusForbidden
+	case errUserConnectionLimit:
+		return fiber.StatusTooManyRequests
+	default:
+		return fiber.StatusServiceUnavailable
+	}
+}
+
+// forceDisconnect closes the user's connections on this replica, or only
+// deviceID's when given, and returns how many it closed. With block > 0
+// reconnects are refused until it passes; block == 0 lifts an earlier one.
+func forceDisconnect(userID, deviceID, reason string, block time.Duration) int {
+	key := userID
+	if deviceID != "" {
+		key = userID + ":" + deviceID
+	}
+
+	var closing []*Client
+	clientsMutex.Lock()
+	for id, c := range clients {
+		if c.UserID != userID || (deviceID != "" && c.DeviceID != deviceID) {
+			continue
+		}
+		c.Connected = false
+		c.CloseReason = reason
+		delete(clients, id)
+		sseDisconnectionsTotal.Inc()
+		closing = append(closing, c)
+	}
+	if block > 0 {
+		blockedConnections[key] = time.Now().Add(block)
+	} else {
+		delete(blockedConnections, key)
+	}
+	updateRegistryGaugesLocked()
+	clientsMutex.Unlock()
+
+	// The writers notice the closed queue and end their streams.
+	for _, c := range closing {
+		c.Queue.close()
+	}
+	sseForcedDisconnectsTotal.Add(float64(len(closing)))
+	return len(closing)
+}
+
+// subscribedLocked reports whether the client receives channel. Callers hold
+// clientsMutex.
+func (c *Client) subscribedLocked(channel string) bool {
+	if c.Channels == nil {
+		return channel == userChannel(c.UserID)
+	}
+	return c.Channels[channel]
+}
+
+// registerClientLocked adds client to the registry. Callers hold clientsMutex.
+func registerClientLocked(clientID string, client *Client) {
+	client.ConnectedAt = time.Now()
+	clients[clientID] = client
+	sseConnectionsTotal.Inc()
+	updateRegistryGaugesLocked()
+}
+
+// updateRegistryGaugesLocked refreshes this replica's connection gauges.
+// Callers hold clientsMutex.
+func updateRegistryGaugesLocked() {
+	users := make(map[string]struct{}, len(clients))
+	for _, c := range clients {
+		users[c.UserID] = struct{}{}
+	}
+	sseConnectedClients.Set(float64(len(clients)))
+	sseConnectedUsers.Set(float64(len(users)))
+}
+
+// deliverLocked pushes each channel's payload, as event eventID, to every
+// connected client subscribed to it, except originDeviceID, which already
+// has the change. Clients with a type filter get typed events instead.
+// Callers hold clientsMutex.
+func deliverLocked(eventID uint64, payloads map[string][]byte, originDeviceID string) {
+	typed := make(map[string][]typedEvent)
+	now := time.Now()
+	for _, client := range clients {
+		if !client.Connected || client.DeviceID == originDeviceID {
+			continue
+		}
+		for channel, data := range payloads {
+			if !client.subscribedLocked(channel) {
+				continue
+			}
+			if client.Types == nil {
+				logPushResult(client, channel, client.Queue.push(sseFrame{ID: eventID, Channel: channel, Data: data}))
+				continue
+			}
+			events, ok := typed[channel]
+			if !ok {
+				events = typedEventsFromPayload(data, now)
+				typed[channel] = events
+			}
+			for _, f := range typedFrames(eventID, channel, events, client.Types) {
+				logPushResult(client, f.Name, client.Queue.push(f))
+			}
+		}
+	}
+}
+
+func logPushResult(client *Client, what string, result pushResult) {
+	switch result {
+	case pushQueued:
+		logrus.Infof("Queued %s update for user=%s device=%s", what, client.UserID, client.DeviceID)
+	case pushCoalesced:
+		logrus.Infof("Coalesced %s update for slow client user=%s device=%s", what, client.UserID, client.DeviceID)
+	case pushOverflowed:
+		logrus.Warnf("Client queue overflowed for user=%s device=%s (policy=%s)",
+			client.UserID, client.DeviceID, config.Events.OverflowPolicy)
+	}
+}
@@ -0,0 +1,190 @@
+// client_queue.go
+
+package main
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+const (
+	overflowPolicyResync     = "resync"
+	overflowPolicyDisconnect = "disconnect"
+)
+
+// sseFrame is one outbound message before it is rendered for SSE or
+// WebSocket. Channel is only rendered for WebSocket clients. Typed frames
+// are named events (pokemon.upserted, ...) about the entity in Key; other
+// named frames are control messages such as resync.
+type sseFrame struct {
+	ID      uint64
+	Name    string
+	Key     string
+	Typed   bool
+	Channel string
+	Data    []byte
+}
+
+type pushResult int
+
+const (
+	pushQueued pushResult = iota
+	pushCoalesced
+	pushOverflowed
+	pushClosed
+)
+
+// outboundQueue is a client's bounded send queue. The broadcaster never
+// blocks on it: once full, a new update is folded into the newest queued
+// one, and when that is not possible the overflow policy applies.
+type outboundQueue struct {
+	mu       sync.Mutex
+	items    []sseFrame
+	size     int
+	maxBytes int
+	policy   string
+
+	notify    chan struct{} // signalled when items are added
+	done      chan struct{} // closed when the queue is closed
+	closed    bool
+	closeOnce sync.Once
+}
+
+func newOutboundQueue(size, maxBytes int, policy string) *outboundQueue {
+	if size <= 0 {
+		size = 1
+	}
+	return &outboundQueue{
+		size:     size,
+		maxBytes: maxBytes,
+		policy:   policy,
+		notify:   make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+}
+
+func newClientQueue() *outboundQueue {
+	return newOutboundQueue(config.Events.QueueSize, config.Events.QueueMaxFrameBytes, config.Events.OverflowPolicy)
+}
+
+func (q *outboundQueue) push(f sseFrame) pushResult {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return pushClosed
+	}
+
+	if len(q.items) < q.size {
+		q.items = append(q.items, f)
+		sseQueuedFrames.Inc()
+		q.signal()
+		return pushQueued
+	}
+
+	tail := &q.items[len(q.items)-1]
+	if tail.Name == "" && f.Name == "" && tail.Channel == f.Channel {
+		if merged, err := coalescePayloads(tail.Data, f.Data); err == nil && (q.maxBytes <= 0 || len(merged) <= q.maxBytes) {
+			tail.Data = merged
+			tail.ID = f.ID
+			sseCoalescedFramesTotal.Inc()
+			return pushCoalesced
+		}
+	}
+	// A typed event supersedes a queued one of the same type for the same
+	// entity; the tail keeps the newest id either carried.
+	if f.Typed && tail.Typed && tail.Name == f.Name && tail.Key == f.Key && tail.Channel == f.Channel {
+		tail.Data = f.Data
+		if f.ID > tail.ID {
+			tail.ID = f.ID
+		}
+		sseCoalescedFramesTotal.Inc()
+		return pushCoalesced
+	}
+
+	// Overflow: the queued frames and this one are lost either way.
+	sseDroppedFramesTotal.WithLabelValues(q.policy).Add(float64(len(q.items) + 1))
+	sseQueuedFrames.Sub(float64(len(q.items)))
+	q.items = q.items[:0]
+	if q.policy == overflowPolicyDisconnect {
+		q.closeLocked()
+		return pushOverflowed
+	}
+	data, _ := json.Marshal(map[string]string{"reason": "queue_overflow"})
+	q.items = append(q.items, sseFrame{Name: "resync", Data: data})
+	sseQueuedFrames.Inc()
+	q.signal()
+	return pushOverflowed
+}
+
+func (q *outboundQueue) signal() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// depth is the number of frames waiting to be written.
+func (q *outboundQueue) depth() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+// drain takes everything queued so far.
+func (q *outboundQueue) drain() []sseFrame {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	out := q.items
+	q.items = nil
+	sseQueuedFrames.Sub(float64(len(out)))
+	return out
+}
+
+func (q *outboundQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closeLocked()
+}
+
+func (q *outboundQueue) closeLocked() {
+	q.closeOnce.Do(func() {
+		q.closed = true
+		sseQueuedFrames.Sub(float64(len(q.items)))
+		q.items = nil
+		close(q.done)
+	})
+}
+
+// coalescePayloads folds newer into older. Keyed sections (pokemon, trade,
+// relatedInstance, tags, ...) merge per key with newer winning, so only the
+// latest state of each instance or trade is sent.
+func coalescePayloads(older, newer []byte) ([]byte, error) {
+	var base, next map[string]json.RawMessage
+	if err := json.Unmarshal(older, &base); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(newer, &next); err != nil {
+		return nil, err
+	}
+	if base == nil {
+		base = map[string]json.RawMessage{}
+	}
+
+	for section, raw := range next {
+		var nextKeys map[string]json.RawMessage
+		var baseKeys map[string]json.RawMessage
+		if json.Unmarshal(raw, &nextKeys) != nil || json.Unmarshal(base[section], &baseKeys) != nil || baseKeys == nil {
+			base[section] = raw
+			continue
+		}
+		for k, v := range nextKeys {
+			baseKeys[k] = v
+		}
+		merged, err := json.Marshal(baseKeys)
+		if err != nil {
+			return nil, err
+		}
+		base[section] = merged
+	}
+	return json.Marshal(base)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestOutboundQueue_CoalescesWhenFull(t *testing.T) {
+	q := newOutboundQueue(1, 0, overflowPolicyResync)
+	if got := q.push(sseFrame{ID: 1, Data: []byte(`{"pokemon":{"a":{"cp":10},"b":{"cp":20}},"trade":{}}`)}); got != pushQueued {
+		t.Fatalf("expected first frame queued, got %v", got)
+	}
+	if got := q.push(sseFrame{ID: 2, Data: []byte(`{"pokemon":{"a":{"cp":99}},"trade":{"t-1":{"trade_status":"pending"}}}`)}); got != pushCoalesced {
+		t.Fatalf("expected second frame coalesced, got %v", got)
+	}
+
+	frames := q.drain()
+	if len(frames) != 1 || frames[0].ID != 2 {
+		t.Fatalf("expected one frame carrying the newest id, got %+v", frames)
+	}
+	var payload struct {
+		Pokemon map[string]map[string]int    `json:"pokemon"`
+		Trade   map[string]map[string]string `json:"trade"`
+	}
+	if err := json.Unmarshal(frames[0].Data, &payload); err != nil {
+		t.Fatalf("decode merged frame: %v", err)
+	}
+	if payload.Pokemon["a"]["cp"] != 99 || payload.Pokemon["b"]["cp"] != 20 {
+		t.Fatalf("expected latest state per instance, got %+v", payload.Pokemon)
+	}
+	if payload.Trade["t-1"]["trade_status"] != "pending" {
+		t.Fatalf("expected trade merged in, got %+v", payload.Trade)
+	}
+}
+
+func TestOutboundQueue_OverflowResync(t *testing.T) {
+	q := newOutboundQueue(1, 16, overflowPolicyResync)
+	q.push(sseFrame{ID: 1, Data: []byte(`{"pokemon":{"a":1}}`)})
+	if got := q.push(sseFrame{ID: 2, Data: []byte(`{"pokemon":{"b":2}}`)}); got != pushOverflowed {
+		t.Fatalf("expected overflow once the merged frame is too large, got %v", got)
+	}
+	frames := q.drain()
+	if len(frames) != 1 || frames[0].Name != "resync" {
+		t.Fatalf("expected a lone resync frame, got %+v", frames)
+	}
+}
+
+func TestOutboundQueue_OverflowDisconnect(t *testing.T) {
+	q := newOutboundQueue(1, 0, overflowPolicyDisconnect)
+	q.push(sseFrame{Name: "resync", Data: []byte(`{}`)})
+	if got := q.push(sseFrame{ID: 2, Data: []byte(`{}`)}); got != pushOverflowed {
+		t.Fatalf("expected overflow, got %v", got)
+	}
+	select {
+	case <-q.done:
+	default:
+		t.Fatalf("expected disconnect policy to close the queue")
+	}
+}
+
+func TestOutboundQueue_CoalescesTypedEventsPerEntity(t *testing.T) {
+	q := newOutboundQueue(1, 0, overflowPolicyResync)
+	q.push(sseFrame{ID: 1, Name: eventTradeAccepted, Key: "t-1", Typed: true, Data: []byte(`{"n":1}`)})
+
+	if got := q.push(sseFrame{Name: eventTradeAccepted, Key: "t-1", Typed: true, Data: []byte(`{"n":2}`)}); got != pushCoalesced {
+		t.Fatalf("expected same-entity event to coalesce, got %v", got)
+	}
+	frames := q.drain()
+	if len(frames) != 1 || string(frames[0].Data) != `{"n":2}` || frames[0].ID != 1 {
+		t.Fatalf("expected newest data with the id kept, got %+v", frames)
+	}
+
+	q.push(sseFrame{Name: eventTradeAccepted, Key: "t-1", Typed: true, Data: []byte(`{}`)})
+	if got := q.push(sseFrame{Name: eventTradeAccepted, Key: "t-2", Typed: true, Data: []byte(`{}`)}); got != pushOverflowed {
+		t.Fatalf("expected a different entity to overflow, got %v", got)
+	}
+}
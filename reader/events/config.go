@@ -22,6 +22,57 @@ type EventsConfig struct {
 	Topic         string `yaml:"topic"`
 	MaxRetries    int    `yaml:"max_retries"`
 	RetryInterval int    `yaml:"retry_interval"` // seconds
+	StorageTopic  string `yaml:"storage_topic"`  // storage-originated updates
+
+	// Replica fan-out. In "per_instance" mode every replica joins its own
+	// consumer group (ConsumerGroup-InstanceID) and sees every message;
+	// "shared" keeps one group and is only correct with a single replica.
+	ConsumerGroup string `yaml:"consumer_group"`
+	FanoutMode    string `yaml:"fanout_mode"`
+	InstanceID    string `yaml:"instance_id"`
+
+	// SSE replay buffer for clients reconnecting with Last-Event-ID.
+	ReplayBufferSize    int    `yaml:"replay_buffer_size"`     // events kept per user
+	ReplayMaxAgeSeconds int    `yaml:"replay_max_age_seconds"` // older events are dropped
+	ReplaySnapshotPath  string `yaml:"replay_snapshot_path"`   // optional; saved on shutdown
+
+	// Connection caps, per replica and counting SSE and WebSocket alike.
+	MaxConnections        int `yaml:"max_connections"`
+	MaxConnectionsPerUser int `yaml:"max_connections_per_user"` // tabs x devices
+
+	// Users allowed to call /api/admin/*.
+	AdminUserIDs []string `yaml:"admin_user_ids"`
+
+	// Per-client outbound queue.
+	QueueSize          int    `yaml:"queue_size"`            // frames before coalescing starts
+	QueueMaxFrameBytes int    `yaml:"queue_max_frame_bytes"` // cap on a coalesced frame
+	OverflowPolicy     string `yaml:"overflow_policy"`       // resync or disconnect
+
+	// WebSocket transport (/api/ws).
+	WSMaxMessageBytes int  `yaml:"ws_max_message_bytes"` // largest inbound message
+	WSPublishEnabled  bool `yaml:"ws_publish_enabled"`   // accept batchedUpdates over the socket
+
+	// Web Push for devices without a live connection. Keys are base64url;
+	// the private key is best left to WEB_PUSH_VAPID_PRIVATE_KEY.
+	PushEnabled     bool   `yaml:"push_enabled"`
+	VAPIDPublicKey  string `yaml:"vapid_public_key"`
+	VAPIDPrivateKey string `yaml:"vapid_private_key"`
+	VAPIDSubject    string `yaml:"vapid_subject"`     // mailto: or https: contact for push services
+	PushTTLSeconds  int    `yaml:"push_ttl_seconds"`  // how long push services hold a message
+	PushMaxAttempts int    `yaml:"push_max_attempts"` // per device, for 429/5xx/network errors
+	// Push services subscription endpoints may point at; a host also
+	// allows its subdomains. Defaults to defaultPushEndpointHosts.
+	PushEndpointHosts []string `yaml:"push_endpoint_hosts"`
+}
+
+// defaultPushEndpointHosts are the push services of Chrome, Firefox, Edge
+// and Safari.
+var defaultPushEndpointHosts = []string{
+	"fcm.googleapis.com",
+	"android.googleapis.com",
+	"updates.push.services.mozilla.com",
+	"notify.windows.com",
+	"push.apple.com",
 }
 
 var config Config
@@ -62,6 +113,43 @@ func applyConfigDefaultsAndEnv() {
 	if config.Events.RetryInterval <= 0 {
 		config.Events.RetryInterval = 3
 	}
+	if config.Events.StorageTopic == "" {
+		config.Events.StorageTopic = "storageUpdates"
+	}
+	if config.Events.ConsumerGroup == "" {
+		config.Events.ConsumerGroup = "sse_consumer_group"
+	}
+	if config.Events.ReplayBufferSize <= 0 {
+		config.Events.ReplayBufferSize = 200
+	}
+	if config.Events.ReplayMaxAgeSeconds <= 0 {
+		config.Events.ReplayMaxAgeSeconds = 900
+	}
+	if config.Events.MaxConnections <= 0 {
+		config.Events.MaxConnections = 10000
+	}
+	if config.Events.MaxConnectionsPerUser <= 0 {
+		config.Events.MaxConnectionsPerUser = 20
+	}
+	if config.Events.QueueSize <= 0 {
+		config.Events.QueueSize = 64
+	}
+	if config.Events.QueueMaxFrameBytes <= 0 {
+		config.Events.QueueMaxFrameBytes = 1 << 20
+	}
+
+	if config.Events.WSMaxMessageBytes <= 0 {
+		config.Events.WSMaxMessageBytes = 4 << 20
+	}
+	if config.Events.PushTTLSeconds <= 0 {
+		config.Events.PushTTLSeconds = 86400
+	}
+	if config.Events.PushMaxAttempts <= 0 {
+		config.Events.PushMaxAttempts = 3
+	}
+	if len(config.Events.PushEndpointHosts) == 0 {
+		config.Events.PushEndpointHosts = defaultPushEndpointHosts
+	}
 
 	if v := strings.TrimSpace(os.Getenv("KAFKA_HOSTNAME")); v != "" {
 		config.Events.Hostname = v
@@ -75,6 +163,9 @@ func applyConfigDefaultsAndEnv() {
 	if v := strings.TrimSpace(os.Getenv("KAFKA_TOPIC")); v != "" {
 		config.Events.Topic = v
 	}
+	if v := strings.TrimSpace(os.Getenv("KAFKA_STORAGE_TOPIC")); v != "" {
+		config.Events.StorageTopic = v
+	}
 	if v := strings.TrimSpace(os.Getenv("KAFKA_MAX_RETRIES")); v != "" {
 		if n, err := strconv.Atoi(v); err == nil && n > 0 {
 			config.Events.MaxRetries = n
@@ -85,4 +176,109 @@ func applyConfigDefaultsAndEnv() {
 			config.Events.RetryInterval = n
 		}
 	}
+	if v := strings.TrimSpace(os.Getenv("KAFKA_CONSUMER_GROUP")); v != "" {
+		config.Events.ConsumerGroup = v
+	}
+	if v := strings.ToLower(strings.TrimSpace(os.Getenv("EVENTS_FANOUT_MODE"))); v != "" {
+		config.Events.FanoutMode = v
+	}
+	if config.Events.FanoutMode != fanoutModeShared {
+		config.Events.FanoutMode = fanoutModePerInstance
+	}
+	if v := strings.TrimSpace(os.Getenv("EVENTS_INSTANCE_ID")); v != "" {
+		config.Events.InstanceID = v
+	}
+	if config.Events.InstanceID == "" {
+		config.Events.InstanceID = defaultInstanceID()
+	}
+	config.Events.InstanceID = sanitizeInstanceID(config.Events.InstanceID)
+	if v := strings.TrimSpace(os.Getenv("SSE_REPLAY_BUFFER_SIZE")); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			config.Events.ReplayBufferSize = n
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv("SSE_REPLAY_MAX_AGE_SEC")); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			config.Events.ReplayMaxAgeSeconds = n
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv("SSE_REPLAY_SNAPSHOT_PATH")); v != "" {
+		config.Events.ReplaySnapshotPath = v
+	}
+	if v := strings.TrimSpace(os.Getenv("SSE_MAX_CONNECTIONS")); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			config.Events.MaxConnections = n
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv("SSE_MAX_CONNECTIONS_PER_USER")); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			config.Events.MaxConnectionsPerUser = n
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv("EVENTS_ADMIN_USER_IDS")); v != "" {
+		config.Events.AdminUserIDs = nil
+		for _, id := range strings.Split(v, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				config.Events.AdminUserIDs = append(config.Events.AdminUserIDs, id)
+			}
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv("SSE_QUEUE_SIZE")); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			config.Events.QueueSize = n
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv("SSE_QUEUE_MAX_FRAME_BYTES")); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			config.Events.QueueMaxFrameBytes = n
+		}
+	}
+	if v := strings.ToLower(strings.TrimSpace(os.Getenv("SSE_OVERFLOW_POLICY"))); v != "" {
+		config.Events.OverflowPolicy = v
+	}
+	if config.Events.OverflowPolicy != overflowPolicyDisconnect {
+		config.Events.OverflowPolicy = overflowPolicyResync
+	}
+	if v := strings.TrimSpace(os.Getenv("WS_MAX_MESSAGE_BYTES")); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			config.Events.WSMaxMessageBytes = n
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv("WS_PUBLISH_ENABLED")); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			config.Events.WSPublishEnabled = b
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv("WEB_PUSH_ENABLED")); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			config.Events.PushEnabled = b
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv("WEB_PUSH_VAPID_PUBLIC_KEY")); v != "" {
+		config.Events.VAPIDPublicKey = v
+	}
+	if v := strings.TrimSpace(os.Getenv("WEB_PUSH_VAPID_PRIVATE_KEY")); v != "" {
+		config.Events.VAPIDPrivateKey = v
+	}
+	if v := strings.TrimSpace(os.Getenv("WEB_PUSH_VAPID_SUBJECT")); v != "" {
+		config.Events.VAPIDSubject = v
+	}
+	if v := strings.TrimSpace(os.Getenv("WEB_PUSH_TTL_SEC")); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			config.Events.PushTTLSeconds = n
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv("WEB_PUSH_MAX_ATTEMPTS")); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			config.Events.PushMaxAttempts = n
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv("WEB_PUSH_ENDPOINT_HOSTS")); v != "" {
+		config.Events.PushEndpointHosts = nil
+		for _, host := range strings.Split(v, ",") {
+			if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+				config.Events.PushEndpointHosts = append(config.Events.PushEndpointHosts, host)
+			}
+		}
+	}
 }
@@ -9,7 +9,25 @@ func clearKafkaEnv(t *testing.T) {
 	t.Setenv("KAFKA_TOPIC", "")
 	t.Setenv("KAFKA_MAX_RETRIES", "")
 	t.Setenv("KAFKA_RETRY_INTERVAL", "")
+	t.Setenv("KAFKA_STORAGE_TOPIC", "")
 	t.Setenv("HOST_IP", "")
+	t.Setenv("SSE_REPLAY_BUFFER_SIZE", "")
+	t.Setenv("SSE_REPLAY_MAX_AGE_SEC", "")
+	t.Setenv("SSE_REPLAY_SNAPSHOT_PATH", "")
+	t.Setenv("SSE_QUEUE_SIZE", "")
+	t.Setenv("SSE_QUEUE_MAX_FRAME_BYTES", "")
+	t.Setenv("SSE_OVERFLOW_POLICY", "")
+	t.Setenv("KAFKA_CONSUMER_GROUP", "")
+	t.Setenv("EVENTS_FANOUT_MODE", "")
+	t.Setenv("EVENTS_INSTANCE_ID", "")
+	t.Setenv("WS_MAX_MESSAGE_BYTES", "")
+	t.Setenv("WS_PUBLISH_ENABLED", "")
+	t.Setenv("SSE_MAX_CONNECTIONS", "")
+	t.Setenv("SSE_MAX_CONNECTIONS_PER_USER", "")
+	t.Setenv("EVENTS_ADMIN_USER_IDS", "")
+	t.Setenv("WEB_PUSH_ENABLED", "")
+	t.Setenv("WEB_PUSH_TTL_SEC", "")
+	t.Setenv("WEB_PUSH_MAX_ATTEMPTS", "")
 }
 
 func TestApplyConfigDefaultsAndEnv_Defaults(t *testing.T) {
@@ -33,6 +51,30 @@ func TestApplyConfigDefaultsAndEnv_Defaults(t *testing.T) {
 	if config.Events.RetryInterval != 3 {
 		t.Fatalf("expected retry interval 3, got %d", config.Events.RetryInterval)
 	}
+	if config.Events.StorageTopic != "storageUpdates" {
+		t.Fatalf("expected storage topic storageUpdates, got %q", config.Events.StorageTopic)
+	}
+	if config.Events.ReplayBufferSize != 200 || config.Events.ReplayMaxAgeSeconds != 900 {
+		t.Fatalf("unexpected replay defaults: %+v", config.Events)
+	}
+	if config.Events.ReplaySnapshotPath != "" {
+		t.Fatalf("expected replay persistence off by default, got %q", config.Events.ReplaySnapshotPath)
+	}
+	if config.Events.QueueSize != 64 || config.Events.QueueMaxFrameBytes != 1<<20 || config.Events.OverflowPolicy != "resync" {
+		t.Fatalf("unexpected queue defaults: %+v", config.Events)
+	}
+	if config.Events.FanoutMode != "per_instance" || config.Events.InstanceID == "" {
+		t.Fatalf("expected per-instance fan-out with a derived id, got %+v", config.Events)
+	}
+	if config.Events.WSMaxMessageBytes != 4<<20 || config.Events.WSPublishEnabled {
+		t.Fatalf("unexpected WebSocket defaults: %+v", config.Events)
+	}
+	if config.Events.PushEnabled || config.Events.PushTTLSeconds != 86400 || config.Events.PushMaxAttempts != 3 {
+		t.Fatalf("unexpected Web Push defaults: %+v", config.Events)
+	}
+	if config.Events.MaxConnections != 10000 || config.Events.MaxConnectionsPerUser != 20 || len(config.Events.AdminUserIDs) != 0 {
+		t.Fatalf("unexpected connection limit defaults: %+v", config.Events)
+	}
 }
 
 func TestApplyConfigDefaultsAndEnv_Overrides(t *testing.T) {
@@ -42,6 +84,15 @@ func TestApplyConfigDefaultsAndEnv_Overrides(t *testing.T) {
 	t.Setenv("KAFKA_TOPIC", "updates")
 	t.Setenv("KAFKA_MAX_RETRIES", "9")
 	t.Setenv("KAFKA_RETRY_INTERVAL", "7")
+	t.Setenv("SSE_REPLAY_BUFFER_SIZE", "50")
+	t.Setenv("SSE_REPLAY_MAX_AGE_SEC", "60")
+	t.Setenv("SSE_REPLAY_SNAPSHOT_PATH", "/data/replay.json")
+	t.Setenv("SSE_QUEUE_SIZE", "8")
+	t.Setenv("SSE_OVERFLOW_POLICY", "Disconnect")
+	t.Setenv("EVENTS_INSTANCE_ID", "events@1")
+	t.Setenv("WS_PUBLISH_ENABLED", "true")
+	t.Setenv("SSE_MAX_CONNECTIONS_PER_USER", "5")
+	t.Setenv("EVENTS_ADMIN_USER_IDS", " admin-1, ,admin-2")
 
 	config = Config{}
 	applyConfigDefaultsAndEnv()
@@ -61,6 +112,39 @@ func TestApplyConfigDefaultsAndEnv_Overrides(t *testing.T) {
 	if config.Events.RetryInterval != 7 {
 		t.Fatalf("expected retry interval 7, got %d", config.Events.RetryInterval)
 	}
+	if config.Events.ReplayBufferSize != 50 || config.Events.ReplayMaxAgeSeconds != 60 {
+		t.Fatalf("unexpected replay overrides: %+v", config.Events)
+	}
+	if config.Events.ReplaySnapshotPath != "/data/replay.json" {
+		t.Fatalf("expected replay snapshot path override, got %q", config.Events.ReplaySnapshotPath)
+	}
+	if config.Events.QueueSize != 8 || config.Events.OverflowPolicy != "disconnect" {
+		t.Fatalf("unexpected queue overrides: %+v", config.Events)
+	}
+	if !config.Events.WSPublishEnabled {
+		t.Fatalf("expected WS publishing to be enabled")
+	}
+	if config.Events.MaxConnectionsPerUser != 5 || len(config.Events.AdminUserIDs) != 2 || config.Events.AdminUserIDs[1] != "admin-2" {
+		t.Fatalf("unexpected connection limit overrides: %+v", config.Events)
+	}
+	if config.Events.InstanceID != "events_1" {
+		t.Fatalf("expected sanitized instance id, got %q", config.Events.InstanceID)
+	}
+	if got := consumerGroupID(); got != "sse_consumer_group-events_1" {
+		t.Fatalf("unexpected per-instance group %q", got)
+	}
+}
+
+func TestConsumerGroupID_SharedMode(t *testing.T) {
+	clearKafkaEnv(t)
+	t.Setenv("EVENTS_FANOUT_MODE", "shared")
+
+	config = Config{}
+	applyConfigDefaultsAndEnv()
+
+	if got := consumerGroupID(); got != "sse_consumer_group" {
+		t.Fatalf("expected the shared legacy group, got %q", got)
+	}
 }
 
 func TestApplyConfigDefaultsAndEnv_HostIPFallback(t *testing.T) {
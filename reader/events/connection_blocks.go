@@ -0,0 +1,138 @@
+// connection_blocks.go
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Admin disconnects reach every replica through connection_blocks: the
+// replica serving the request inserts a row, and every replica applies the
+// rows it has not seen on its next poll. A row with blocked_until in the
+// future also refuses reconnects until then; one without lifts an earlier
+// block. A replica that starts later replays the rows that may still block.
+
+const (
+	connectionBlockPollInterval = 2 * time.Second
+	// connectionBlockLookback is how far back a poll reads, so a row that
+	// commits after a newer one is still seen.
+	connectionBlockLookback = time.Minute
+)
+
+const createConnectionBlocksTableSQL = `
+CREATE TABLE IF NOT EXISTS connection_blocks (
+  id            BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  user_id       VARCHAR(255) NOT NULL,
+  device_id     VARCHAR(255) NOT NULL DEFAULT '',
+  blocked_until DATETIME(6) NULL,
+  created_by    VARCHAR(255) NOT NULL,
+  created_at    DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  KEY idx_connection_blocks_created (created_at)
+)`
+
+// connectionBlock is one admin disconnect. An empty DeviceID covers all of
+// the user's devices.
+type connectionBlock struct {
+	ID           uint64     `gorm:"column:id;primaryKey"`
+	UserID       string     `gorm:"column:user_id"`
+	DeviceID     string     `gorm:"column:device_id"`
+	BlockedUntil *time.Time `gorm:"column:blocked_until"`
+	CreatedBy    string     `gorm:"column:created_by"`
+	CreatedAt    time.Time  `gorm:"column:created_at"`
+}
+
+func (connectionBlock) TableName() string {
+	return "connection_blocks"
+}
+
+// appliedConnectionBlocks holds the ids of rows already applied here, with
+// their creation time so they can be forgotten once out of the lookback.
+var (
+	appliedConnectionBlocks = make(map[uint64]time.Time)
+	connectionBlocksMutex   = &sync.Mutex{}
+)
+
+// initConnectionBlocks prepares the table, replays the blocks still in force
+// and starts polling for new rows.
+func initConnectionBlocks() {
+	if err := db.Exec(createConnectionBlocksTableSQL).Error; err != nil {
+		logrus.Fatalf("Failed to prepare connection_blocks schema: %v", err)
+	}
+	if err := pollConnectionBlocks(time.Now(), maxDisconnectBlock); err != nil {
+		logrus.Warnf("Failed to load connection blocks: %v", err)
+	}
+	go runConnectionBlocks()
+}
+
+func runConnectionBlocks() {
+	poll := time.NewTicker(connectionBlockPollInterval)
+	prune := time.NewTicker(time.Hour)
+	defer poll.Stop()
+	defer prune.Stop()
+	for {
+		select {
+		case now := <-poll.C:
+			if err := pollConnectionBlocks(now, connectionBlockLookback); err != nil {
+				logrus.Warnf("Failed to poll connection blocks: %v", err)
+			}
+		case now := <-prune.C:
+			// No block outlasts maxDisconnectBlock.
+			if err := db.Exec("DELETE FROM connection_blocks WHERE created_at < ?", now.Add(-maxDisconnectBlock).UTC()).Error; err != nil {
+				logrus.Warnf("Failed to prune connection blocks: %v", err)
+			}
+		}
+	}
+}
+
+// recordConnectionBlock stores an admin disconnect for every replica and
+// applies it here right away, returning how many local connections it
+// closed.
+func recordConnectionBlock(block *connectionBlock) (int, error) {
+	if err := db.Create(block).Error; err != nil {
+		return 0, err
+	}
+	connectionBlocksMutex.Lock()
+	defer connectionBlocksMutex.Unlock()
+	appliedConnectionBlocks[block.ID] = block.CreatedAt
+	return applyConnectionBlock(*block, time.Now()), nil
+}
+
+// pollConnectionBlocks applies, in insertion order, the rows created within
+// lookback that this replica has not applied yet.
+func pollConnectionBlocks(now time.Time, lookback time.Duration) error {
+	var rows []connectionBlock
+	if err := db.Where("created_at >= ?", now.Add(-lookback).UTC()).Order("id").Find(&rows).Error; err != nil {
+		return err
+	}
+
+	connectionBlocksMutex.Lock()
+	defer connectionBlocksMutex.Unlock()
+	for _, row := range rows {
+		if _, ok := appliedConnectionBlocks[row.ID]; ok {
+			continue
+		}
+		appliedConnectionBlocks[row.ID] = row.CreatedAt
+		if closed := applyConnectionBlock(row, now); closed > 0 {
+			logrus.Infof("Applied admin disconnect %d by %s: user=%s device=%s (%d connections)", row.ID, row.CreatedBy, row.UserID, row.DeviceID, closed)
+		}
+	}
+	for id, created := range appliedConnectionBlocks {
+		if created.Before(now.Add(-connectionBlockLookback)) {
+			delete(appliedConnectionBlocks, id)
+		}
+	}
+	return nil
+}
+
+// applyConnectionBlock closes the row's connections on this replica and sets
+// or lifts its block. A block that has already run out lifts.
+func applyConnectionBlock(row connectionBlock, now time.Time) int {
+	var block time.Duration
+	if row.BlockedUntil != nil {
+		block = row.BlockedUntil.Sub(now)
+	}
+	return forceDisconnect(row.UserID, row.DeviceID, "disconnected by admin", block)
+}
@@ -0,0 +1,49 @@
+// instance.go
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	fanoutModePerInstance = "per_instance"
+	fanoutModeShared      = "shared"
+)
+
+// defaultInstanceID names this replica: the container hostname when there is
+// one, otherwise the pid.
+func defaultInstanceID() string {
+	if h := strings.TrimSpace(os.Getenv("HOSTNAME")); h != "" {
+		return h
+	}
+	if h, err := os.Hostname(); err == nil && h != "" {
+		return h
+	}
+	return fmt.Sprintf("pid%d", os.Getpid())
+}
+
+// sanitizeInstanceID keeps the id safe inside consumer group names and SSE
+// event ids.
+func sanitizeInstanceID(id string) string {
+	var b strings.Builder
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	return b.String()
+}
+
+// consumerGroupID is the Kafka group this replica reads broadcasts with.
+func consumerGroupID() string {
+	if config.Events.FanoutMode == fanoutModeShared {
+		return config.Events.ConsumerGroup
+	}
+	return config.Events.ConsumerGroup + "-" + config.Events.InstanceID
+}
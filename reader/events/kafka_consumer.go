@@ -16,17 +16,32 @@ import (
 )
 
 func startKafkaConsumer() {
+	startTopicConsumer(config.Events.Topic)
+	// Storage publishes scheduler-driven changes (expiry, reminders) on its
+	// own topic using the same envelope as batchedUpdates.
+	if config.Events.StorageTopic != "" && config.Events.StorageTopic != config.Events.Topic {
+		startTopicConsumer(config.Events.StorageTopic)
+	}
+}
+
+func startTopicConsumer(topic string) {
 	// Build the Kafka broker address
 	kafkaAddress := fmt.Sprintf("%s:%s", config.Events.Hostname, config.Events.Port)
 
-	r := kafka.NewReader(kafka.ReaderConfig{
+	readerConfig := kafka.ReaderConfig{
 		Brokers:        []string{kafkaAddress},
-		Topic:          config.Events.Topic,
-		GroupID:        "sse_consumer_group", // Use a unique GroupID
-		MinBytes:       10e3,                 // 10KB
-		MaxBytes:       10e6,                 // 10MB
-		CommitInterval: 0,                    // Disable auto-commit
-	})
+		Topic:          topic,
+		GroupID:        consumerGroupID(),
+		MinBytes:       10e3, // 10KB
+		MaxBytes:       10e6, // 10MB
+		CommitInterval: 0,    // Disable auto-commit
+	}
+	if config.Events.FanoutMode == fanoutModePerInstance {
+		// A new replica has no clients to catch up; start from new messages.
+		readerConfig.StartOffset = kafka.LastOffset
+	}
+	r := kafka.NewReader(readerConfig)
+	logrus.Infof("Consuming %s as group %s (fanout=%s)", topic, readerConfig.GroupID, config.Events.FanoutMode)
 
 	go func() {
 		retryCount := 0
@@ -150,6 +165,55 @@ func startKafkaConsumer() {
 			}
 			transformed["trade"] = tradeMap
 
+			// Reminders come from storage's expiry scheduler and carry no
+			// state change, only a heads-up for both sides of the trade.
+			if reminders := collectTradeReminders(data); len(reminders) > 0 {
+				for _, raw := range reminders {
+					reminder := raw.(map[string]interface{})
+					for _, key := range []string{"username_proposed", "username_accepting"} {
+						name, _ := reminder[key].(string)
+						if name == "" {
+							continue
+						}
+						if id, err := getUserIDByUsername(name); err == nil {
+							affectedTradeUserIDs[id] = true
+						} else {
+							logrus.Errorf("Failed to get userID for reminder username %s: %v", name, err)
+						}
+					}
+				}
+				transformed["tradeReminders"] = reminders
+			}
+
+			// Profile changes the sender's other devices should know about;
+			// typed clients see them as profile.updated.
+			if location, ok := data["location"].(map[string]interface{}); ok && len(location) > 0 && username != "" {
+				transformed["profile"] = map[string]interface{}{
+					username: map[string]interface{}{"changed": []string{"location"}},
+				}
+			}
+
+			// Tag changes only concern the sender's other devices.
+			if tags := collectTagUpdates(data); len(tags) > 0 {
+				transformed["tags"] = tags
+			}
+
+			// Storage addresses each new inbox notification to its
+			// recipient as the message's user.
+			if notifications := collectNotifications(data); len(notifications) > 0 {
+				transformed["notifications"] = notifications
+			}
+
+			// Chat messages and read receipts go to both sides of the
+			// trade.
+			chatUserIDs := make(map[string]bool)
+			if messages := collectTradeMessages(data, chatUserIDs); len(messages) > 0 {
+				transformed["tradeMessages"] = messages
+			}
+			if reads := collectTradeMessageReads(data, chatUserIDs); len(reads) > 0 {
+				transformed["tradeMessageReads"] = reads
+			}
+
 			// --------------------------------------------------
 			// 2a) (Optional) Fetch relatedInstance for reference
 			// --------------------------------------------------
@@ -160,37 +224,21 @@ func startKafkaConsumer() {
 					continue
 				}
 
-				// Attempt to fetch instance for user_accepting
-				if pidAccepting, ok := td["pokemon_instance_id_user_accepting"].(string); ok && pidAccepting != "" {
+				// Attempt to fetch every instance on both sides of the trade
+				proposedIDs, acceptingIDs := tradeDataSides(td)
+				for _, pid := range append(acceptingIDs, proposedIDs...) {
 					var instance PokemonInstance
-					if err := db.Where("instance_id = ?", pidAccepting).First(&instance).Error; err != nil {
-						logrus.Errorf("Failed to fetch instance %s: %v", pidAccepting, err)
-					} else {
-						var instanceMap map[string]interface{}
-						if b, err := json.Marshal(instance); err != nil {
-							logrus.Errorf("Error marshalling instance %s: %v", pidAccepting, err)
-						} else if err := json.Unmarshal(b, &instanceMap); err != nil {
-							logrus.Errorf("Error unmarshalling instance %s: %v", pidAccepting, err)
-						} else {
-							relatedInstance[pidAccepting] = instanceMap
-						}
+					if err := db.Where("instance_id = ?", pid).First(&instance).Error; err != nil {
+						logrus.Errorf("Failed to fetch instance %s: %v", pid, err)
+						continue
 					}
-				}
-
-				// Attempt to fetch instance for user_proposed
-				if pidProposed, ok := td["pokemon_instance_id_user_proposed"].(string); ok && pidProposed != "" {
-					var instance PokemonInstance
-					if err := db.Where("instance_id = ?", pidProposed).First(&instance).Error; err != nil {
-						logrus.Errorf("Failed to fetch instance %s: %v", pidProposed, err)
+					var instanceMap map[string]interface{}
+					if b, err := json.Marshal(instance); err != nil {
+						logrus.Errorf("Error marshalling instance %s: %v", pid, err)
+					} else if err := json.Unmarshal(b, &instanceMap); err != nil {
+						logrus.Errorf("Error unmarshalling instance %s: %v", pid, err)
 					} else {
-						var instanceMap map[string]interface{}
-						if b, err := json.Marshal(instance); err != nil {
-							logrus.Errorf("Error marshalling instance %s: %v", pidProposed, err)
-						} else if err := json.Unmarshal(b, &instanceMap); err != nil {
-							logrus.Errorf("Error unmarshalling instance %s: %v", pidProposed, err)
-						} else {
-							relatedInstance[pidProposed] = instanceMap
-						}
+						relatedInstance[pid] = instanceMap
 					}
 				}
 			}
@@ -208,6 +256,9 @@ func startKafkaConsumer() {
 			for uid := range affectedTradeUserIDs {
 				broadcastUserIDs[uid] = true
 			}
+			for uid := range chatUserIDs {
+				broadcastUserIDs[uid] = true
+			}
 
 			// ---------------------------------------------------
 			// 4) Marshal the final data we want to send via SSE
@@ -219,22 +270,23 @@ func startKafkaConsumer() {
 			}
 
 			// -------------------------------------------------------------------
-			// 5) Broadcast to all userIDs in "broadcastUserIDs"
-			//    except the same deviceID that triggered the update
+			// 5) Broadcast to every subscriber of the affected channels (the
+			//    users in "broadcastUserIDs", plus WebSocket trade/trainer
+			//    subscribers) except the same deviceID that triggered the update
 			// -------------------------------------------------------------------
+			payloads := channelPayloads(messageBytes, broadcastUserIDs, tradeMap, relatedInstance, username, pokemonMap)
 			clientsMutex.Lock()
-			for _, client := range clients {
-				if broadcastUserIDs[client.UserID] && client.DeviceID != deviceID && client.Connected {
-					select {
-					case client.Channel <- messageBytes:
-						logrus.Infof("Sent update to user=%s device=%s", client.UserID, client.DeviceID)
-					default:
-						logrus.Warnf("Client channel full for user=%s device=%s", client.UserID, client.DeviceID)
-					}
-				}
+			eventID := nextEventID()
+			now := time.Now()
+			for uid := range broadcastUserIDs {
+				replay.add(uid, replayEntry{ID: eventID, OriginDeviceID: deviceID, Data: messageBytes, At: now})
 			}
+			deliverLocked(eventID, payloads, deviceID)
 			clientsMutex.Unlock()
 
+			// Devices without a live connection hear about trades by Web Push.
+			enqueueTradePushes(data, tradeMap, username)
+
 			// Manually commit the message after successful processing
 			if err := r.CommitMessages(context.Background(), m); err != nil {
 				logrus.Errorf("Failed to commit message: %v", err)
@@ -243,18 +295,165 @@ func startKafkaConsumer() {
 	}()
 }
 
+// collectTradeReminders indexes the optional "tradeReminders" list by trade_id.
+func collectTradeReminders(data map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{})
+	items, ok := data["tradeReminders"].([]interface{})
+	if !ok {
+		return out
+	}
+	for _, raw := range items {
+		item, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if tradeID, ok := item["trade_id"].(string); ok && tradeID != "" {
+			out[tradeID] = item
+		}
+	}
+	return out
+}
+
+// collectTagUpdates indexes tagUpdates by tag_id, keeping the operation so
+// clients can tell deletes from edits.
+func collectTagUpdates(data map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{})
+	items, ok := data["tagUpdates"].([]interface{})
+	if !ok {
+		return out
+	}
+	for _, raw := range items {
+		item, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		op, _ := item["operation"].(string)
+		tagData, _ := item["tagData"].(map[string]interface{})
+		tagID, _ := item["key"].(string)
+		if id, ok := tagData["tag_id"].(string); ok && id != "" {
+			tagID = id
+		}
+		if tagID == "" || op == "" {
+			continue
+		}
+		out[tagID] = map[string]interface{}{"operation": op, "tagData": tagData}
+	}
+	return out
+}
+
+// collectNotifications indexes the optional "notifications" list by
+// notification_id.
+func collectNotifications(data map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{})
+	items, ok := data["notifications"].([]interface{})
+	if !ok {
+		return out
+	}
+	for _, raw := range items {
+		item, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if id, ok := item["notification_id"].(string); ok && id != "" {
+			out[id] = item
+		}
+	}
+	return out
+}
+
+// collectTradeMessages indexes storage's "tradeMessages" by message id and
+// adds both sides of each message to userIDs. Client batches carry their
+// unsent messages under the same key, so only storage's are forwarded.
+func collectTradeMessages(data map[string]interface{}, userIDs map[string]bool) map[string]interface{} {
+	return collectChatItems(data, "tradeMessages", "message_id", []string{"sender_user_id", "recipient_user_id"}, userIDs)
+}
+
+// collectTradeMessageReads indexes storage's "tradeMessageReads" by trade
+// id and adds the reader and the sender to userIDs.
+func collectTradeMessageReads(data map[string]interface{}, userIDs map[string]bool) map[string]interface{} {
+	return collectChatItems(data, "tradeMessageReads", "trade_id", []string{"reader_user_id", "sender_user_id"}, userIDs)
+}
+
+// collectChatItems indexes data[section] by idKey. The routing user ids are
+// taken out of each item so they never reach clients.
+func collectChatItems(data map[string]interface{}, section, idKey string, routeKeys []string, userIDs map[string]bool) map[string]interface{} {
+	out := make(map[string]interface{})
+	if source, _ := data["source"].(string); source != "storage" {
+		return out
+	}
+	items, ok := data[section].([]interface{})
+	if !ok {
+		return out
+	}
+	for _, raw := range items {
+		item, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		id, ok := item[idKey].(string)
+		if !ok || id == "" {
+			continue
+		}
+		clean := make(map[string]interface{}, len(item))
+		for k, v := range item {
+			clean[k] = v
+		}
+		for _, key := range routeKeys {
+			if uid, ok := clean[key].(string); ok && uid != "" {
+				userIDs[uid] = true
+			}
+			delete(clean, key)
+		}
+		out[id] = clean
+	}
+	return out
+}
+
+// tradeDataSides returns the instance IDs on each side of a trade payload,
+// preferring the bundle lists and falling back to the single-instance keys.
+func tradeDataSides(tradeData map[string]interface{}) (proposed, accepting []string) {
+	proposed = stringList(tradeData["pokemon_instance_ids_user_proposed"])
+	accepting = stringList(tradeData["pokemon_instance_ids_user_accepting"])
+	if len(proposed) == 0 {
+		if id, ok := tradeData["pokemon_instance_id_user_proposed"].(string); ok && id != "" {
+			proposed = []string{id}
+		}
+	}
+	if len(accepting) == 0 {
+		if id, ok := tradeData["pokemon_instance_id_user_accepting"].(string); ok && id != "" {
+			accepting = []string{id}
+		}
+	}
+	return proposed, accepting
+}
+
+func stringList(value interface{}) []string {
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil
+	}
+	out := make([]string, 0, len(items))
+	for _, item := range items {
+		if id, ok := item.(string); ok && id != "" {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
 // doCompletedTradeSwap is the "new logic" that handles trade_status="completed"
 // *** DOES NOT *** update the DB; only modifies the instances in memory.
+// Every instance on each side of the bundle is shown as owned by the other user.
 func doCompletedTradeSwap(tradeData map[string]interface{}, pokemonMapPtr *map[string]interface{}) {
-	// tradeData should contain "username_proposed", "username_accepting",
-	// "pokemon_instance_id_user_proposed", "pokemon_instance_id_user_accepting"
 	usernameProposed, _ := tradeData["username_proposed"].(string)
 	usernameAccepting, _ := tradeData["username_accepting"].(string)
 
-	propInstanceID, _ := tradeData["pokemon_instance_id_user_proposed"].(string)
-	accInstanceID, _ := tradeData["pokemon_instance_id_user_accepting"].(string)
+	propInstanceIDs, accInstanceIDs := tradeDataSides(tradeData)
 
-	if propInstanceID == "" || accInstanceID == "" {
+	// Trade cycle legs move one instance; other trades need both sides.
+	cycleLeg, _ := tradeData["trade_cycle_id"].(string)
+	if len(propInstanceIDs)+len(accInstanceIDs) == 0 ||
+		(cycleLeg == "" && (len(propInstanceIDs) == 0 || len(accInstanceIDs) == 0)) {
 		logrus.Warnf("Cannot swap ownership because instance IDs are missing.")
 		return
 	}
@@ -263,67 +462,63 @@ func doCompletedTradeSwap(tradeData map[string]interface{}, pokemonMapPtr *map[s
 		return
 	}
 
-	// 1) Attempt to fetch from DB to get full details
-	var propInstance, accInstance PokemonInstance
-	if err := db.Where("instance_id = ?", propInstanceID).First(&propInstance).Error; err != nil {
-		logrus.Errorf("Failed to load proposed instance %s: %v", propInstanceID, err)
-		return
+	// 1) Fetch every instance from DB to get full details; bail out before
+	//    touching the SSE map if any of them is missing.
+	newOwner := make(map[string]string, len(propInstanceIDs)+len(accInstanceIDs))
+	order := make([]string, 0, len(propInstanceIDs)+len(accInstanceIDs))
+	for _, id := range propInstanceIDs {
+		newOwner[id] = usernameAccepting
+		order = append(order, id)
 	}
-	if err := db.Where("instance_id = ?", accInstanceID).First(&accInstance).Error; err != nil {
-		logrus.Errorf("Failed to load accepting instance %s: %v", accInstanceID, err)
-		return
+	for _, id := range accInstanceIDs {
+		newOwner[id] = usernameProposed
+		order = append(order, id)
+	}
+
+	instances := make(map[string]PokemonInstance, len(order))
+	for _, id := range order {
+		var instance PokemonInstance
+		if err := db.Where("instance_id = ?", id).First(&instance).Error; err != nil {
+			logrus.Errorf("Failed to load traded instance %s: %v", id, err)
+			return
+		}
+		instances[id] = instance
 	}
 
 	// 2) In memory, mark them as "caught" by the opposite user
 	//    but do NOT save to DB. This is purely so the SSE shows them.
 	nowTs := time.Now().Unix()
-
-	// Proposed instance -> belongs to username_accepting
-	propInstance.LastUpdate = &nowTs
-	propInstance.IsCaught = true
-	propInstance.IsForTrade = false
-	propInstance.IsWanted = false
-
-	// Accepting instance -> belongs to username_proposed
-	accInstance.LastUpdate = &nowTs
-	accInstance.IsCaught = true
-	accInstance.IsForTrade = false
-	accInstance.IsWanted = false
-
-	// 3) Marshal each updated instance to JSON so we can attach all fields
-	propInstanceJson, err := json.Marshal(propInstance)
-	if err != nil {
-		logrus.Errorf("Failed to marshal propInstance %s: %v", propInstanceID, err)
-		return
-	}
-	accInstanceJson, err := json.Marshal(accInstance)
-	if err != nil {
-		logrus.Errorf("Failed to marshal accInstance %s: %v", accInstanceID, err)
-		return
-	}
-
-	// 4) Convert each back into a generic map, and add "username" for clarity
-	var propPayload map[string]interface{}
-	if err := json.Unmarshal(propInstanceJson, &propPayload); err != nil {
-		logrus.Errorf("Failed to unmarshal propInstance JSON: %v", err)
-		return
-	}
-	propPayload["username"] = usernameAccepting
-
-	var accPayload map[string]interface{}
-	if err := json.Unmarshal(accInstanceJson, &accPayload); err != nil {
-		logrus.Errorf("Failed to unmarshal accInstance JSON: %v", err)
-		return
+	payloads := make(map[string]interface{}, len(order))
+	for _, id := range order {
+		instance := instances[id]
+		instance.LastUpdate = &nowTs
+		instance.IsCaught = true
+		instance.IsForTrade = false
+		instance.IsWanted = false
+
+		// 3) Marshal to JSON and back so we can attach all fields plus "username"
+		raw, err := json.Marshal(instance)
+		if err != nil {
+			logrus.Errorf("Failed to marshal traded instance %s: %v", id, err)
+			return
+		}
+		var payload map[string]interface{}
+		if err := json.Unmarshal(raw, &payload); err != nil {
+			logrus.Errorf("Failed to unmarshal traded instance %s: %v", id, err)
+			return
+		}
+		payload["username"] = newOwner[id]
+		payloads[id] = payload
 	}
-	accPayload["username"] = usernameProposed
 
-	// 5) Place them into the SSE "pokemon" map
+	// 4) Place them into the SSE "pokemon" map
 	pokemonMap := *pokemonMapPtr
-	pokemonMap[propInstanceID] = propPayload
-	pokemonMap[accInstanceID] = accPayload
+	for id, payload := range payloads {
+		pokemonMap[id] = payload
+	}
 
-	logrus.Infof("Completed trade swap for propInst=%s -> user=%s, accInst=%s -> user=%s",
-		propInstanceID, usernameAccepting, accInstanceID, usernameProposed)
+	logrus.Infof("Completed trade swap: %d instance(s) -> user=%s, %d instance(s) -> user=%s",
+		len(propInstanceIDs), usernameAccepting, len(accInstanceIDs), usernameProposed)
 }
 
 func getUserIDByUsername(username string) (string, error) {
@@ -81,6 +81,16 @@ func TestDoCompletedTradeSwap_MissingIDs_NoMutation(t *testing.T) {
 	if _, ok := pokemonMap["existing"]; !ok {
 		t.Fatalf("expected existing entry to remain")
 	}
+
+	// Only trade cycle legs may leave a side empty.
+	doCompletedTradeSwap(map[string]interface{}{
+		"username_proposed":                  "alice",
+		"username_accepting":                 "bob",
+		"pokemon_instance_id_user_accepting": "acc-1",
+	}, &pokemonMap)
+	if len(pokemonMap) != 1 {
+		t.Fatalf("expected a one-sided trade outside a cycle to be skipped, got len=%d", len(pokemonMap))
+	}
 }
 
 func TestDoCompletedTradeSwap_Success(t *testing.T) {
@@ -179,3 +189,132 @@ func TestGetUserIDByUsername(t *testing.T) {
 		t.Fatalf("unmet sql expectations: %v", err)
 	}
 }
+
+func TestCollectTradeReminders_IndexesByTradeID(t *testing.T) {
+	data := map[string]interface{}{
+		"tradeReminders": []interface{}{
+			map[string]interface{}{"trade_id": "t-1", "trade_status": "proposed"},
+			map[string]interface{}{"trade_status": "pending"},
+			"not-a-map",
+		},
+	}
+
+	got := collectTradeReminders(data)
+	if len(got) != 1 {
+		t.Fatalf("expected 1 reminder, got %d (%#v)", len(got), got)
+	}
+	if _, ok := got["t-1"]; !ok {
+		t.Fatalf("expected reminder for t-1")
+	}
+	if len(collectTradeReminders(map[string]interface{}{})) != 0 {
+		t.Fatalf("expected no reminders when field is absent")
+	}
+}
+
+func TestCollectNotifications_IndexesByID(t *testing.T) {
+	data := map[string]interface{}{
+		"notifications": []interface{}{
+			map[string]interface{}{"notification_id": "7", "notification_type": "trade_proposed"},
+			map[string]interface{}{"notification_type": "trade_rated"},
+			"not-a-map",
+		},
+	}
+
+	got := collectNotifications(data)
+	if len(got) != 1 {
+		t.Fatalf("expected 1 notification, got %d (%#v)", len(got), got)
+	}
+	if _, ok := got["7"]; !ok {
+		t.Fatalf("expected notification 7")
+	}
+}
+
+func TestCollectTradeMessages_RoutesBothSidesFromStorageOnly(t *testing.T) {
+	messages := []interface{}{
+		map[string]interface{}{"message_id": "12", "trade_id": "t-1", "body": "hi", "sender_user_id": "u-1", "recipient_user_id": "u-2"},
+		map[string]interface{}{"trade_id": "t-1", "body": "unsent"},
+	}
+
+	userIDs := make(map[string]bool)
+	if got := collectTradeMessages(map[string]interface{}{"tradeMessages": messages}, userIDs); len(got) != 0 || len(userIDs) != 0 {
+		t.Fatalf("expected client batches to be ignored, got %#v routed to %v", got, userIDs)
+	}
+
+	got := collectTradeMessages(map[string]interface{}{"source": "storage", "tradeMessages": messages}, userIDs)
+	if len(got) != 1 || !userIDs["u-1"] || !userIDs["u-2"] {
+		t.Fatalf("expected message 12 routed to both sides, got %#v routed to %v", got, userIDs)
+	}
+	item := got["12"].(map[string]interface{})
+	if _, ok := item["recipient_user_id"]; ok {
+		t.Fatalf("expected routing ids to be stripped, got %#v", item)
+	}
+	if _, ok := messages[0].(map[string]interface{})["recipient_user_id"]; !ok {
+		t.Fatalf("expected the source message to be left untouched")
+	}
+}
+
+func TestCollectTagUpdates_IndexesByTagID(t *testing.T) {
+	data := map[string]interface{}{
+		"tagUpdates": []interface{}{
+			map[string]interface{}{"key": "tag-1", "operation": "create", "tagData": map[string]interface{}{"name": "Hundos"}},
+			map[string]interface{}{"operation": "delete", "tagData": map[string]interface{}{"tag_id": "tag-2"}},
+			map[string]interface{}{"key": "tag-3"},
+			"not-a-map",
+		},
+	}
+
+	got := collectTagUpdates(data)
+	if len(got) != 2 {
+		t.Fatalf("expected 2 tag updates, got %d (%#v)", len(got), got)
+	}
+	if entry, ok := got["tag-2"].(map[string]interface{}); !ok || entry["operation"] != "delete" {
+		t.Fatalf("expected delete for tag-2, got %#v", got["tag-2"])
+	}
+}
+
+func TestTradeDataSides_PrefersBundleLists(t *testing.T) {
+	proposed, accepting := tradeDataSides(map[string]interface{}{
+		"pokemon_instance_id_user_proposed":   "p-1",
+		"pokemon_instance_id_user_accepting":  "a-1",
+		"pokemon_instance_ids_user_proposed":  []interface{}{"p-1", "p-2"},
+		"pokemon_instance_ids_user_accepting": []interface{}{},
+	})
+	if len(proposed) != 2 || proposed[1] != "p-2" {
+		t.Fatalf("expected bundle list for proposed side, got %#v", proposed)
+	}
+	if len(accepting) != 1 || accepting[0] != "a-1" {
+		t.Fatalf("expected single-id fallback for accepting side, got %#v", accepting)
+	}
+}
+
+func TestDoCompletedTradeSwap_BundleMissingInstanceLeavesMapUntouched(t *testing.T) {
+	origDB := db
+	defer func() { db = origDB }()
+
+	gdb, mock, sqlDB := setupMockGormDB(t)
+	defer sqlDB.Close()
+	db = gdb
+
+	query := regexp.QuoteMeta("SELECT * FROM `instances` WHERE instance_id = ? ORDER BY `instances`.`instance_id` LIMIT ?")
+	mock.ExpectQuery(query).
+		WithArgs("prop-1", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"instance_id", "user_id", "pokemon_id"}).AddRow("prop-1", "u-prop", 25))
+	mock.ExpectQuery(query).
+		WithArgs("prop-2", 1).
+		WillReturnError(gorm.ErrRecordNotFound)
+
+	pokemonMap := map[string]interface{}{}
+	doCompletedTradeSwap(map[string]interface{}{
+		"username_proposed":                   "alice",
+		"username_accepting":                  "bob",
+		"pokemon_instance_ids_user_proposed":  []interface{}{"prop-1", "prop-2"},
+		"pokemon_instance_ids_user_accepting": []interface{}{"acc-1"},
+	}, &pokemonMap)
+
+	if len(pokemonMap) != 0 {
+		t.Fatalf("expected no partial swap in SSE payload, got %#v", pokemonMap)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet sql expectations: %v", err)
+	}
+}
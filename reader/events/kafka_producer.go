@@ -0,0 +1,220 @@
+// kafka_producer.go
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+// Matches the receiver's limit so both paths accept the same batches.
+const maxPublishMessageSize = 3145728 // 3 MB in bytes
+
+var batchWriter *kafka.Writer
+var batchWriterMu sync.RWMutex
+
+// publishBatchFunc is swapped out in tests.
+var publishBatchFunc = produceBatchedUpdates
+
+var errPublishDisabled = errors.New("publishing over WebSocket is disabled")
+
+// initKafkaProducer sets up the writer WebSocket clients publish through.
+// It is only created when WS publishing is enabled.
+func initKafkaProducer() {
+	if !config.Events.WSPublishEnabled {
+		return
+	}
+
+	batchWriterMu.Lock()
+	defer batchWriterMu.Unlock()
+	batchWriter = &kafka.Writer{
+		Addr:         kafka.TCP(fmt.Sprintf("%s:%s", config.Events.Hostname, config.Events.Port)),
+		Topic:        config.Events.Topic,
+		Balancer:     &kafka.LeastBytes{},
+		BatchTimeout: 10 * time.Millisecond,
+		RequiredAcks: 1,
+		Transport: &kafka.Transport{
+			DialTimeout: 10 * time.Second,
+			IdleTimeout: 5 * time.Minute,
+		},
+	}
+	logrus.Infof("WebSocket batches will be published to %s", config.Events.Topic)
+}
+
+func closeKafkaProducer() {
+	batchWriterMu.Lock()
+	defer batchWriterMu.Unlock()
+	if batchWriter == nil {
+		return
+	}
+	if err := batchWriter.Close(); err != nil {
+		logrus.Warnf("Failed to close Kafka writer: %v", err)
+	}
+	batchWriter = nil
+}
+
+// produceBatchedUpdates writes one receiver-format envelope to the
+// batchedUpdates topic. Unlike the receiver it does not spool failures to
+// disk; the client gets an error and can retry.
+func produceBatchedUpdates(data []byte) error {
+	batchWriterMu.RLock()
+	w := batchWriter
+	batchWriterMu.RUnlock()
+	if w == nil {
+		return errPublishDisabled
+	}
+
+	compressed, err := compressData(data)
+	if err != nil {
+		return err
+	}
+	if len(compressed) > maxPublishMessageSize {
+		return fmt.Errorf("compressed message too large: %d bytes (max %d)", len(compressed), maxPublishMessageSize)
+	}
+
+	// Same constant key as the receiver: batches from both paths land on one
+	// partition and stay in order.
+	msg := kafka.Message{Key: []byte("Key"), Value: compressed, Time: time.Now().UTC()}
+
+	var writeErr error
+	for attempt := 1; attempt <= config.Events.MaxRetries; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		writeErr = w.WriteMessages(ctx, msg)
+		cancel()
+		if writeErr == nil {
+			return nil
+		}
+		if attempt < config.Events.MaxRetries {
+			logrus.Warnf("Kafka write attempt %d/%d failed: %v", attempt, config.Events.MaxRetries, writeErr)
+			time.Sleep(time.Duration(config.Events.RetryInterval) * time.Second)
+		}
+	}
+	return writeErr
+}
+
+// Match the receiver's per-list caps.
+const (
+	maxUpdatesPerBatch             = 5000
+	maxTradeMessagesPerBatch       = 20
+	maxTradeCycleProposalsPerBatch = 5
+)
+
+// wsBatch is the body of a batchedUpdates message; the same shape the
+// receiver accepts on POST /api/batchedUpdates. Keep the two in step: an
+// unknown key is rejected rather than dropped.
+type wsBatch struct {
+	Location       map[string]any `json:"location"`
+	PokemonUpdates []any          `json:"pokemonUpdates"`
+	TradeUpdates   []any          `json:"tradeUpdates"`
+	TradeRatings   []any          `json:"tradeRatings"`
+	TagUpdates     []any          `json:"tagUpdates"`
+
+	TradeMessages       []any `json:"tradeMessages"`
+	TradeMessageReads   []any `json:"tradeMessageReads"`
+	TradeMessageReports []any `json:"tradeMessageReports"`
+
+	TradeCycleProposals []any `json:"tradeCycleProposals"`
+}
+
+// buildBatchedUpdatesMessage wraps a client batch in the receiver's Kafka
+// envelope with the receiver's limits, so storage handles a batch the same
+// whichever path it took.
+func buildBatchedUpdatesMessage(userID, username, deviceID string, raw json.RawMessage) ([]byte, string, error) {
+	var batch wsBatch
+	if len(raw) > 0 {
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&batch); err != nil {
+			return nil, "", fmt.Errorf("invalid batchedUpdates payload: %w", err)
+		}
+		if err := dec.Decode(&struct{}{}); err != io.EOF {
+			return nil, "", errors.New("invalid batchedUpdates payload: trailing data")
+		}
+	}
+	if batch.PokemonUpdates == nil {
+		batch.PokemonUpdates = []any{}
+	}
+	if batch.TradeUpdates == nil {
+		batch.TradeUpdates = []any{}
+	}
+	if batch.TradeRatings == nil {
+		batch.TradeRatings = []any{}
+	}
+	if batch.TagUpdates == nil {
+		batch.TagUpdates = []any{}
+	}
+	if batch.TradeMessages == nil {
+		batch.TradeMessages = []any{}
+	}
+	if batch.TradeMessageReads == nil {
+		batch.TradeMessageReads = []any{}
+	}
+	if batch.TradeMessageReports == nil {
+		batch.TradeMessageReports = []any{}
+	}
+	if batch.TradeCycleProposals == nil {
+		batch.TradeCycleProposals = []any{}
+	}
+	if len(batch.PokemonUpdates) > maxUpdatesPerBatch ||
+		len(batch.TradeUpdates) > maxUpdatesPerBatch ||
+		len(batch.TradeRatings) > maxUpdatesPerBatch ||
+		len(batch.TagUpdates) > maxUpdatesPerBatch {
+		return nil, "", errors.New("too many updates in a single batch")
+	}
+	if len(batch.TradeMessages) > maxTradeMessagesPerBatch ||
+		len(batch.TradeMessageReads) > maxTradeMessagesPerBatch ||
+		len(batch.TradeMessageReports) > maxTradeMessagesPerBatch {
+		return nil, "", errors.New("too many trade messages in a single batch")
+	}
+	if len(batch.TradeCycleProposals) > maxTradeCycleProposalsPerBatch {
+		return nil, "", errors.New("too many trade cycle proposals in a single batch")
+	}
+
+	traceID := uuid.New().String()
+	message, err := json.Marshal(map[string]interface{}{
+		"user_id":        userID,
+		"username":       username,
+		"device_id":      deviceID,
+		"trace_id":       traceID,
+		"location":       batch.Location,
+		"pokemonUpdates": batch.PokemonUpdates,
+		"tradeUpdates":   batch.TradeUpdates,
+		"tradeRatings":   batch.TradeRatings,
+		"tagUpdates":     batch.TagUpdates,
+
+		"tradeMessages":       batch.TradeMessages,
+		"tradeMessageReads":   batch.TradeMessageReads,
+		"tradeMessageReports": batch.TradeMessageReports,
+
+		"tradeCycleProposals": batch.TradeCycleProposals,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return message, traceID, nil
+}
+
+// compressData gzips data the way the receiver does; decompressData reverses it.
+func compressData(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(data); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
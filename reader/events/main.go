@@ -4,7 +4,11 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
 	"gorm.io/gorm"
 )
@@ -31,6 +35,7 @@ func main() {
 	initJWTSecret()
 	initAllowedOrigins()
 	initDB()
+	initReplayBuffer()
 
 	app := fiber.New(fiber.Config{
 		ErrorHandler:          errorHandler,
@@ -38,6 +43,7 @@ func main() {
 	})
 
 	registerMetrics()
+	eventsInstanceInfo.WithLabelValues(config.Events.InstanceID, consumerGroupID(), config.Events.FanoutMode).Set(1)
 	app.Use(requestLogger)
 	app.Use(corsMiddleware)
 	app.Use(metricsMiddleware)
@@ -57,14 +63,39 @@ func main() {
 	protected.Get("/api/sse", sseHandler)
 	protected.Get("/api/getUpdates", GetUpdates)
 	protected.Get("/api/sse-token", issueSSEToken)
+	protected.Get("/api/ws", wsUpgrade, websocket.New(wsHandler))
+	protected.Get("/api/push/vapid-public-key", getVAPIDPublicKey)
+	protected.Put("/api/push/subscription", putPushSubscription)
+	protected.Delete("/api/push/subscription", deletePushSubscription)
+	protected.Get("/api/presence", getPresence)
+	protected.Get("/api/admin/connections", requireAdmin, listConnections)
+	protected.Delete("/api/admin/connections", requireAdmin, disconnectConnections)
+
+	initPush()
+	initPresence()
+	initConnectionBlocks()
 
 	startKafkaConsumer()
+	initKafkaProducer()
 
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "3008"
 	}
 
+	// Persist the replay buffer (when configured) before exiting.
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		<-sig
+		saveReplaySnapshot()
+		releasePresence()
+		closeKafkaProducer()
+		if err := app.ShutdownWithTimeout(5 * time.Second); err != nil {
+			log.Printf("Events Service shutdown: %v", err)
+		}
+	}()
+
 	fmt.Printf("Starting Events Service at http://127.0.0.1:%s/\n", port)
 	fmt.Println("Quit the server with CTRL-C")
 
@@ -31,12 +31,146 @@ var (
 		},
 		[]string{"method", "route", "status"},
 	)
+
+	sseReplayedEventsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "events_sse_replayed_events_total",
+			Help: "Events replayed to SSE clients reconnecting with Last-Event-ID.",
+		},
+	)
+
+	sseResyncsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "events_sse_resyncs_total",
+			Help: "Reconnects whose gap was no longer buffered and got a resync event.",
+		},
+	)
+
+	sseConnectedClients = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "events_sse_connected_clients",
+			Help: "SSE and WebSocket connections currently registered on this replica.",
+		},
+	)
+
+	sseConnectedUsers = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "events_sse_connected_users",
+			Help: "Distinct users with at least one SSE or WebSocket connection on this replica.",
+		},
+	)
+
+	sseConnectionsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "events_sse_connections_total",
+			Help: "SSE and WebSocket connections registered on this replica.",
+		},
+	)
+
+	sseDisconnectionsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "events_sse_disconnections_total",
+			Help: "SSE and WebSocket connections removed from this replica's registry.",
+		},
+	)
+
+	sseRejectedConnectionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "events_sse_rejected_connections_total",
+			Help: "SSE and WebSocket connections refused by reason: global_limit, user_limit or blocked.",
+		},
+		[]string{"reason"},
+	)
+
+	sseForcedDisconnectsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "events_sse_forced_disconnects_total",
+			Help: "Connections closed through the admin API.",
+		},
+	)
+
+	presenceUpdatesTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "events_presence_updates_total",
+			Help: "Trade partner presence changes pushed to users.",
+		},
+	)
+
+	eventsInstanceInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "events_instance_info",
+			Help: "Constant 1, labelled with this replica's id, consumer group and fan-out mode.",
+		},
+		[]string{"instance_id", "consumer_group", "fanout_mode"},
+	)
+
+	sseQueuedFrames = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "events_sse_queued_frames",
+			Help: "Frames waiting in SSE client queues across all clients.",
+		},
+	)
+
+	sseCoalescedFramesTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "events_sse_coalesced_frames_total",
+			Help: "Updates folded into an already queued frame because the client queue was full.",
+		},
+	)
+
+	sseDroppedFramesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "events_sse_dropped_frames_total",
+			Help: "Frames dropped when a client queue overflowed, by overflow policy.",
+		},
+		[]string{"policy"},
+	)
+
+	wsMessagesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "events_ws_messages_total",
+			Help: "Messages received from WebSocket clients, by type.",
+		},
+		[]string{"type"},
+	)
+
+	wsPublishedBatchesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "events_ws_published_batches_total",
+			Help: "batchedUpdates submitted over WebSocket, by result.",
+		},
+		[]string{"result"},
+	)
+
+	pushDeliveriesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "events_push_deliveries_total",
+			Help: "Web Push deliveries by result: sent, live (device connected), duplicate, pruned, failed or dropped.",
+		},
+		[]string{"result"},
+	)
 )
 
 func registerMetrics() {
 	metricsOnce.Do(func() {
 		tryRegister(httpRequestsTotal)
 		tryRegister(httpRequestDurationSeconds)
+		tryRegister(sseReplayedEventsTotal)
+		tryRegister(sseResyncsTotal)
+		tryRegister(sseConnectedClients)
+		tryRegister(sseConnectedUsers)
+		tryRegister(sseConnectionsTotal)
+		tryRegister(sseDisconnectionsTotal)
+		tryRegister(sseRejectedConnectionsTotal)
+		tryRegister(sseForcedDisconnectsTotal)
+		tryRegister(presenceUpdatesTotal)
+		tryRegister(eventsInstanceInfo)
+		tryRegister(sseQueuedFrames)
+		tryRegister(sseCoalescedFramesTotal)
+		tryRegister(sseDroppedFramesTotal)
+		tryRegister(wsMessagesTotal)
+		tryRegister(wsPublishedBatchesTotal)
+		tryRegister(pushDeliveriesTotal)
 	})
 }
 
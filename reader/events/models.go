@@ -98,6 +98,7 @@ type PokemonInstance struct {
 	LocationCard    *string        `gorm:"column:location_card" json:"location_card"`
 	FriendshipLevel *int           `gorm:"column:friendship_level" json:"friendship_level"`
 	LastUpdate      *int64         `gorm:"column:last_update" json:"last_update"`
+	ChangeSeq       uint64         `gorm:"column:change_seq;->" json:"-"` // stamped by storage's trigger
 	DateCaught      *string        `gorm:"column:date_caught" json:"date_caught"`
 	DateAdded       *string        `gorm:"column:date_added" json:"date_added"`
 	WantedFilters   JSON           `gorm:"column:wanted_filters;type:json" json:"wanted_filters"`
@@ -139,6 +140,9 @@ type Trade struct {
 	TradeCompletedDate               *time.Time `gorm:"column:trade_completed_date"`
 	TradeCancelledDate               *time.Time `gorm:"column:trade_cancelled_date"`
 	TradeCancelledBy                 *string    `gorm:"column:trade_cancelled_by" json:"trade_cancelled_by"`
+	TradeExpiredDate                 *time.Time `gorm:"column:trade_expired_date" json:"trade_expired_date"`
+	CounterOfTradeID                 *string    `gorm:"column:counter_of_trade_id" json:"counter_of_trade_id"`
+	TradeCycleID                     *string    `gorm:"column:trade_cycle_id" json:"trade_cycle_id"`
 	IsSpecialTrade                   bool       `gorm:"column:is_special_trade" json:"is_special_trade"`
 	IsRegisteredTrade                bool       `gorm:"column:is_registered_trade" json:"is_registered_trade"`
 	IsLuckyTrade                     bool       `gorm:"column:is_lucky_trade" json:"is_lucky_trade"`
@@ -148,8 +152,21 @@ type Trade struct {
 	User2TradeSatisfaction           *int       `gorm:"column:user_2_trade_satisfaction" json:"user_2_trade_satisfaction"`
 	TraceID                          *string    `gorm:"column:trace_id" json:"trace_id"`
 	LastUpdate                       *int64     `gorm:"column:last_update" json:"last_update"`
+	ChangeSeq                        uint64     `gorm:"column:change_seq;->" json:"-"` // stamped by storage's trigger
 }
 
 func (Trade) TableName() string {
 	return "trades"
 }
+
+// TradeItem maps one instance on one side of a multi-Pokemon trade.
+type TradeItem struct {
+	TradeID    string `gorm:"column:trade_id;primaryKey" json:"trade_id"`
+	InstanceID string `gorm:"column:instance_id;primaryKey" json:"instance_id"`
+	Side       string `gorm:"column:side" json:"side"`
+	Position   int    `gorm:"column:position" json:"position"`
+}
+
+func (TradeItem) TableName() string {
+	return "trade_items"
+}
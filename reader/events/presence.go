@@ -0,0 +1,369 @@
+// presence.go
+
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+)
+
+// Presence is opt-in (users.share_presence). Every replica writes one
+// user_presence row per user it holds connections for, so any replica, and
+// the search reader, can tell whether a user is online anywhere. Rows are
+// only written for users who opted in.
+
+const (
+	// presenceFlushInterval is how quickly connects and disconnects reach
+	// user_presence.
+	presenceFlushInterval = 5 * time.Second
+	// presenceHeartbeatInterval refreshes every connected user's row, so a
+	// replica that dies stops counting once its rows go stale.
+	presenceHeartbeatInterval = 30 * time.Second
+	presenceStaleAfter        = 3 * presenceHeartbeatInterval
+	// presenceRecentWindow is how long after the last connection a user
+	// still shows as recently active.
+	presenceRecentWindow = 15 * time.Minute
+	presenceRetention    = 7 * 24 * time.Hour
+	presenceWriteBatch   = 500
+	maxPresenceLookup    = 100
+)
+
+const (
+	presenceOnline  = "online"
+	presenceRecent  = "recent"
+	presenceOffline = "offline"
+)
+
+// presencePollInterval is how often trade partners' presence is checked
+// for changes to push.
+var presencePollInterval = 15 * time.Second
+
+// activePartnerStatuses are the trade statuses whose two sides see each
+// other's presence.
+var activePartnerStatuses = []string{"proposed", "pending"}
+
+// Owned by the presence loop.
+var (
+	// presenceWritten is the connection count last written per user.
+	presenceWritten  = make(map[string]int)
+	presenceLastBeat time.Time
+	// presenceSent is the status last pushed to each watcher, per partner
+	// username.
+	presenceSent = make(map[string]map[string]string)
+)
+
+// presenceRow is one opted-in user's presence across replicas.
+type presenceRow struct {
+	UserID     string     `gorm:"column:user_id"`
+	Username   string     `gorm:"column:username"`
+	Online     bool       `gorm:"column:online"`
+	LastSeenAt *time.Time `gorm:"column:last_seen_at"`
+}
+
+// presenceState is what other trainers see. LastActiveAt is only set for
+// recently active users.
+type presenceState struct {
+	Username     string     `json:"username"`
+	Status       string     `json:"status"`
+	LastActiveAt *time.Time `json:"last_active_at,omitempty"`
+}
+
+func (r presenceRow) state(now time.Time) presenceState {
+	s := presenceState{Username: r.Username, Status: presenceOffline}
+	switch {
+	case r.Online:
+		s.Status = presenceOnline
+	case r.LastSeenAt != nil && now.Sub(*r.LastSeenAt) < presenceRecentWindow:
+		s.Status = presenceRecent
+		at := r.LastSeenAt.UTC()
+		s.LastActiveAt = &at
+	}
+	return s
+}
+
+// initPresence starts the loop that writes this replica's presence rows and
+// pushes partner presence changes.
+func initPresence() {
+	go runPresence()
+}
+
+func runPresence() {
+	flush := time.NewTicker(presenceFlushInterval)
+	poll := time.NewTicker(presencePollInterval)
+	prune := time.NewTicker(time.Hour)
+	defer flush.Stop()
+	defer poll.Stop()
+	defer prune.Stop()
+	for {
+		select {
+		case now := <-flush.C:
+			flushPresence(now)
+		case now := <-poll.C:
+			pollPresence(now)
+		case now := <-prune.C:
+			if err := db.Exec("DELETE FROM user_presence WHERE last_seen_at < ?", now.Add(-presenceRetention)).Error; err != nil {
+				logrus.Warnf("Failed to prune presence rows: %v", err)
+			}
+		}
+	}
+}
+
+// localConnectionCounts counts this replica's connections per user.
+func localConnectionCounts() map[string]int {
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+	counts := make(map[string]int, len(clients))
+	for _, c := range clients {
+		if c.Connected {
+			counts[c.UserID]++
+		}
+	}
+	return counts
+}
+
+// flushPresence writes the users whose connection count changed since the
+// last flush (disconnected users as 0), or every connected user when a
+// heartbeat is due. A failed write is retried on the next flush.
+func flushPresence(now time.Time) {
+	counts := localConnectionCounts()
+	beat := now.Sub(presenceLastBeat) >= presenceHeartbeatInterval
+
+	byCount := make(map[int][]string)
+	for user, n := range counts {
+		if beat || presenceWritten[user] != n {
+			byCount[n] = append(byCount[n], user)
+		}
+	}
+	for user := range presenceWritten {
+		if _, ok := counts[user]; !ok {
+			byCount[0] = append(byCount[0], user)
+		}
+	}
+
+	ns := make([]int, 0, len(byCount))
+	for n := range byCount {
+		ns = append(ns, n)
+	}
+	sort.Ints(ns)
+	for _, n := range ns {
+		users := byCount[n]
+		sort.Strings(users)
+		if err := writePresence(users, n, now); err != nil {
+			logrus.Warnf("Failed to write presence: %v", err)
+			return
+		}
+	}
+
+	presenceWritten = counts
+	if beat {
+		presenceLastBeat = now
+	}
+}
+
+// writePresence upserts this replica's row for the users that share their
+// presence; the rest are skipped by the join.
+func writePresence(userIDs []string, connections int, now time.Time) error {
+	for start := 0; start < len(userIDs); start += presenceWriteBatch {
+		end := start + presenceWriteBatch
+		if end > len(userIDs) {
+			end = len(userIDs)
+		}
+		err := db.Exec(
+			`INSERT INTO user_presence (user_id, instance_id, connections, last_seen_at)
+			 SELECT user_id, ?, ?, ? FROM users WHERE share_presence = 1 AND user_id IN ?
+			 ON DUPLICATE KEY UPDATE connections = VALUES(connections), last_seen_at = VALUES(last_seen_at)`,
+			config.Events.InstanceID, connections, now.UTC(), userIDs[start:end],
+		).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releasePresence zeroes this replica's rows on shutdown, so its users do
+// not show online until the rows go stale.
+func releasePresence() {
+	if db == nil {
+		return
+	}
+	if err := db.Exec(
+		"UPDATE user_presence SET connections = 0, last_seen_at = ? WHERE instance_id = ? AND connections > 0",
+		time.Now().UTC(), config.Events.InstanceID,
+	).Error; err != nil {
+		logrus.Warnf("Failed to release presence rows: %v", err)
+	}
+}
+
+// loadPresence reads the presence of opted-in users matching filter, which
+// is "u.user_id IN ?" or "u.username IN ?". Users who do not share their
+// presence are left out.
+func loadPresence(filter string, values []string, now time.Time) ([]presenceRow, error) {
+	var rows []presenceRow
+	if len(values) == 0 {
+		return rows, nil
+	}
+	err := db.Raw(
+		`SELECT u.user_id, u.username,
+		        COALESCE(MAX(p.connections > 0 AND p.last_seen_at >= ?), 0) AS online,
+		        MAX(p.last_seen_at) AS last_seen_at
+		   FROM users u
+		   LEFT JOIN user_presence p ON p.user_id = u.user_id
+		  WHERE u.share_presence = 1 AND `+filter+`
+		  GROUP BY u.user_id, u.username`,
+		now.Add(-presenceStaleAfter).UTC(), values,
+	).Scan(&rows).Error
+	return rows, err
+}
+
+// loadTradePartners maps each watcher to the users on the other side of
+// their active trades.
+func loadTradePartners(watchers []string) (map[string][]string, error) {
+	var trades []Trade
+	if err := db.Select("user_id_proposed", "user_id_accepting").
+		Where("trade_status IN ? AND (user_id_proposed IN ? OR user_id_accepting IN ?)", activePartnerStatuses, watchers, watchers).
+		Find(&trades).Error; err != nil {
+		return nil, err
+	}
+	watching := make(map[string]bool, len(watchers))
+	for _, w := range watchers {
+		watching[w] = true
+	}
+	partners := make(map[string][]string)
+	for _, t := range trades {
+		if t.UserIDProposed == t.UserIDAccepting {
+			continue
+		}
+		if watching[t.UserIDProposed] {
+			partners[t.UserIDProposed] = append(partners[t.UserIDProposed], t.UserIDAccepting)
+		}
+		if watching[t.UserIDAccepting] {
+			partners[t.UserIDAccepting] = append(partners[t.UserIDAccepting], t.UserIDProposed)
+		}
+	}
+	return partners, nil
+}
+
+// pollPresence pushes a "presence" section to each locally connected user
+// whose trade partners' status changed since it was last pushed. A new
+// connection gets its partners' current status on the next poll; a partner
+// who stops sharing is pushed as offline.
+func pollPresence(now time.Time) {
+	counts := localConnectionCounts()
+	for watcher := range presenceSent {
+		if _, ok := counts[watcher]; !ok {
+			delete(presenceSent, watcher)
+		}
+	}
+	if len(counts) == 0 {
+		return
+	}
+	watchers := sortedKeys(counts)
+
+	partners, err := loadTradePartners(watchers)
+	if err != nil {
+		logrus.Warnf("Failed to load trade partners for presence: %v", err)
+		return
+	}
+	var partnerIDs []string
+	seen := make(map[string]bool)
+	for _, ps := range partners {
+		for _, p := range ps {
+			if !seen[p] {
+				seen[p] = true
+				partnerIDs = append(partnerIDs, p)
+			}
+		}
+	}
+	rows, err := loadPresence("u.user_id IN ?", partnerIDs, now)
+	if err != nil {
+		logrus.Warnf("Failed to load partner presence: %v", err)
+		return
+	}
+	states := make(map[string]presenceState, len(rows))
+	for _, r := range rows {
+		states[r.UserID] = r.state(now)
+	}
+
+	payloads := make(map[string][]byte)
+	for _, watcher := range watchers {
+		prev := presenceSent[watcher]
+		next := make(map[string]string)
+		changed := make(map[string]presenceState)
+		for _, p := range partners[watcher] {
+			s, ok := states[p]
+			if !ok {
+				continue
+			}
+			next[s.Username] = s.Status
+			if prev == nil || prev[s.Username] != s.Status {
+				changed[s.Username] = s
+			}
+		}
+		for name, status := range prev {
+			if _, ok := next[name]; !ok && status != presenceOffline {
+				changed[name] = presenceState{Username: name, Status: presenceOffline}
+			}
+		}
+		presenceSent[watcher] = next
+		if len(changed) == 0 {
+			continue
+		}
+		data, err := json.Marshal(map[string]interface{}{"presence": changed})
+		if err != nil {
+			logrus.Errorf("Error marshalling presence for user=%s: %v", watcher, err)
+			continue
+		}
+		payloads[userChannel(watcher)] = data
+	}
+	if len(payloads) == 0 {
+		return
+	}
+
+	// Presence is not replayed: a reconnecting client gets the current
+	// status on the next poll instead.
+	clientsMutex.Lock()
+	deliverLocked(nextEventID(), payloads, "")
+	clientsMutex.Unlock()
+	presenceUpdatesTotal.Add(float64(len(payloads)))
+}
+
+/* -------------------------------------------------------------------------- */
+/*  GET /api/presence?usernames=a,b                                           */
+/* -------------------------------------------------------------------------- */
+
+// getPresence returns the presence of the listed trainers who share it,
+// keyed by username. Trainers who do not share it are left out.
+func getPresence(c *fiber.Ctx) error {
+	var usernames []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(c.Query("usernames"), ",") {
+		if name = strings.TrimSpace(name); name != "" && !seen[name] {
+			seen[name] = true
+			usernames = append(usernames, name)
+		}
+	}
+	if len(usernames) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Missing usernames"})
+	}
+	if len(usernames) > maxPresenceLookup {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Too many usernames"})
+	}
+
+	now := time.Now()
+	rows, err := loadPresence("u.username IN ?", usernames, now)
+	if err != nil {
+		logrus.Errorf("Failed to load presence: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to load presence"})
+	}
+	out := make(map[string]presenceState, len(rows))
+	for _, r := range rows {
+		out[r.Username] = r.state(now)
+	}
+	return c.JSON(fiber.Map{"presence": out})
+}
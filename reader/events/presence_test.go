@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+)
+
+func withPresenceState(t *testing.T) {
+	t.Helper()
+	withRegistry(t)
+	origWritten, origBeat, origSent := presenceWritten, presenceLastBeat, presenceSent
+	presenceWritten = make(map[string]int)
+	presenceLastBeat = time.Time{}
+	presenceSent = make(map[string]map[string]string)
+	t.Cleanup(func() {
+		presenceWritten, presenceLastBeat, presenceSent = origWritten, origBeat, origSent
+	})
+}
+
+func TestPresenceRow_State(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	recently := now.Add(-5 * time.Minute)
+	longAgo := now.Add(-2 * time.Hour)
+
+	if s := (presenceRow{Username: "a", Online: true, LastSeenAt: &recently}).state(now); s.Status != presenceOnline || s.LastActiveAt != nil {
+		t.Fatalf("expected online without a timestamp, got %+v", s)
+	}
+	if s := (presenceRow{Username: "b", LastSeenAt: &recently}).state(now); s.Status != presenceRecent || !s.LastActiveAt.Equal(recently) {
+		t.Fatalf("expected recently active, got %+v", s)
+	}
+	for _, row := range []presenceRow{{Username: "c", LastSeenAt: &longAgo}, {Username: "d"}} {
+		if s := row.state(now); s.Status != presenceOffline || s.LastActiveAt != nil {
+			t.Fatalf("expected offline without a timestamp, got %+v", s)
+		}
+	}
+}
+
+func TestFlushPresence_WritesChangesAndHeartbeats(t *testing.T) {
+	withPresenceState(t)
+	gdb, mock, sqlDB := setupMockGormDB(t)
+	defer sqlDB.Close()
+	origDB := db
+	defer func() { db = origDB }()
+	db = gdb
+	config.Events.InstanceID = "events-a"
+
+	addTestClient("u-1", "d-1", transportSSE)
+	addTestClient("u-1", "d-2", transportWS)
+	_, u2 := addTestClient("u-2", "d-1", transportSSE)
+
+	upsert := regexp.QuoteMeta("INSERT INTO user_presence (user_id, instance_id, connections, last_seen_at)")
+	start := time.Now()
+	mock.ExpectExec(upsert).WithArgs("events-a", 1, sqlmock.AnyArg(), "u-2").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(upsert).WithArgs("events-a", 2, sqlmock.AnyArg(), "u-1").WillReturnResult(sqlmock.NewResult(0, 1))
+	flushPresence(start)
+
+	// Nothing changed and no heartbeat is due.
+	flushPresence(start.Add(presenceFlushInterval))
+
+	handleClientDisconnect(findClientID(u2), u2)
+	mock.ExpectExec(upsert).WithArgs("events-a", 0, sqlmock.AnyArg(), "u-2").WillReturnResult(sqlmock.NewResult(0, 1))
+	flushPresence(start.Add(2 * presenceFlushInterval))
+
+	// The heartbeat rewrites everyone still connected.
+	mock.ExpectExec(upsert).WithArgs("events-a", 2, sqlmock.AnyArg(), "u-1").WillReturnResult(sqlmock.NewResult(0, 1))
+	flushPresence(start.Add(presenceHeartbeatInterval))
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet sql expectations: %v", err)
+	}
+	if _, ok := presenceWritten["u-2"]; ok {
+		t.Fatalf("expected the disconnected user to be forgotten once written")
+	}
+}
+
+func findClientID(client *Client) string {
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+	for id, c := range clients {
+		if c == client {
+			return id
+		}
+	}
+	return ""
+}
+
+func TestPollPresence_PushesPartnerChangesOnce(t *testing.T) {
+	withPresenceState(t)
+	gdb, mock, sqlDB := setupMockGormDB(t)
+	defer sqlDB.Close()
+	origDB := db
+	defer func() { db = origDB }()
+	db = gdb
+
+	_, watcher := addTestClient("u-1", "d-1", transportSSE)
+	now := time.Now()
+	seen := now.Add(-time.Minute)
+
+	expectPoll := func(online bool) {
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT `user_id_proposed`,`user_id_accepting` FROM `trades`")).
+			WithArgs("proposed", "pending", "u-1", "u-1").
+			WillReturnRows(sqlmock.NewRows([]string{"user_id_proposed", "user_id_accepting"}).
+				AddRow("u-1", "u-2").
+				AddRow("u-3", "u-1"))
+		mock.ExpectQuery(regexp.QuoteMeta("FROM users u")).
+			WithArgs(sqlmock.AnyArg(), "u-2", "u-3").
+			WillReturnRows(sqlmock.NewRows([]string{"user_id", "username", "online", "last_seen_at"}).
+				AddRow("u-2", "misty", online, seen))
+	}
+
+	expectPoll(true)
+	pollPresence(now)
+	frames := watcher.Queue.drain()
+	if len(frames) != 1 {
+		t.Fatalf("expected one presence frame, got %d", len(frames))
+	}
+	var body struct {
+		Presence map[string]presenceState `json:"presence"`
+	}
+	if err := json.Unmarshal(frames[0].Data, &body); err != nil {
+		t.Fatalf("decode frame: %v", err)
+	}
+	if len(body.Presence) != 1 || body.Presence["misty"].Status != presenceOnline {
+		t.Fatalf("expected only the sharing partner online, got %+v", body.Presence)
+	}
+
+	// Unchanged status is not pushed again.
+	expectPoll(true)
+	pollPresence(now)
+	if got := watcher.Queue.drain(); len(got) != 0 {
+		t.Fatalf("expected no push without a change, got %+v", got)
+	}
+
+	expectPoll(false)
+	pollPresence(now)
+	frames = watcher.Queue.drain()
+	if len(frames) != 1 || !strings.Contains(string(frames[0].Data), `"status":"recent"`) {
+		t.Fatalf("expected the partner to turn recently active, got %+v", frames)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet sql expectations: %v", err)
+	}
+}
+
+func TestGetPresence_ValidatesUsernames(t *testing.T) {
+	app := fiber.New()
+	app.Get("/api/presence", getPresence)
+
+	tooMany := make([]string, maxPresenceLookup+1)
+	for i := range tooMany {
+		tooMany[i] = "trainer" + string(rune('a'+i%26)) + strings.Repeat("x", i/26)
+	}
+	for _, target := range []string{
+		"/api/presence",
+		"/api/presence?usernames=,%20,",
+		"/api/presence?usernames=" + strings.Join(tooMany, ","),
+	} {
+		resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, target, nil), -1)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		if resp.StatusCode != fiber.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", target, resp.StatusCode)
+		}
+	}
+}
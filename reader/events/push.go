@@ -0,0 +1,473 @@
+// push.go
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	pushWorkers   = 4
+	pushQueueSize = 1024
+	// pushClaimRetention is how long delivery claims are kept to catch
+	// redelivered Kafka messages and other replicas.
+	pushClaimRetention = 7 * 24 * time.Hour
+)
+
+var (
+	// pushLiveGrace gives the replica holding a device's live connection
+	// time to claim the delivery first. Tests set it to zero.
+	pushLiveGrace = 2 * time.Second
+	// pushRetryBackoff is multiplied by the attempt number.
+	pushRetryBackoff = time.Second
+
+	activePushSender pushSender // nil while Web Push is off
+	pushJobs         chan pushJob
+	vapidPublicKey   string
+
+	errInvalidPushSubscription = errors.New("invalid push subscription")
+)
+
+// PushSubscription mirrors "push_subscriptions": one browser push endpoint
+// per user and device.
+type PushSubscription struct {
+	UserID    string    `gorm:"column:user_id;primaryKey" json:"-"`
+	DeviceID  string    `gorm:"column:device_id;primaryKey" json:"device_id"`
+	Endpoint  string    `gorm:"column:endpoint" json:"endpoint"`
+	P256dh    string    `gorm:"column:p256dh" json:"-"`
+	Auth      string    `gorm:"column:auth" json:"-"`
+	CreatedAt time.Time `gorm:"column:created_at;autoCreateTime" json:"created_at"`
+	UpdatedAt time.Time `gorm:"column:updated_at;autoUpdateTime" json:"updated_at"`
+}
+
+func (PushSubscription) TableName() string {
+	return "push_subscriptions"
+}
+
+const createPushSubscriptionsTableSQL = `
+CREATE TABLE IF NOT EXISTS push_subscriptions (
+  user_id    VARCHAR(255) NOT NULL,
+  device_id  VARCHAR(255) NOT NULL,
+  endpoint   VARCHAR(1024) NOT NULL,
+  p256dh     VARCHAR(255) NOT NULL,
+  auth       VARCHAR(255) NOT NULL,
+  created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  updated_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  PRIMARY KEY (user_id, device_id)
+)`
+
+const createPushDeliveriesTableSQL = `
+CREATE TABLE IF NOT EXISTS push_deliveries (
+  dedupe_key VARCHAR(512) NOT NULL PRIMARY KEY,
+  created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  KEY idx_push_deliveries_created (created_at)
+)`
+
+// pushMessage is the JSON the service worker receives.
+type pushMessage struct {
+	Title string                 `json:"title"`
+	Body  string                 `json:"body"`
+	Tag   string                 `json:"tag"`
+	Data  map[string]interface{} `json:"data"`
+}
+
+// pushJob is one message for every push-subscribed device of a trainer.
+type pushJob struct {
+	Username  string
+	DedupeKey string
+	Message   pushMessage
+}
+
+// initPush loads the VAPID keys and starts the push workers when Web Push
+// is enabled.
+func initPush() {
+	if !config.Events.PushEnabled {
+		return
+	}
+	keys, err := parseVAPIDKeys(config.Events.VAPIDPrivateKey, config.Events.VAPIDPublicKey, config.Events.VAPIDSubject)
+	if err != nil {
+		logrus.Fatalf("Web Push is enabled but the VAPID keys are unusable: %v", err)
+	}
+	for _, ddl := range []string{createPushSubscriptionsTableSQL, createPushDeliveriesTableSQL} {
+		if err := db.Exec(ddl).Error; err != nil {
+			logrus.Fatalf("Failed to prepare push schema: %v", err)
+		}
+	}
+	vapidPublicKey = keys.publicB64
+	startPushWorkers(newWebPushSender(keys))
+	go prunePushClaims()
+	logrus.Info("Web Push delivery enabled")
+}
+
+func startPushWorkers(sender pushSender) {
+	activePushSender = sender
+	pushJobs = make(chan pushJob, pushQueueSize)
+	for i := 0; i < pushWorkers; i++ {
+		go func() {
+			for job := range pushJobs {
+				deliverPushJob(job)
+			}
+		}()
+	}
+}
+
+func prunePushClaims() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := db.Exec("DELETE FROM push_deliveries WHERE created_at < ?", time.Now().Add(-pushClaimRetention)).Error; err != nil {
+			logrus.Warnf("Failed to prune push delivery claims: %v", err)
+		}
+	}
+}
+
+// pushTradeStatusText is the notification shown for each trade status.
+// Statuses missing here (countered, deleted) do not push: a counter-offer
+// pushes as its own proposal.
+var pushTradeStatusText = map[string]struct{ title, body string }{
+	"proposed":  {"New trade proposal", "%s wants to trade with you"},
+	"pending":   {"Trade accepted", "%s accepted your trade"},
+	"completed": {"Trade completed", "Your trade with %s is complete"},
+	"cancelled": {"Trade cancelled", "Your trade with %s was cancelled"},
+	"denied":    {"Trade declined", "%s declined your trade"},
+	"expired":   {"Trade expired", "Your trade with %s expired"},
+}
+
+// storagePushEvents are the storage-originated events that change a
+// trade's status, mapped to whether both sides hear about it: a trainer's
+// change skips the trainer, a scheduler change has nobody to skip. The
+// rest (repricing, ratings) only restate a status.
+var storagePushEvents = map[string]bool{
+	"trade_status_changed": false,
+	"trade_expired":        true,
+	"trade_auto_cancelled": true,
+}
+
+// tradePushJobs picks who to notify about the trades in one message. Only
+// storage's events push: a client's tradeUpdates have not been validated
+// yet, and storage republishes the changes it accepts as
+// trade_status_changed.
+func tradePushJobs(data map[string]interface{}, tradeMap map[string]interface{}, senderUsername string) []pushJob {
+	if source, _ := data["source"].(string); source != "storage" {
+		return nil
+	}
+	event, _ := data["event"].(string)
+	bothSides, ok := storagePushEvents[event]
+	if !ok {
+		return nil
+	}
+	if bothSides {
+		senderUsername = ""
+	}
+
+	var jobs []pushJob
+	for _, tradeID := range sortedKeys(tradeMap) {
+		td, ok := tradeMap[tradeID].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		status, _ := td["trade_status"].(string)
+		text, ok := pushTradeStatusText[status]
+		if !ok {
+			continue
+		}
+		proposer, _ := td["username_proposed"].(string)
+		accepter, _ := td["username_accepting"].(string)
+
+		recipients := []string{proposer, accepter}
+		switch status {
+		case "proposed":
+			recipients = []string{accepter}
+		case "pending":
+			recipients = []string{proposer}
+		}
+		for _, recipient := range recipients {
+			if recipient == "" || recipient == senderUsername {
+				continue
+			}
+			other := proposer
+			if recipient == proposer {
+				other = accepter
+			}
+			jobs = append(jobs, pushJob{
+				Username:  recipient,
+				DedupeKey: fmt.Sprintf("trade:%s:%s:%s", tradeID, status, recipient),
+				Message: pushMessage{
+					Title: text.title,
+					Body:  fmt.Sprintf(text.body, other),
+					Tag:   "trade-" + tradeID,
+					Data: map[string]interface{}{
+						"trade_id":     tradeID,
+						"trade_status": status,
+						"url":          "/trades/" + url.PathEscape(tradeID),
+					},
+				},
+			})
+		}
+	}
+	return jobs
+}
+
+// enqueueTradePushes hands a message's trade pushes to the workers without
+// blocking the consumer; a full queue drops them.
+func enqueueTradePushes(data map[string]interface{}, tradeMap map[string]interface{}, senderUsername string) {
+	if activePushSender == nil || len(tradeMap) == 0 {
+		return
+	}
+	for _, job := range tradePushJobs(data, tradeMap, senderUsername) {
+		select {
+		case pushJobs <- job:
+		default:
+			pushDeliveriesTotal.WithLabelValues("dropped").Inc()
+			logrus.Warnf("Push queue full; dropped %s", job.DedupeKey)
+		}
+	}
+}
+
+// deviceConnected reports whether the device has a live SSE or WebSocket
+// connection on this replica.
+func deviceConnected(userID, deviceID string) bool {
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+	for _, c := range clients {
+		if c.Connected && c.UserID == userID && c.DeviceID == deviceID {
+			return true
+		}
+	}
+	return false
+}
+
+// deliverPushJob sends a job to each of the trainer's subscribed devices
+// that is not connected. Devices connected here already got the event
+// live, so their delivery is claimed without a push.
+func deliverPushJob(job pushJob) {
+	userID, err := getUserIDByUsername(job.Username)
+	if err != nil {
+		logrus.Warnf("Skipping push for %s: %v", job.Username, err)
+		return
+	}
+	var subs []PushSubscription
+	if err := db.Where("user_id = ?", userID).Find(&subs).Error; err != nil {
+		logrus.Errorf("Failed to load push subscriptions for user %s: %v", userID, err)
+		return
+	}
+
+	payload, err := json.Marshal(job.Message)
+	if err != nil {
+		logrus.Errorf("Error marshalling push %s: %v", job.DedupeKey, err)
+		return
+	}
+
+	var offline []PushSubscription
+	for _, sub := range subs {
+		if deviceConnected(userID, sub.DeviceID) {
+			if claimed, _ := claimPushDelivery(job.DedupeKey + ":" + sub.DeviceID); claimed {
+				pushDeliveriesTotal.WithLabelValues("live").Inc()
+			}
+			continue
+		}
+		offline = append(offline, sub)
+	}
+	if len(offline) == 0 {
+		return
+	}
+
+	send := func() {
+		for _, sub := range offline {
+			sendPushWithRetry(sub, job.DedupeKey+":"+sub.DeviceID, payload)
+		}
+	}
+	if pushLiveGrace <= 0 {
+		send()
+		return
+	}
+	time.AfterFunc(pushLiveGrace, send)
+}
+
+// claimPushDelivery records that this replica handles key. Only the first
+// claim wins, so every replica can consume every message.
+func claimPushDelivery(key string) (bool, error) {
+	res := db.Exec("INSERT IGNORE INTO push_deliveries (dedupe_key) VALUES (?)", key)
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected > 0, nil
+}
+
+// sendPushWithRetry retries network errors, 429 and 5xx with a linear
+// backoff, and removes subscriptions the push service reports gone.
+func sendPushWithRetry(sub PushSubscription, key string, payload []byte) {
+	claimed, err := claimPushDelivery(key)
+	if err != nil {
+		// Better a duplicate push than a lost one.
+		logrus.Warnf("Failed to claim push %s, sending anyway: %v", key, err)
+	} else if !claimed {
+		pushDeliveriesTotal.WithLabelValues("duplicate").Inc()
+		return
+	}
+
+	ttl := time.Duration(config.Events.PushTTLSeconds) * time.Second
+	for attempt := 1; attempt <= config.Events.PushMaxAttempts; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		status, err := activePushSender.Send(ctx, sub, payload, ttl)
+		cancel()
+
+		switch {
+		case err == nil && status >= 200 && status < 300:
+			pushDeliveriesTotal.WithLabelValues("sent").Inc()
+			return
+		case status == http.StatusNotFound || status == http.StatusGone || errors.Is(err, errInvalidPushSubscription):
+			pruneSubscription(sub)
+			pushDeliveriesTotal.WithLabelValues("pruned").Inc()
+			return
+		case errors.Is(err, errPushPayloadTooLarge) || (err == nil && status != http.StatusTooManyRequests && status < 500):
+			pushDeliveriesTotal.WithLabelValues("failed").Inc()
+			logrus.Warnf("Push %s rejected with status %d: %v", key, status, err)
+			return
+		}
+
+		logrus.Warnf("Push %s attempt %d/%d failed (status %d): %v", key, attempt, config.Events.PushMaxAttempts, status, err)
+		if attempt < config.Events.PushMaxAttempts {
+			time.Sleep(time.Duration(attempt) * pushRetryBackoff)
+		}
+	}
+	pushDeliveriesTotal.WithLabelValues("failed").Inc()
+}
+
+// pruneSubscription removes an expired subscription unless the device has
+// registered a new endpoint meanwhile.
+func pruneSubscription(sub PushSubscription) {
+	if err := db.Where("user_id = ? AND device_id = ? AND endpoint = ?", sub.UserID, sub.DeviceID, sub.Endpoint).
+		Delete(&PushSubscription{}).Error; err != nil {
+		logrus.Warnf("Failed to prune push subscription of user %s device %s: %v", sub.UserID, sub.DeviceID, err)
+		return
+	}
+	logrus.Infof("Pruned expired push subscription of user %s device %s", sub.UserID, sub.DeviceID)
+}
+
+/* -------------------------------------------------------------------------- */
+/*  HTTP                                                                      */
+/* -------------------------------------------------------------------------- */
+
+// getVAPIDPublicKey gives browsers the applicationServerKey to subscribe
+// with.
+func getVAPIDPublicKey(c *fiber.Ctx) error {
+	if activePushSender == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Web Push is not enabled"})
+	}
+	return c.JSON(fiber.Map{"public_key": vapidPublicKey})
+}
+
+// pushSubscriptionBody is what PushSubscription.toJSON() returns in the
+// browser.
+type pushSubscriptionBody struct {
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		P256dh string `json:"p256dh"`
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+}
+
+// pushEndpointAllowed reports whether endpoint may be posted to: https on
+// the default port, with no credentials, at a configured push service. The
+// service posts to stored endpoints, so anything else would let a client
+// aim it at internal hosts.
+func pushEndpointAllowed(u *url.URL) bool {
+	if u.Scheme != "https" || u.User != nil || (u.Port() != "" && u.Port() != "443") {
+		return false
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "" || net.ParseIP(host) != nil {
+		return false
+	}
+	for _, allowed := range config.Events.PushEndpointHosts {
+		if host == allowed || strings.HasSuffix(host, "."+allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b pushSubscriptionBody) validate() error {
+	u, err := url.Parse(b.Endpoint)
+	if err != nil || u.Scheme != "https" || u.Host == "" || len(b.Endpoint) > 1024 {
+		return errors.New("endpoint must be an https URL")
+	}
+	if !pushEndpointAllowed(u) {
+		return errors.New("endpoint is not a known push service")
+	}
+	raw, err := decodeBase64URL(b.Keys.P256dh)
+	if err != nil || len(raw) != 65 || raw[0] != 0x04 {
+		return errors.New("keys.p256dh must be an uncompressed P-256 point")
+	}
+	auth, err := decodeBase64URL(b.Keys.Auth)
+	if err != nil || len(auth) != 16 {
+		return errors.New("keys.auth must be 16 bytes")
+	}
+	return nil
+}
+
+// putPushSubscription stores the device's subscription, replacing any
+// older one. An endpoint belongs to one browser profile, so it is taken
+// away from whoever registered it before.
+func putPushSubscription(c *fiber.Ctx) error {
+	if activePushSender == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Web Push is not enabled"})
+	}
+	userID, _ := c.Locals("user_id").(string)
+	deviceID := strings.TrimSpace(c.Query("device_id"))
+	if deviceID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Missing device_id"})
+	}
+
+	var body pushSubscriptionBody
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid subscription"})
+	}
+	if err := body.validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if err := db.Where("endpoint = ? AND NOT (user_id = ? AND device_id = ?)", body.Endpoint, userID, deviceID).
+		Delete(&PushSubscription{}).Error; err != nil {
+		logrus.Errorf("Failed to release push endpoint for user %s: %v", userID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to save subscription"})
+	}
+	sub := PushSubscription{UserID: userID, DeviceID: deviceID, Endpoint: body.Endpoint, P256dh: body.Keys.P256dh, Auth: body.Keys.Auth}
+	if err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "device_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"endpoint", "p256dh", "auth", "updated_at"}),
+	}).Create(&sub).Error; err != nil {
+		logrus.Errorf("Failed to save push subscription for user %s device %s: %v", userID, deviceID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to save subscription"})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"device_id": deviceID, "endpoint": body.Endpoint})
+}
+
+func deletePushSubscription(c *fiber.Ctx) error {
+	if activePushSender == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Web Push is not enabled"})
+	}
+	userID, _ := c.Locals("user_id").(string)
+	deviceID := strings.TrimSpace(c.Query("device_id"))
+	if deviceID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Missing device_id"})
+	}
+	if err := db.Where("user_id = ? AND device_id = ?", userID, deviceID).Delete(&PushSubscription{}).Error; err != nil {
+		logrus.Errorf("Failed to delete push subscription for user %s device %s: %v", userID, deviceID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete subscription"})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
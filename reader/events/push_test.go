@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+)
+
+type fakeSender struct {
+	statuses []int
+	calls    int
+}
+
+func (f *fakeSender) Send(_ context.Context, _ PushSubscription, _ []byte, _ time.Duration) (int, error) {
+	status := f.statuses[f.calls]
+	f.calls++
+	if status == 0 {
+		return 0, errors.New("connection reset")
+	}
+	return status, nil
+}
+
+func withPushSender(t *testing.T, sender pushSender) {
+	t.Helper()
+	prevSender, prevBackoff := activePushSender, pushRetryBackoff
+	prevConfig := config
+	t.Cleanup(func() {
+		activePushSender, pushRetryBackoff = prevSender, prevBackoff
+		config = prevConfig
+	})
+	activePushSender = sender
+	pushRetryBackoff = 0
+	config.Events.PushTTLSeconds = 60
+	config.Events.PushMaxAttempts = 3
+}
+
+func TestTradePushJobs_RecipientRules(t *testing.T) {
+	tradeMap := map[string]interface{}{
+		"t-1": map[string]interface{}{"trade_status": "proposed", "username_proposed": "alice", "username_accepting": "bob"},
+		"t-2": map[string]interface{}{"trade_status": "pending", "username_proposed": "carol", "username_accepting": "alice"},
+		"t-3": map[string]interface{}{"trade_status": "countered", "username_proposed": "alice", "username_accepting": "dave"},
+	}
+	if got := tradePushJobs(map[string]interface{}{}, tradeMap, "alice"); len(got) != 0 {
+		t.Fatalf("expected a client's own payload never to push, got %+v", got)
+	}
+	accepted := map[string]interface{}{"source": "storage", "event": "trade_status_changed"}
+	jobs := tradePushJobs(accepted, tradeMap, "alice")
+	if len(jobs) != 2 {
+		t.Fatalf("expected two pushes, got %+v", jobs)
+	}
+	if jobs[0].Username != "bob" || jobs[0].DedupeKey != "trade:t-1:proposed:bob" || jobs[0].Message.Body != "alice wants to trade with you" {
+		t.Fatalf("unexpected proposal push: %+v", jobs[0])
+	}
+	if jobs[1].Username != "carol" || jobs[1].Message.Data["url"] != "/trades/t-2" {
+		t.Fatalf("unexpected acceptance push: %+v", jobs[1])
+	}
+
+	expired := map[string]interface{}{
+		"t-4": map[string]interface{}{"trade_status": "expired", "username_proposed": "alice", "username_accepting": "bob"},
+	}
+	storage := map[string]interface{}{"source": "storage", "event": "trade_expired"}
+	if got := tradePushJobs(storage, expired, "alice"); len(got) != 2 {
+		t.Fatalf("expected a scheduler expiry to reach both sides, got %+v", got)
+	}
+	storage["event"] = "trade_repriced"
+	if got := tradePushJobs(storage, expired, "alice"); len(got) != 0 {
+		t.Fatalf("expected storage restatements not to push, got %+v", got)
+	}
+}
+
+func TestSendPushWithRetry_RetriesThenPrunesGoneSubscription(t *testing.T) {
+	gdb, mock, sqlDB := setupMockGormDB(t)
+	defer sqlDB.Close()
+	origDB := db
+	defer func() { db = origDB }()
+	db = gdb
+
+	sender := &fakeSender{statuses: []int{http.StatusServiceUnavailable, 0, http.StatusGone}}
+	withPushSender(t, sender)
+
+	sub := PushSubscription{UserID: "u-1", DeviceID: "d-1", Endpoint: "https://push.example.com/x"}
+	mock.ExpectExec(regexp.QuoteMeta("INSERT IGNORE INTO push_deliveries (dedupe_key) VALUES (?)")).
+		WithArgs("trade:t-1:proposed:bob:d-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `push_subscriptions` WHERE user_id = ? AND device_id = ? AND endpoint = ?")).
+		WithArgs("u-1", "d-1", "https://push.example.com/x").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	sendPushWithRetry(sub, "trade:t-1:proposed:bob:d-1", []byte(`{}`))
+
+	if sender.calls != 3 {
+		t.Fatalf("expected 3 attempts, got %d", sender.calls)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet sql expectations: %v", err)
+	}
+}
+
+func TestSendPushWithRetry_SkipsDeliveryClaimedElsewhere(t *testing.T) {
+	gdb, mock, sqlDB := setupMockGormDB(t)
+	defer sqlDB.Close()
+	origDB := db
+	defer func() { db = origDB }()
+	db = gdb
+
+	sender := &fakeSender{}
+	withPushSender(t, sender)
+
+	mock.ExpectExec(regexp.QuoteMeta("INSERT IGNORE INTO push_deliveries")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	sendPushWithRetry(PushSubscription{UserID: "u-1", DeviceID: "d-1"}, "trade:t-1:proposed:bob:d-1", []byte(`{}`))
+
+	if sender.calls != 0 {
+		t.Fatalf("expected no push for a delivery another replica claimed, got %d", sender.calls)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet sql expectations: %v", err)
+	}
+}
+
+func TestPutPushSubscription_RejectsInvalidKeys(t *testing.T) {
+	withPushSender(t, &fakeSender{})
+
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user_id", "u-1")
+		return c.Next()
+	})
+	app.Put("/api/push/subscription", putPushSubscription)
+
+	cases := map[string]string{
+		"missing device": `{"endpoint":"https://push.example.com/x","keys":{"p256dh":"","auth":""}}`,
+		"plain http":     `{"endpoint":"http://push.example.com/x","keys":{"p256dh":"","auth":""}}`,
+		"bad keys":       `{"endpoint":"https://push.example.com/x","keys":{"p256dh":"AAAA","auth":"AAAA"}}`,
+	}
+	for name, body := range cases {
+		target := "/api/push/subscription?device_id=d-1"
+		if name == "missing device" {
+			target = "/api/push/subscription"
+		}
+		req := httptest.NewRequest(fiber.MethodPut, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("%s: request failed: %v", name, err)
+		}
+		if resp.StatusCode != fiber.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", name, resp.StatusCode)
+		}
+	}
+}
+
+func TestPushEndpointAllowed(t *testing.T) {
+	prevConfig := config
+	t.Cleanup(func() { config = prevConfig })
+	config.Events.PushEndpointHosts = defaultPushEndpointHosts
+
+	for endpoint, want := range map[string]bool{
+		"https://fcm.googleapis.com/fcm/send/abc":                 true,
+		"https://updates.push.services.mozilla.com/wpush/v2/abc":  true,
+		"https://wns2-par02p.notify.windows.com/w/?token=abc":     true,
+		"https://web.push.apple.com/abc":                          true,
+		"https://fcm.googleapis.com:443/fcm/send/abc":             true,
+		"https://fcm.googleapis.com:8443/fcm/send/abc":            false,
+		"https://user:pw@fcm.googleapis.com/fcm/send/abc":         false,
+		"https://evilfcm.googleapis.com.attacker.example/abc":     false,
+		"https://notify.windows.com.attacker.example/abc":         false,
+		"https://127.0.0.1/abc":                                   false,
+		"https://[::1]/abc":                                       false,
+		"https://169.254.169.254/latest/meta-data":                false,
+		"https://localhost/abc":                                   false,
+		"https://metadata.google.internal/computeMetadata/v1/abc": false,
+	} {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			t.Fatalf("parse %s: %v", endpoint, err)
+		}
+		if got := pushEndpointAllowed(u); got != want {
+			t.Fatalf("%s: expected allowed=%v, got %v", endpoint, want, got)
+		}
+	}
+}
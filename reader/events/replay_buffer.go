@@ -0,0 +1,239 @@
+// replay_buffer.go
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// lastEventID is the SSE id of the latest broadcast. It is seeded from the
+// clock so ids keep increasing across restarts.
+var lastEventID atomic.Uint64
+
+func init() {
+	lastEventID.Store(uint64(time.Now().UnixMicro()))
+}
+
+func nextEventID() uint64 {
+	return lastEventID.Add(1)
+}
+
+// replayEntry is one broadcast as a given user saw it. OriginDeviceID is the
+// device that caused it, which never receives its own update.
+type replayEntry struct {
+	ID             uint64          `json:"id"`
+	OriginDeviceID string          `json:"origin_device_id"`
+	Data           json.RawMessage `json:"data"`
+	At             time.Time       `json:"at"`
+}
+
+type userReplay struct {
+	Entries []replayEntry `json:"entries"`
+	// EvictedThrough is the newest id dropped from Entries; a client behind
+	// it has a gap that cannot be replayed.
+	EvictedThrough uint64 `json:"evicted_through"`
+}
+
+// replayBuffer keeps the last few broadcasts per user so a reconnecting
+// client can catch up from its Last-Event-ID.
+type replayBuffer struct {
+	mu     sync.Mutex
+	size   int
+	maxAge time.Duration
+	users  map[string]*userReplay
+	// coveredFrom is the id after which every broadcast was recorded; ids
+	// issued before it (a previous process) are unknown.
+	coveredFrom uint64
+}
+
+var replay = newReplayBuffer(200, 15*time.Minute)
+
+func newReplayBuffer(size int, maxAge time.Duration) *replayBuffer {
+	return &replayBuffer{
+		size:        size,
+		maxAge:      maxAge,
+		users:       make(map[string]*userReplay),
+		coveredFrom: lastEventID.Load(),
+	}
+}
+
+func initReplayBuffer() {
+	replay = newReplayBuffer(config.Events.ReplayBufferSize,
+		time.Duration(config.Events.ReplayMaxAgeSeconds)*time.Second)
+
+	path := config.Events.ReplaySnapshotPath
+	if path == "" {
+		return
+	}
+	if err := replay.load(path); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logrus.Warnf("Failed to load SSE replay snapshot %s: %v", path, err)
+		}
+		return
+	}
+	// The snapshot is only complete as of the shutdown that wrote it; a
+	// crash later must not reuse it.
+	if err := os.Remove(path); err != nil {
+		logrus.Warnf("Failed to remove SSE replay snapshot %s: %v", path, err)
+	}
+	logrus.Infof("Loaded SSE replay snapshot for %d users", len(replay.users))
+}
+
+func (b *replayBuffer) add(userID string, entry replayEntry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ur := b.users[userID]
+	if ur == nil {
+		ur = &userReplay{}
+		b.users[userID] = ur
+	}
+	ur.Entries = append(ur.Entries, entry)
+	b.prune(ur, entry.At)
+}
+
+// prune drops entries over the size cap or older than maxAge.
+func (b *replayBuffer) prune(ur *userReplay, now time.Time) {
+	drop := 0
+	if over := len(ur.Entries) - b.size; over > 0 {
+		drop = over
+	}
+	for drop < len(ur.Entries) && now.Sub(ur.Entries[drop].At) > b.maxAge {
+		drop++
+	}
+	if drop == 0 {
+		return
+	}
+	ur.EvictedThrough = ur.Entries[drop-1].ID
+	ur.Entries = append(ur.Entries[:0], ur.Entries[drop:]...)
+}
+
+// since returns what userID missed after lastID, skipping deviceID's own
+// updates. ok is false when some of it is no longer buffered.
+func (b *replayBuffer) since(userID, deviceID string, lastID uint64, now time.Time) (missed []replayEntry, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if lastID < b.coveredFrom {
+		return nil, false
+	}
+	ur := b.users[userID]
+	if ur == nil {
+		return nil, true
+	}
+	b.prune(ur, now)
+	if lastID < ur.EvictedThrough {
+		return nil, false
+	}
+	for _, e := range ur.Entries {
+		if e.ID > lastID && e.OriginDeviceID != deviceID {
+			missed = append(missed, e)
+		}
+	}
+	return missed, true
+}
+
+type replaySnapshot struct {
+	CoveredFrom uint64                 `json:"covered_from"`
+	LastEventID uint64                 `json:"last_event_id"`
+	Users       map[string]*userReplay `json:"users"`
+}
+
+func (b *replayBuffer) save(path string) error {
+	b.mu.Lock()
+	snap := replaySnapshot{CoveredFrom: b.coveredFrom, LastEventID: lastEventID.Load(), Users: b.users}
+	data, err := json.Marshal(snap)
+	b.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (b *replayBuffer) load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var snap replaySnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("decode snapshot: %w", err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if snap.Users != nil {
+		b.users = snap.Users
+	}
+	b.coveredFrom = snap.CoveredFrom
+	// Keep ids monotonic even if the clock went backwards.
+	for {
+		cur := lastEventID.Load()
+		if snap.LastEventID <= cur || lastEventID.CompareAndSwap(cur, snap.LastEventID) {
+			break
+		}
+	}
+	return nil
+}
+
+// saveReplaySnapshot persists the buffer on shutdown when configured.
+func saveReplaySnapshot() {
+	path := config.Events.ReplaySnapshotPath
+	if path == "" {
+		return
+	}
+	if err := replay.save(path); err != nil {
+		logrus.Errorf("Failed to save SSE replay snapshot %s: %v", path, err)
+		return
+	}
+	logrus.Infof("Saved SSE replay snapshot to %s", path)
+}
+
+// formatEventID scopes an event id to this replica. Ids are per process, so
+// a client that resumes on another replica must be told to resync instead of
+// having its id compared against an unrelated sequence.
+func formatEventID(id uint64) string {
+	if config.Events.InstanceID == "" {
+		return strconv.FormatUint(id, 10)
+	}
+	return strconv.FormatUint(id, 10) + "@" + config.Events.InstanceID
+}
+
+// parseEventID reverses formatEventID. ok is false for ids this replica did
+// not issue.
+func parseEventID(raw string) (id uint64, ok bool) {
+	num, instance, scoped := strings.Cut(raw, "@")
+	if scoped && instance != config.Events.InstanceID {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(num, 10, 64)
+	return id, err == nil
+}
+
+// formatSSEFrame renders one SSE message. An empty name is the default
+// "message" event; id 0 leaves the client's Last-Event-ID unchanged.
+func formatSSEFrame(id uint64, name string, data []byte) []byte {
+	frame := make([]byte, 0, len(data)+64)
+	if id != 0 {
+		frame = fmt.Appendf(frame, "id: %s\n", formatEventID(id))
+	}
+	if name != "" {
+		frame = fmt.Appendf(frame, "event: %s\n", name)
+	}
+	return fmt.Appendf(frame, "data: %s\n\n", data)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReplayBuffer_ReplaysMissedEventsExceptOwnDevice(t *testing.T) {
+	b := newReplayBuffer(10, time.Hour)
+	now := time.Now()
+	first := nextEventID()
+	b.add("u-1", replayEntry{ID: first, OriginDeviceID: "phone", Data: []byte(`{"n":1}`), At: now})
+	second := nextEventID()
+	b.add("u-1", replayEntry{ID: second, OriginDeviceID: "laptop", Data: []byte(`{"n":2}`), At: now})
+	third := nextEventID()
+	b.add("u-1", replayEntry{ID: third, OriginDeviceID: "phone", Data: []byte(`{"n":3}`), At: now})
+
+	missed, ok := b.since("u-1", "laptop", first, now)
+	if !ok {
+		t.Fatalf("expected replay to be possible")
+	}
+	if len(missed) != 1 || missed[0].ID != third {
+		t.Fatalf("expected only event %d, got %+v", third, missed)
+	}
+
+	if missed, ok := b.since("u-2", "laptop", third, now); !ok || len(missed) != 0 {
+		t.Fatalf("expected nothing to replay for a quiet user, got %+v ok=%v", missed, ok)
+	}
+}
+
+func TestReplayBuffer_GapRequiresResync(t *testing.T) {
+	b := newReplayBuffer(2, time.Hour)
+	now := time.Now()
+	ids := make([]uint64, 3)
+	for i := range ids {
+		ids[i] = nextEventID()
+		b.add("u-1", replayEntry{ID: ids[i], OriginDeviceID: "phone", At: now})
+	}
+
+	if _, ok := b.since("u-1", "laptop", b.coveredFrom, now); ok {
+		t.Fatalf("expected evicted event to force a resync")
+	}
+	if missed, ok := b.since("u-1", "laptop", ids[0], now); !ok || len(missed) != 2 {
+		t.Fatalf("expected the two buffered events, got %+v ok=%v", missed, ok)
+	}
+	if _, ok := b.since("u-1", "laptop", b.coveredFrom-1, now); ok {
+		t.Fatalf("expected ids from before this process to force a resync")
+	}
+}
+
+func TestReplayBuffer_DropsExpiredEvents(t *testing.T) {
+	b := newReplayBuffer(10, time.Minute)
+	old := time.Now().Add(-2 * time.Minute)
+	id := nextEventID()
+	b.add("u-1", replayEntry{ID: id, OriginDeviceID: "phone", At: old})
+
+	if _, ok := b.since("u-1", "laptop", id-1, time.Now()); ok {
+		t.Fatalf("expected expired event to force a resync")
+	}
+	if missed, ok := b.since("u-1", "laptop", id, time.Now()); !ok || len(missed) != 0 {
+		t.Fatalf("expected a caught-up client to need nothing, got %+v ok=%v", missed, ok)
+	}
+}
+
+func TestReplayBuffer_SnapshotRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "replay.json")
+	b := newReplayBuffer(10, time.Hour)
+	id := nextEventID()
+	b.add("u-1", replayEntry{ID: id, OriginDeviceID: "phone", Data: []byte(`{"pokemon":{}}`), At: time.Now()})
+	if err := b.save(path); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	restored := newReplayBuffer(10, time.Hour)
+	if err := restored.load(path); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	missed, ok := restored.since("u-1", "laptop", id-1, time.Now())
+	if !ok || len(missed) != 1 || string(missed[0].Data) != `{"pokemon":{}}` {
+		t.Fatalf("expected restored event, got %+v ok=%v", missed, ok)
+	}
+	if nextEventID() <= id {
+		t.Fatalf("expected ids to stay monotonic after load")
+	}
+}
+
+func TestFormatSSEFrame(t *testing.T) {
+	prev := config.Events.InstanceID
+	t.Cleanup(func() { config.Events.InstanceID = prev })
+	config.Events.InstanceID = "events-a"
+
+	if got := string(formatSSEFrame(42, "", []byte(`{}`))); got != "id: 42@events-a\ndata: {}\n\n" {
+		t.Fatalf("unexpected frame %q", got)
+	}
+	if got := string(formatSSEFrame(0, "resync", []byte(`{}`))); got != "event: resync\ndata: {}\n\n" {
+		t.Fatalf("unexpected frame %q", got)
+	}
+}
+
+func TestParseEventID_RejectsOtherReplicas(t *testing.T) {
+	prev := config.Events.InstanceID
+	t.Cleanup(func() { config.Events.InstanceID = prev })
+	config.Events.InstanceID = "events-a"
+
+	if id, ok := parseEventID("42@events-a"); !ok || id != 42 {
+		t.Fatalf("expected own id to parse, got %d ok=%v", id, ok)
+	}
+	if _, ok := parseEventID("42@events-b"); ok {
+		t.Fatalf("expected another replica's id to be rejected")
+	}
+	if _, ok := parseEventID("nope"); ok {
+		t.Fatalf("expected garbled id to be rejected")
+	}
+}
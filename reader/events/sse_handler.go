@@ -4,8 +4,9 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
-	"sync"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -30,20 +31,44 @@ func sseHandler(c *fiber.Ctx) error {
 		}
 	}
 
-	// Create a unique client ID
-	clientID := fmt.Sprintf("%s:%s", userID, deviceID)
+	// Optional typed events; without types the stream keeps sending the
+	// merged unnamed frame.
+	types, err := parseEventFilter([]string{c.Query("types")})
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("Unknown event type")
+	}
+
+	// Every tab gets its own registry entry
+	clientID := newClientID(userID, deviceID, transportSSE)
 
 	// Create a new client
 	client := &Client{
 		UserID:    userID,
 		DeviceID:  deviceID,
-		Channel:   make(chan []byte),
+		Transport: transportSSE,
+		Queue:     newClientQueue(),
 		Connected: true,
+		Types:     types,
 	}
 
-	// Add client to the clients map
+	// Resume point: browsers send Last-Event-ID on automatic reconnects;
+	// clients that reopen the stream themselves pass last_event_id.
+	lastID, resuming := parseLastEventID(c)
+
+	// Register and snapshot the replay under the same lock the broadcaster
+	// holds, so every event is either replayed or delivered live, never both.
+	var missed []replayEntry
+	replayOK := true
 	clientsMutex.Lock()
-	clients[clientID] = client
+	if err := admitClientLocked(userID, deviceID, time.Now()); err != nil {
+		clientsMutex.Unlock()
+		logrus.Warnf("Refused SSE connection for user=%s device=%s: %v", userID, deviceID, err)
+		return c.Status(admissionStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	registerClientLocked(clientID, client)
+	if resuming {
+		missed, replayOK = replay.since(userID, deviceID, lastID, time.Now())
+	}
 	clientsMutex.Unlock()
 
 	// Set necessary headers for SSE
@@ -54,78 +79,131 @@ func sseHandler(c *fiber.Ctx) error {
 	// Use SetBodyStreamWriter for streaming
 	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
 		// Send initial event to confirm connection
-		if _, err := fmt.Fprintf(w, "event: connected\ndata: Connected to SSE stream\n\n"); err != nil {
+		n, err := fmt.Fprintf(w, "event: connected\ndata: Connected to SSE stream\n\n")
+		client.BytesSent.Add(int64(n))
+		if err != nil {
 			handleClientDisconnect(clientID, client)
 			return
 		}
+		if resuming {
+			if err := writeReplay(w, client, types, lastID, missed, replayOK); err != nil {
+				handleClientDisconnect(clientID, client)
+				return
+			}
+		}
 		if err := w.Flush(); err != nil {
 			handleClientDisconnect(clientID, client)
 			return
 		}
 
-		// Start the heartbeat goroutine to keep the connection alive
-		heartbeatDone := make(chan struct{})
-		var hbOnce sync.Once
-		closeHeartbeat := func() {
-			hbOnce.Do(func() {
-				close(heartbeatDone)
-			})
-		}
-
-		go func() {
-			ticker := time.NewTicker(30 * time.Second)
-			defer ticker.Stop()
-			for {
-				select {
-				case <-ticker.C:
-					// Send a heartbeat comment (SSE comments start with ':')
-					if _, err := fmt.Fprintf(w, ": heartbeat\n\n"); err != nil {
-						closeHeartbeat()
-						return
-					}
-					if err := w.Flush(); err != nil {
-						closeHeartbeat()
+		// One goroutine owns the writer: queued frames and heartbeats are
+		// interleaved here rather than written concurrently.
+		defer handleClientDisconnect(clientID, client)
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-client.Queue.notify:
+				for _, f := range client.Queue.drain() {
+					n, err := w.Write(formatSSEFrame(f.ID, f.Name, f.Data))
+					client.BytesSent.Add(int64(n))
+					if err != nil {
 						return
 					}
-				case <-heartbeatDone:
+				}
+			case <-ticker.C:
+				// Send a heartbeat comment (SSE comments start with ':')
+				n, err := fmt.Fprintf(w, ": heartbeat\n\n")
+				client.BytesSent.Add(int64(n))
+				if err != nil {
 					return
 				}
-			}
-		}()
-
-		// Listen for messages from the client channel
-		for msg := range client.Channel {
-			if _, err := fmt.Fprintf(w, "data: %s\n\n", msg); err != nil {
-				handleClientDisconnect(clientID, client)
-				closeHeartbeat()
+			case <-client.Queue.done:
+				// Closed by disconnect or by the overflow policy.
 				return
 			}
 			if err := w.Flush(); err != nil {
-				handleClientDisconnect(clientID, client)
-				closeHeartbeat()
 				return
 			}
 		}
-
-		// When the channel is closed, signal the heartbeat goroutine to stop
-		closeHeartbeat()
 	})
 
 	// Return nil to keep the connection open
 	return nil
 }
 
+func parseLastEventID(c *fiber.Ctx) (uint64, bool) {
+	raw := strings.TrimSpace(c.Get("Last-Event-ID"))
+	if raw == "" {
+		raw = strings.TrimSpace(c.Query("last_event_id"))
+	}
+	return parseResumeID(raw)
+}
+
+// parseResumeID reads a client's resume point. resuming is false when there
+// is none.
+func parseResumeID(raw string) (id uint64, resuming bool) {
+	if raw == "" {
+		return 0, false
+	}
+	id, ok := parseEventID(raw)
+	if !ok {
+		// An id we cannot place (garbled, or from another replica) is a
+		// gap we cannot fill.
+		return 0, true
+	}
+	return id, true
+}
+
+// writeReplay sends the events a reconnecting client missed, or a "resync"
+// event telling it to fall back to /api/getUpdates when the gap is too old.
+func writeReplay(w *bufio.Writer, client *Client, types *eventFilter, lastID uint64, missed []replayEntry, ok bool) error {
+	for _, f := range replayFrames(client.UserID, types, lastID, missed, ok) {
+		n, err := w.Write(formatSSEFrame(f.ID, f.Name, f.Data))
+		client.BytesSent.Add(int64(n))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replayFrames turns a replay lookup into the frames to send first, typed
+// when the client asked for types.
+func replayFrames(userID string, types *eventFilter, lastID uint64, missed []replayEntry, ok bool) []sseFrame {
+	if !ok {
+		sseResyncsTotal.Inc()
+		data, _ := json.Marshal(map[string]interface{}{
+			"reason":        "replay_gap",
+			"last_event_id": formatEventID(lastID),
+		})
+		return []sseFrame{{Name: "resync", Data: data}}
+	}
+	frames := make([]sseFrame, 0, len(missed))
+	for _, e := range missed {
+		if types != nil {
+			frames = append(frames, typedFrames(e.ID, userChannel(userID), typedEventsFromPayload(e.Data, e.At), types)...)
+			continue
+		}
+		frames = append(frames, sseFrame{ID: e.ID, Channel: userChannel(userID), Data: e.Data})
+	}
+	sseReplayedEventsTotal.Add(float64(len(missed)))
+	return frames
+}
+
 func handleClientDisconnect(clientID string, client *Client) {
 	clientsMutex.Lock()
 	// Mark client as disconnected and remove from the clients map
 	client.Connected = false
-	delete(clients, clientID)
+	if clients[clientID] == client {
+		delete(clients, clientID)
+		sseDisconnectionsTotal.Inc()
+	}
+	updateRegistryGaugesLocked()
 	clientsMutex.Unlock()
 
-	// Close the client channel only once using sync.Once
-	client.closeOnce.Do(func() {
-		close(client.Channel)
-	})
+	// Idempotent; wakes the writer if it is still running
+	client.Queue.close()
 
 	logrus.Infof("Client disconnected: UserID=%s, DeviceID=%s", client.UserID, client.DeviceID)
 }
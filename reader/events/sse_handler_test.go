@@ -49,7 +49,7 @@ func TestHandleClientDisconnect_Idempotent(t *testing.T) {
 	client := &Client{
 		UserID:    "u-1",
 		DeviceID:  "d-1",
-		Channel:   make(chan []byte),
+		Queue:     newOutboundQueue(4, 0, overflowPolicyResync),
 		Connected: true,
 	}
 	clients["u-1:d-1"] = client
@@ -66,25 +66,26 @@ func TestHandleClientDisconnect_Idempotent(t *testing.T) {
 	handleClientDisconnect("u-1:d-1", client)
 
 	select {
-	case _, ok := <-client.Channel:
-		if ok {
-			t.Fatalf("expected channel to be closed")
-		}
+	case <-client.Queue.done:
 	default:
-		// channel may already be drained, but should still be closed.
-		select {
-		case _, ok := <-client.Channel:
-			if ok {
-				t.Fatalf("expected channel to be closed")
-			}
-		default:
-			// no buffered value and non-blocking read defaulted; attempt send to verify closed.
-			defer func() {
-				if r := recover(); r == nil {
-					t.Fatalf("expected send on closed channel panic")
-				}
-			}()
-			client.Channel <- []byte("x")
-		}
+		t.Fatalf("expected queue to be closed")
+	}
+	if got := client.Queue.push(sseFrame{ID: 1, Data: []byte(`{}`)}); got != pushClosed {
+		t.Fatalf("expected push after close to be refused, got %v", got)
+	}
+}
+
+func TestHandleClientDisconnect_KeepsReplacementClient(t *testing.T) {
+	origClients := clients
+	clients = make(map[string]*Client)
+	defer func() { clients = origClients }()
+
+	stale := &Client{UserID: "u-1", DeviceID: "d-1", Queue: newOutboundQueue(4, 0, overflowPolicyResync)}
+	fresh := &Client{UserID: "u-1", DeviceID: "d-1", Queue: newOutboundQueue(4, 0, overflowPolicyResync), Connected: true}
+	clients["u-1:d-1"] = fresh
+
+	handleClientDisconnect("u-1:d-1", stale)
+	if clients["u-1:d-1"] != fresh {
+		t.Fatalf("expected the reconnected client to stay registered")
 	}
 }
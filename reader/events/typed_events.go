@@ -0,0 +1,310 @@
+// typed_events.go
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// eventSchemaVersion is the "v" of every typed event. Bump it, and add a
+// schemas/v<N> directory, for any change that is not purely additive.
+const eventSchemaVersion = 1
+
+const (
+	eventPokemonUpserted = "pokemon.upserted"
+	eventPokemonDeleted  = "pokemon.deleted"
+	eventTradeProposed   = "trade.proposed"
+	eventTradeAccepted   = "trade.accepted"
+	eventTradeCompleted  = "trade.completed"
+	eventTradeCancelled  = "trade.cancelled"
+	eventTradeReminder   = "trade.reminder"
+	eventProfileUpdated  = "profile.updated"
+	eventTagUpdated      = "tag.updated"
+
+	eventTradeMessageCreated = "trade.message_created"
+	eventTradeMessagesRead   = "trade.messages_read"
+	eventNotificationCreated = "notification.created"
+	eventPresenceChanged     = "presence.changed"
+)
+
+var eventTypes = []string{
+	eventPokemonUpserted, eventPokemonDeleted,
+	eventTradeProposed, eventTradeAccepted, eventTradeCompleted, eventTradeCancelled, eventTradeReminder,
+	eventProfileUpdated, eventTagUpdated,
+	eventTradeMessageCreated, eventTradeMessagesRead,
+	eventNotificationCreated,
+	eventPresenceChanged,
+}
+
+// tradeStatusEvents maps trade_status to its event. Every way a trade ends
+// without completing is a cancellation; the status is kept as the reason.
+var tradeStatusEvents = map[string]string{
+	"proposed":  eventTradeProposed,
+	"pending":   eventTradeAccepted,
+	"completed": eventTradeCompleted,
+	"cancelled": eventTradeCancelled,
+	"denied":    eventTradeCancelled,
+	"deleted":   eventTradeCancelled,
+	"expired":   eventTradeCancelled,
+	"countered": eventTradeCancelled,
+}
+
+var errUnknownEventType = errors.New("unknown event type")
+
+// typedEvent is one rendered event. Key identifies the entity, so queued
+// events for the same entity can be coalesced.
+type typedEvent struct {
+	Type string
+	Key  string
+	Data []byte
+}
+
+type typedEnvelope struct {
+	V          int         `json:"v"`
+	Type       string      `json:"type"`
+	Key        string      `json:"key"`
+	OccurredAt int64       `json:"occurred_at"`
+	Data       interface{} `json:"data"`
+}
+
+// eventFilter selects the typed events a client wants. A nil filter means
+// the client takes the legacy merged frame instead.
+type eventFilter struct {
+	all      bool
+	exact    map[string]bool
+	prefixes []string
+}
+
+// parseEventFilter reads a comma-separated list of event types, "trade.*"
+// style prefixes, or "*". An empty list returns nil (legacy frames).
+func parseEventFilter(raw []string) (*eventFilter, error) {
+	f := &eventFilter{exact: make(map[string]bool)}
+	for _, item := range raw {
+		for _, t := range strings.Split(item, ",") {
+			t = strings.TrimSpace(t)
+			switch {
+			case t == "":
+				continue
+			case t == "*":
+				f.all = true
+			case strings.HasSuffix(t, ".*"):
+				prefix := strings.TrimSuffix(t, "*")
+				if !knownEventPrefix(prefix) {
+					return nil, errUnknownEventType
+				}
+				f.prefixes = append(f.prefixes, prefix)
+			default:
+				if !knownEventType(t) {
+					return nil, errUnknownEventType
+				}
+				f.exact[t] = true
+			}
+		}
+	}
+	if !f.all && len(f.exact) == 0 && len(f.prefixes) == 0 {
+		return nil, nil
+	}
+	return f, nil
+}
+
+func knownEventType(t string) bool {
+	for _, known := range eventTypes {
+		if known == t {
+			return true
+		}
+	}
+	return false
+}
+
+func knownEventPrefix(prefix string) bool {
+	for _, known := range eventTypes {
+		if strings.HasPrefix(known, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *eventFilter) allows(t string) bool {
+	if f.all || f.exact[t] {
+		return true
+	}
+	for _, p := range f.prefixes {
+		if strings.HasPrefix(t, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// typedEventsFromPayload splits a merged broadcast payload into typed
+// events. It works from the payload alone so replayed events convert the
+// same way as live ones.
+func typedEventsFromPayload(payload []byte, at time.Time) []typedEvent {
+	var sections struct {
+		Pokemon         map[string]map[string]interface{} `json:"pokemon"`
+		Trade           map[string]map[string]interface{} `json:"trade"`
+		RelatedInstance map[string]interface{}            `json:"relatedInstance"`
+		Reminders       map[string]map[string]interface{} `json:"tradeReminders"`
+		Tags            map[string]map[string]interface{} `json:"tags"`
+		Profile         map[string]map[string]interface{} `json:"profile"`
+		Trainer         map[string]map[string]interface{} `json:"trainer"`
+		Notifications   map[string]map[string]interface{} `json:"notifications"`
+		TradeMessages   map[string]map[string]interface{} `json:"tradeMessages"`
+		MessageReads    map[string]map[string]interface{} `json:"tradeMessageReads"`
+		Presence        map[string]map[string]interface{} `json:"presence"`
+	}
+	if err := json.Unmarshal(payload, &sections); err != nil {
+		logrus.Warnf("Cannot derive typed events from payload: %v", err)
+		return nil
+	}
+
+	var out []typedEvent
+	add := func(eventType, key string, data interface{}) {
+		raw, err := json.Marshal(typedEnvelope{
+			V:          eventSchemaVersion,
+			Type:       eventType,
+			Key:        key,
+			OccurredAt: at.UnixMilli(),
+			Data:       data,
+		})
+		if err != nil {
+			logrus.Errorf("Error marshalling %s event for %s: %v", eventType, key, err)
+			return
+		}
+		out = append(out, typedEvent{Type: eventType, Key: key, Data: raw})
+	}
+
+	for _, id := range sortedKeys(sections.Pokemon) {
+		item := sections.Pokemon[id]
+		if !truthy(item["is_caught"]) && !truthy(item["is_wanted"]) && !truthy(item["is_for_trade"]) {
+			// Storage deletes instances that are neither caught nor listed.
+			add(eventPokemonDeleted, id, map[string]interface{}{"instance_id": id})
+			continue
+		}
+		data := map[string]interface{}{"instance_id": id, "instance": item}
+		if owner, ok := item["username"].(string); ok && owner != "" {
+			data["username"] = owner
+		}
+		add(eventPokemonUpserted, id, data)
+	}
+
+	for _, id := range sortedKeys(sections.Trade) {
+		td := sections.Trade[id]
+		status, _ := td["trade_status"].(string)
+		eventType, ok := tradeStatusEvents[status]
+		if !ok {
+			continue
+		}
+		related := make(map[string]interface{})
+		proposedIDs, acceptingIDs := tradeDataSides(td)
+		for _, instanceID := range append(proposedIDs, acceptingIDs...) {
+			if inst, ok := sections.RelatedInstance[instanceID]; ok {
+				related[instanceID] = inst
+			}
+		}
+		data := map[string]interface{}{
+			"trade_id":          id,
+			"status":            status,
+			"trade":             td,
+			"related_instances": related,
+		}
+		if eventType == eventTradeCancelled {
+			data["reason"] = status
+		}
+		add(eventType, id, data)
+	}
+
+	// Reminders carry no state change, only the trade and its deadline.
+	for _, id := range sortedKeys(sections.Reminders) {
+		data := map[string]interface{}{"trade_id": id}
+		for k, v := range sections.Reminders[id] {
+			data[k] = v
+		}
+		add(eventTradeReminder, id, data)
+	}
+
+	// Own-profile changes and trainer-channel notices both surface as
+	// profile.updated for that username.
+	profiles := make(map[string][]string)
+	for name, p := range sections.Profile {
+		profiles[name] = append(profiles[name], stringList(p["changed"])...)
+	}
+	for name := range sections.Trainer {
+		profiles[name] = append(profiles[name], "pokemon")
+	}
+	for _, name := range sortedKeys(profiles) {
+		changed := profiles[name]
+		sort.Strings(changed)
+		add(eventProfileUpdated, name, map[string]interface{}{"username": name, "changed": changed})
+	}
+
+	// Chat messages are passed through as reader lists them; read receipts
+	// are keyed by trade, so only the latest per trade is kept.
+	for _, id := range sortedKeys(sections.TradeMessages) {
+		add(eventTradeMessageCreated, id, sections.TradeMessages[id])
+	}
+	for _, id := range sortedKeys(sections.MessageReads) {
+		add(eventTradeMessagesRead, id, sections.MessageReads[id])
+	}
+
+	// Tag changes keep their operation, so deletes can be told from edits.
+	for _, id := range sortedKeys(sections.Tags) {
+		tag := sections.Tags[id]
+		add(eventTagUpdated, id, map[string]interface{}{"tag_id": id, "operation": tag["operation"], "tag": tag["tagData"]})
+	}
+
+	// Inbox rows are passed through as reader lists them.
+	for _, id := range sortedKeys(sections.Notifications) {
+		add(eventNotificationCreated, id, sections.Notifications[id])
+	}
+
+	// Trade partners' presence, keyed by username.
+	for _, name := range sortedKeys(sections.Presence) {
+		add(eventPresenceChanged, name, sections.Presence[name])
+	}
+	return out
+}
+
+// typedFrames turns typed events into queue frames for one client. Only
+// the last frame carries the broadcast id, so a client that drops halfway
+// through resumes before the broadcast rather than after it.
+func typedFrames(eventID uint64, channel string, events []typedEvent, filter *eventFilter) []sseFrame {
+	var frames []sseFrame
+	for _, e := range events {
+		if filter.allows(e.Type) {
+			frames = append(frames, sseFrame{Name: e.Type, Key: e.Key, Typed: true, Channel: channel, Data: e.Data})
+		}
+	}
+	if len(frames) > 0 {
+		frames[len(frames)-1].ID = eventID
+	}
+	return frames
+}
+
+func truthy(v interface{}) bool {
+	switch t := v.(type) {
+	case bool:
+		return t
+	case string:
+		return t == "true" || t == "1"
+	case float64:
+		return t != 0
+	}
+	return false
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseEventFilter(t *testing.T) {
+	if f, err := parseEventFilter([]string{""}); err != nil || f != nil {
+		t.Fatalf("expected no filter for an empty list, got %+v err=%v", f, err)
+	}
+	f, err := parseEventFilter([]string{"pokemon.deleted, trade.*"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !f.allows(eventPokemonDeleted) || !f.allows(eventTradeCancelled) || f.allows(eventPokemonUpserted) {
+		t.Fatalf("unexpected filter matches: %+v", f)
+	}
+	if f, _ := parseEventFilter([]string{"*"}); !f.allows(eventProfileUpdated) {
+		t.Fatalf("expected * to allow everything")
+	}
+	for _, bad := range []string{"pokemon.moved", "chat.*"} {
+		if _, err := parseEventFilter([]string{bad}); err == nil {
+			t.Fatalf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+// TestTypedEvents_MatchSchemas checks every event type against the
+// required fields of its published schema.
+func TestTypedEvents_MatchSchemas(t *testing.T) {
+	payload := []byte(`{
+		"pokemon": {
+			"i-1": {"key": "i-1", "is_caught": true, "cp": 1500},
+			"i-2": {"key": "i-2", "is_caught": false, "is_wanted": false, "is_for_trade": false}
+		},
+		"trade": {
+			"t-p": {"trade_status": "proposed", "pokemon_instance_id_user_proposed": "i-1"},
+			"t-a": {"trade_status": "pending"},
+			"t-c": {"trade_status": "completed"},
+			"t-x": {"trade_status": "expired"},
+			"t-?": {"trade_status": "mystery"}
+		},
+		"relatedInstance": {"i-1": {"instance_id": "i-1"}},
+		"profile": {"ash": {"changed": ["location"]}},
+		"tradeMessages": {"12": {"message_id": "12", "trade_id": "t-a", "client_message_id": "c-1", "sender_username": "ash", "body": "hi", "created_at": "2025-01-01T00:00:00Z", "read_at": null}},
+		"tradeMessageReads": {"t-a": {"trade_id": "t-a", "reader_username": "misty", "up_to_message_id": "12", "read_at": "2025-01-01T00:01:00Z"}},
+		"notifications": {"7": {"notification_id": "7", "notification_type": "trade_proposed", "created_at": "2025-01-01T00:00:00Z"}},
+		"presence": {"misty": {"username": "misty", "status": "recent", "last_active_at": "2025-01-01T00:00:00Z"}},
+		"tradeReminders": {"t-p": {"trade_id": "t-p", "trade_status": "proposed", "username_proposed": "ash", "username_accepting": "misty", "expires_at": "2025-01-03T00:00:00Z"}},
+		"tags": {"tag-1": {"operation": "update", "tagData": {"tag_id": "tag-1", "name": "Raid"}}}
+	}`)
+
+	events := typedEventsFromPayload(payload, time.UnixMilli(1700000000000))
+	seen := make(map[string]bool)
+	for _, e := range events {
+		seen[e.Type] = true
+		checkAgainstSchema(t, e)
+	}
+	for _, eventType := range eventTypes {
+		if !seen[eventType] {
+			t.Fatalf("expected a %s event from the sample payload", eventType)
+		}
+	}
+	if len(events) != len(eventTypes) {
+		t.Fatalf("expected one event per type (unknown statuses skipped), got %d", len(events))
+	}
+}
+
+func checkAgainstSchema(t *testing.T, e typedEvent) {
+	t.Helper()
+
+	raw, err := os.ReadFile(filepath.Join("schemas", "v1", e.Type+".json"))
+	if err != nil {
+		t.Fatalf("missing schema for %s: %v", e.Type, err)
+	}
+	var schema struct {
+		Required   []string `json:"required"`
+		Properties struct {
+			Data struct {
+				Required []string `json:"required"`
+			} `json:"data"`
+		} `json:"properties"`
+	}
+	if err := json.Unmarshal(raw, &schema); err != nil {
+		t.Fatalf("decode schema %s: %v", e.Type, err)
+	}
+
+	var event map[string]interface{}
+	if err := json.Unmarshal(e.Data, &event); err != nil {
+		t.Fatalf("decode %s event: %v", e.Type, err)
+	}
+	for _, field := range schema.Required {
+		if _, ok := event[field]; !ok {
+			t.Fatalf("%s event missing %q: %s", e.Type, field, e.Data)
+		}
+	}
+	data, _ := event["data"].(map[string]interface{})
+	for _, field := range schema.Properties.Data.Required {
+		if _, ok := data[field]; !ok {
+			t.Fatalf("%s data missing %q: %s", e.Type, field, e.Data)
+		}
+	}
+	if event["v"] != float64(eventSchemaVersion) || event["type"] != e.Type {
+		t.Fatalf("unexpected envelope for %s: %s", e.Type, e.Data)
+	}
+}
+
+func TestDeliverLocked_TypedClientsGetNamedEvents(t *testing.T) {
+	origClients := clients
+	clients = make(map[string]*Client)
+	defer func() { clients = origClients }()
+
+	filter, _ := parseEventFilter([]string{"trade.*"})
+	typed := &Client{UserID: "u-1", DeviceID: "d-1", Queue: newOutboundQueue(8, 0, overflowPolicyResync), Connected: true, Types: filter}
+	legacy := &Client{UserID: "u-1", DeviceID: "d-2", Queue: newOutboundQueue(8, 0, overflowPolicyResync), Connected: true}
+	clients["u-1:d-1"], clients["u-1:d-2"] = typed, legacy
+
+	payload := []byte(`{"pokemon":{"i-1":{"is_caught":true}},"trade":{"t-1":{"trade_status":"pending"},"t-2":{"trade_status":"completed"}}}`)
+	deliverLocked(9, map[string][]byte{"user:u-1": payload}, "d-0")
+
+	got := typed.Queue.drain()
+	if len(got) != 2 || got[0].Name != eventTradeAccepted || got[1].Name != eventTradeCompleted {
+		t.Fatalf("expected only the two trade events, got %+v", got)
+	}
+	if got[0].ID != 0 || got[1].ID != 9 {
+		t.Fatalf("expected only the last event of the broadcast to carry its id, got %d/%d", got[0].ID, got[1].ID)
+	}
+	if got := legacy.Queue.drain(); len(got) != 1 || got[0].Name != "" || string(got[0].Data) != string(payload) {
+		t.Fatalf("expected the legacy client to keep the merged frame, got %+v", got)
+	}
+}
+
+func TestReplayFrames_TypedClient(t *testing.T) {
+	filter, _ := parseEventFilter([]string{"pokemon.deleted"})
+	missed := []replayEntry{
+		{ID: 5, Data: []byte(`{"pokemon":{"i-1":{"is_caught":false}}}`), At: time.Now()},
+		{ID: 6, Data: []byte(`{"pokemon":{"i-2":{"is_caught":true}}}`), At: time.Now()},
+	}
+	frames := replayFrames("u-1", filter, 4, missed, true)
+	if len(frames) != 1 || frames[0].Name != eventPokemonDeleted || frames[0].ID != 5 {
+		t.Fatalf("expected the replayed deletion only, got %+v", frames)
+	}
+}
@@ -3,12 +3,25 @@
 package main
 
 import (
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
 	"strconv"
+	"strings"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
 )
 
+const (
+	defaultUpdatesPageSize = 500
+	maxUpdatesPageSize     = 2000
+)
+
+var errInvalidSyncCursor = errors.New("invalid cursor")
+
 func getUsernameByUserID(userID string) (string, error) {
 	var username string
 	if err := db.Table("users").Where("user_id = ?", userID).Select("username").Scan(&username).Error; err != nil {
@@ -75,6 +88,195 @@ func buildPokemonInstancePayload(instance PokemonInstance) map[string]interface{
 	}
 }
 
+// tradeSides groups the bundle items of one trade.
+type tradeSides struct {
+	Proposed  []string
+	Accepting []string
+}
+
+// loadTradeSides fetches bundle items for the given trades in one query and
+// falls back to the single-instance columns for trades without items.
+func loadTradeSides(tx *gorm.DB, trades []Trade) (map[string]tradeSides, error) {
+	out := make(map[string]tradeSides, len(trades))
+	if len(trades) == 0 {
+		return out, nil
+	}
+
+	tradeIDs := make([]string, 0, len(trades))
+	for _, t := range trades {
+		tradeIDs = append(tradeIDs, t.TradeID)
+	}
+
+	var items []TradeItem
+	if err := tx.Where("trade_id IN ?", tradeIDs).Order("trade_id, side, position").Find(&items).Error; err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		sides := out[item.TradeID]
+		if item.Side == "proposed" {
+			sides.Proposed = append(sides.Proposed, item.InstanceID)
+		} else {
+			sides.Accepting = append(sides.Accepting, item.InstanceID)
+		}
+		out[item.TradeID] = sides
+	}
+
+	for _, t := range trades {
+		sides := out[t.TradeID]
+		if len(sides.Proposed) == 0 && t.PokemonInstanceIDUserProposed != "" {
+			sides.Proposed = []string{t.PokemonInstanceIDUserProposed}
+		}
+		if len(sides.Accepting) == 0 && t.PokemonInstanceIDUserAccepting != "" {
+			sides.Accepting = []string{t.PokemonInstanceIDUserAccepting}
+		}
+		out[t.TradeID] = sides
+	}
+	return out, nil
+}
+
+// syncCursor is how far a client has synced: every change up to Seq in
+// storage's change sequence. Since keeps a legacy timestamp filter while a
+// client that started from ?timestamp= is still paging through its backlog.
+type syncCursor struct {
+	Seq   uint64
+	Since *int64
+}
+
+// encodeSyncCursor renders a cursor as the opaque token clients pass back.
+func encodeSyncCursor(c syncCursor) string {
+	raw := "v1:" + strconv.FormatUint(c.Seq, 10)
+	if c.Since != nil {
+		raw += ":" + strconv.FormatInt(*c.Since, 10)
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeSyncCursor(token string) (syncCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return syncCursor{}, errInvalidSyncCursor
+	}
+	parts := strings.Split(string(raw), ":")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] != "v1" {
+		return syncCursor{}, errInvalidSyncCursor
+	}
+	seq, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return syncCursor{}, errInvalidSyncCursor
+	}
+	c := syncCursor{Seq: seq}
+	if len(parts) == 3 {
+		since, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			return syncCursor{}, errInvalidSyncCursor
+		}
+		c.Since = &since
+	}
+	return c, nil
+}
+
+// syncPage is one page of a user's changes, read from one snapshot.
+type syncPage struct {
+	Instances []PokemonInstance
+	Trades    []Trade
+	Next      syncCursor
+	HasMore   bool
+}
+
+// loadSyncPage reads the user's instances and trades changed after cur,
+// oldest change first, at most limit of them together. When the page
+// reaches the end, Next jumps to the snapshot's high-water mark so the
+// cursor also skips other users' changes.
+func loadSyncPage(tx *gorm.DB, userID string, cur syncCursor, limit int) (syncPage, error) {
+	var high uint64
+	if err := tx.Raw("SELECT value FROM change_sequence WHERE id = 1").Scan(&high).Error; err != nil {
+		return syncPage{}, fmt.Errorf("read change sequence: %w", err)
+	}
+
+	instQ := tx.Where("user_id = ? AND change_seq > ?", userID, cur.Seq)
+	tradeQ := tx.Where("(user_id_proposed = ? OR user_id_accepting = ?) AND change_seq > ?", userID, userID, cur.Seq)
+	if cur.Since != nil {
+		instQ = instQ.Where("last_update > ?", *cur.Since)
+		tradeQ = tradeQ.Where("last_update > ?", *cur.Since)
+	}
+
+	var instances []PokemonInstance
+	if err := instQ.Order("change_seq").Limit(limit + 1).Find(&instances).Error; err != nil {
+		return syncPage{}, fmt.Errorf("read instances: %w", err)
+	}
+	var trades []Trade
+	if err := tradeQ.Order("change_seq").Limit(limit + 1).Find(&trades).Error; err != nil {
+		return syncPage{}, fmt.Errorf("read trades: %w", err)
+	}
+
+	page := mergeSyncPage(instances, trades, limit)
+	if page.HasMore {
+		page.Next.Since = cur.Since
+	} else {
+		page.Next = syncCursor{Seq: high}
+	}
+	return page, nil
+}
+
+// mergeSyncPage keeps the limit oldest changes of both (already ordered)
+// lists. Next.Seq is the last change kept when more remain.
+func mergeSyncPage(instances []PokemonInstance, trades []Trade, limit int) syncPage {
+	var page syncPage
+	i, j := 0, 0
+	for i+j < limit && (i < len(instances) || j < len(trades)) {
+		if j >= len(trades) || (i < len(instances) && instances[i].ChangeSeq < trades[j].ChangeSeq) {
+			page.Next.Seq = instances[i].ChangeSeq
+			i++
+		} else {
+			page.Next.Seq = trades[j].ChangeSeq
+			j++
+		}
+	}
+	page.Instances, page.Trades = instances[:i], trades[:j]
+	page.HasMore = i < len(instances) || j < len(trades)
+	return page
+}
+
+func buildTradePayload(trade Trade, sides tradeSides) map[string]interface{} {
+	return map[string]interface{}{
+		"is_special_trade":                    trade.IsSpecialTrade,
+		"is_registered_trade":                 trade.IsRegisteredTrade,
+		"is_lucky_trade":                      trade.IsLuckyTrade,
+		"pokemon_instance_id_user_proposed":   trade.PokemonInstanceIDUserProposed,
+		"pokemon_instance_id_user_accepting":  trade.PokemonInstanceIDUserAccepting,
+		"pokemon_instance_ids_user_proposed":  sides.Proposed,
+		"pokemon_instance_ids_user_accepting": sides.Accepting,
+		"counter_of_trade_id":                 trade.CounterOfTradeID,
+		"trade_cycle_id":                      trade.TradeCycleID,
+		"trade_accepted_date":                 trade.TradeAcceptedDate,
+		"trade_cancelled_by":                  trade.TradeCancelledBy,
+		"trade_cancelled_date":                trade.TradeCancelledDate,
+		"trade_completed_date":                trade.TradeCompletedDate,
+		"trade_expired_date":                  trade.TradeExpiredDate,
+		"trade_dust_cost":                     trade.TradeDustCost,
+		"trade_friendship_level":              trade.TradeFriendshipLevel,
+		"trade_id":                            trade.TradeID,
+		"trade_proposal_date":                 trade.TradeProposalDate,
+		"trade_status":                        trade.TradeStatus,
+		"username_proposed":                   trade.UsernameProposed,
+		"username_accepting":                  trade.UsernameAccepting,
+		"user_1_trade_satisfaction":           trade.User1TradeSatisfaction,
+		"user_2_trade_satisfaction":           trade.User2TradeSatisfaction,
+		"user_proposed_completion_confirmed":  trade.UserProposedCompletionConfirmed,
+		"user_accepting_completion_confirmed": trade.UserAcceptingCompletionConfirmed,
+		"last_update":                         trade.LastUpdate,
+	}
+}
+
+// GetUpdates returns the caller's changed instances and trades.
+//
+// Clients sync with ?cursor=<next_cursor from the previous response> and an
+// optional limit; the first sync has no cursor (everything) or a legacy
+// ?timestamp=<ms> to only get rows whose last_update is newer. Keep calling
+// while has_more is true. Each page is read from one consistent snapshot.
+//
+// ?timestamp= alone keeps the old unpaged response, now with a next_cursor
+// to switch over with.
 func GetUpdates(c *fiber.Ctx) error {
 	userID, ok := c.Locals("user_id").(string)
 	if !ok || userID == "" {
@@ -87,78 +289,115 @@ func GetUpdates(c *fiber.Ctx) error {
 		logrus.Warn("device_id is missing in request")
 	}
 
+	var cur syncCursor
+	cursorStr := strings.TrimSpace(c.Query("cursor"))
 	timestampStr := c.Query("timestamp")
-	if timestampStr == "" {
+	limitStr := c.Query("limit")
+	if cursorStr != "" {
+		decoded, err := decodeSyncCursor(cursorStr)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid cursor"})
+		}
+		cur = decoded
+	} else if timestampStr != "" {
+		timestampInt, err := strconv.ParseInt(timestampStr, 10, 64)
+		if err != nil {
+			logrus.Errorf("Invalid timestamp format: %v", err)
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid timestamp format"})
+		}
+		cur.Since = &timestampInt
+	} else if limitStr == "" {
 		logrus.Error("timestamp is missing in request")
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Missing timestamp"})
 	}
 
-	timestampInt, err := strconv.ParseInt(timestampStr, 10, 64)
-	if err != nil {
-		logrus.Errorf("Invalid timestamp format: %v", err)
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid timestamp format"})
+	// Legacy callers (timestamp only) get everything in one response.
+	legacy := cursorStr == "" && limitStr == ""
+	limit := defaultUpdatesPageSize
+	if limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n < 1 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "limit must be a positive integer"})
+		}
+		if n > maxUpdatesPageSize {
+			n = maxUpdatesPageSize
+		}
+		limit = n
 	}
 
-	logrus.Infof("Fetching updates for username %s since %d", c.Locals("username"), timestampInt)
+	logrus.Infof("Fetching updates for username %s (cursor=%q timestamp=%q limit=%d)", c.Locals("username"), cursorStr, timestampStr, limit)
 
-	var instances []PokemonInstance
-	if err := db.Where("user_id = ? AND last_update > ?", userID, timestampInt).Find(&instances).Error; err != nil {
-		logrus.Errorf("Error retrieving Pokemon updates: %v", err)
+	tx := db.Begin(&sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if tx.Error != nil {
+		logrus.Errorf("Error opening sync snapshot: %v", tx.Error)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve updates"})
 	}
+	defer tx.Rollback()
+
+	var page syncPage
+	var nextCursor interface{}
+	if legacy {
+		var err error
+		page, err = loadLegacyUpdates(tx, userID, *cur.Since)
+		if err != nil {
+			logrus.Errorf("Error retrieving updates: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve updates"})
+		}
+		// Before storage has the change sequence there is nothing to
+		// switch over to; the response is the same as before.
+		var high uint64
+		if err := tx.Raw("SELECT value FROM change_sequence WHERE id = 1").Scan(&high).Error; err != nil {
+			logrus.Warnf("Change sequence unavailable, omitting next_cursor: %v", err)
+		} else {
+			nextCursor = encodeSyncCursor(syncCursor{Seq: high})
+		}
+	} else {
+		var err error
+		page, err = loadSyncPage(tx, userID, cur, limit)
+		if err != nil {
+			logrus.Errorf("Error retrieving updates: %v", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve updates"})
+		}
+		nextCursor = encodeSyncCursor(page.Next)
+	}
 
-	pokemonData := make(map[string]interface{})
-	for _, instance := range instances {
+	pokemonData := make(map[string]interface{}, len(page.Instances))
+	for _, instance := range page.Instances {
 		pokemonData[instance.InstanceID] = buildPokemonInstancePayload(instance)
 	}
 
-	var tradesList []Trade
-	if err := db.Where("((user_id_proposed = ? OR user_id_accepting = ?) AND last_update > ?)", userID, userID, timestampInt).
-		Find(&tradesList).Error; err != nil {
-		logrus.Errorf("Error retrieving trades: %v", err)
+	sidesByTrade, err := loadTradeSides(tx, page.Trades)
+	if err != nil {
+		logrus.Errorf("Error retrieving trade items: %v", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve trade updates"})
 	}
 
-	tradeMap := make(map[string]interface{})
-	for _, trade := range tradesList {
-		tradeMap[trade.TradeID] = map[string]interface{}{
-			"is_special_trade":                    trade.IsSpecialTrade,
-			"is_registered_trade":                 trade.IsRegisteredTrade,
-			"is_lucky_trade":                      trade.IsLuckyTrade,
-			"pokemon_instance_id_user_proposed":   trade.PokemonInstanceIDUserProposed,
-			"pokemon_instance_id_user_accepting":  trade.PokemonInstanceIDUserAccepting,
-			"trade_accepted_date":                 trade.TradeAcceptedDate,
-			"trade_cancelled_by":                  trade.TradeCancelledBy,
-			"trade_cancelled_date":                trade.TradeCancelledDate,
-			"trade_completed_date":                trade.TradeCompletedDate,
-			"trade_dust_cost":                     trade.TradeDustCost,
-			"trade_friendship_level":              trade.TradeFriendshipLevel,
-			"trade_id":                            trade.TradeID,
-			"trade_proposal_date":                 trade.TradeProposalDate,
-			"trade_status":                        trade.TradeStatus,
-			"username_proposed":                   trade.UsernameProposed,
-			"username_accepting":                  trade.UsernameAccepting,
-			"user_1_trade_satisfaction":           trade.User1TradeSatisfaction,
-			"user_2_trade_satisfaction":           trade.User2TradeSatisfaction,
-			"user_proposed_completion_confirmed":  trade.UserProposedCompletionConfirmed,
-			"user_accepting_completion_confirmed": trade.UserAcceptingCompletionConfirmed,
-			"last_update":                         trade.LastUpdate,
+	tradeMap := make(map[string]interface{}, len(page.Trades))
+	for _, trade := range page.Trades {
+		tradeMap[trade.TradeID] = buildTradePayload(trade, sidesByTrade[trade.TradeID])
+	}
+
+	// Related instances are the other trainer's side of each trade, loaded
+	// for the whole page at once.
+	var relatedIDs []string
+	for _, trade := range page.Trades {
+		sides := sidesByTrade[trade.TradeID]
+		if trade.UserIDProposed != userID {
+			relatedIDs = append(relatedIDs, sides.Proposed...)
+		}
+		if trade.UserIDAccepting != userID {
+			relatedIDs = append(relatedIDs, sides.Accepting...)
 		}
 	}
 
 	relatedInstances := make(map[string]interface{})
-	for _, trade := range tradesList {
-		if trade.UserIDProposed != userID && trade.PokemonInstanceIDUserProposed != "" {
-			var instance PokemonInstance
-			if err := db.Where("instance_id = ?", trade.PokemonInstanceIDUserProposed).First(&instance).Error; err == nil {
-				relatedInstances[instance.InstanceID] = buildPokemonInstancePayload(instance)
-			}
+	if len(relatedIDs) > 0 {
+		var related []PokemonInstance
+		if err := tx.Where("instance_id IN ?", relatedIDs).Find(&related).Error; err != nil {
+			logrus.Errorf("Error retrieving related instances: %v", err)
 		}
-		if trade.UserIDAccepting != userID && trade.PokemonInstanceIDUserAccepting != "" {
-			var instance PokemonInstance
-			if err := db.Where("instance_id = ?", trade.PokemonInstanceIDUserAccepting).First(&instance).Error; err == nil {
-				relatedInstances[instance.InstanceID] = buildPokemonInstancePayload(instance)
-			}
+		for _, instance := range related {
+			relatedInstances[instance.InstanceID] = buildPokemonInstancePayload(instance)
 		}
 	}
 
@@ -166,9 +405,24 @@ func GetUpdates(c *fiber.Ctx) error {
 		"pokemon":          pokemonData,
 		"trade":            tradeMap,
 		"relatedInstances": relatedInstances,
+		"next_cursor":      nextCursor,
+		"has_more":         page.HasMore,
 	}
 
-	logrus.Infof("User %s retrieved %d Pokemon updates, %d trades, and %d related instances",
-		c.Locals("username"), len(pokemonData), len(tradeMap), len(relatedInstances))
+	logrus.Infof("User %s retrieved %d Pokemon updates, %d trades, and %d related instances (has_more=%t)",
+		c.Locals("username"), len(pokemonData), len(tradeMap), len(relatedInstances), page.HasMore)
 	return c.Status(fiber.StatusOK).JSON(response)
 }
+
+// loadLegacyUpdates is the unpaged ?timestamp= read.
+func loadLegacyUpdates(tx *gorm.DB, userID string, since int64) (syncPage, error) {
+	var page syncPage
+	if err := tx.Where("user_id = ? AND last_update > ?", userID, since).Find(&page.Instances).Error; err != nil {
+		return page, fmt.Errorf("read instances: %w", err)
+	}
+	if err := tx.Where("((user_id_proposed = ? OR user_id_accepting = ?) AND last_update > ?)", userID, userID, since).
+		Find(&page.Trades).Error; err != nil {
+		return page, fmt.Errorf("read trades: %w", err)
+	}
+	return page, nil
+}
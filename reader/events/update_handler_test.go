@@ -1,8 +1,13 @@
 package main
 
 import (
+	"encoding/json"
+	"net/http/httptest"
+	"regexp"
 	"testing"
 
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
 	"gorm.io/datatypes"
 )
 
@@ -65,3 +70,112 @@ func TestBuildPokemonInstancePayload_CanonicalOwnershipFields(t *testing.T) {
 		}
 	}
 }
+
+func TestSyncCursor_RoundTrip(t *testing.T) {
+	since := int64(1739000000000)
+	for _, in := range []syncCursor{{Seq: 0}, {Seq: 42}, {Seq: 7, Since: &since}} {
+		out, err := decodeSyncCursor(encodeSyncCursor(in))
+		if err != nil {
+			t.Fatalf("decode %+v: %v", in, err)
+		}
+		if out.Seq != in.Seq || (in.Since == nil) != (out.Since == nil) || (in.Since != nil && *out.Since != *in.Since) {
+			t.Fatalf("round trip changed cursor: %+v -> %+v", in, out)
+		}
+	}
+	for _, bad := range []string{"", "!!", "djI6MQ", "djE6eA"} {
+		if _, err := decodeSyncCursor(bad); err == nil {
+			t.Fatalf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestMergeSyncPage_OrdersAcrossTables(t *testing.T) {
+	instances := []PokemonInstance{{InstanceID: "i-1", ChangeSeq: 3}, {InstanceID: "i-2", ChangeSeq: 6}, {InstanceID: "i-3", ChangeSeq: 9}}
+	trades := []Trade{{TradeID: "t-1", ChangeSeq: 4}, {TradeID: "t-2", ChangeSeq: 5}}
+
+	page := mergeSyncPage(instances, trades, 3)
+	if len(page.Instances) != 1 || len(page.Trades) != 2 || !page.HasMore || page.Next.Seq != 5 {
+		t.Fatalf("unexpected first page: %+v", page)
+	}
+
+	page = mergeSyncPage(instances[1:], nil, 3)
+	if len(page.Instances) != 2 || page.HasMore || page.Next.Seq != 9 {
+		t.Fatalf("unexpected last page: %+v", page)
+	}
+}
+
+func TestGetUpdates_PagesByCursor(t *testing.T) {
+	origDB := db
+	defer func() { db = origDB }()
+	gdb, mock, sqlDB := setupMockGormDB(t)
+	defer sqlDB.Close()
+	db = gdb
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT value FROM change_sequence WHERE id = 1")).
+		WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow(120))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `instances` WHERE user_id = ? AND change_seq > ? ORDER BY change_seq LIMIT ?")).
+		WithArgs("u-1", 10, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"instance_id", "user_id", "pokemon_id", "change_seq"}).
+			AddRow("i-1", "u-1", 25, 11).
+			AddRow("i-2", "u-1", 26, 14).
+			AddRow("i-3", "u-1", 27, 15))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `trades` WHERE (user_id_proposed = ? OR user_id_accepting = ?) AND change_seq > ? ORDER BY change_seq LIMIT ?")).
+		WithArgs("u-1", "u-1", 10, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"trade_id", "user_id_proposed", "user_id_accepting", "pokemon_instance_id_user_proposed", "change_seq"}).
+			AddRow("t-1", "u-2", "u-1", "p-9", 12))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `trade_items` WHERE trade_id IN (?)")).
+		WithArgs("t-1").
+		WillReturnRows(sqlmock.NewRows([]string{"trade_id", "instance_id", "side", "position"}))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `instances` WHERE instance_id IN (?)")).
+		WithArgs("p-9").
+		WillReturnRows(sqlmock.NewRows([]string{"instance_id", "user_id", "pokemon_id", "change_seq"}).AddRow("p-9", "u-2", 1, 8))
+	mock.ExpectRollback()
+
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user_id", "u-1")
+		return c.Next()
+	})
+	app.Get("/api/getUpdates", GetUpdates)
+
+	req := httptest.NewRequest(fiber.MethodGet, "/api/getUpdates?device_id=d-1&limit=2&cursor="+encodeSyncCursor(syncCursor{Seq: 10}), nil)
+	resp, err := app.Test(req, -1)
+	if err != nil || resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("expected 200, got %v %v", resp.StatusCode, err)
+	}
+	var body struct {
+		Pokemon          map[string]interface{} `json:"pokemon"`
+		Trade            map[string]interface{} `json:"trade"`
+		RelatedInstances map[string]interface{} `json:"relatedInstances"`
+		NextCursor       string                 `json:"next_cursor"`
+		HasMore          bool                   `json:"has_more"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(body.Pokemon) != 1 || body.Pokemon["i-1"] == nil || len(body.Trade) != 1 || body.RelatedInstances["p-9"] == nil {
+		t.Fatalf("unexpected page: %+v", body)
+	}
+	next, err := decodeSyncCursor(body.NextCursor)
+	if err != nil || !body.HasMore || next.Seq != 12 {
+		t.Fatalf("expected more after seq 12, got %+v has_more=%v", next, body.HasMore)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet sql expectations: %v", err)
+	}
+}
+
+func TestGetUpdates_RejectsBadCursor(t *testing.T) {
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user_id", "u-1")
+		return c.Next()
+	})
+	app.Get("/api/getUpdates", GetUpdates)
+
+	resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/api/getUpdates?cursor=nope", nil), -1)
+	if err != nil || resp.StatusCode != fiber.StatusBadRequest {
+		t.Fatalf("expected 400, got %v %v", resp.StatusCode, err)
+	}
+}
@@ -0,0 +1,239 @@
+// webpush.go
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	// pushRecordSize is the aes128gcm record size; the whole payload goes
+	// in one record.
+	pushRecordSize = 4096
+	// maxPushPayloadBytes leaves room for the header, the padding
+	// delimiter and the GCM tag inside one record.
+	maxPushPayloadBytes = 3800
+	vapidTokenTTL       = 12 * time.Hour
+)
+
+var errPushPayloadTooLarge = errors.New("push payload too large")
+
+// pushSender delivers one encrypted message to a push service. It returns
+// the push service's status code; tests swap in a fake.
+type pushSender interface {
+	Send(ctx context.Context, sub PushSubscription, payload []byte, ttl time.Duration) (int, error)
+}
+
+// vapidKeys identify this server to push services (RFC 8292).
+type vapidKeys struct {
+	private   *ecdsa.PrivateKey
+	publicB64 string // uncompressed point, base64url, as browsers expect
+	subject   string // mailto: or https: contact
+}
+
+// parseVAPIDKeys reads the base64url private scalar (the format web-push
+// tooling generates) and checks the public key, when given, matches it.
+func parseVAPIDKeys(privateB64, publicB64, subject string) (*vapidKeys, error) {
+	raw, err := decodeBase64URL(privateB64)
+	if err != nil {
+		return nil, fmt.Errorf("decode VAPID private key: %w", err)
+	}
+	priv, err := ecdsa.ParseRawPrivateKey(elliptic.P256(), raw)
+	if err != nil {
+		return nil, fmt.Errorf("parse VAPID private key: %w", err)
+	}
+	pub, err := priv.PublicKey.Bytes()
+	if err != nil {
+		return nil, fmt.Errorf("encode VAPID public key: %w", err)
+	}
+	derived := base64.RawURLEncoding.EncodeToString(pub)
+	if publicB64 != "" {
+		given, err := decodeBase64URL(publicB64)
+		if err != nil || !bytes.Equal(given, pub) {
+			return nil, errors.New("VAPID public key does not match the private key")
+		}
+	}
+	if subject == "" {
+		return nil, errors.New("VAPID subject is required")
+	}
+	return &vapidKeys{private: priv, publicB64: derived, subject: subject}, nil
+}
+
+// authorization builds the "vapid" Authorization header for an endpoint.
+func (k *vapidKeys) authorization(endpoint string, now time.Time) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("invalid push endpoint")
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": now.Add(vapidTokenTTL).Unix(),
+		"sub": k.subject,
+	})
+	signed, err := token.SignedString(k.private)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("vapid t=%s, k=%s", signed, k.publicB64), nil
+}
+
+// encryptPushPayload encrypts payload for one subscription with the
+// aes128gcm content coding (RFC 8291 on top of RFC 8188).
+func encryptPushPayload(payload []byte, p256dh, authSecret string) ([]byte, error) {
+	if len(payload) > maxPushPayloadBytes {
+		return nil, errPushPayloadTooLarge
+	}
+	uaRaw, err := decodeBase64URL(p256dh)
+	if err != nil {
+		return nil, fmt.Errorf("decode p256dh: %w", err)
+	}
+	uaPublic, err := ecdh.P256().NewPublicKey(uaRaw)
+	if err != nil {
+		return nil, fmt.Errorf("parse p256dh: %w", err)
+	}
+	auth, err := decodeBase64URL(authSecret)
+	if err != nil || len(auth) == 0 {
+		return nil, fmt.Errorf("decode auth secret")
+	}
+
+	asPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return sealPushPayload(payload, uaPublic, auth, asPrivate, salt)
+}
+
+// sealPushPayload does the encryption with a given server key and salt,
+// which are random in production and fixed in the RFC test vector.
+func sealPushPayload(payload []byte, uaPublic *ecdh.PublicKey, auth []byte, asPrivate *ecdh.PrivateKey, salt []byte) ([]byte, error) {
+	shared, err := asPrivate.ECDH(uaPublic)
+	if err != nil {
+		return nil, err
+	}
+	asPublic := asPrivate.PublicKey().Bytes()
+	uaRaw := uaPublic.Bytes()
+
+	cek, nonce, err := pushContentKeys(shared, auth, salt, uaRaw, asPublic)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	// 0x02 marks the last (and only) record.
+	plaintext := append(append([]byte{}, payload...), 0x02)
+
+	header := make([]byte, 0, 16+4+1+len(asPublic))
+	header = append(header, salt...)
+	header = binary.BigEndian.AppendUint32(header, pushRecordSize)
+	header = append(header, byte(len(asPublic)))
+	header = append(header, asPublic...)
+	return gcm.Seal(header, nonce, plaintext, nil), nil
+}
+
+// pushContentKeys derives the content encryption key and nonce shared by
+// this server and the user agent.
+func pushContentKeys(shared, auth, salt, uaPublic, asPublic []byte) (cek, nonce []byte, err error) {
+	keyInfo := "WebPush: info\x00" + string(uaPublic) + string(asPublic)
+	ikm, err := hkdf.Key(sha256.New, shared, auth, keyInfo, 32)
+	if err != nil {
+		return nil, nil, err
+	}
+	prk, err := hkdf.Extract(sha256.New, ikm, salt)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cek, err = hkdf.Expand(sha256.New, prk, "Content-Encoding: aes128gcm\x00", 16); err != nil {
+		return nil, nil, err
+	}
+	if nonce, err = hkdf.Expand(sha256.New, prk, "Content-Encoding: nonce\x00", 12); err != nil {
+		return nil, nil, err
+	}
+	return cek, nonce, nil
+}
+
+// webPushSender talks to real push services over HTTP.
+type webPushSender struct {
+	keys   *vapidKeys
+	client *http.Client
+}
+
+// newWebPushSender's client does not follow redirects: push services
+// answer directly, and a redirect could lead off the allowed hosts.
+func newWebPushSender(keys *vapidKeys) *webPushSender {
+	return &webPushSender{keys: keys, client: &http.Client{
+		Timeout: 10 * time.Second,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}}
+}
+
+func (s *webPushSender) Send(ctx context.Context, sub PushSubscription, payload []byte, ttl time.Duration) (int, error) {
+	body, err := encryptPushPayload(payload, sub.P256dh, sub.Auth)
+	if errors.Is(err, errPushPayloadTooLarge) {
+		return 0, err
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", errInvalidPushSubscription, err)
+	}
+	authz, err := s.keys.authorization(sub.Endpoint, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", authz)
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", strconv.Itoa(int(ttl.Seconds())))
+	req.Header.Set("Urgency", "high")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// decodeBase64URL accepts base64url with or without padding, which is how
+// browsers and key generators variously emit it.
+func decodeBase64URL(s string) ([]byte, error) {
+	if b, err := base64.RawURLEncoding.DecodeString(s); err == nil {
+		return b, nil
+	}
+	return base64.URLEncoding.DecodeString(s)
+}
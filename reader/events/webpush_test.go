@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func mustB64(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := decodeBase64URL(s)
+	if err != nil {
+		t.Fatalf("decode %q: %v", s, err)
+	}
+	return b
+}
+
+// TestSealPushPayload_RFC8291Vector checks the encryption against the
+// example in RFC 8291 appendix A.
+func TestSealPushPayload_RFC8291Vector(t *testing.T) {
+	asPrivate, err := ecdh.P256().NewPrivateKey(mustB64(t, "yfWPiYE-n46HLnH0KqZOF1fJJU3MYrct3AELtAQ-oRw"))
+	if err != nil {
+		t.Fatalf("server key: %v", err)
+	}
+	uaPublic, err := ecdh.P256().NewPublicKey(mustB64(t, "BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4"))
+	if err != nil {
+		t.Fatalf("user agent key: %v", err)
+	}
+
+	body, err := sealPushPayload([]byte("When I grow up, I want to be a watermelon"), uaPublic,
+		mustB64(t, "BTBZMqHH6r4Tts7J_aSIgg"), asPrivate, mustB64(t, "DGv6ra1nlYgDCS1FRnbzlw"))
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	want := "DGv6ra1nlYgDCS1FRnbzlwAAEABBBP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A_yl95bQpu6cVPTpK4Mqgkf1CXztLVBSt2Ks3oZwbuwXPXLWyouBWLVWGNWQexSgSxsj_Qulcy4a-fN"
+	if got := base64.RawURLEncoding.EncodeToString(body); got != want {
+		t.Fatalf("unexpected ciphertext:\n got %s\nwant %s", got, want)
+	}
+}
+
+// fakePushService is a local push endpoint that checks the VAPID header and
+// decrypts what it receives with the subscription's private key.
+type fakePushService struct {
+	t        *testing.T
+	uaKey    *ecdh.PrivateKey
+	auth     []byte
+	vapidPub *ecdsa.PublicKey
+	status   int
+	received []string
+}
+
+func (f *fakePushService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Encoding") != "aes128gcm" || r.Header.Get("TTL") == "" {
+		f.t.Errorf("missing push headers: %v", r.Header)
+	}
+	authz := r.Header.Get("Authorization")
+	token := strings.TrimPrefix(strings.SplitN(authz, ",", 2)[0], "vapid t=")
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) { return f.vapidPub, nil }); err != nil {
+		f.t.Errorf("invalid VAPID token: %v", err)
+	}
+	if claims["aud"] != "http://"+r.Host {
+		f.t.Errorf("unexpected audience %v", claims["aud"])
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	salt, idLen := body[:16], int(body[20])
+	asRaw, ciphertext := body[21:21+idLen], body[21+idLen:]
+	asPublic, err := ecdh.P256().NewPublicKey(asRaw)
+	if err != nil {
+		f.t.Fatalf("bad server key id: %v", err)
+	}
+	shared, _ := f.uaKey.ECDH(asPublic)
+	cek, nonce, _ := pushContentKeys(shared, f.auth, salt, f.uaKey.PublicKey().Bytes(), asRaw)
+	block, _ := aes.NewCipher(cek)
+	gcm, _ := cipher.NewGCM(block)
+	plain, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		f.t.Fatalf("decrypt: %v", err)
+	}
+	f.received = append(f.received, strings.TrimSuffix(string(plain), "\x02"))
+	w.WriteHeader(f.status)
+}
+
+func TestWebPushSender_DeliversToLocalEndpoint(t *testing.T) {
+	vapid, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rawPriv, _ := vapid.Bytes()
+	keys, err := parseVAPIDKeys(base64.RawURLEncoding.EncodeToString(rawPriv), "", "mailto:ops@example.com")
+	if err != nil {
+		t.Fatalf("parse VAPID keys: %v", err)
+	}
+
+	uaKey, _ := ecdh.P256().GenerateKey(rand.Reader)
+	auth := make([]byte, 16)
+	rand.Read(auth)
+	fake := &fakePushService{t: t, uaKey: uaKey, auth: auth, vapidPub: &vapid.PublicKey, status: http.StatusCreated}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	sub := PushSubscription{
+		Endpoint: srv.URL + "/push/abc",
+		P256dh:   base64.RawURLEncoding.EncodeToString(uaKey.PublicKey().Bytes()),
+		Auth:     base64.RawURLEncoding.EncodeToString(auth),
+	}
+	status, err := newWebPushSender(keys).Send(context.Background(), sub, []byte(`{"title":"hi"}`), time.Hour)
+	if err != nil || status != http.StatusCreated {
+		t.Fatalf("expected delivery, got status=%d err=%v", status, err)
+	}
+	if len(fake.received) != 1 || fake.received[0] != `{"title":"hi"}` {
+		t.Fatalf("unexpected payload at the push service: %q", fake.received)
+	}
+
+	sub.P256dh = "not-a-key"
+	if _, err := newWebPushSender(keys).Send(context.Background(), sub, []byte(`{}`), time.Hour); err == nil {
+		t.Fatalf("expected a broken subscription to be reported")
+	}
+}
+
+func TestParseVAPIDKeys_RejectsMismatchedPublicKey(t *testing.T) {
+	a, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	b, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rawA, _ := a.Bytes()
+	pubB, _ := b.PublicKey.Bytes()
+	if _, err := parseVAPIDKeys(base64.RawURLEncoding.EncodeToString(rawA), base64.RawURLEncoding.EncodeToString(pubB), "mailto:x@example.com"); err == nil {
+		t.Fatalf("expected mismatched keys to be rejected")
+	}
+}
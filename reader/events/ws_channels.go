@@ -0,0 +1,138 @@
+// ws_channels.go
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Channel kinds a WebSocket client can subscribe to. SSE streams only ever
+// receive their user's own channel.
+const (
+	channelUser    = "user"    // user:<user_id>, the caller's own collection
+	channelTrade   = "trade"   // trade:<trade_id>, one trade the caller is part of
+	channelTrainer = "trainer" // trainer:<username>, change notices for a public profile
+)
+
+const maxWSSubscriptions = 64
+
+var (
+	errChannelInvalid   = errors.New("invalid channel")
+	errChannelForbidden = errors.New("channel not allowed")
+	errChannelNotFound  = errors.New("channel not found")
+	errChannelLimit     = errors.New("too many subscriptions")
+)
+
+func userChannel(userID string) string      { return channelUser + ":" + userID }
+func tradeChannel(tradeID string) string    { return channelTrade + ":" + tradeID }
+func trainerChannel(username string) string { return channelTrainer + ":" + username }
+
+func parseChannel(channel string) (kind, id string, err error) {
+	kind, id, ok := strings.Cut(channel, ":")
+	if !ok || id == "" || len(channel) > 128 {
+		return "", "", errChannelInvalid
+	}
+	switch kind {
+	case channelUser, channelTrade, channelTrainer:
+		return kind, id, nil
+	}
+	return "", "", errChannelInvalid
+}
+
+// authorizeChannel checks that userID may subscribe to channel.
+func authorizeChannel(userID, channel string) error {
+	kind, id, err := parseChannel(channel)
+	if err != nil {
+		return err
+	}
+
+	var count int64
+	switch kind {
+	case channelUser:
+		if id != userID {
+			return errChannelForbidden
+		}
+		return nil
+	case channelTrade:
+		err = db.Model(&Trade{}).
+			Where("trade_id = ? AND (user_id_proposed = ? OR user_id_accepting = ?)", id, userID, userID).
+			Count(&count).Error
+	case channelTrainer:
+		err = db.Model(&User{}).Where("username = ?", id).Count(&count).Error
+	}
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		// Trades the caller is not part of look the same as missing ones.
+		return errChannelNotFound
+	}
+	return nil
+}
+
+// channelPayloads splits one broadcast into per-channel payloads. Users get
+// the full message; each trade channel gets its trade and related
+// instances; trainer channels only get a change notice, since the update
+// itself may contain private data.
+func channelPayloads(userPayload []byte, userIDs map[string]bool, tradeMap, relatedInstance map[string]interface{},
+	username string, pokemonMap map[string]interface{}) map[string][]byte {
+	out := make(map[string][]byte, len(userIDs)+len(tradeMap)+1)
+	for uid := range userIDs {
+		out[userChannel(uid)] = userPayload
+	}
+
+	for tradeID, tdRaw := range tradeMap {
+		related := make(map[string]interface{})
+		if td, ok := tdRaw.(map[string]interface{}); ok {
+			proposedIDs, acceptingIDs := tradeDataSides(td)
+			for _, id := range append(proposedIDs, acceptingIDs...) {
+				if inst, ok := relatedInstance[id]; ok {
+					related[id] = inst
+				}
+			}
+		}
+		data, err := json.Marshal(map[string]interface{}{
+			"trade":           map[string]interface{}{tradeID: tdRaw},
+			"relatedInstance": related,
+		})
+		if err != nil {
+			logrus.Errorf("Error marshalling trade channel payload for %s: %v", tradeID, err)
+			continue
+		}
+		out[tradeChannel(tradeID)] = data
+	}
+
+	// Completed trades put the other side's instances in the map too, tagged
+	// with their new owner.
+	changed := make(map[string]int)
+	for _, raw := range pokemonMap {
+		owner := username
+		if item, ok := raw.(map[string]interface{}); ok {
+			if name, ok := item["username"].(string); ok && name != "" {
+				owner = name
+			}
+		}
+		if owner != "" {
+			changed[owner]++
+		}
+	}
+	now := time.Now().Unix()
+	for owner, n := range changed {
+		data, err := json.Marshal(map[string]interface{}{
+			"trainer": map[string]interface{}{
+				owner: map[string]interface{}{"pokemon_changed": n, "updated_at": now},
+			},
+		})
+		if err != nil {
+			logrus.Errorf("Error marshalling trainer channel payload for %s: %v", owner, err)
+			continue
+		}
+		out[trainerChannel(owner)] = data
+	}
+	return out
+}
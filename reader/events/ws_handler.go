@@ -0,0 +1,378 @@
+// ws_handler.go
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	wsPingInterval = 30 * time.Second
+	wsPongWait     = 75 * time.Second
+	wsWriteWait    = 10 * time.Second
+)
+
+// wsUpgrade runs before the upgrade, while errors can still be plain HTTP
+// responses. verifyJWT has already set the user.
+func wsUpgrade(c *fiber.Ctx) error {
+	if !websocket.IsWebSocketUpgrade(c) {
+		return c.Status(fiber.StatusUpgradeRequired).JSON(fiber.Map{"error": "WebSocket upgrade required"})
+	}
+
+	// Browsers send cookies on cross-site WebSocket handshakes, so the
+	// origin has to be checked here rather than left to CORS.
+	if origin := c.Get("Origin"); origin != "" {
+		if _, ok := allowedOrigins[origin]; !ok {
+			logrus.Warnf("Rejected WebSocket upgrade from origin %s", origin)
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Origin not allowed"})
+		}
+	}
+
+	deviceID, _ := c.Locals("device_id").(string)
+	if deviceID == "" {
+		deviceID = strings.TrimSpace(c.Query("device_id"))
+	}
+	if deviceID == "" {
+		return c.Status(fiber.StatusBadRequest).SendString("Missing device_id")
+	}
+	c.Locals("device_id", deviceID)
+
+	types, err := parseEventFilter([]string{c.Query("types")})
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("Unknown event type")
+	}
+	c.Locals("event_filter", types)
+
+	// Checked again on register; this gives the caller a proper status.
+	userID, _ := c.Locals("user_id").(string)
+	clientsMutex.Lock()
+	err = admitClientLocked(userID, deviceID, time.Now())
+	clientsMutex.Unlock()
+	if err != nil {
+		logrus.Warnf("Refused WebSocket connection for user=%s device=%s: %v", userID, deviceID, err)
+		return c.Status(admissionStatus(err)).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Next()
+}
+
+// wsInbound is a message from the client. Ref is echoed in the reply so the
+// client can match it to its request.
+type wsInbound struct {
+	Type    string          `json:"type"`
+	Ref     string          `json:"ref,omitempty"`
+	Channel string          `json:"channel,omitempty"`
+	ID      string          `json:"id,omitempty"`
+	Types   []string        `json:"types,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+type wsSession struct {
+	conn     *websocket.Conn
+	client   *Client
+	clientID string
+	username string
+	replies  chan []byte
+}
+
+func wsHandler(conn *websocket.Conn) {
+	userID, _ := conn.Locals("user_id").(string)
+	username, _ := conn.Locals("username").(string)
+	deviceID, _ := conn.Locals("device_id").(string)
+	types, _ := conn.Locals("event_filter").(*eventFilter)
+
+	clientID := newClientID(userID, deviceID, transportWS)
+	client := &Client{
+		UserID:    userID,
+		DeviceID:  deviceID,
+		Transport: transportWS,
+		Queue:     newClientQueue(),
+		Connected: true,
+		Channels:  map[string]bool{userChannel(userID): true},
+		Types:     types,
+	}
+	s := &wsSession{
+		conn:     conn,
+		client:   client,
+		clientID: clientID,
+		username: username,
+		replies:  make(chan []byte, 16),
+	}
+
+	lastID, resuming := parseResumeID(strings.TrimSpace(conn.Query("last_event_id")))
+	var missed []replayEntry
+	replayOK := true
+	clientsMutex.Lock()
+	if err := admitClientLocked(userID, deviceID, time.Now()); err != nil {
+		clientsMutex.Unlock()
+		// Lost a race for the last slot since the upgrade check.
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseTryAgainLater, err.Error()), time.Now().Add(wsWriteWait))
+		return
+	}
+	registerClientLocked(clientID, client)
+	if resuming {
+		missed, replayOK = replay.since(userID, deviceID, lastID, time.Now())
+	}
+	clientsMutex.Unlock()
+	defer handleClientDisconnect(clientID, client)
+	logrus.Infof("WebSocket connected: UserID=%s, DeviceID=%s", userID, deviceID)
+
+	welcome, _ := json.Marshal(map[string]interface{}{
+		"type":     "welcome",
+		"channels": []string{userChannel(userID)},
+		"publish":  config.Events.WSPublishEnabled,
+	})
+	if err := s.write(welcome); err != nil {
+		return
+	}
+	if resuming {
+		for _, f := range replayFrames(userID, types, lastID, missed, replayOK) {
+			if err := s.write(formatWSFrame(f)); err != nil {
+				return
+			}
+		}
+	}
+
+	conn.SetReadLimit(int64(config.Events.WSMaxMessageBytes))
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	go s.readLoop()
+
+	// This goroutine owns all writes, as with SSE.
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-client.Queue.notify:
+			for _, f := range client.Queue.drain() {
+				if err := s.write(formatWSFrame(f)); err != nil {
+					return
+				}
+			}
+		case reply := <-s.replies:
+			if err := s.write(reply); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				return
+			}
+		case <-client.Queue.done:
+			// Closed by the reader, by disconnect, by the overflow policy or
+			// by an admin, who leaves a reason.
+			code := websocket.CloseNormalClosure
+			clientsMutex.Lock()
+			reason := client.CloseReason
+			clientsMutex.Unlock()
+			if reason != "" {
+				code = websocket.ClosePolicyViolation
+			}
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(code, reason), time.Now().Add(wsWriteWait))
+			return
+		}
+	}
+}
+
+func (s *wsSession) write(data []byte) error {
+	s.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	if err := s.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		return err
+	}
+	s.client.BytesSent.Add(int64(len(data)))
+	return nil
+}
+
+// readLoop handles client messages until the socket fails, then closes the
+// queue so the writer exits too.
+func (s *wsSession) readLoop() {
+	defer s.client.Queue.close()
+	for {
+		_, raw, err := s.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				logrus.Warnf("WebSocket read failed for user=%s device=%s: %v", s.client.UserID, s.client.DeviceID, err)
+			}
+			return
+		}
+		reply := s.handleMessage(raw)
+		if reply == nil {
+			continue
+		}
+		select {
+		case s.replies <- reply:
+		case <-s.client.Queue.done:
+			return
+		}
+	}
+}
+
+// handleMessage applies one client message and returns the reply, if any.
+func (s *wsSession) handleMessage(raw []byte) []byte {
+	var msg wsInbound
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		wsMessagesTotal.WithLabelValues("invalid").Inc()
+		return wsError("", "invalid message")
+	}
+
+	switch msg.Type {
+	case "subscribe":
+		wsMessagesTotal.WithLabelValues(msg.Type).Inc()
+		if err := s.subscribe(msg.Channel); err != nil {
+			return wsError(msg.Ref, err.Error())
+		}
+		return wsOK(msg.Ref, map[string]interface{}{"channel": msg.Channel})
+
+	case "unsubscribe":
+		wsMessagesTotal.WithLabelValues(msg.Type).Inc()
+		clientsMutex.Lock()
+		delete(s.client.Channels, msg.Channel)
+		clientsMutex.Unlock()
+		return wsOK(msg.Ref, map[string]interface{}{"channel": msg.Channel})
+
+	case "ack":
+		// Acks need no reply; the newest acked id is the client's resume point.
+		wsMessagesTotal.WithLabelValues(msg.Type).Inc()
+		id, ok := parseEventID(msg.ID)
+		if !ok {
+			return wsError(msg.Ref, "unknown event id")
+		}
+		clientsMutex.Lock()
+		if id > s.client.LastAckedID {
+			s.client.LastAckedID = id
+		}
+		clientsMutex.Unlock()
+		return nil
+
+	case "batchedUpdates":
+		wsMessagesTotal.WithLabelValues(msg.Type).Inc()
+		traceID, err := s.publish(msg.Data)
+		if err != nil {
+			return wsError(msg.Ref, err.Error())
+		}
+		return wsOK(msg.Ref, map[string]interface{}{"trace_id": traceID})
+
+	case "filter":
+		// An empty list goes back to the merged frames.
+		wsMessagesTotal.WithLabelValues(msg.Type).Inc()
+		types, err := parseEventFilter(msg.Types)
+		if err != nil {
+			return wsError(msg.Ref, err.Error())
+		}
+		clientsMutex.Lock()
+		s.client.Types = types
+		clientsMutex.Unlock()
+		return wsOK(msg.Ref, map[string]interface{}{"types": msg.Types})
+
+	case "ping":
+		wsMessagesTotal.WithLabelValues(msg.Type).Inc()
+		return wsOK(msg.Ref, nil)
+	}
+
+	wsMessagesTotal.WithLabelValues("invalid").Inc()
+	return wsError(msg.Ref, "unknown message type")
+}
+
+func (s *wsSession) subscribe(channel string) error {
+	clientsMutex.Lock()
+	already := s.client.Channels[channel]
+	full := len(s.client.Channels) >= maxWSSubscriptions
+	clientsMutex.Unlock()
+	if already {
+		return nil
+	}
+	if full {
+		return errChannelLimit
+	}
+
+	// Authorization reads the database, so it runs outside the lock.
+	if err := authorizeChannel(s.client.UserID, channel); err != nil {
+		if err != errChannelInvalid && err != errChannelForbidden && err != errChannelNotFound {
+			logrus.Errorf("Failed to authorize channel %s for user %s: %v", channel, s.client.UserID, err)
+			return fmt.Errorf("subscription failed")
+		}
+		return err
+	}
+
+	clientsMutex.Lock()
+	s.client.Channels[channel] = true
+	clientsMutex.Unlock()
+	return nil
+}
+
+func (s *wsSession) publish(data json.RawMessage) (string, error) {
+	if !config.Events.WSPublishEnabled {
+		wsPublishedBatchesTotal.WithLabelValues("disabled").Inc()
+		return "", errPublishDisabled
+	}
+	message, traceID, err := buildBatchedUpdatesMessage(s.client.UserID, s.username, s.client.DeviceID, data)
+	if err != nil {
+		wsPublishedBatchesTotal.WithLabelValues("rejected").Inc()
+		return "", err
+	}
+	if err := publishBatchFunc(message); err != nil {
+		wsPublishedBatchesTotal.WithLabelValues("failed").Inc()
+		logrus.Errorf("Failed to publish WebSocket batch trace_id=%s user=%s: %v", traceID, s.client.UserID, err)
+		return "", fmt.Errorf("publish failed")
+	}
+	wsPublishedBatchesTotal.WithLabelValues("published").Inc()
+	logrus.Infof("Published WebSocket batch trace_id=%s user=%s device=%s", traceID, s.client.UserID, s.client.DeviceID)
+	return traceID, nil
+}
+
+// formatWSFrame renders a queued frame as a JSON message. Broadcasts are
+// "event" messages on their channel, with "event" naming a typed event;
+// control frames (resync) use their name as the type.
+func formatWSFrame(f sseFrame) []byte {
+	msg := map[string]interface{}{"type": "event"}
+	switch {
+	case f.Typed:
+		msg["event"] = f.Name
+	case f.Name != "":
+		msg["type"] = f.Name
+	}
+	if f.Channel != "" {
+		msg["channel"] = f.Channel
+	}
+	if f.ID != 0 {
+		msg["id"] = formatEventID(f.ID)
+	}
+	msg["data"] = json.RawMessage(f.Data)
+	out, err := json.Marshal(msg)
+	if err != nil {
+		// Data that is not JSON is sent as a string instead.
+		msg["data"] = string(f.Data)
+		out, _ = json.Marshal(msg)
+	}
+	return out
+}
+
+func wsOK(ref string, fields map[string]interface{}) []byte {
+	msg := map[string]interface{}{"type": "ok"}
+	for k, v := range fields {
+		msg[k] = v
+	}
+	if ref != "" {
+		msg["ref"] = ref
+	}
+	out, _ := json.Marshal(msg)
+	return out
+}
+
+func wsError(ref, reason string) []byte {
+	msg := map[string]interface{}{"type": "error", "error": reason}
+	if ref != "" {
+		msg["ref"] = ref
+	}
+	out, _ := json.Marshal(msg)
+	return out
+}
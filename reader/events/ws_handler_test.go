@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	fastws "github.com/fasthttp/websocket"
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestWSUpgrade_RequiresUpgradeAndAllowedOrigin(t *testing.T) {
+	prevOrigins := allowedOrigins
+	t.Cleanup(func() { allowedOrigins = prevOrigins })
+	allowedOrigins = map[string]struct{}{"https://app.example": {}}
+
+	app := fiber.New()
+	app.Get("/api/ws", wsUpgrade, func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusNoContent) })
+
+	req := httptest.NewRequest(fiber.MethodGet, "/api/ws?device_id=d-1", nil)
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if resp.StatusCode != fiber.StatusUpgradeRequired {
+		t.Fatalf("expected %d, got %d", fiber.StatusUpgradeRequired, resp.StatusCode)
+	}
+
+	req = httptest.NewRequest(fiber.MethodGet, "/api/ws?device_id=d-1", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Origin", "https://evil.example")
+	resp, err = app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if resp.StatusCode != fiber.StatusForbidden {
+		t.Fatalf("expected %d for a foreign origin, got %d", fiber.StatusForbidden, resp.StatusCode)
+	}
+}
+
+func TestAuthorizeChannel(t *testing.T) {
+	origDB := db
+	defer func() { db = origDB }()
+
+	gdb, mock, sqlDB := setupMockGormDB(t)
+	defer sqlDB.Close()
+	db = gdb
+
+	if err := authorizeChannel("u-1", "user:u-1"); err != nil {
+		t.Fatalf("expected own channel to be allowed, got %v", err)
+	}
+	if err := authorizeChannel("u-1", "user:u-2"); err != errChannelForbidden {
+		t.Fatalf("expected another user's channel to be forbidden, got %v", err)
+	}
+	if err := authorizeChannel("u-1", "boxes:1"); err != errChannelInvalid {
+		t.Fatalf("expected unknown kind to be invalid, got %v", err)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `trades` WHERE trade_id = ? AND (user_id_proposed = ? OR user_id_accepting = ?)")).
+		WithArgs("t-1", "u-1", "u-1").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	if err := authorizeChannel("u-1", "trade:t-1"); err != errChannelNotFound {
+		t.Fatalf("expected a trade the caller is not part of to be hidden, got %v", err)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `users` WHERE username = ?")).
+		WithArgs("misty").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	if err := authorizeChannel("u-1", "trainer:misty"); err != nil {
+		t.Fatalf("expected trainer channel to be allowed, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet sql expectations: %v", err)
+	}
+}
+
+func TestChannelPayloads_SplitsByChannel(t *testing.T) {
+	tradeMap := map[string]interface{}{
+		"t-1": map[string]interface{}{"pokemon_instance_id_user_proposed": "i-1", "pokemon_instance_id_user_accepting": "i-2"},
+	}
+	related := map[string]interface{}{"i-1": map[string]interface{}{}, "i-2": map[string]interface{}{}, "i-9": map[string]interface{}{}}
+	pokemon := map[string]interface{}{
+		"i-3": map[string]interface{}{"key": "i-3"},
+		"i-2": map[string]interface{}{"username": "brock"},
+	}
+
+	out := channelPayloads([]byte(`{"full":true}`), map[string]bool{"u-1": true}, tradeMap, related, "ash", pokemon)
+
+	if string(out["user:u-1"]) != `{"full":true}` {
+		t.Fatalf("expected the full payload on the user channel, got %s", out["user:u-1"])
+	}
+	var trade struct {
+		Trade           map[string]interface{} `json:"trade"`
+		RelatedInstance map[string]interface{} `json:"relatedInstance"`
+	}
+	if err := json.Unmarshal(out["trade:t-1"], &trade); err != nil {
+		t.Fatalf("decode trade payload: %v", err)
+	}
+	if _, ok := trade.Trade["t-1"]; !ok || len(trade.RelatedInstance) != 2 {
+		t.Fatalf("expected the trade and its two instances, got %s", out["trade:t-1"])
+	}
+	for _, name := range []string{"ash", "brock"} {
+		if !strings.Contains(string(out["trainer:"+name]), `"pokemon_changed":1`) {
+			t.Fatalf("expected a change notice for %s, got %s", name, out["trainer:"+name])
+		}
+	}
+	if strings.Contains(string(out["trainer:ash"]), "i-3") {
+		t.Fatalf("trainer notices must not carry instance data: %s", out["trainer:ash"])
+	}
+}
+
+func TestDeliverLocked_RoutesBySubscription(t *testing.T) {
+	origClients := clients
+	clients = make(map[string]*Client)
+	defer func() { clients = origClients }()
+
+	sse := &Client{UserID: "u-1", DeviceID: "d-1", Queue: newOutboundQueue(4, 0, overflowPolicyResync), Connected: true}
+	ws := &Client{UserID: "u-2", DeviceID: "d-2", Queue: newOutboundQueue(4, 0, overflowPolicyResync), Connected: true,
+		Channels: map[string]bool{"user:u-2": true, "trade:t-1": true}}
+	origin := &Client{UserID: "u-1", DeviceID: "d-0", Queue: newOutboundQueue(4, 0, overflowPolicyResync), Connected: true}
+	clients["u-1:d-1"], clients["u-2:d-2:ws"], clients["u-1:d-0"] = sse, ws, origin
+
+	deliverLocked(7, map[string][]byte{
+		"user:u-1":  []byte(`{"u":1}`),
+		"trade:t-1": []byte(`{"t":1}`),
+	}, "d-0")
+
+	if got := sse.Queue.drain(); len(got) != 1 || got[0].Channel != "user:u-1" || got[0].ID != 7 {
+		t.Fatalf("expected the SSE client to get its user channel only, got %+v", got)
+	}
+	if got := ws.Queue.drain(); len(got) != 1 || got[0].Channel != "trade:t-1" {
+		t.Fatalf("expected the WS client to get the subscribed trade only, got %+v", got)
+	}
+	if got := origin.Queue.drain(); len(got) != 0 {
+		t.Fatalf("expected the originating device to be skipped, got %+v", got)
+	}
+}
+
+func TestBuildBatchedUpdatesMessage(t *testing.T) {
+	msg, traceID, err := buildBatchedUpdatesMessage("u-1", "ash", "d-1", json.RawMessage(`{"pokemonUpdates":[{"key":"i-1","cp":1500}]}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var env map[string]interface{}
+	if err := json.Unmarshal(msg, &env); err != nil {
+		t.Fatalf("decode envelope: %v", err)
+	}
+	if env["user_id"] != "u-1" || env["username"] != "ash" || env["device_id"] != "d-1" || env["trace_id"] != traceID {
+		t.Fatalf("unexpected envelope identity: %v", env)
+	}
+	for _, key := range []string{"tradeUpdates", "tradeRatings", "tagUpdates", "tradeMessages", "tradeMessageReads",
+		"tradeMessageReports", "tradeCycleProposals"} {
+		if list, ok := env[key].([]interface{}); !ok || len(list) != 0 {
+			t.Fatalf("expected %s to default to an empty list, got %v", key, env[key])
+		}
+	}
+	if !strings.Contains(string(msg), `"cp":1500`) {
+		t.Fatalf("expected numbers to pass through unchanged: %s", msg)
+	}
+
+	if _, _, err := buildBatchedUpdatesMessage("u-1", "ash", "d-1", json.RawMessage(`[1]`)); err == nil {
+		t.Fatalf("expected a non-object batch to be rejected")
+	}
+	big := `{"tagUpdates":[` + strings.TrimSuffix(strings.Repeat(`{},`, maxUpdatesPerBatch+1), ",") + `]}`
+	if _, _, err := buildBatchedUpdatesMessage("u-1", "ash", "d-1", json.RawMessage(big)); err == nil {
+		t.Fatalf("expected an oversized batch to be rejected")
+	}
+	for _, bad := range []string{
+		`{"tradeMessage":[{"trade_id":"t-1","body":"hi"}]}`,
+		`{"tradeUpdates":[]}{"tradeUpdates":[]}`,
+		`{"tradeMessages":[` + strings.TrimSuffix(strings.Repeat(`{},`, maxTradeMessagesPerBatch+1), ",") + `]}`,
+		`{"tradeCycleProposals":[` + strings.TrimSuffix(strings.Repeat(`{},`, maxTradeCycleProposalsPerBatch+1), ",") + `]}`,
+	} {
+		if _, _, err := buildBatchedUpdatesMessage("u-1", "ash", "d-1", json.RawMessage(bad)); err == nil {
+			t.Fatalf("expected %.60s to be rejected", bad)
+		}
+	}
+}
+
+func TestBuildBatchedUpdatesMessage_ForwardsChatAndCycles(t *testing.T) {
+	raw := `{"tradeMessages":[{"trade_id":"t-1","body":"hi"}],"tradeMessageReads":[{"trade_id":"t-1","up_to_message_id":"9"}],` +
+		`"tradeMessageReports":[{"message_id":"9","reason":"spam"}],"tradeCycleProposals":[{"trade_cycle_id":"c-1","legs":[]}]}`
+	msg, _, err := buildBatchedUpdatesMessage("u-1", "ash", "d-1", json.RawMessage(raw))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var env map[string]interface{}
+	if err := json.Unmarshal(msg, &env); err != nil {
+		t.Fatalf("decode envelope: %v", err)
+	}
+	for _, key := range []string{"tradeMessages", "tradeMessageReads", "tradeMessageReports", "tradeCycleProposals"} {
+		if list, ok := env[key].([]interface{}); !ok || len(list) != 1 {
+			t.Fatalf("expected %s to be forwarded, got %v", key, env[key])
+		}
+	}
+}
+
+func TestWSSession_HandleMessage(t *testing.T) {
+	prevPublish, prevEnabled := publishBatchFunc, config.Events.WSPublishEnabled
+	t.Cleanup(func() { publishBatchFunc, config.Events.WSPublishEnabled = prevPublish, prevEnabled })
+	prevInstance := config.Events.InstanceID
+	t.Cleanup(func() { config.Events.InstanceID = prevInstance })
+	config.Events.InstanceID = "events-a"
+
+	s := &wsSession{
+		client:   &Client{UserID: "u-1", DeviceID: "d-1", Channels: map[string]bool{"user:u-1": true}},
+		username: "ash",
+	}
+
+	if reply := s.handleMessage([]byte(`{"type":"ack","id":"42@events-a"}`)); reply != nil || s.client.LastAckedID != 42 {
+		t.Fatalf("expected a silent ack recording 42, got %s / %d", reply, s.client.LastAckedID)
+	}
+	if reply := string(s.handleMessage([]byte(`{"type":"subscribe","ref":"1","channel":"user:u-2"}`))); !strings.Contains(reply, `"error":"channel not allowed"`) || !strings.Contains(reply, `"ref":"1"`) {
+		t.Fatalf("expected a forbidden subscription error, got %s", reply)
+	}
+
+	config.Events.WSPublishEnabled = false
+	if reply := string(s.handleMessage([]byte(`{"type":"batchedUpdates","ref":"2","data":{}}`))); !strings.Contains(reply, `"type":"error"`) {
+		t.Fatalf("expected publishing to be refused when disabled, got %s", reply)
+	}
+
+	config.Events.WSPublishEnabled = true
+	var published []byte
+	publishBatchFunc = func(data []byte) error { published = data; return nil }
+	reply := string(s.handleMessage([]byte(`{"type":"batchedUpdates","ref":"3","data":{"pokemonUpdates":[]}}`)))
+	if !strings.Contains(reply, `"type":"ok"`) || !strings.Contains(reply, `"trace_id"`) || published == nil {
+		t.Fatalf("expected the batch to be published, got %s", reply)
+	}
+
+	if reply := string(s.handleMessage([]byte(`{"type":"shout"}`))); !strings.Contains(reply, "unknown message type") {
+		t.Fatalf("expected unknown types to be rejected, got %s", reply)
+	}
+}
+
+func TestFormatWSFrame(t *testing.T) {
+	prev := config.Events.InstanceID
+	t.Cleanup(func() { config.Events.InstanceID = prev })
+	config.Events.InstanceID = "events-a"
+
+	got := string(formatWSFrame(sseFrame{ID: 42, Channel: "user:u-1", Data: []byte(`{"pokemon":{}}`)}))
+	if got != `{"channel":"user:u-1","data":{"pokemon":{}},"id":"42@events-a","type":"event"}` {
+		t.Fatalf("unexpected frame %s", got)
+	}
+	got = string(formatWSFrame(sseFrame{Name: eventTradeAccepted, Typed: true, Channel: "user:u-1", Data: []byte(`{}`)}))
+	if got != `{"channel":"user:u-1","data":{},"event":"trade.accepted","type":"event"}` {
+		t.Fatalf("unexpected typed frame %s", got)
+	}
+	got = string(formatWSFrame(sseFrame{Name: "resync", Data: []byte(`{"reason":"queue_overflow"}`)}))
+	if got != `{"data":{"reason":"queue_overflow"},"type":"resync"}` {
+		t.Fatalf("unexpected frame %s", got)
+	}
+}
+
+func TestWSHandler_DeliversSubscribedEvents(t *testing.T) {
+	origClients := clients
+	clients = make(map[string]*Client)
+	defer func() { clients = origClients }()
+	prevQueue, prevMax := config.Events.QueueSize, config.Events.WSMaxMessageBytes
+	t.Cleanup(func() { config.Events.QueueSize, config.Events.WSMaxMessageBytes = prevQueue, prevMax })
+	config.Events.QueueSize, config.Events.WSMaxMessageBytes = 8, 1<<20
+
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user_id", "u-1")
+		c.Locals("username", "ash")
+		return c.Next()
+	})
+	app.Get("/api/ws", wsUpgrade, websocket.New(wsHandler))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go app.Listener(ln)
+	defer app.Shutdown()
+
+	conn, _, err := fastws.DefaultDialer.Dial("ws://"+ln.Addr().String()+"/api/ws?device_id=d-1", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	read := func() map[string]interface{} {
+		t.Helper()
+		var msg map[string]interface{}
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		return msg
+	}
+	if msg := read(); msg["type"] != "welcome" {
+		t.Fatalf("expected a welcome message, got %v", msg)
+	}
+
+	clientsMutex.Lock()
+	deliverLocked(nextEventID(), map[string][]byte{"user:u-1": []byte(`{"pokemon":{}}`)}, "other-device")
+	clientsMutex.Unlock()
+	if msg := read(); msg["type"] != "event" || msg["channel"] != "user:u-1" {
+		t.Fatalf("expected the broadcast on the user channel, got %v", msg)
+	}
+
+	if err := conn.WriteJSON(map[string]string{"type": "ping", "ref": "p"}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if msg := read(); msg["type"] != "ok" || msg["ref"] != "p" {
+		t.Fatalf("expected the ping to be answered, got %v", msg)
+	}
+}
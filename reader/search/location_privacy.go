@@ -0,0 +1,91 @@
+// location_privacy.go
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Other trainers' coordinates never leave the service as stored. Only
+// trainers who opted in (users.allow_location) are located at all, and
+// then at the centre of their geohash cell of locationCellPrecision
+// characters, about 4.9 km square. Distances are measured from that centre
+// and rounded to whole km.
+
+const (
+	locationCellPrecision = 5
+	// locationCellDegrees is the side of such a cell: its 13 longitude and
+	// 12 latitude bits make both 360/2^13 = 180/2^12 degrees.
+	locationCellDegrees = 360.0 / 8192
+	// locationCellSlackKM is half a cell diagonal. Bounding boxes on the
+	// stored point are widened by it, so no trainer whose cell centre is in
+	// range is cut.
+	locationCellSlackKM = 3.5
+)
+
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// blurCoordinate snaps v, offset to be non-negative, to the centre of its
+// cell.
+func blurCoordinate(v, offset float64) float64 {
+	return (math.Floor((v+offset)/locationCellDegrees)+0.5)*locationCellDegrees - offset
+}
+
+// blurLocation is the centre of the cell holding (lat, lng).
+func blurLocation(lat, lng float64) (float64, float64) {
+	return blurCoordinate(lat, 90), blurCoordinate(lng, 180)
+}
+
+// locationCell is the geohash of the cell holding (lat, lng).
+func locationCell(lat, lng float64) string {
+	latLo, latHi, lngLo, lngHi := -90.0, 90.0, -180.0, 180.0
+	out := make([]byte, 0, locationCellPrecision)
+	bits, ch := 0, 0
+	for even := true; len(out) < locationCellPrecision; even = !even {
+		ch <<= 1
+		if even {
+			if mid := (lngLo + lngHi) / 2; lng >= mid {
+				ch |= 1
+				lngLo = mid
+			} else {
+				lngHi = mid
+			}
+		} else {
+			if mid := (latLo + latHi) / 2; lat >= mid {
+				ch |= 1
+				latLo = mid
+			} else {
+				latHi = mid
+			}
+		}
+		if bits++; bits == 5 {
+			out = append(out, geohashAlphabet[ch])
+			bits, ch = 0, 0
+		}
+	}
+	return string(out)
+}
+
+// blurredPointSQL is blurLocation for a POINT(longitude, latitude) column.
+func blurredPointSQL(column string) string {
+	side := strconv.FormatFloat(locationCellDegrees, 'f', -1, 64)
+	return fmt.Sprintf("POINT((FLOOR((ST_X(%[1]s) + 180) / %[2]s) + 0.5) * %[2]s - 180, "+
+		"(FLOOR((ST_Y(%[1]s) + 90) / %[2]s) + 0.5) * %[2]s - 90)", column, side)
+}
+
+// roundDistanceKM rounds a distance to whole km; anything closer reads as 1.
+func roundDistanceKM(km float64) float64 {
+	return math.Max(1, math.Round(km))
+}
+
+// trainerLocation is the blurred location of a trainer and its cell; ok is
+// false when they did not opt in or have no location.
+func trainerLocation(u *User) (lat, lng float64, cell string, ok bool) {
+	if u == nil || !u.AllowLocation || u.Latitude == nil || u.Longitude == nil {
+		return 0, 0, "", false
+	}
+	lat, lng = blurLocation(*u.Latitude, *u.Longitude)
+	return lat, lng, locationCell(*u.Latitude, *u.Longitude), true
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestLocationCell(t *testing.T) {
+	if got := locationCell(57.64911, 10.40744); got != "u4pru" {
+		t.Fatalf("expected geohash u4pru, got %q", got)
+	}
+	if got := locationCell(-25.382708, -49.265506); got != "6gkzw" {
+		t.Fatalf("expected geohash 6gkzw, got %q", got)
+	}
+}
+
+func TestBlurLocation_SnapsToCellCentre(t *testing.T) {
+	lat, lng := blurLocation(57.64911, 10.40744)
+	if locationCell(lat, lng) != "u4pru" {
+		t.Fatalf("expected the centre to stay in its cell, got %v,%v", lat, lng)
+	}
+	if otherLat, otherLng := blurLocation(lat+0.01, lng-0.01); otherLat != lat || otherLng != lng {
+		t.Fatalf("expected points in one cell to share a location")
+	}
+	if d := haversine(57.64911, 10.40744, lat, lng); d == 0 || d > locationCellSlackKM {
+		t.Fatalf("expected the centre within half a cell diagonal, got %.2f km", d)
+	}
+	if blurCoordinate(-0.01, 90) >= 0 || blurCoordinate(0.01, 90) <= 0 {
+		t.Fatalf("expected the equator to split cells")
+	}
+}
+
+func TestBlurredPointSQL(t *testing.T) {
+	sql := blurredPointSQL("User.location")
+	if !strings.Contains(sql, "ST_X(User.location) + 180") || !strings.Contains(sql, "0.0439453125") {
+		t.Fatalf("unexpected blur expression %s", sql)
+	}
+}
+
+func TestTradeMatchInRangeSQL_UsesBlurredOptedInLocations(t *testing.T) {
+	for _, want := range []string{"tu.allow_location = 1", "wu.allow_location = 1", blurredPointSQL("tu.location"), blurredPointSQL("wu.location")} {
+		if !strings.Contains(tradeMatchInRangeSQL, want) {
+			t.Fatalf("expected %q in %s", want, tradeMatchInRangeSQL)
+		}
+	}
+	if strings.Contains(tradeMatchInRangeSQL, "ST_Distance_Sphere(tu.location") {
+		t.Fatalf("expected the exact points never to be compared: %s", tradeMatchInRangeSQL)
+	}
+}
+
+func TestRoundDistanceKM(t *testing.T) {
+	for in, want := range map[float64]float64{0: 1, 0.4: 1, 1.49: 1, 1.5: 2, 12.7: 13} {
+		if got := roundDistanceKM(in); got != want {
+			t.Fatalf("distance %v: expected %v, got %v", in, want, got)
+		}
+	}
+}
+
+func TestTrainerLocation_NeedsOptIn(t *testing.T) {
+	lat, lng := 52.52, 13.405
+	u := &User{UserID: "u-1", Latitude: &lat, Longitude: &lng}
+	if _, _, _, ok := trainerLocation(u); ok {
+		t.Fatalf("expected no location without allow_location")
+	}
+	u.AllowLocation = true
+	bLat, bLng, cell, ok := trainerLocation(u)
+	if !ok || cell != locationCell(lat, lng) || (bLat == lat && bLng == lng) {
+		t.Fatalf("expected a blurred location, got %v,%v %q ok=%v", bLat, bLng, cell, ok)
+	}
+	if math.Abs(bLat-lat) > locationCellDegrees/2 || math.Abs(bLng-lng) > locationCellDegrees/2 {
+		t.Fatalf("expected the blurred location within the cell")
+	}
+	if _, _, _, ok := trainerLocation(nil); ok {
+		t.Fatalf("expected no location without a trainer")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"database/sql/driver"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"gorm.io/datatypes"
 )
@@ -45,10 +46,12 @@ func (j *JSON) Scan(src interface{}) error {
 
 // User struct for the users table
 type User struct {
-	UserID    string   `gorm:"column:user_id;primaryKey" json:"user_id"`
-	Username  string   `gorm:"column:username;unique" json:"username"`
-	Latitude  *float64 `gorm:"column:latitude" json:"latitude"`
-	Longitude *float64 `gorm:"column:longitude" json:"longitude"`
+	UserID   string `gorm:"column:user_id;primaryKey" json:"user_id"`
+	Username string `gorm:"column:username;unique" json:"username"`
+	// Stored coordinates stay in the service; see location_privacy.go.
+	Latitude      *float64 `gorm:"column:latitude" json:"-"`
+	Longitude     *float64 `gorm:"column:longitude" json:"-"`
+	AllowLocation bool     `gorm:"column:allow_location" json:"-"`
 }
 
 // TableName sets the name of the table in the database
@@ -111,9 +114,34 @@ type PokemonInstance struct {
 	MaxAttack       *string        `gorm:"column:max_attack"`
 	MaxGuard        *string        `gorm:"column:max_guard"`
 	MaxSpirit       *string        `gorm:"column:max_spirit"`
+	// DistanceKM is computed by location searches; it is not a column.
+	DistanceKM *float64 `gorm:"column:distance_km;->;-:migration" json:"-"`
 }
 
 // TableName sets the name of the table in the database
 func (PokemonInstance) TableName() string {
 	return "instances" // Correct table name
 }
+
+// TrainerReputation is the per-trainer rollup of trade ratings and outcomes
+// maintained by storage.
+type TrainerReputation struct {
+	UserID           string   `gorm:"column:user_id;primaryKey" json:"-"`
+	RatingAverage    *float64 `gorm:"column:rating_average" json:"rating_average"`
+	RatingCount      int64    `gorm:"column:rating_count" json:"rating_count"`
+	TradesCompleted  int64    `gorm:"column:trades_completed" json:"trades_completed"`
+	TradesCancelled  int64    `gorm:"column:trades_cancelled" json:"trades_cancelled"`
+	CompletionRate   *float64 `gorm:"column:completion_rate" json:"completion_rate"`
+	CancellationRate *float64 `gorm:"column:cancellation_rate" json:"cancellation_rate"`
+}
+
+func (TrainerReputation) TableName() string {
+	return "trainer_reputation"
+}
+
+// TrainerPresence is a result owner's online status, for trainers who share
+// it. The events replicas maintain user_presence.
+type TrainerPresence struct {
+	Status       string     `json:"status"` // online, recent or offline
+	LastActiveAt *time.Time `json:"last_active_at,omitempty"`
+}
@@ -7,9 +7,11 @@ import (
 	"fmt"
 	"math"
 	"strconv"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
 )
 
 // Haversine function to calculate the distance between two latitude/longitude pairs
@@ -28,6 +30,53 @@ func haversine(lat1, lon1, lat2, lon2 float64) float64 {
 	return R * c
 }
 
+const earthRadiusKM = 6371
+
+// geoBox is a latitude/longitude rectangle around a search circle.
+type geoBox struct {
+	MinLat, MinLng, MaxLat, MaxLng float64
+}
+
+// boundingBox returns the smallest box holding every point within rangeKM
+// of (lat, lng). Near a pole or across the antimeridian it spans every
+// longitude instead.
+func boundingBox(lat, lng, rangeKM float64) geoBox {
+	angular := rangeKM / earthRadiusKM
+	dLat := angular * 180 / math.Pi
+	box := geoBox{MinLat: lat - dLat, MinLng: -180, MaxLat: lat + dLat, MaxLng: 180}
+	if box.MinLat <= -90 || box.MaxLat >= 90 {
+		box.MinLat = math.Max(box.MinLat, -90)
+		box.MaxLat = math.Min(box.MaxLat, 90)
+		return box
+	}
+	dLng := math.Asin(math.Sin(angular)/math.Cos(lat*math.Pi/180)) * 180 / math.Pi
+	if lng-dLng >= -180 && lng+dLng <= 180 {
+		box.MinLng, box.MaxLng = lng-dLng, lng+dLng
+	}
+	return box
+}
+
+// wkt renders the box as a polygon in users.location's (longitude,
+// latitude) axis order.
+func (b geoBox) wkt() string {
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', 6, 64) }
+	minLng, minLat, maxLng, maxLat := f(b.MinLng), f(b.MinLat), f(b.MaxLng), f(b.MaxLat)
+	return fmt.Sprintf("POLYGON((%s %s, %s %s, %s %s, %s %s, %s %s))",
+		minLng, minLat, maxLng, minLat, maxLng, maxLat, minLng, maxLat, minLng, minLat)
+}
+
+// distanceExpr is the distance in km from (lat, lng) to each trainer's
+// blurred location. The coordinates are inlined so GORM keeps the joined
+// User columns next to it in the select.
+func distanceExpr(lat, lng float64) string {
+	return fmt.Sprintf("(ST_Distance_Sphere(%s, POINT(%s, %s)) / 1000)", blurredPointSQL("User.location"),
+		strconv.FormatFloat(lng, 'f', -1, 64), strconv.FormatFloat(lat, 'f', -1, 64))
+}
+
+func distanceSelect(lat, lng float64) string {
+	return distanceExpr(lat, lng) + " AS distance_km"
+}
+
 func SearchPokemonInstances(c *fiber.Ctx) error {
 	// Extract query parameters
 	pokemonIDStr := c.Query("pokemon_id")
@@ -54,28 +103,30 @@ func SearchPokemonInstances(c *fiber.Ctx) error {
 	tradeInWantedListStr := c.Query("trade_in_wanted_list")
 	dynamaxStr := c.Query("dynamax")
 	gigantamaxStr := c.Query("gigantamax")
+	sortStr := c.Query("sort")
+	cursorStr := c.Query("cursor")
 
 	logrus.Infof("Received search query with params: pokemon_id=%s, shiny=%s, shadow=%s, costume_id=%s, ownership=%s, limit=%s, range_km=%s, latitude=%s, longitude=%s, fast_move_id=%s, charged_move_1_id=%s, charged_move_2_id=%s, gender=%s, already_registered=%s, attack_iv=%s, defense_iv=%s, stamina_iv=%s, background_id=%s, pref_lucky=%s, friendship_level=%s, only_matching_trades=%s, trade_in_wanted_list=%s, dynamax=%s, gigantamax=%s",
 		pokemonIDStr, shinyStr, shadowStr, costumeIDStr, ownership, limitStr, rangeKMStr, latitudeStr, longitudeStr, fastMoveIDStr, chargedMove1IDStr, chargedMove2IDStr, genderStr, alreadyRegisteredStr, attackIVStr, defenseIVStr, staminaIVStr, backgroundIDStr, prefLuckyStr, friendshipLevelStr, onlyMatchingTradesStr, tradeInWantedListStr, dynamaxStr, gigantamaxStr)
 
 	// Parse parameters into appropriate types
-	var pokemonID, fastMoveID, chargedMove1ID, chargedMove2ID int
+	var chargedMove1ID, chargedMove2ID int
 	var err error
 
-	// Parse individual parameters
-	if pokemonIDStr != "" {
-		pokemonID, err = strconv.Atoi(pokemonIDStr)
-		if err != nil {
-			logrus.Error("Invalid pokemon_id: ", err)
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid pokemon_id"})
-		}
+	// Species, costume and fast move lists, ranges and flags.
+	filters, err := parseSearchFilters(func(key string) string { return c.Query(key) })
+	if err != nil {
+		logrus.Error("Invalid search filter: ", err)
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
 	}
-
-	if fastMoveIDStr != "" {
-		fastMoveID, err = strconv.Atoi(fastMoveIDStr)
-		if err != nil {
-			logrus.Error("Invalid fast_move_id: ", err)
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid fast_move_id"})
+	var sizes map[int]speciesSize
+	if len(filters.Sizes) > 0 {
+		if len(filters.PokemonIDs) == 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "size requires pokemon_id"})
+		}
+		if sizes, err = speciesSizes(filters.PokemonIDs); err != nil {
+			logrus.Error("Error loading species sizes: ", err)
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "Size filter unavailable"})
 		}
 	}
 
@@ -158,17 +209,6 @@ func SearchPokemonInstances(c *fiber.Ctx) error {
 		staminaIV = &iv
 	}
 
-	// Determine the value of costumeID based on the input
-	var costumeID *int
-	if costumeIDStr != "" && costumeIDStr != "null" {
-		cid, err := strconv.Atoi(costumeIDStr)
-		if err != nil {
-			logrus.Error("Invalid costume_id: ", err)
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid costume_id"})
-		}
-		costumeID = &cid
-	}
-
 	// Determine the value of gender based on the input
 	var gender *string
 	if genderStr != "" && genderStr != "null" {
@@ -223,13 +263,10 @@ func SearchPokemonInstances(c *fiber.Ctx) error {
 		prefLucky = &pl
 	}
 
-	var limit int = 25 // Default limit
-	if limitStr != "" {
-		limit, err = strconv.Atoi(limitStr)
-		if err != nil {
-			logrus.Error("Invalid limit value: ", err)
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid limit"})
-		}
+	limit, err := parseSearchLimit(limitStr)
+	if err != nil {
+		logrus.Error("Invalid limit value: ", limitStr)
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid limit"})
 	}
 
 	var rangeKM float64 = 5 // Default range in km
@@ -253,6 +290,31 @@ func SearchPokemonInstances(c *fiber.Ctx) error {
 			logrus.Error("Invalid longitude: ", err)
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid longitude"})
 		}
+		if latitude < -90 || latitude > 90 || longitude < -180 || longitude > 180 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Latitude or longitude out of range"})
+		}
+	}
+
+	hasLocation := latitudeStr != "" && longitudeStr != ""
+	order, err := searchSortFor(sortStr, hasLocation, latitude, longitude)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	facetNames, err := parseFacetNames(c.Query("facets"), hasLocation)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	if facetNames != nil && cursorStr != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "facets cannot be paged"})
+	}
+	fingerprint := searchFingerprint(c)
+	var cursor *searchCursor
+	if cursorStr != "" {
+		cur, err := decodeSearchCursor(cursorStr, order.Name, fingerprint)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid cursor"})
+		}
+		cursor = &cur
 	}
 
 	var friendshipLevel *int
@@ -307,7 +369,7 @@ func SearchPokemonInstances(c *fiber.Ctx) error {
 	}
 
 	// Start building the query
-	query := db.Preload("User").Model(&PokemonInstance{})
+	query := db.Model(&PokemonInstance{})
 
 	// Exclude current user's own instances if ownership is "trade" and only_matching_trades is true
 	if ownership == "trade" && onlyMatchingTrades {
@@ -320,8 +382,8 @@ func SearchPokemonInstances(c *fiber.Ctx) error {
 	}
 
 	// Apply filters based on parameters
-	if pokemonIDStr != "" {
-		query = query.Where("pokemon_id = ?", pokemonID)
+	for _, cond := range filters.conds(sizes) {
+		query = query.Where(cond.SQL, cond.Args...)
 	}
 
 	if shinyStr != "" {
@@ -340,13 +402,6 @@ func SearchPokemonInstances(c *fiber.Ctx) error {
 		query = query.Where("gigantamax = ?", gigantamax)
 	}
 
-	// Apply costume_id filtering logic
-	if costumeIDStr == "" || costumeIDStr == "null" {
-		query = query.Where("costume_id IS NULL")
-	} else if costumeID != nil {
-		query = query.Where("costume_id = ?", *costumeID)
-	}
-
 	// Apply gender filtering logic
 	if gender != nil {
 		query = query.Where("gender = ?", *gender)
@@ -395,11 +450,6 @@ func SearchPokemonInstances(c *fiber.Ctx) error {
 		}
 	}
 
-	// Apply fast_move_id filter if present
-	if fastMoveIDStr != "" {
-		query = query.Where("fast_move_id = ?", fastMoveID)
-	}
-
 	// Apply charged_move filtering logic
 	if chargedMove1IDStr != "" || chargedMove2IDStr != "" {
 		var chargedMoveQuery string
@@ -419,204 +469,155 @@ func SearchPokemonInstances(c *fiber.Ctx) error {
 		query = query.Where(chargedMoveQuery, chargedMoveArgs...)
 	}
 
-	// Handle location and range filtering
-	if latitudeStr != "" && longitudeStr != "" {
+	// Handle location and range filtering. Only trainers who allow their
+	// location are searched. The bounding box, widened by half a cell, is
+	// answered by the SPATIAL index on users.location; only trainers inside
+	// it get the spherical distance to their blurred location.
+	// The columns are always named: with joins GORM would otherwise list
+	// every model field, distance_km included.
+	selects := []interface{}{}
+	if hasLocation {
 		query = query.Joins("User").
-			Where("User.latitude IS NOT NULL AND User.longitude IS NOT NULL").
-			Where(`
-				6371 * 2 * ASIN(
-					SQRT(
-						POWER(SIN(RADIANS(User.latitude - ?) / 2), 2) +
-						COS(RADIANS(?)) * COS(RADIANS(User.latitude)) *
-						POWER(SIN(RADIANS(User.longitude - ?) / 2), 2)
-					)
-				) < ?`, latitude, latitude, longitude, rangeKM)
+			Where("User.allow_location = ? AND User.latitude IS NOT NULL AND User.longitude IS NOT NULL", true).
+			Where("MBRContains(ST_GeomFromText(?), User.location)", boundingBox(latitude, longitude, rangeKM+locationCellSlackKM).wkt()).
+			Where(distanceExpr(latitude, longitude)+" <= ?", rangeKM)
+		selects = append(selects, distanceSelect(latitude, longitude))
 	}
+	query = query.Select("instances.*", selects...)
 
 	if friendshipLevel != nil {
 		query = query.Where("friendship_level = ?", *friendshipLevel)
 	}
+	if order.Name == sortReputation {
+		query = query.Joins(reputationJoin)
+	}
 
-	// Apply the limit
-	query = query.Limit(limit)
+	// Mutual matches are looked up in the trade_matches index storage keeps.
+	if ownership == "trade" && onlyMatchingTrades && userID != "" {
+		query = query.Where(tradeMatchExistsSQL, userID)
+	}
+	if ownership == "wanted" && tradeInWantedList && userID != "" {
+		query = query.Where(wantedMatchExistsSQL, userID)
+	}
+
+	if facetNames != nil {
+		return respondSearchFacets(c, query, facetNames, hasLocation, latitude, longitude, userID+"|"+fingerprint)
+	}
+
+	totalIsEstimate := false
+	var totalCount *int64
+	if cursor == nil {
+		var n int64
+		matched := query.Session(&gorm.Session{}).Select("instances.instance_id").Limit(searchCountCap + 1)
+		if err := db.Table("(?) AS matched", matched).Count(&n).Error; err != nil {
+			logrus.Error("Error counting instances: ", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve instances"})
+		}
+		if n > searchCountCap {
+			n = searchCountCap
+			totalIsEstimate = true
+		}
+		totalCount = &n
+	}
+
+	if cursor != nil {
+		cond, args := order.after(*cursor)
+		query = query.Where(cond, args...)
+	}
+	// One extra row tells whether another page follows.
+	query = query.Order(order.orderBy()).Limit(limit + 1)
 
 	// Execute the query
 	var instances []PokemonInstance
-	if err := query.Find(&instances).Error; err != nil {
+	if err := query.Preload("User").Find(&instances).Error; err != nil {
 		logrus.Error("Error retrieving instances: ", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve instances"})
 	}
+	hasMore := len(instances) > limit
+	if hasMore {
+		instances = instances[:limit]
+	}
+
+	results := make([]map[string]interface{}, 0, len(instances))
+	respond := func(nextCursor *string) error {
+		logrus.Infof("Returning %d Pokemon instances", len(results))
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"results":           results,
+			"next_cursor":       nextCursor,
+			"total_count":       totalCount,
+			"total_is_estimate": totalIsEstimate,
+		})
+	}
 
 	// Check if no instances were found
 	if len(instances) == 0 {
 		logrus.Info("No Pokemon instances found for the given parameters")
-		return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "No Pokemon instances found"})
+		return respond(nil)
 	}
 
 	logrus.Infof("Found %d Pokemon instances", len(instances))
 
-	// Retrieve current user's 'for trade' instances if needed
-	var currentUserTradeInstances []PokemonInstance
-	if ownership == "trade" && onlyMatchingTrades && userID != "" {
-		err := db.Model(&PokemonInstance{}).
-			Where("user_id = ? AND is_for_trade = ?", userID, true).
-			Find(&currentUserTradeInstances).Error
+	reputations := loadTrainerReputations(instances)
+	presence := loadTrainerPresence(instances, time.Now())
+
+	// The cursor follows the last row of the page.
+	var nextCursor *string
+	if hasMore {
+		last := instances[len(instances)-1]
+		encoded := encodeSearchCursor(searchCursor{
+			Sort:       order.Name,
+			Value:      order.Value(last, reputations),
+			InstanceID: last.InstanceID,
+			Query:      fingerprint,
+		})
+		nextCursor = &encoded
+	}
+
+	// The owners' lists and which of their entries match the searching
+	// trainer are read once for the whole page.
+	ownerIDs := resultOwnerIDs(instances)
+	var ownerLists map[string][]PokemonInstance
+	var matchedIDs map[string]bool
+	switch ownership {
+	case "trade":
+		ownerLists, err = loadOwnerInstances(ownerIDs, "is_wanted")
 		if err != nil {
-			logrus.Error("Error retrieving current user's trade instances: ", err)
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve current user's trade instances"})
+			logrus.Error("Error retrieving wanted instances: ", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve wanted instances"})
 		}
-	}
-
-	// Retrieve current user's 'wanted' instances if needed
-	var currentUserWantedInstances []PokemonInstance
-	if ownership == "wanted" && tradeInWantedList && userID != "" {
-		err := db.Model(&PokemonInstance{}).
-			Where("user_id = ? AND is_wanted = ?", userID, true).
-			Find(&currentUserWantedInstances).Error
+		if onlyMatchingTrades {
+			matchedIDs = loadMatchedWantedIDs(userID, ownerIDs)
+		}
+	case "wanted":
+		ownerLists, err = loadOwnerInstances(ownerIDs, "is_for_trade")
 		if err != nil {
-			logrus.Error("Error retrieving current user's wanted instances: ", err)
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve current user's wanted instances"})
+			logrus.Error("Error retrieving trade instances: ", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve trade instances"})
+		}
+		if tradeInWantedList {
+			matchedIDs = loadMatchedTradeIDs(userID, ownerIDs)
 		}
 	}
 
-	// Prepare the response data
-	responseData := make(map[string]interface{})
+	// Prepare the response data, in query order
 	for _, instance := range instances {
 		var userDistance float64
 		var instanceUserID, username string
-		var userLatitude, userLongitude *float64
+		userLatitude, userLongitude, userCell, located := trainerLocation(instance.User)
 		if instance.User != nil {
 			instanceUserID = instance.User.UserID
 			username = instance.User.Username
-			userLatitude = instance.User.Latitude
-			userLongitude = instance.User.Longitude
-			if instance.User.Latitude != nil && instance.User.Longitude != nil && latitudeStr != "" && longitudeStr != "" {
-				userDistance = haversine(latitude, longitude, *instance.User.Latitude, *instance.User.Longitude)
-			} else {
-				logrus.Warnf("User %s has missing latitude/longitude data, skipping distance calculation", instance.User.UserID)
+			if instance.DistanceKM != nil {
+				userDistance = roundDistanceKM(*instance.DistanceKM)
+			} else if located && hasLocation {
+				userDistance = roundDistanceKM(haversine(latitude, longitude, userLatitude, userLongitude))
+			} else if hasLocation {
+				logrus.Warnf("User %s has no shared location, skipping distance calculation", instance.User.UserID)
 			}
 		} else {
 			logrus.Warnf("Instance %s has no associated user, skipping user and distance information", instance.InstanceID)
 		}
 
-		// New logic for ownership == "trade" and only_matching_trades == true
-		if ownership == "trade" && onlyMatchingTrades && userID != "" {
-			// Retrieve the wanted instances for the user associated with this instance
-			var wantedInstances []PokemonInstance
-			err := db.Model(&PokemonInstance{}).
-				Where("instances.user_id = ? AND is_wanted = ?", instanceUserID, true).
-				Find(&wantedInstances).Error
-			if err != nil {
-				logrus.Error("Error retrieving wanted instances for user ", instanceUserID, ": ", err)
-				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve wanted instances"})
-			}
-
-			// Compare current user's 'for trade' instances with the wanted instances
-			matchFound := false
-			mismatchReasons := []string{}
-			for _, currentTradeInstance := range currentUserTradeInstances {
-				for _, wantedInstance := range wantedInstances {
-					matched, reason := instancesMatch(currentTradeInstance, wantedInstance)
-					if matched {
-						// Check if the wantedInstance is in the instance's not_wanted_list
-						var notWantedList map[string]bool
-						if len(instance.NotWantedList) > 0 {
-							err := json.Unmarshal(instance.NotWantedList, &notWantedList)
-							if err != nil {
-								logrus.Warnf("Failed to parse not_wanted_list for instance %s: %v", instance.InstanceID, err)
-							}
-						}
-
-						if notWantedList != nil {
-							if _, exists := notWantedList[wantedInstance.InstanceID]; exists {
-								logrus.Infof("Matched wanted instance %s is in instance %s's not_wanted_list; skipping this match.", wantedInstance.InstanceID, instance.InstanceID)
-								continue
-							}
-						}
-
-						logrus.Infof("Match found: Current user's trade instance %s matches user's wanted instance %s", currentTradeInstance.InstanceID, wantedInstance.InstanceID)
-						matchFound = true
-						break
-					} else if reason != "" {
-						mismatchReasons = append(mismatchReasons, fmt.Sprintf(
-							"Trade instance %s and wanted instance %s do not match: %s",
-							currentTradeInstance.InstanceID, wantedInstance.InstanceID, reason))
-					}
-				}
-				if matchFound {
-					break
-				}
-			}
-
-			// If no match is found, skip this instance
-			if !matchFound {
-				logrus.Infof("No matching trade found for instance %s belonging to user %s; skipping instance.", instance.InstanceID, instanceUserID)
-				for _, reason := range mismatchReasons {
-					logrus.Info(reason)
-				}
-				continue
-			}
-		}
-
-		// New logic for ownership == "wanted" and trade_in_wanted_list == true
-		if ownership == "wanted" && tradeInWantedList && userID != "" {
-			// Retrieve the 'for trade' instances for the user associated with this instance
-			var tradeInstances []PokemonInstance
-			err := db.Model(&PokemonInstance{}).
-				Where("instances.user_id = ? AND is_for_trade = ?", instanceUserID, true).
-				Find(&tradeInstances).Error
-			if err != nil {
-				logrus.Error("Error retrieving trade instances for user ", instanceUserID, ": ", err)
-				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve trade instances"})
-			}
-
-			// Compare current user's 'wanted' instances with the trade instances
-			matchFound := false
-			mismatchReasons := []string{}
-			for _, currentWantedInstance := range currentUserWantedInstances {
-				for _, tradeInstance := range tradeInstances {
-					matched, reason := instancesMatch(currentWantedInstance, tradeInstance)
-					if matched {
-						// Check if the tradeInstance is in the instance's not_trade_list
-						var notTradeList map[string]bool
-						if len(instance.NotTradeList) > 0 {
-							err := json.Unmarshal(instance.NotTradeList, &notTradeList)
-							if err != nil {
-								logrus.Warnf("Failed to parse not_trade_list for instance %s: %v", instance.InstanceID, err)
-							}
-						}
-
-						if notTradeList != nil {
-							if _, exists := notTradeList[tradeInstance.InstanceID]; exists {
-								logrus.Infof("Matched trade instance %s is in instance %s's not_trade_list; skipping this match.", tradeInstance.InstanceID, instance.InstanceID)
-								continue
-							}
-						}
-
-						logrus.Infof("Match found: Current user's wanted instance %s matches user's trade instance %s", currentWantedInstance.InstanceID, tradeInstance.InstanceID)
-						matchFound = true
-						break
-					} else if reason != "" {
-						mismatchReasons = append(mismatchReasons, fmt.Sprintf(
-							"Wanted instance %s and trade instance %s do not match: %s",
-							currentWantedInstance.InstanceID, tradeInstance.InstanceID, reason))
-					}
-				}
-				if matchFound {
-					break
-				}
-			}
-
-			// If no match is found, skip this instance
-			if !matchFound {
-				logrus.Infof("No matching trade found in trade_list for instance %s belonging to user %s; skipping instance.", instance.InstanceID, instanceUserID)
-				for _, reason := range mismatchReasons {
-					logrus.Info(reason)
-				}
-				continue
-			}
-		}
-
 		instanceData := map[string]interface{}{
 			"instance_id":      instance.InstanceID,
 			"variant_id":       instance.VariantID,
@@ -660,24 +661,25 @@ func SearchPokemonInstances(c *fiber.Ctx) error {
 		if instanceUserID != "" && username != "" {
 			instanceData["user_id"] = instanceUserID
 			instanceData["username"] = username
-			if userLatitude != nil && userLongitude != nil {
-				instanceData["latitude"] = *userLatitude
-				instanceData["longitude"] = *userLongitude
+			if rep, ok := reputations[instanceUserID]; ok {
+				instanceData["reputation"] = rep
+			} else {
+				instanceData["reputation"] = TrainerReputation{UserID: instanceUserID}
+			}
+			if p, ok := presence[instanceUserID]; ok {
+				instanceData["presence"] = p
+			}
+			if located {
+				instanceData["latitude"] = userLatitude
+				instanceData["longitude"] = userLongitude
+				instanceData["location_cell"] = userCell
 			}
 		}
 
 		// New logic to add trade_list when ownership is "wanted"
 		if ownership == "wanted" {
 			if instanceUserID != "" {
-				// Retrieve all 'for trade' instances for this user
-				var tradeInstances []PokemonInstance
-				err := db.Model(&PokemonInstance{}).
-					Where("instances.user_id = ? AND is_for_trade = ?", instanceUserID, true).
-					Find(&tradeInstances).Error
-				if err != nil {
-					logrus.Error("Error retrieving trade instances for user ", instanceUserID, ": ", err)
-					return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve trade instances"})
-				}
+				tradeInstances := ownerLists[instanceUserID]
 
 				// Parse the not_trade_list
 				notTradeList := make(map[string]bool)
@@ -739,16 +741,7 @@ func SearchPokemonInstances(c *fiber.Ctx) error {
 					}
 					// Add 'match' field based on tradeInWantedList
 					if tradeInWantedList && userID != "" {
-						// Determine if any of the user's 'wanted' instances match this tradeInstance
-						matchFound := false
-						for _, currentWantedInstance := range currentUserWantedInstances {
-							matched, _ := instancesMatch(currentWantedInstance, tradeInstance)
-							if matched {
-								matchFound = true
-								break
-							}
-						}
-						tradeInstanceData["match"] = matchFound
+						tradeInstanceData["match"] = matchedIDs[tradeInstance.InstanceID]
 					} else {
 						// Set match to null (nil in Go)
 						tradeInstanceData["match"] = nil
@@ -764,15 +757,7 @@ func SearchPokemonInstances(c *fiber.Ctx) error {
 		// New logic for ownership == "trade"
 		if ownership == "trade" {
 			if instanceUserID != "" {
-				// Retrieve all 'wanted' instances for this user
-				var wantedInstances []PokemonInstance
-				err := db.Model(&PokemonInstance{}).
-					Where("instances.user_id = ? AND is_wanted = ?", instanceUserID, true).
-					Find(&wantedInstances).Error
-				if err != nil {
-					logrus.Error("Error retrieving wanted instances for user ", instanceUserID, ": ", err)
-					return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve wanted instances"})
-				}
+				wantedInstances := ownerLists[instanceUserID]
 
 				// Parse the not_wanted_list
 				notWantedList := make(map[string]bool)
@@ -845,16 +830,7 @@ func SearchPokemonInstances(c *fiber.Ctx) error {
 
 					// Add 'match' field based on onlyMatchingTrades
 					if onlyMatchingTrades && userID != "" {
-						// Determine if any of the user's 'for trade' instances match this wantedInstance
-						matchFound := false
-						for _, currentTradeInstance := range currentUserTradeInstances {
-							matched, _ := instancesMatch(currentTradeInstance, wantedInstance)
-							if matched {
-								matchFound = true
-								break
-							}
-						}
-						wantedInstanceData["match"] = matchFound
+						wantedInstanceData["match"] = matchedIDs[wantedInstance.InstanceID]
 					} else {
 						// Set match to null (nil in Go)
 						wantedInstanceData["match"] = nil
@@ -867,66 +843,78 @@ func SearchPokemonInstances(c *fiber.Ctx) error {
 			}
 		}
 
-		responseData[instance.InstanceID] = instanceData
+		results = append(results, instanceData)
 	}
 
-	logrus.Infof("Returning %d Pokemon instances", len(responseData))
-	return c.Status(fiber.StatusOK).JSON(responseData)
+	return respond(nextCursor)
 }
 
-// Helper function to compare two PokemonInstances based on specified criteria
-func instancesMatch(a, b PokemonInstance) (bool, string) {
-	// PokemonID, Shiny, and Shadow checks
-	if a.PokemonID != b.PokemonID || a.Shiny != b.Shiny || a.Shadow != b.Shadow {
-		return false, ""
-	}
-
-	// CostumeID check with detailed logging
-	if (a.CostumeID == nil && b.CostumeID != nil) || (a.CostumeID != nil && b.CostumeID == nil) {
-		return false, "CostumeID presence mismatch"
-	}
-	if a.CostumeID != nil && b.CostumeID != nil && *a.CostumeID != *b.CostumeID {
-		return false, "CostumeID value mismatch"
-	}
-
-	// Gender check
-	if a.Gender != nil && b.Gender != nil && *a.Gender != *b.Gender {
-		return false, "Gender mismatch"
-	}
-
-	// LocationCard check
-	if a.LocationCard != b.LocationCard {
-		return false, "LocationCard mismatch"
-	}
-
-	// Dynamax check
-	if a.Dynamax != b.Dynamax {
-		return false, "Dynamax mismatch"
-	}
+// loadTrainerReputations fetches the reputation of every trainer in the
+// result set in one query. Failures only drop the reputation from results.
+func loadTrainerReputations(instances []PokemonInstance) map[string]TrainerReputation {
+	userIDs := resultOwnerIDs(instances)
 
-	// Gigantamax check
-	if a.Gigantamax != b.Gigantamax {
-		return false, "Gigantamax mismatch"
+	out := make(map[string]TrainerReputation, len(userIDs))
+	if len(userIDs) == 0 {
+		return out
 	}
-
-	// FastMoveID check
-	if a.FastMoveID != nil && b.FastMoveID != nil && *a.FastMoveID != *b.FastMoveID {
-		return false, "FastMoveID mismatch"
+	var rows []TrainerReputation
+	if err := db.Where("user_id IN ?", userIDs).Find(&rows).Error; err != nil {
+		logrus.Warnf("Failed to load trainer reputations: %v", err)
+		return out
 	}
-
-	// ChargedMoveID check
-	chargedMovesMatch := false
-	if a.ChargedMove1ID != nil && a.ChargedMove2ID != nil && b.ChargedMove1ID != nil && b.ChargedMove2ID != nil {
-		chargedMovesMatch = (*a.ChargedMove1ID == *b.ChargedMove1ID && *a.ChargedMove2ID == *b.ChargedMove2ID) ||
-			(*a.ChargedMove1ID == *b.ChargedMove2ID && *a.ChargedMove2ID == *b.ChargedMove1ID)
-	} else {
-		// If any of the ChargedMoveIDs are nil, consider them matching
-		chargedMovesMatch = true
+	for _, row := range rows {
+		out[row.UserID] = row
 	}
+	return out
+}
 
-	if !chargedMovesMatch {
-		return false, "ChargedMoveID mismatch"
-	}
+// Presence thresholds, matching the events service that writes
+// user_presence.
+const (
+	presenceStaleAfter   = 90 * time.Second
+	presenceRecentWindow = 15 * time.Minute
+)
 
-	return true, ""
+// loadTrainerPresence fetches the presence of the result owners who share
+// it. Owners who do not are left out; failures only drop presence from
+// results.
+func loadTrainerPresence(instances []PokemonInstance, now time.Time) map[string]TrainerPresence {
+	userIDs := resultOwnerIDs(instances)
+
+	out := make(map[string]TrainerPresence, len(userIDs))
+	if len(userIDs) == 0 {
+		return out
+	}
+	var rows []struct {
+		UserID     string     `gorm:"column:user_id"`
+		Online     bool       `gorm:"column:online"`
+		LastSeenAt *time.Time `gorm:"column:last_seen_at"`
+	}
+	if err := db.Raw(
+		`SELECT u.user_id,
+		        COALESCE(MAX(p.connections > 0 AND p.last_seen_at >= ?), 0) AS online,
+		        MAX(p.last_seen_at) AS last_seen_at
+		   FROM users u
+		   LEFT JOIN user_presence p ON p.user_id = u.user_id
+		  WHERE u.share_presence = 1 AND u.user_id IN ?
+		  GROUP BY u.user_id`,
+		now.Add(-presenceStaleAfter).UTC(), userIDs,
+	).Scan(&rows).Error; err != nil {
+		logrus.Warnf("Failed to load trainer presence: %v", err)
+		return out
+	}
+	for _, row := range rows {
+		p := TrainerPresence{Status: "offline"}
+		switch {
+		case row.Online:
+			p.Status = "online"
+		case row.LastSeenAt != nil && now.Sub(*row.LastSeenAt) < presenceRecentWindow:
+			p.Status = "recent"
+			at := row.LastSeenAt.UTC()
+			p.LastActiveAt = &at
+		}
+		out[row.UserID] = p
+	}
+	return out
 }
@@ -5,8 +5,7 @@ import (
 	"testing"
 )
 
-func intPtr(v int) *int       { return &v }
-func strPtr(v string) *string { return &v }
+func intPtr(v int) *int { return &v }
 
 func TestHaversine(t *testing.T) {
 	if got := haversine(0, 0, 0, 0); got != 0 {
@@ -19,41 +18,33 @@ func TestHaversine(t *testing.T) {
 	}
 }
 
-func TestInstancesMatch(t *testing.T) {
-	base := PokemonInstance{
-		PokemonID:      25,
-		Shiny:          true,
-		Shadow:         false,
-		CostumeID:      intPtr(1),
-		Gender:         strPtr("Female"),
-		LocationCard:   strPtr("11"),
-		Dynamax:        true,
-		Gigantamax:     false,
-		FastMoveID:     intPtr(10),
-		ChargedMove1ID: intPtr(20),
-		ChargedMove2ID: intPtr(30),
-	}
-
-	sameDifferentMoveOrder := base
-	sameDifferentMoveOrder.ChargedMove1ID = intPtr(30)
-	sameDifferentMoveOrder.ChargedMove2ID = intPtr(20)
-
-	matched, reason := instancesMatch(base, sameDifferentMoveOrder)
-	if !matched || reason != "" {
-		t.Fatalf("expected match with swapped charged moves, got matched=%v reason=%q", matched, reason)
-	}
-
-	diff := base
-	diff.PokemonID = 26
-	matched, _ = instancesMatch(base, diff)
-	if matched {
-		t.Fatalf("expected mismatch for different pokemon_id")
-	}
-
-	diffCard := base
-	diffCard.LocationCard = strPtr("12")
-	matched, reason = instancesMatch(base, diffCard)
-	if matched || reason != "LocationCard mismatch" {
-		t.Fatalf("expected location mismatch, got matched=%v reason=%q", matched, reason)
+func TestBoundingBox(t *testing.T) {
+	box := boundingBox(52.52, 13.405, 50)
+	for _, corner := range [][2]float64{{box.MinLat, 13.405}, {box.MaxLat, 13.405}, {52.52, box.MinLng}, {52.52, box.MaxLng}} {
+		if d := haversine(52.52, 13.405, corner[0], corner[1]); d < 49.5 {
+			t.Fatalf("expected the box to hold the 50 km circle, edge at %.2fkm", d)
+		}
+	}
+	if box.MaxLng-box.MinLng > 2 || box.MaxLat-box.MinLat > 1 {
+		t.Fatalf("expected a tight box, got %+v", box)
+	}
+
+	if wrap := boundingBox(0, 179.9, 100); wrap.MinLng != -180 || wrap.MaxLng != 180 {
+		t.Fatalf("expected every longitude across the antimeridian, got %+v", wrap)
+	}
+	if pole := boundingBox(89.9, 0, 100); pole.MaxLat != 90 || pole.MinLng != -180 {
+		t.Fatalf("expected every longitude near the pole, got %+v", pole)
+	}
+
+	want := "POLYGON((-1.000000 -2.000000, 3.000000 -2.000000, 3.000000 4.000000, -1.000000 4.000000, -1.000000 -2.000000))"
+	if got := (geoBox{MinLat: -2, MinLng: -1, MaxLat: 4, MaxLng: 3}).wkt(); got != want {
+		t.Fatalf("unexpected polygon: %s", got)
+	}
+}
+
+func TestResultOwnerIDs(t *testing.T) {
+	got := resultOwnerIDs([]PokemonInstance{{UserID: "b"}, {UserID: "a"}, {UserID: "b"}, {UserID: ""}})
+	if len(got) != 2 || got[0] != "b" || got[1] != "a" {
+		t.Fatalf("expected distinct owners in result order, got %v", got)
 	}
 }
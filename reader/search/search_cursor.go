@@ -0,0 +1,190 @@
+// search_cursor.go
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	defaultSearchLimit = 25
+	maxSearchLimit     = 100
+	// searchCountCap bounds the first-page count; past it the count is
+	// reported as an estimate.
+	searchCountCap = 1000
+)
+
+// Search sort keys. Every order ends with instance_id so pages never skip
+// or repeat rows that tie on the key.
+const (
+	sortDistance   = "distance"
+	sortIVTotal    = "iv_total"
+	sortCP         = "cp"
+	sortUpdated    = "updated"
+	sortReputation = "reputation"
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// searchSort is one orderable key. Expr is what rows are ordered and paged
+// by in SQL; Value reads the same key back from a fetched row. Missing
+// values sort last.
+type searchSort struct {
+	Name  string
+	Expr  string
+	Desc  bool
+	Value func(inst PokemonInstance, reputations map[string]TrainerReputation) float64
+}
+
+// reputationJoin makes trainer_reputation available as "rep" for the
+// reputation sort.
+const reputationJoin = "LEFT JOIN trainer_reputation rep ON rep.user_id = instances.user_id"
+
+// searchSortFor returns the sort named by the sort parameter. hasLocation
+// reports whether the search has a latitude and longitude.
+func searchSortFor(name string, hasLocation bool, lat, lng float64) (searchSort, error) {
+	if name == "" {
+		name = sortUpdated
+		if hasLocation {
+			name = sortDistance
+		}
+	}
+	switch name {
+	case sortDistance:
+		if !hasLocation {
+			return searchSort{}, errors.New("sort=distance requires latitude and longitude")
+		}
+		return searchSort{Name: name, Expr: distanceExpr(lat, lng), Value: func(inst PokemonInstance, _ map[string]TrainerReputation) float64 {
+			if inst.DistanceKM == nil {
+				return 0
+			}
+			return *inst.DistanceKM
+		}}, nil
+	case sortIVTotal:
+		return searchSort{
+			Name: name,
+			Expr: "(COALESCE(instances.attack_iv, 0) + COALESCE(instances.defense_iv, 0) + COALESCE(instances.stamina_iv, 0))",
+			Desc: true,
+			Value: func(inst PokemonInstance, _ map[string]TrainerReputation) float64 {
+				return float64(intOr(inst.AttackIV, 0) + intOr(inst.DefenseIV, 0) + intOr(inst.StaminaIV, 0))
+			},
+		}, nil
+	case sortCP:
+		return searchSort{Name: name, Expr: "COALESCE(instances.cp, -1)", Desc: true, Value: func(inst PokemonInstance, _ map[string]TrainerReputation) float64 {
+			return float64(intOr(inst.CP, -1))
+		}}, nil
+	case sortUpdated:
+		return searchSort{Name: name, Expr: "COALESCE(instances.last_update, 0)", Desc: true, Value: func(inst PokemonInstance, _ map[string]TrainerReputation) float64 {
+			if inst.LastUpdate == nil {
+				return 0
+			}
+			return float64(*inst.LastUpdate)
+		}}, nil
+	case sortReputation:
+		return searchSort{Name: name, Expr: "COALESCE(rep.rating_average, -1)", Desc: true, Value: func(inst PokemonInstance, reputations map[string]TrainerReputation) float64 {
+			if rep, ok := reputations[inst.UserID]; ok && rep.RatingAverage != nil {
+				return *rep.RatingAverage
+			}
+			return -1
+		}}, nil
+	}
+	return searchSort{}, errors.New("invalid sort")
+}
+
+func intOr(v *int, fallback int) int {
+	if v == nil {
+		return fallback
+	}
+	return *v
+}
+
+// orderBy is the ORDER BY for the sort.
+func (s searchSort) orderBy() string {
+	dir := "ASC"
+	if s.Desc {
+		dir = "DESC"
+	}
+	return fmt.Sprintf("%s %s, instances.instance_id ASC", s.Expr, dir)
+}
+
+// after is the keyset condition for rows past the cursor.
+func (s searchSort) after(cur searchCursor) (string, []interface{}) {
+	op := ">"
+	if s.Desc {
+		op = "<"
+	}
+	return fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND instances.instance_id > ?))", s.Expr, op),
+		[]interface{}{cur.Value, cur.Value, cur.InstanceID}
+}
+
+// searchCursor marks the last row of a page. Query ties it to the search
+// it came from, so it cannot be replayed against other filters.
+type searchCursor struct {
+	Sort       string  `json:"s"`
+	Value      float64 `json:"v"`
+	InstanceID string  `json:"id"`
+	Query      string  `json:"q"`
+}
+
+func encodeSearchCursor(cur searchCursor) string {
+	raw, _ := json.Marshal(cur)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// decodeSearchCursor reads a cursor and checks it belongs to this sort
+// and query.
+func decodeSearchCursor(raw, sortName, query string) (searchCursor, error) {
+	var cur searchCursor
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return cur, errInvalidCursor
+	}
+	if err := json.Unmarshal(data, &cur); err != nil || cur.InstanceID == "" {
+		return cur, errInvalidCursor
+	}
+	if cur.Sort != sortName || cur.Query != query {
+		return cur, errInvalidCursor
+	}
+	return cur, nil
+}
+
+// searchFingerprint identifies a search by its parameters, ignoring the
+// ones that only page through it.
+func searchFingerprint(c *fiber.Ctx) string {
+	var params []string
+	c.Context().QueryArgs().VisitAll(func(key, value []byte) {
+		switch k := string(key); k {
+		case "cursor", "limit":
+		default:
+			params = append(params, k+"="+string(value))
+		}
+	})
+	sort.Strings(params)
+	sum := sha256.Sum256([]byte(strings.Join(params, "&")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// parseSearchLimit reads limit, clamped to maxSearchLimit.
+func parseSearchLimit(raw string) (int, error) {
+	if raw == "" {
+		return defaultSearchLimit, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 1 {
+		return 0, errors.New("invalid limit")
+	}
+	if n > maxSearchLimit {
+		n = maxSearchLimit
+	}
+	return n, nil
+}
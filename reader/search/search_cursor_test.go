@@ -0,0 +1,112 @@
+package main
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestSearchSortFor(t *testing.T) {
+	if s, err := searchSortFor("", false, 0, 0); err != nil || s.Name != sortUpdated {
+		t.Fatalf("expected updated by default, got %q err=%v", s.Name, err)
+	}
+	if s, err := searchSortFor("", true, 52.5, 13.4); err != nil || s.Name != sortDistance || s.Desc {
+		t.Fatalf("expected nearest first with a location, got %+v err=%v", s, err)
+	}
+	if _, err := searchSortFor(sortDistance, false, 0, 0); err == nil {
+		t.Fatalf("expected distance without a location to be rejected")
+	}
+	if _, err := searchSortFor("level", false, 0, 0); err == nil {
+		t.Fatalf("expected an unknown sort to be rejected")
+	}
+
+	ivs, _ := searchSortFor(sortIVTotal, false, 0, 0)
+	if got := ivs.Value(PokemonInstance{AttackIV: intPtr(15), DefenseIV: intPtr(14)}, nil); got != 29 {
+		t.Fatalf("expected missing IVs to count as 0, got %v", got)
+	}
+	rating := 4.5
+	rep, _ := searchSortFor(sortReputation, false, 0, 0)
+	reputations := map[string]TrainerReputation{"u-1": {UserID: "u-1", RatingAverage: &rating}}
+	if got := rep.Value(PokemonInstance{UserID: "u-1"}, reputations); got != 4.5 {
+		t.Fatalf("expected the trainer's rating, got %v", got)
+	}
+	if got := rep.Value(PokemonInstance{UserID: "u-2"}, reputations); got != -1 {
+		t.Fatalf("expected unrated trainers last, got %v", got)
+	}
+}
+
+func TestSearchSort_KeysetCondition(t *testing.T) {
+	cp, _ := searchSortFor(sortCP, false, 0, 0)
+	cond, args := cp.after(searchCursor{Value: 2500, InstanceID: "i-9"})
+	if cond != "(COALESCE(instances.cp, -1) < ? OR (COALESCE(instances.cp, -1) = ? AND instances.instance_id > ?))" {
+		t.Fatalf("unexpected condition: %s", cond)
+	}
+	if len(args) != 3 || args[0] != float64(2500) || args[2] != "i-9" {
+		t.Fatalf("unexpected args: %v", args)
+	}
+	if got := cp.orderBy(); got != "COALESCE(instances.cp, -1) DESC, instances.instance_id ASC" {
+		t.Fatalf("unexpected order: %s", got)
+	}
+
+	dist, _ := searchSortFor(sortDistance, true, 1, 2)
+	if cond, _ := dist.after(searchCursor{InstanceID: "i-1"}); !strings.Contains(cond, "POINT(2, 1)) / 1000) > ?") {
+		t.Fatalf("expected nearest-first paging, got %s", cond)
+	}
+}
+
+func TestSearchCursor_RoundTripAndBinding(t *testing.T) {
+	cur := searchCursor{Sort: sortCP, Value: 2500, InstanceID: "i-9", Query: "abc"}
+	raw := encodeSearchCursor(cur)
+
+	got, err := decodeSearchCursor(raw, sortCP, "abc")
+	if err != nil || got != cur {
+		t.Fatalf("expected the cursor back, got %+v err=%v", got, err)
+	}
+	for _, tc := range []struct{ raw, sort, query string }{
+		{raw, sortUpdated, "abc"},
+		{raw, sortCP, "other"},
+		{"not base64!", sortCP, "abc"},
+		{encodeSearchCursor(searchCursor{Sort: sortCP, Query: "abc"}), sortCP, "abc"},
+	} {
+		if _, err := decodeSearchCursor(tc.raw, tc.sort, tc.query); err != errInvalidCursor {
+			t.Fatalf("expected %+v to be rejected, got %v", tc, err)
+		}
+	}
+}
+
+func TestSearchFingerprint_IgnoresPaging(t *testing.T) {
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error { return c.SendString(searchFingerprint(c)) })
+	fingerprint := func(target string) string {
+		resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, target, nil), -1)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	base := fingerprint("/?pokemon_id=25&shiny=true")
+	if got := fingerprint("/?shiny=true&pokemon_id=25&limit=50&cursor=x"); got != base {
+		t.Fatalf("expected order, limit and cursor to be ignored")
+	}
+	if got := fingerprint("/?pokemon_id=26&shiny=true"); got == base {
+		t.Fatalf("expected other filters to change the fingerprint")
+	}
+}
+
+func TestParseSearchLimit(t *testing.T) {
+	for raw, want := range map[string]int{"": defaultSearchLimit, "10": 10, "5000": maxSearchLimit} {
+		if got, err := parseSearchLimit(raw); err != nil || got != want {
+			t.Fatalf("limit %q: expected %d, got %d err=%v", raw, want, got, err)
+		}
+	}
+	for _, raw := range []string{"0", "-3", "ten"} {
+		if _, err := parseSearchLimit(raw); err == nil {
+			t.Fatalf("expected limit %q to be rejected", raw)
+		}
+	}
+}
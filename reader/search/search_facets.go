@@ -0,0 +1,300 @@
+// search_facets.go
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// Facets mode (facets=species,costume,...) answers a search with counts
+// instead of rows. Each facet is one GROUP BY over every match of the
+// filtered query, keeping at most searchFacetValueCap values, and the
+// response is cached per query for searchFacetsTTL.
+
+const (
+	searchFacetValueCap = 50
+	searchFacetsTTL     = time.Minute
+	maxCachedFacets     = 1000
+)
+
+// Facet names.
+const (
+	facetSpecies  = "species"
+	facetCostume  = "costume"
+	facetFlags    = "flags"
+	facetDistance = "distance"
+	facetTeam     = "team"
+)
+
+// facetDistanceBounds are the upper ends (km) of the distance buckets; the
+// last bucket is open-ended.
+var facetDistanceBounds = []float64{1, 5, 10, 25, 50}
+
+type facetCount struct {
+	Value interface{} `json:"value"`
+	Count int         `json:"count"`
+}
+
+type facetBucket struct {
+	Bucket string   `json:"bucket"`
+	MinKM  float64  `json:"min_km"`
+	MaxKM  *float64 `json:"max_km"`
+	Count  int      `json:"count"`
+}
+
+type facetFlagCounts struct {
+	Shiny  int `json:"shiny"`
+	Shadow int `json:"shadow"`
+	Lucky  int `json:"lucky"`
+}
+
+type searchFacets struct {
+	Species  []facetCount     `json:"species,omitempty"`
+	Costume  []facetCount     `json:"costume,omitempty"`
+	Flags    *facetFlagCounts `json:"flags,omitempty"`
+	Distance []facetBucket    `json:"distance,omitempty"`
+	Team     []facetCount     `json:"team,omitempty"`
+}
+
+type facetsResponse struct {
+	Facets     searchFacets `json:"facets"`
+	TotalCount int          `json:"total_count"`
+}
+
+// parseFacetNames reads the facets parameter. distance needs a location.
+func parseFacetNames(raw string, hasLocation bool) ([]string, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	seen := map[string]bool{}
+	var names []string
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case facetSpecies, facetCostume, facetFlags, facetTeam:
+		case facetDistance:
+			if !hasLocation {
+				return nil, errors.New("distance facet requires latitude and longitude")
+			}
+		default:
+			return nil, errors.New("Invalid facets")
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// facetValueSQL is the grouped column of each value-list facet. An empty
+// team counts as no team.
+var facetValueSQL = map[string]string{
+	facetSpecies: "pokemon_id",
+	facetCostume: "costume_id",
+	facetTeam:    "NULLIF(team, '')",
+}
+
+// facetDistanceBucketSQL numbers each match's facetDistanceBounds bucket:
+// 0 up to the first bound, len(facetDistanceBounds) past the last.
+func facetDistanceBucketSQL() string {
+	var b strings.Builder
+	b.WriteString("CASE")
+	for i, upper := range facetDistanceBounds {
+		fmt.Fprintf(&b, " WHEN distance_km <= %g THEN %d", upper, i)
+	}
+	fmt.Fprintf(&b, " ELSE %d END", len(facetDistanceBounds))
+	return b.String()
+}
+
+// facetValueRow is one group of a value-list facet.
+type facetValueRow struct {
+	Value *string `gorm:"column:value"`
+	Count int     `gorm:"column:count"`
+}
+
+// facetCounts turns grouped rows into value/count pairs. Species and
+// costume values are ids; NULL stays null.
+func facetCounts(name string, rows []facetValueRow) []facetCount {
+	out := make([]facetCount, 0, len(rows))
+	for _, r := range rows {
+		fc := facetCount{Count: r.Count}
+		switch {
+		case r.Value == nil:
+		case name == facetTeam:
+			fc.Value = *r.Value
+		default:
+			if n, err := strconv.Atoi(*r.Value); err == nil {
+				fc.Value = n
+			} else {
+				fc.Value = *r.Value
+			}
+		}
+		out = append(out, fc)
+	}
+	return out
+}
+
+// distanceBuckets labels the per-bucket counts, in bucket order. Buckets
+// without matches are not counted, so they are left out.
+func distanceBuckets(counts map[int]int) []facetBucket {
+	var out []facetBucket
+	lower := 0.0
+	for i := 0; i <= len(facetDistanceBounds); i++ {
+		b := facetBucket{MinKM: lower, Count: counts[i]}
+		if i < len(facetDistanceBounds) {
+			upper := facetDistanceBounds[i]
+			b.MaxKM = &upper
+			b.Bucket = fmt.Sprintf("%g-%g", lower, upper)
+			lower = upper
+		} else {
+			b.Bucket = fmt.Sprintf("%g+", lower)
+		}
+		if b.Count > 0 {
+			out = append(out, b)
+		}
+	}
+	return out
+}
+
+// countSearchFacets runs one grouped query per named facet over matched,
+// the filtered matches with the columns the facets read.
+func countSearchFacets(matched *gorm.DB, names []string) (searchFacets, error) {
+	var f searchFacets
+	from := func() *gorm.DB { return db.Table("(?) AS matched", matched) }
+	for _, name := range names {
+		switch name {
+		case facetSpecies, facetCostume, facetTeam:
+			col := facetValueSQL[name]
+			var rows []facetValueRow
+			if err := from().
+				Select("CAST(" + col + " AS CHAR) AS value, COUNT(*) AS count").
+				Group(col).
+				Order("count DESC, " + col).
+				Limit(searchFacetValueCap).
+				Scan(&rows).Error; err != nil {
+				return f, fmt.Errorf("%s facet: %w", name, err)
+			}
+			counts := facetCounts(name, rows)
+			switch name {
+			case facetSpecies:
+				f.Species = counts
+			case facetCostume:
+				f.Costume = counts
+			default:
+				f.Team = counts
+			}
+		case facetFlags:
+			var flags facetFlagCounts
+			if err := from().
+				Select("COALESCE(SUM(shiny), 0) AS shiny, COALESCE(SUM(shadow), 0) AS shadow, COALESCE(SUM(lucky), 0) AS lucky").
+				Scan(&flags).Error; err != nil {
+				return f, fmt.Errorf("flags facet: %w", err)
+			}
+			f.Flags = &flags
+		case facetDistance:
+			var rows []struct {
+				Bucket int `gorm:"column:bucket"`
+				Count  int `gorm:"column:count"`
+			}
+			bucket := facetDistanceBucketSQL()
+			if err := from().
+				Select(bucket + " AS bucket, COUNT(*) AS count").
+				Where("distance_km IS NOT NULL").
+				Group("bucket").
+				Scan(&rows).Error; err != nil {
+				return f, fmt.Errorf("distance facet: %w", err)
+			}
+			counts := make(map[int]int, len(rows))
+			for _, r := range rows {
+				counts[r.Bucket] = r.Count
+			}
+			f.Distance = distanceBuckets(counts)
+		}
+	}
+	return f, nil
+}
+
+// facetCache keeps recent facet responses by query.
+type facetCache struct {
+	mu      sync.Mutex
+	entries map[string]facetCacheEntry
+}
+
+type facetCacheEntry struct {
+	resp    facetsResponse
+	expires time.Time
+}
+
+var searchFacetCache = &facetCache{entries: map[string]facetCacheEntry{}}
+
+func (fc *facetCache) get(key string, now time.Time) (facetsResponse, bool) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	e, ok := fc.entries[key]
+	if !ok || now.After(e.expires) {
+		return facetsResponse{}, false
+	}
+	return e.resp, true
+}
+
+// put stores resp, first dropping expired entries when the cache is full;
+// if it is still full the new entry is not kept.
+func (fc *facetCache) put(key string, resp facetsResponse, now time.Time) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if len(fc.entries) >= maxCachedFacets {
+		for k, e := range fc.entries {
+			if now.After(e.expires) {
+				delete(fc.entries, k)
+			}
+		}
+		if len(fc.entries) >= maxCachedFacets {
+			return
+		}
+	}
+	fc.entries[key] = facetCacheEntry{resp: resp, expires: now.Add(searchFacetsTTL)}
+}
+
+// respondSearchFacets counts the matches of query, the fully filtered
+// search, into the named facets. cacheKey identifies the query.
+func respondSearchFacets(c *fiber.Ctx, query *gorm.DB, names []string, hasLocation bool, lat, lng float64, cacheKey string) error {
+	now := time.Now()
+	if resp, ok := searchFacetCache.get(cacheKey, now); ok {
+		return c.Status(fiber.StatusOK).JSON(resp)
+	}
+
+	cols := []interface{}{"instances.shiny", "instances.shadow", "instances.lucky", "facet_user.team"}
+	if hasLocation {
+		cols = append(cols, distanceSelect(lat, lng))
+	}
+	matched := query.Session(&gorm.Session{}).
+		Joins("LEFT JOIN users facet_user ON facet_user.user_id = instances.user_id").
+		Select("instances.pokemon_id, instances.costume_id", cols...)
+
+	var total int64
+	if err := db.Table("(?) AS matched", matched).Count(&total).Error; err != nil {
+		logrus.Error("Error counting search facets: ", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve facets"})
+	}
+	facets, err := countSearchFacets(matched, names)
+	if err != nil {
+		logrus.Error("Error computing search facets: ", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve facets"})
+	}
+
+	resp := facetsResponse{Facets: facets, TotalCount: int(total)}
+	searchFacetCache.put(cacheKey, resp, now)
+
+	logrus.Infof("Returning facets %v over %d matches", names, total)
+	return c.Status(fiber.StatusOK).JSON(resp)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseFacetNames(t *testing.T) {
+	names, err := parseFacetNames(" species,Flags,species,distance ", true)
+	if err != nil || len(names) != 3 || names[1] != facetFlags || names[2] != facetDistance {
+		t.Fatalf("unexpected facets %v err=%v", names, err)
+	}
+	if names, err := parseFacetNames("", false); err != nil || names != nil {
+		t.Fatalf("expected no facets, got %v err=%v", names, err)
+	}
+	if _, err := parseFacetNames("distance", false); err == nil {
+		t.Fatalf("expected distance without a location to be rejected")
+	}
+	if _, err := parseFacetNames("species,level", false); err == nil {
+		t.Fatalf("expected an unknown facet to be rejected")
+	}
+}
+
+func TestFacetCounts(t *testing.T) {
+	str := func(v string) *string { return &v }
+	species := facetCounts(facetSpecies, []facetValueRow{{Value: str("25"), Count: 2}, {Value: str("1"), Count: 1}})
+	if len(species) != 2 || species[0].Value != 25 || species[0].Count != 2 {
+		t.Fatalf("unexpected species facet %+v", species)
+	}
+	costume := facetCounts(facetCostume, []facetValueRow{{Count: 2}, {Value: str("3"), Count: 1}})
+	if costume[0].Value != nil || costume[1].Value != 3 {
+		t.Fatalf("expected no costume to stay null, got %+v", costume)
+	}
+	team := facetCounts(facetTeam, []facetValueRow{{Value: str("Mystic"), Count: 2}, {Count: 1}})
+	if team[0].Value != "Mystic" || team[1].Value != nil {
+		t.Fatalf("unexpected team facet %+v", team)
+	}
+}
+
+func TestDistanceBuckets(t *testing.T) {
+	out := distanceBuckets(map[int]int{0: 2, len(facetDistanceBounds): 1})
+	if len(out) != 2 || out[0].Bucket != "0-1" || out[0].Count != 2 || *out[0].MaxKM != 1 ||
+		out[1].Bucket != "50+" || out[1].MinKM != 50 || out[1].MaxKM != nil {
+		t.Fatalf("unexpected distance buckets %+v", out)
+	}
+	if out := distanceBuckets(nil); out != nil {
+		t.Fatalf("expected no buckets without matches, got %+v", out)
+	}
+}
+
+func TestFacetDistanceBucketSQL(t *testing.T) {
+	want := "CASE WHEN distance_km <= 1 THEN 0 WHEN distance_km <= 5 THEN 1 WHEN distance_km <= 10 THEN 2" +
+		" WHEN distance_km <= 25 THEN 3 WHEN distance_km <= 50 THEN 4 ELSE 5 END"
+	if got := facetDistanceBucketSQL(); got != want {
+		t.Fatalf("unexpected bucket expression %q", got)
+	}
+}
+
+func TestFacetCache_ExpiresAndBounds(t *testing.T) {
+	fc := &facetCache{entries: map[string]facetCacheEntry{}}
+	now := time.Now()
+	fc.put("a", facetsResponse{TotalCount: 3}, now)
+	if resp, ok := fc.get("a", now.Add(time.Second)); !ok || resp.TotalCount != 3 {
+		t.Fatalf("expected a cached response, got %+v ok=%v", resp, ok)
+	}
+	if _, ok := fc.get("a", now.Add(searchFacetsTTL+time.Second)); ok {
+		t.Fatalf("expected the entry to expire")
+	}
+
+	for i := 0; len(fc.entries) < maxCachedFacets; i++ {
+		fc.put(string(rune('b'+i)), facetsResponse{}, now)
+	}
+	fc.put("late", facetsResponse{}, now)
+	if _, ok := fc.get("late", now); ok {
+		t.Fatalf("expected a full cache to skip new entries")
+	}
+	fc.put("late", facetsResponse{}, now.Add(searchFacetsTTL+time.Second))
+	if _, ok := fc.get("late", now.Add(searchFacetsTTL+time.Second)); !ok || len(fc.entries) != 1 {
+		t.Fatalf("expected expired entries to make room, have %d", len(fc.entries))
+	}
+}
@@ -0,0 +1,370 @@
+// search_filters.go
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxSearchListValues caps every comma-separated list parameter.
+const maxSearchListValues = 50
+
+// searchFilters are the list, range and flag filters of a search. Nil or
+// empty fields do not filter.
+type searchFilters struct {
+	PokemonIDs []int
+	// CostumeIDs with CostumeNone also keeps costume-less instances. With
+	// neither set and CostumeAny false, only costume-less instances match,
+	// as before lists were accepted.
+	CostumeIDs     []int
+	CostumeNone    bool
+	CostumeAny     bool
+	FastMoveIDs    []int
+	ChargedMoveIDs []int
+
+	MinAttackIV  *int
+	MinDefenseIV *int
+	MinStaminaIV *int
+	MinIVPercent *float64
+	MaxIVPercent *float64
+	MinCP        *int
+	MaxCP        *int
+	MinLevel     *float64
+	MaxLevel     *float64
+	Sizes        []string
+	// CaughtFrom and CaughtTo are whole days; CaughtTo is inclusive.
+	CaughtFrom *time.Time
+	CaughtTo   *time.Time
+
+	Lucky    *bool
+	Purified *bool
+	Traded   *bool
+}
+
+// searchCond is one parameterized WHERE condition.
+type searchCond struct {
+	SQL  string
+	Args []interface{}
+}
+
+// Height classes, as the Pokédex shows them.
+const (
+	sizeXXS = "xxs"
+	sizeXS  = "xs"
+	sizeXL  = "xl"
+	sizeXXL = "xxl"
+)
+
+// parseIDList reads a comma-separated list of ids, e.g. "1,4,7".
+func parseIDList(raw string) ([]int, error) {
+	parts := strings.Split(raw, ",")
+	if len(parts) > maxSearchListValues {
+		return nil, fmt.Errorf("at most %d values", maxSearchListValues)
+	}
+	ids := make([]int, 0, len(parts))
+	for _, p := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, n)
+	}
+	return ids, nil
+}
+
+// parseSearchFilters reads the filters from query, the request's query
+// parameters. Errors name the offending parameter.
+func parseSearchFilters(query func(string) string) (searchFilters, error) {
+	var f searchFilters
+
+	idList := func(key string) ([]int, error) {
+		raw := query(key)
+		if raw == "" {
+			return nil, nil
+		}
+		ids, err := parseIDList(raw)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s", key)
+		}
+		return ids, nil
+	}
+	var err error
+	if f.PokemonIDs, err = idList("pokemon_id"); err != nil {
+		return f, err
+	}
+	if f.FastMoveIDs, err = idList("fast_move_id"); err != nil {
+		return f, err
+	}
+	if f.ChargedMoveIDs, err = idList("charged_move_ids"); err != nil {
+		return f, err
+	}
+
+	// costume_id takes ids, "null" for no costume, or "any".
+	switch raw := query("costume_id"); raw {
+	case "", "null":
+		f.CostumeNone = true
+	case "any":
+		f.CostumeAny = true
+	default:
+		parts := strings.Split(raw, ",")
+		if len(parts) > maxSearchListValues {
+			return f, errors.New("Invalid costume_id")
+		}
+		for _, p := range parts {
+			p = strings.TrimSpace(p)
+			if p == "null" {
+				f.CostumeNone = true
+				continue
+			}
+			n, err := strconv.Atoi(p)
+			if err != nil {
+				return f, errors.New("Invalid costume_id")
+			}
+			f.CostumeIDs = append(f.CostumeIDs, n)
+		}
+	}
+
+	intIn := func(key string, lo, hi int) (*int, error) {
+		raw := query(key)
+		if raw == "" {
+			return nil, nil
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < lo || n > hi {
+			return nil, fmt.Errorf("Invalid %s", key)
+		}
+		return &n, nil
+	}
+	floatIn := func(key string, lo, hi float64) (*float64, error) {
+		raw := query(key)
+		if raw == "" {
+			return nil, nil
+		}
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil || math.IsNaN(v) || v < lo || v > hi {
+			return nil, fmt.Errorf("Invalid %s", key)
+		}
+		return &v, nil
+	}
+	for _, iv := range []struct {
+		key string
+		dst **int
+	}{{"min_attack_iv", &f.MinAttackIV}, {"min_defense_iv", &f.MinDefenseIV}, {"min_stamina_iv", &f.MinStaminaIV}} {
+		if *iv.dst, err = intIn(iv.key, 0, 15); err != nil {
+			return f, err
+		}
+	}
+	if f.MinIVPercent, err = floatIn("min_iv_percent", 0, 100); err != nil {
+		return f, err
+	}
+	if f.MaxIVPercent, err = floatIn("max_iv_percent", 0, 100); err != nil {
+		return f, err
+	}
+	if f.MinCP, err = intIn("min_cp", 0, math.MaxInt32); err != nil {
+		return f, err
+	}
+	if f.MaxCP, err = intIn("max_cp", 0, math.MaxInt32); err != nil {
+		return f, err
+	}
+	if f.MinLevel, err = floatIn("min_level", 1, 51); err != nil {
+		return f, err
+	}
+	if f.MaxLevel, err = floatIn("max_level", 1, 51); err != nil {
+		return f, err
+	}
+	if f.MinIVPercent != nil && f.MaxIVPercent != nil && *f.MinIVPercent > *f.MaxIVPercent {
+		return f, errors.New("min_iv_percent is above max_iv_percent")
+	}
+	if f.MinCP != nil && f.MaxCP != nil && *f.MinCP > *f.MaxCP {
+		return f, errors.New("min_cp is above max_cp")
+	}
+	if f.MinLevel != nil && f.MaxLevel != nil && *f.MinLevel > *f.MaxLevel {
+		return f, errors.New("min_level is above max_level")
+	}
+
+	if raw := query("size"); raw != "" {
+		for _, s := range strings.Split(raw, ",") {
+			switch s = strings.ToLower(strings.TrimSpace(s)); s {
+			case sizeXXS, sizeXS, sizeXL, sizeXXL:
+				f.Sizes = append(f.Sizes, s)
+			default:
+				return f, errors.New("Invalid size")
+			}
+		}
+	}
+
+	day := func(key string) (*time.Time, error) {
+		raw := query(key)
+		if raw == "" {
+			return nil, nil
+		}
+		t, err := time.Parse("2006-01-02", raw)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s", key)
+		}
+		return &t, nil
+	}
+	if f.CaughtFrom, err = day("caught_from"); err != nil {
+		return f, err
+	}
+	if f.CaughtTo, err = day("caught_to"); err != nil {
+		return f, err
+	}
+	if f.CaughtFrom != nil && f.CaughtTo != nil && f.CaughtFrom.After(*f.CaughtTo) {
+		return f, errors.New("caught_from is after caught_to")
+	}
+
+	flag := func(key string) (*bool, error) {
+		raw := query(key)
+		if raw == "" {
+			return nil, nil
+		}
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s value", key)
+		}
+		return &b, nil
+	}
+	if f.Lucky, err = flag("lucky"); err != nil {
+		return f, err
+	}
+	if f.Purified, err = flag("purified"); err != nil {
+		return f, err
+	}
+	if f.Traded, err = flag("traded"); err != nil {
+		return f, err
+	}
+	return f, nil
+}
+
+// ivTotalAtLeast and ivTotalAtMost turn an IV percentage into the IV total
+// (out of 45) it takes, so 82.2 asks for 37 and up (three stars).
+func ivTotalAtLeast(percent float64) int {
+	return int(math.Ceil(percent*45/100 - 1e-9))
+}
+
+func ivTotalAtMost(percent float64) int {
+	return int(math.Floor(percent*45/100 + 1e-9))
+}
+
+const ivTotalExpr = "(instances.attack_iv + instances.defense_iv + instances.stamina_iv)"
+
+// conds returns the WHERE conditions of f. sizes holds the height
+// thresholds of the searched species when f.Sizes is set.
+func (f searchFilters) conds(sizes map[int]speciesSize) []searchCond {
+	var out []searchCond
+	add := func(sql string, args ...interface{}) {
+		out = append(out, searchCond{SQL: sql, Args: args})
+	}
+
+	if len(f.PokemonIDs) == 1 {
+		add("instances.pokemon_id = ?", f.PokemonIDs[0])
+	} else if len(f.PokemonIDs) > 1 {
+		add("instances.pokemon_id IN ?", f.PokemonIDs)
+	}
+	switch {
+	case f.CostumeAny:
+	case len(f.CostumeIDs) > 0 && f.CostumeNone:
+		add("(instances.costume_id IS NULL OR instances.costume_id IN ?)", f.CostumeIDs)
+	case len(f.CostumeIDs) > 0:
+		add("instances.costume_id IN ?", f.CostumeIDs)
+	default:
+		add("instances.costume_id IS NULL")
+	}
+	if len(f.FastMoveIDs) > 0 {
+		add("instances.fast_move_id IN ?", f.FastMoveIDs)
+	}
+	if len(f.ChargedMoveIDs) > 0 {
+		add("(instances.charged_move1_id IN ? OR instances.charged_move2_id IN ?)", f.ChargedMoveIDs, f.ChargedMoveIDs)
+	}
+
+	if f.MinAttackIV != nil {
+		add("instances.attack_iv >= ?", *f.MinAttackIV)
+	}
+	if f.MinDefenseIV != nil {
+		add("instances.defense_iv >= ?", *f.MinDefenseIV)
+	}
+	if f.MinStaminaIV != nil {
+		add("instances.stamina_iv >= ?", *f.MinStaminaIV)
+	}
+	if f.MinIVPercent != nil {
+		add(ivTotalExpr+" >= ?", ivTotalAtLeast(*f.MinIVPercent))
+	}
+	if f.MaxIVPercent != nil {
+		add(ivTotalExpr+" <= ?", ivTotalAtMost(*f.MaxIVPercent))
+	}
+	if f.MinCP != nil {
+		add("instances.cp >= ?", *f.MinCP)
+	}
+	if f.MaxCP != nil {
+		add("instances.cp <= ?", *f.MaxCP)
+	}
+	if f.MinLevel != nil {
+		add("instances.level >= ?", *f.MinLevel)
+	}
+	if f.MaxLevel != nil {
+		add("instances.level <= ?", *f.MaxLevel)
+	}
+	if len(f.Sizes) > 0 {
+		sql, args := sizeCond(f.PokemonIDs, f.Sizes, sizes)
+		out = append(out, searchCond{SQL: sql, Args: args})
+	}
+	if f.CaughtFrom != nil {
+		add("instances.date_caught >= ?", *f.CaughtFrom)
+	}
+	if f.CaughtTo != nil {
+		add("instances.date_caught < ?", f.CaughtTo.AddDate(0, 0, 1))
+	}
+
+	if f.Lucky != nil {
+		add("instances.lucky = ?", *f.Lucky)
+	}
+	if f.Purified != nil {
+		add("instances.purified = ?", *f.Purified)
+	}
+	if f.Traded != nil {
+		add("instances.is_traded = ?", *f.Traded)
+	}
+	return out
+}
+
+// sizeCond keeps instances whose height falls in one of the classes, per
+// species. Species without thresholds match nothing.
+func sizeCond(pokemonIDs []int, classes []string, sizes map[int]speciesSize) (string, []interface{}) {
+	var terms []string
+	var args []interface{}
+	for _, id := range pokemonIDs {
+		s, ok := sizes[id]
+		if !ok {
+			continue
+		}
+		var ranges []string
+		args = append(args, id)
+		for _, class := range classes {
+			switch class {
+			case sizeXXS:
+				ranges = append(ranges, "instances.height < ?")
+				args = append(args, s.HeightXXS)
+			case sizeXS:
+				ranges = append(ranges, "(instances.height >= ? AND instances.height < ?)")
+				args = append(args, s.HeightXXS, s.HeightXS)
+			case sizeXL:
+				ranges = append(ranges, "(instances.height > ? AND instances.height <= ?)")
+				args = append(args, s.HeightXL, s.HeightXXL)
+			case sizeXXL:
+				ranges = append(ranges, "instances.height > ?")
+				args = append(args, s.HeightXXL)
+			}
+		}
+		terms = append(terms, "(instances.pokemon_id = ? AND ("+strings.Join(ranges, " OR ")+"))")
+	}
+	if len(terms) == 0 {
+		return "1 = 0", nil
+	}
+	return "(" + strings.Join(terms, " OR ") + ")", args
+}
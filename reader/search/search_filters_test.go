@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func queryOf(params map[string]string) func(string) string {
+	return func(key string) string { return params[key] }
+}
+
+func TestParseSearchFilters(t *testing.T) {
+	f, err := parseSearchFilters(queryOf(map[string]string{
+		"pokemon_id":     "1, 4,7",
+		"costume_id":     "null,3",
+		"min_attack_iv":  "10",
+		"min_iv_percent": "82.2",
+		"min_cp":         "1500",
+		"max_cp":         "2500",
+		"size":           "XXS,xxl",
+		"caught_from":    "2024-01-01",
+		"caught_to":      "2024-01-31",
+		"lucky":          "true",
+		"traded":         "false",
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(f.PokemonIDs) != 3 || f.PokemonIDs[1] != 4 || !f.CostumeNone || len(f.CostumeIDs) != 1 {
+		t.Fatalf("unexpected lists: %+v", f)
+	}
+	if *f.MinAttackIV != 10 || *f.MinCP != 1500 || *f.MaxCP != 2500 || len(f.Sizes) != 2 || f.Sizes[0] != sizeXXS {
+		t.Fatalf("unexpected ranges: %+v", f)
+	}
+	if !*f.Lucky || *f.Traded || f.Purified != nil {
+		t.Fatalf("unexpected flags: %+v", f)
+	}
+
+	if f, _ := parseSearchFilters(queryOf(nil)); !f.CostumeNone || f.CostumeAny {
+		t.Fatalf("expected costume-less instances by default, got %+v", f)
+	}
+	if f, _ := parseSearchFilters(queryOf(map[string]string{"costume_id": "any"})); !f.CostumeAny {
+		t.Fatalf("expected costume_id=any to lift the costume filter")
+	}
+
+	tooMany := strings.TrimSuffix(strings.Repeat("1,", maxSearchListValues+1), ",")
+	for _, bad := range []map[string]string{
+		{"pokemon_id": "1,x"},
+		{"pokemon_id": tooMany},
+		{"costume_id": "3,y"},
+		{"min_attack_iv": "16"},
+		{"min_iv_percent": "101"},
+		{"min_cp": "2000", "max_cp": "1000"},
+		{"min_level": "0.5"},
+		{"size": "huge"},
+		{"caught_from": "01/02/2024"},
+		{"caught_from": "2024-02-01", "caught_to": "2024-01-01"},
+		{"purified": "maybe"},
+	} {
+		if _, err := parseSearchFilters(queryOf(bad)); err == nil {
+			t.Fatalf("expected %v to be rejected", bad)
+		}
+	}
+}
+
+func TestIVTotalFromPercent(t *testing.T) {
+	for _, tc := range []struct {
+		percent       float64
+		least, atMost int
+	}{{82.2, 37, 36}, {100, 45, 45}, {0, 0, 0}, {80, 36, 36}} {
+		if got := ivTotalAtLeast(tc.percent); got != tc.least {
+			t.Fatalf("ivTotalAtLeast(%v) = %d, want %d", tc.percent, got, tc.least)
+		}
+		if got := ivTotalAtMost(tc.percent); got != tc.atMost {
+			t.Fatalf("ivTotalAtMost(%v) = %d, want %d", tc.percent, got, tc.atMost)
+		}
+	}
+}
+
+func TestSearchFilterConds(t *testing.T) {
+	f, _ := parseSearchFilters(queryOf(map[string]string{"pokemon_id": "1,7,9", "size": "xxs,xl", "charged_move_ids": "5,6"}))
+	conds := f.conds(map[int]speciesSize{1: {0.1, 0.2, 0.8, 0.9}, 7: {1, 2, 3, 4}})
+
+	var sqls []string
+	for _, c := range conds {
+		sqls = append(sqls, c.SQL)
+		if n := strings.Count(c.SQL, "?"); n != len(c.Args) {
+			t.Fatalf("%q has %d placeholders for %d args", c.SQL, n, len(c.Args))
+		}
+	}
+	joined := strings.Join(sqls, " AND ")
+	for _, want := range []string{"instances.pokemon_id IN ?", "instances.costume_id IS NULL", "instances.charged_move2_id IN ?", "instances.height < ?"} {
+		if !strings.Contains(joined, want) {
+			t.Fatalf("expected %q in %s", want, joined)
+		}
+	}
+	size := conds[len(conds)-1]
+	if strings.Count(size.SQL, "instances.pokemon_id = ?") != 2 || size.Args[0] != 1 || size.Args[4] != 7 {
+		t.Fatalf("expected a term per species with thresholds, got %s %v", size.SQL, size.Args)
+	}
+
+	if sql, args := sizeCond([]int{9}, []string{sizeXXL}, nil); sql != "1 = 0" || args != nil {
+		t.Fatalf("expected species without thresholds to match nothing, got %s %v", sql, args)
+	}
+}
+
+func TestSpeciesSizes_KeepsSnapshotOnFailure(t *testing.T) {
+	prevFetch, prevCache := fetchSpeciesSizesFn, sizeCache
+	defer func() { fetchSpeciesSizesFn, sizeCache = prevFetch, prevCache }()
+	sizeCache = &speciesSizeCache{}
+
+	fetchSpeciesSizesFn = func(string) (map[int]speciesSize, error) { return nil, errors.New("down") }
+	if _, err := speciesSizes([]int{1}); err == nil {
+		t.Fatalf("expected an error without any snapshot")
+	}
+
+	fetchSpeciesSizesFn = func(string) (map[int]speciesSize, error) {
+		return map[int]speciesSize{1: {HeightXXL: 1}, 2: {HeightXXL: 2}}, nil
+	}
+	got, err := speciesSizes([]int{1, 3})
+	if err != nil || len(got) != 1 || got[1].HeightXXL != 1 {
+		t.Fatalf("expected the requested species only, got %v err=%v", got, err)
+	}
+
+	sizeCache.loadedAt = sizeCache.loadedAt.Add(-2 * speciesSizesTTL)
+	fetchSpeciesSizesFn = func(string) (map[int]speciesSize, error) { return nil, errors.New("down") }
+	if got, err := speciesSizes([]int{2}); err != nil || got[2].HeightXXL != 2 {
+		t.Fatalf("expected the stale snapshot to be served, got %v err=%v", got, err)
+	}
+}
@@ -0,0 +1,114 @@
+// species_sizes.go
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// speciesSizesTTL is how long the size thresholds are kept before the
+// pokemon data service is asked again.
+const speciesSizesTTL = 6 * time.Hour
+
+// speciesSize holds the height thresholds (in metres) of one species'
+// size classes, as the pokemon data service serves them.
+type speciesSize struct {
+	HeightXXS float64 `json:"height_xxs_threshold"`
+	HeightXS  float64 `json:"height_xs_threshold"`
+	HeightXL  float64 `json:"height_xl_threshold"`
+	HeightXXL float64 `json:"height_xxl_threshold"`
+}
+
+// speciesSizeCache keeps every species' thresholds. A failed refresh keeps
+// the previous snapshot.
+type speciesSizeCache struct {
+	mu       sync.Mutex
+	sizes    map[int]speciesSize
+	loadedAt time.Time
+}
+
+var sizeCache = &speciesSizeCache{}
+
+// Package var so tests can stub the catalog without an HTTP server.
+var fetchSpeciesSizesFn = fetchSpeciesSizes
+
+func pokemonAPIURL() string {
+	if v := strings.TrimSpace(os.Getenv("POKEMON_API_URL")); v != "" {
+		return v
+	}
+	return "http://pokemon_data_container:3001"
+}
+
+func fetchSpeciesSizes(baseURL string) (map[int]speciesSize, error) {
+	url := strings.TrimRight(baseURL, "/") + "/pokemon/pokemons"
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: status %d", url, resp.StatusCode)
+	}
+
+	var rows []struct {
+		PokemonID int          `json:"pokemon_id"`
+		Sizes     *speciesSize `json:"sizes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&rows); err != nil {
+		return nil, fmt.Errorf("decode sizes: %w", err)
+	}
+	out := make(map[int]speciesSize, len(rows))
+	for _, row := range rows {
+		if row.PokemonID == 0 || row.Sizes == nil || row.Sizes.HeightXXL == 0 {
+			continue
+		}
+		out[row.PokemonID] = *row.Sizes
+	}
+	return out, nil
+}
+
+// speciesSizes returns the thresholds of pokemonIDs, refreshing the cache
+// when it is stale. It fails only when no snapshot was ever loaded.
+func speciesSizes(pokemonIDs []int) (map[int]speciesSize, error) {
+	sizeCache.mu.Lock()
+	defer sizeCache.mu.Unlock()
+
+	if sizeCache.sizes == nil || time.Since(sizeCache.loadedAt) > speciesSizesTTL {
+		sizes, err := fetchSpeciesSizesFn(pokemonAPIURL())
+		switch {
+		case err == nil:
+			sizeCache.sizes = sizes
+			sizeCache.loadedAt = time.Now()
+		case sizeCache.sizes == nil:
+			return nil, err
+		default:
+			logrus.Warnf("Failed to refresh species sizes, keeping the previous snapshot: %v", err)
+			// Try again after a minute rather than on every request.
+			sizeCache.loadedAt = time.Now().Add(time.Minute - speciesSizesTTL)
+		}
+	}
+
+	out := make(map[int]speciesSize, len(pokemonIDs))
+	for _, id := range pokemonIDs {
+		if s, ok := sizeCache.sizes[id]; ok {
+			out[id] = s
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,427 @@
+// trade_cycles.go
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+)
+
+// Trade cycles: A wants what B offers, B what C offers and C what A
+// offers. Nearby trainers and their trade_matches pairs form a directed
+// graph (an edge runs from the trainer giving to the trainer who wants);
+// cycles through the requester are found on the trainers first, then each
+// hop is given a pair that both ends' not_trade_list / not_wanted_list
+// allow.
+
+const (
+	defaultCycleRangeKM = 25
+	maxCycleRangeKM     = 100
+	minCycleLength      = 3
+	maxCycleLength      = 4
+	defaultCycleLimit   = 10
+	maxCycleLimit       = 25
+
+	// Bounds on the graph and the search, so a dense area answers in time.
+	maxCycleTrainers   = 500
+	maxCycleEdges      = 5000
+	maxCycleCandidates = 200
+	maxCyclePairEdges  = 10
+
+	// neutralCycleRating stands in for trainers nobody has rated yet.
+	neutralCycleRating = 3.0
+)
+
+// tradeCycleUsableSQL drops pairs whose instance for trade is already
+// promised in a pending trade.
+const tradeCycleUsableSQL = `NOT EXISTS (SELECT 1 FROM trade_items ti JOIN trades t ON t.trade_id = ti.trade_id
+  WHERE ti.instance_id = m.trade_instance_id AND t.trade_status = 'pending')`
+
+// cycleEdge is one trade_matches pair: TradeUserID can give
+// TradeInstanceID to WantedUserID, who wants it through WantedInstanceID.
+type cycleEdge struct {
+	TradeInstanceID  string `gorm:"column:trade_instance_id"`
+	TradeUserID      string `gorm:"column:trade_user_id"`
+	WantedInstanceID string `gorm:"column:wanted_instance_id"`
+	WantedUserID     string `gorm:"column:wanted_user_id"`
+}
+
+type tradeCycleLeg struct {
+	FromUserID       string  `json:"from_user_id"`
+	FromUsername     string  `json:"from_username"`
+	ToUserID         string  `json:"to_user_id"`
+	ToUsername       string  `json:"to_username"`
+	TradeInstanceID  string  `json:"trade_instance_id"`
+	WantedInstanceID string  `json:"wanted_instance_id"`
+	PokemonID        int     `json:"pokemon_id"`
+	DistanceKM       float64 `json:"distance_km"`
+}
+
+type tradeCycleMember struct {
+	UserID     string             `json:"user_id"`
+	Username   string             `json:"username"`
+	DistanceKM float64            `json:"distance_km"`
+	Reputation *TrainerReputation `json:"reputation,omitempty"`
+}
+
+type tradeCycle struct {
+	Length          int                `json:"length"`
+	Score           float64            `json:"score"`
+	TotalDistanceKM float64            `json:"total_distance_km"`
+	Legs            []tradeCycleLeg    `json:"legs"`
+	Members         []tradeCycleMember `json:"members"`
+}
+
+// findUserCycles returns up to maxCycles cycles of 3 to maxLength distinct
+// trainers, each starting at requesterID and following edge direction.
+func findUserCycles(requesterID string, edges []cycleEdge, maxLength, maxCycles int) (cycles [][]string, truncated bool) {
+	outSet := make(map[string]map[string]bool)
+	backToRequester := make(map[string]bool)
+	for _, e := range edges {
+		if e.TradeUserID == e.WantedUserID {
+			continue
+		}
+		if outSet[e.TradeUserID] == nil {
+			outSet[e.TradeUserID] = make(map[string]bool)
+		}
+		outSet[e.TradeUserID][e.WantedUserID] = true
+		if e.WantedUserID == requesterID {
+			backToRequester[e.TradeUserID] = true
+		}
+	}
+	out := make(map[string][]string, len(outSet))
+	for u, next := range outSet {
+		for v := range next {
+			out[u] = append(out[u], v)
+		}
+		sort.Strings(out[u])
+	}
+
+	path := []string{requesterID}
+	onPath := map[string]bool{requesterID: true}
+	var walk func()
+	walk = func() {
+		cur := path[len(path)-1]
+		if len(path) >= minCycleLength && backToRequester[cur] {
+			cycles = append(cycles, append([]string(nil), path...))
+			if len(cycles) >= maxCycles {
+				truncated = true
+				return
+			}
+		}
+		if len(path) == maxLength {
+			return
+		}
+		for _, next := range out[cur] {
+			if onPath[next] {
+				continue
+			}
+			// The last member has to hand back to the requester.
+			if len(path)+1 == maxLength && !backToRequester[next] {
+				continue
+			}
+			path = append(path, next)
+			onPath[next] = true
+			walk()
+			onPath[next] = false
+			path = path[:len(path)-1]
+			if truncated {
+				return
+			}
+		}
+	}
+	walk()
+	return cycles, truncated
+}
+
+// resolveCycleLegs picks a pair for every hop of cycle so that each member
+// may give their instance in return for the one they receive. allows
+// reports whether the giver of give accepts receiving received.
+func resolveCycleLegs(cycle []string, byPair map[[2]string][]cycleEdge, allows func(give, received cycleEdge) bool) ([]cycleEdge, bool) {
+	n := len(cycle)
+	legs := make([]cycleEdge, n)
+	var pick func(i int) bool
+	pick = func(i int) bool {
+		if i == n {
+			// The requester gives in the first leg for what arrives in the last.
+			return allows(legs[0], legs[n-1])
+		}
+		for _, e := range byPair[[2]string{cycle[i], cycle[(i+1)%n]}] {
+			if i > 0 && !allows(e, legs[i-1]) {
+				continue
+			}
+			legs[i] = e
+			if pick(i + 1) {
+				return true
+			}
+		}
+		return false
+	}
+	if !pick(0) {
+		return nil, false
+	}
+	return legs, true
+}
+
+// cycleScore ranks a cycle by its least trusted member (unrated trainers
+// count as neutralCycleRating) less the average hop as a share of the
+// search range, so a full-range hop costs one rating point.
+func cycleScore(ratings []float64, totalKM, rangeKM float64, length int) float64 {
+	lowest := math.Inf(1)
+	for _, r := range ratings {
+		lowest = math.Min(lowest, r)
+	}
+	if math.IsInf(lowest, 1) {
+		lowest = neutralCycleRating
+	}
+	return lowest - totalKM/float64(length)/rangeKM
+}
+
+// sortTradeCycles orders by score, then fewer legs, then shorter hops.
+func sortTradeCycles(cycles []tradeCycle) {
+	sort.SliceStable(cycles, func(i, j int) bool {
+		a, b := cycles[i], cycles[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.Length != b.Length {
+			return a.Length < b.Length
+		}
+		return a.TotalDistanceKM < b.TotalDistanceKM
+	})
+}
+
+// jsonKeys reads a not_trade_list / not_wanted_list object into a set.
+func jsonKeys(raw []byte) map[string]bool {
+	var obj map[string]interface{}
+	if len(raw) == 0 || json.Unmarshal(raw, &obj) != nil {
+		return nil
+	}
+	out := make(map[string]bool, len(obj))
+	for k := range obj {
+		out[k] = true
+	}
+	return out
+}
+
+func parseCycleParams(query func(string) string) (rangeKM float64, maxLength, limit int, err error) {
+	rangeKM, maxLength, limit = defaultCycleRangeKM, maxCycleLength, defaultCycleLimit
+	if raw := query("range_km"); raw != "" {
+		rangeKM, err = strconv.ParseFloat(raw, 64)
+		if err != nil || math.IsNaN(rangeKM) || rangeKM <= 0 || rangeKM > maxCycleRangeKM {
+			return 0, 0, 0, errors.New("Invalid range_km")
+		}
+	}
+	if raw := query("max_length"); raw != "" {
+		maxLength, err = strconv.Atoi(raw)
+		if err != nil || maxLength < minCycleLength || maxLength > maxCycleLength {
+			return 0, 0, 0, errors.New("Invalid max_length")
+		}
+	}
+	if raw := query("limit"); raw != "" {
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit < 1 {
+			return 0, 0, 0, errors.New("Invalid limit")
+		}
+		if limit > maxCycleLimit {
+			limit = maxCycleLimit
+		}
+	}
+	return rangeKM, maxLength, limit, nil
+}
+
+// FindTradeCycles answers GET /api/tradeCycles for the authenticated
+// trainer, around their stored location.
+func FindTradeCycles(c *fiber.Ctx) error {
+	userID, _ := c.Locals("user_id").(string)
+	if userID == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+	rangeKM, maxLength, limit, err := parseCycleParams(func(key string) string { return c.Query(key) })
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var me User
+	if err := db.Where("user_id = ?", userID).Limit(1).Find(&me).Error; err != nil {
+		logrus.Error("Error loading trade cycle requester: ", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to find trade cycles"})
+	}
+	if me.UserID == "" || me.Latitude == nil || me.Longitude == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Set a location to find trade cycles"})
+	}
+	lat, lng := *me.Latitude, *me.Longitude
+
+	// Closest trainers first, so the cap drops the farthest. Only trainers
+	// who allow their location take part, measured from its blurred cell.
+	point := "POINT(" + strconv.FormatFloat(lng, 'f', -1, 64) + ", " + strconv.FormatFloat(lat, 'f', -1, 64) + ")"
+	distanceSQL := "ST_Distance_Sphere(" + blurredPointSQL("location") + ", " + point + ")"
+	var nearby []User
+	if err := db.Where("allow_location = ? AND latitude IS NOT NULL AND longitude IS NOT NULL AND user_id <> ?", true, userID).
+		Where("MBRContains(ST_GeomFromText(?), location)", boundingBox(lat, lng, rangeKM+locationCellSlackKM).wkt()).
+		Where(distanceSQL+" <= ?", rangeKM*1000).
+		Order(distanceSQL).
+		Limit(maxCycleTrainers).
+		Find(&nearby).Error; err != nil {
+		logrus.Error("Error loading nearby trainers: ", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to find trade cycles"})
+	}
+	users := map[string]User{userID: me}
+	userIDs := []string{userID}
+	for _, u := range nearby {
+		users[u.UserID] = u
+		userIDs = append(userIDs, u.UserID)
+	}
+
+	respond := func(cycles []tradeCycle, truncated bool) error {
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"cycles": cycles, "truncated": truncated})
+	}
+	if len(nearby) < minCycleLength-1 {
+		return respond([]tradeCycle{}, false)
+	}
+
+	var edges []cycleEdge
+	if err := db.Table("trade_matches m").
+		Select("m.trade_instance_id, m.trade_user_id, m.wanted_instance_id, m.wanted_user_id").
+		Where("m.trade_user_id IN ? AND m.wanted_user_id IN ?", userIDs, userIDs).
+		Where(tradeMatchInRangeSQL).
+		Where(tradeCycleUsableSQL).
+		Order("m.trade_instance_id, m.wanted_instance_id").
+		Limit(maxCycleEdges + 1).
+		Scan(&edges).Error; err != nil {
+		logrus.Error("Error loading trade matches for cycles: ", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to find trade cycles"})
+	}
+	truncated := len(edges) > maxCycleEdges
+	if truncated {
+		edges = edges[:maxCycleEdges]
+	}
+
+	userCycles, cut := findUserCycles(userID, edges, maxLength, maxCycleCandidates)
+	truncated = truncated || cut
+	if len(userCycles) == 0 {
+		return respond([]tradeCycle{}, truncated)
+	}
+
+	// Only pairs between consecutive members matter from here on, at most
+	// maxCyclePairEdges per hop.
+	onCycle := make(map[[2]string]bool)
+	for _, cycle := range userCycles {
+		for i := range cycle {
+			onCycle[[2]string{cycle[i], cycle[(i+1)%len(cycle)]}] = true
+		}
+	}
+	byPair := make(map[[2]string][]cycleEdge)
+	var instanceIDs []string
+	for _, e := range edges {
+		key := [2]string{e.TradeUserID, e.WantedUserID}
+		if !onCycle[key] || len(byPair[key]) >= maxCyclePairEdges {
+			continue
+		}
+		byPair[key] = append(byPair[key], e)
+		instanceIDs = append(instanceIDs, e.TradeInstanceID, e.WantedInstanceID)
+	}
+
+	var instances []PokemonInstance
+	if err := db.Model(&PokemonInstance{}).
+		Select("instance_id", "user_id", "pokemon_id", "not_trade_list", "not_wanted_list").
+		Where("instance_id IN ?", instanceIDs).
+		Find(&instances).Error; err != nil {
+		logrus.Error("Error loading cycle instances: ", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to find trade cycles"})
+	}
+	pokemonIDs := make(map[string]int, len(instances))
+	notTrade := make(map[string]map[string]bool, len(instances))
+	notWanted := make(map[string]map[string]bool, len(instances))
+	for _, inst := range instances {
+		pokemonIDs[inst.InstanceID] = inst.PokemonID
+		notTrade[inst.InstanceID] = jsonKeys(inst.NotTradeList)
+		notWanted[inst.InstanceID] = jsonKeys(inst.NotWantedList)
+	}
+	allows := func(give, received cycleEdge) bool {
+		return !notWanted[give.TradeInstanceID][received.WantedInstanceID] &&
+			!notTrade[received.WantedInstanceID][give.TradeInstanceID]
+	}
+
+	type resolvedCycle struct {
+		members []string
+		legs    []cycleEdge
+	}
+	var resolved []resolvedCycle
+	var memberInstances []PokemonInstance
+	for _, cycle := range userCycles {
+		legs, ok := resolveCycleLegs(cycle, byPair, allows)
+		if !ok {
+			continue
+		}
+		resolved = append(resolved, resolvedCycle{members: cycle, legs: legs})
+		for _, m := range cycle[1:] {
+			memberInstances = append(memberInstances, PokemonInstance{UserID: m})
+		}
+	}
+	reputations := loadTrainerReputations(memberInstances)
+
+	// Distances run between blurred locations; the caller's own is exact.
+	blurred := make(map[string][2]float64, len(users))
+	for id, u := range users {
+		if id == userID {
+			blurred[id] = [2]float64{lat, lng}
+			continue
+		}
+		bLat, bLng := blurLocation(*u.Latitude, *u.Longitude)
+		blurred[id] = [2]float64{bLat, bLng}
+	}
+	distance := func(a, b User) float64 {
+		pa, pb := blurred[a.UserID], blurred[b.UserID]
+		return haversine(pa[0], pa[1], pb[0], pb[1])
+	}
+	cycles := make([]tradeCycle, 0, len(resolved))
+	for _, rc := range resolved {
+		out := tradeCycle{Length: len(rc.members)}
+		var ratings []float64
+		for i, e := range rc.legs {
+			from, to := users[e.TradeUserID], users[e.WantedUserID]
+			km := distance(from, to)
+			out.TotalDistanceKM += km
+			out.Legs = append(out.Legs, tradeCycleLeg{
+				FromUserID:       from.UserID,
+				FromUsername:     from.Username,
+				ToUserID:         to.UserID,
+				ToUsername:       to.Username,
+				TradeInstanceID:  e.TradeInstanceID,
+				WantedInstanceID: e.WantedInstanceID,
+				PokemonID:        pokemonIDs[e.TradeInstanceID],
+				DistanceKM:       roundDistanceKM(km),
+			})
+			if i == 0 {
+				continue
+			}
+			member := tradeCycleMember{UserID: from.UserID, Username: from.Username, DistanceKM: roundDistanceKM(distance(me, from))}
+			rating := neutralCycleRating
+			if rep, ok := reputations[from.UserID]; ok {
+				member.Reputation = &rep
+				if rep.RatingAverage != nil {
+					rating = *rep.RatingAverage
+				}
+			}
+			ratings = append(ratings, rating)
+			out.Members = append(out.Members, member)
+		}
+		out.Score = math.Round(cycleScore(ratings, out.TotalDistanceKM, rangeKM, out.Length)*100) / 100
+		out.TotalDistanceKM = roundDistanceKM(out.TotalDistanceKM)
+		cycles = append(cycles, out)
+	}
+	sortTradeCycles(cycles)
+	if len(cycles) > limit {
+		cycles = cycles[:limit]
+	}
+
+	logrus.Infof("Returning %d trade cycles for user %s (%d candidates)", len(cycles), userID, len(userCycles))
+	return respond(cycles, truncated)
+}
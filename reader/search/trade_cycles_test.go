@@ -0,0 +1,101 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func edge(from, give, to, wanted string) cycleEdge {
+	return cycleEdge{TradeUserID: from, TradeInstanceID: give, WantedUserID: to, WantedInstanceID: wanted}
+}
+
+func TestFindUserCycles(t *testing.T) {
+	edges := []cycleEdge{
+		// me -> a -> b -> me and me -> a -> b -> c -> me
+		edge("me", "m1", "a", "aw"),
+		edge("a", "a1", "b", "bw"),
+		edge("b", "b1", "me", "mw"),
+		edge("b", "b2", "c", "cw"),
+		edge("c", "c1", "me", "mw2"),
+		// A direct swap is not a cycle here.
+		edge("a", "a2", "me", "mw3"),
+		// d never leads back.
+		edge("me", "m2", "d", "dw"),
+	}
+	cycles, truncated := findUserCycles("me", edges, 4, 10)
+	want := [][]string{{"me", "a", "b"}, {"me", "a", "b", "c"}}
+	if truncated || !reflect.DeepEqual(cycles, want) {
+		t.Fatalf("got %v truncated=%v, want %v", cycles, truncated, want)
+	}
+
+	if cycles, _ := findUserCycles("me", edges, 3, 10); len(cycles) != 1 {
+		t.Fatalf("expected max_length 3 to keep only the three-way cycle, got %v", cycles)
+	}
+	if cycles, truncated := findUserCycles("me", edges, 4, 1); len(cycles) != 1 || !truncated {
+		t.Fatalf("expected the candidate cap to truncate, got %v truncated=%v", cycles, truncated)
+	}
+}
+
+func TestResolveCycleLegs_HonoursExclusionLists(t *testing.T) {
+	byPair := map[[2]string][]cycleEdge{
+		{"me", "a"}: {edge("me", "m1", "a", "aw")},
+		{"a", "b"}:  {edge("a", "a1", "b", "bw"), edge("a", "a2", "b", "bw")},
+		{"b", "me"}: {edge("b", "b1", "me", "mw")},
+	}
+	// a will not give a1 for aw; me will not give m1 for mw2.
+	allows := func(give, received cycleEdge) bool {
+		return !(give.TradeInstanceID == "a1" && received.WantedInstanceID == "aw") &&
+			!(give.TradeInstanceID == "m1" && received.WantedInstanceID == "mw2")
+	}
+	legs, ok := resolveCycleLegs([]string{"me", "a", "b"}, byPair, allows)
+	if !ok || legs[1].TradeInstanceID != "a2" {
+		t.Fatalf("expected a2 to replace the excluded a1, got %v ok=%v", legs, ok)
+	}
+
+	byPair[[2]string{"b", "me"}] = []cycleEdge{edge("b", "b1", "me", "mw2")}
+	if _, ok := resolveCycleLegs([]string{"me", "a", "b"}, byPair, allows); ok {
+		t.Fatalf("expected the closing hop to be checked against the requester's lists")
+	}
+}
+
+func TestCycleScoreAndOrder(t *testing.T) {
+	if got := cycleScore(nil, 0, 25, 3); got != neutralCycleRating {
+		t.Fatalf("expected unrated cycles to score neutral, got %v", got)
+	}
+	if got := cycleScore([]float64{4.8, 3.5}, 75, 25, 3); got != 2.5 {
+		t.Fatalf("expected the weakest member less one point per full-range hop, got %v", got)
+	}
+
+	cycles := []tradeCycle{
+		{Length: 4, Score: 3, TotalDistanceKM: 4},
+		{Length: 3, Score: 3, TotalDistanceKM: 9},
+		{Length: 3, Score: 4.2, TotalDistanceKM: 30},
+		{Length: 3, Score: 3, TotalDistanceKM: 2},
+	}
+	sortTradeCycles(cycles)
+	got := []float64{cycles[0].TotalDistanceKM, cycles[1].TotalDistanceKM, cycles[2].TotalDistanceKM, cycles[3].TotalDistanceKM}
+	if !reflect.DeepEqual(got, []float64{30, 2, 9, 4}) {
+		t.Fatalf("unexpected order: %v", got)
+	}
+}
+
+func TestParseCycleParams(t *testing.T) {
+	rangeKM, maxLength, limit, err := parseCycleParams(queryOf(nil))
+	if err != nil || rangeKM != defaultCycleRangeKM || maxLength != maxCycleLength || limit != defaultCycleLimit {
+		t.Fatalf("unexpected defaults: %v %v %v err=%v", rangeKM, maxLength, limit, err)
+	}
+	if _, _, limit, _ := parseCycleParams(queryOf(map[string]string{"limit": "500"})); limit != maxCycleLimit {
+		t.Fatalf("expected limit to be capped, got %d", limit)
+	}
+	for _, bad := range []map[string]string{
+		{"range_km": "0"},
+		{"range_km": "101"},
+		{"max_length": "2"},
+		{"max_length": "5"},
+		{"limit": "0"},
+	} {
+		if _, _, _, err := parseCycleParams(queryOf(bad)); err == nil {
+			t.Fatalf("expected %v to be rejected", bad)
+		}
+	}
+}
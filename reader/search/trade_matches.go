@@ -0,0 +1,106 @@
+// trade_matches.go
+
+package main
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+// trade_matches is kept by storage: one row per (instance offered for
+// trade, instance another trainer wants) pair that matches, match filters
+// included. Search only reads it.
+
+// tradeMatchInRangeSQL holds a pair to the max_distance_km of its match
+// filters. Both trainers must share their location, and the distance is
+// measured between their blurred cell centres, so the filter cannot be
+// used to home in on where anyone is.
+var tradeMatchInRangeSQL = `(m.max_distance_km IS NULL OR EXISTS (SELECT 1 FROM users tu JOIN users wu ON wu.user_id = m.wanted_user_id
+  WHERE tu.user_id = m.trade_user_id AND tu.allow_location = 1 AND wu.allow_location = 1
+    AND tu.latitude IS NOT NULL AND tu.longitude IS NOT NULL AND wu.latitude IS NOT NULL AND wu.longitude IS NOT NULL
+    AND ST_Distance_Sphere(` + blurredPointSQL("tu.location") + `, ` + blurredPointSQL("wu.location") + `) <= m.max_distance_km * 1000))`
+
+// tradeMatchExistsSQL keeps results whose owner wants something the
+// searching trainer offers, unless the result's not_wanted_list rules that
+// wanted instance out.
+var tradeMatchExistsSQL = `EXISTS (SELECT 1 FROM trade_matches m
+  WHERE m.wanted_user_id = instances.user_id AND m.trade_user_id = ?
+    AND COALESCE(JSON_CONTAINS_PATH(instances.not_wanted_list, 'one', CONCAT('$.', JSON_QUOTE(m.wanted_instance_id))), 0) = 0
+    AND ` + tradeMatchInRangeSQL + `)`
+
+// wantedMatchExistsSQL keeps results whose owner offers something the
+// searching trainer wants, unless the result's not_trade_list rules that
+// trade instance out.
+var wantedMatchExistsSQL = `EXISTS (SELECT 1 FROM trade_matches m
+  WHERE m.trade_user_id = instances.user_id AND m.wanted_user_id = ?
+    AND COALESCE(JSON_CONTAINS_PATH(instances.not_trade_list, 'one', CONCAT('$.', JSON_QUOTE(m.trade_instance_id))), 0) = 0
+    AND ` + tradeMatchInRangeSQL + `)`
+
+// resultOwnerIDs lists the distinct owners of a page of results.
+func resultOwnerIDs(instances []PokemonInstance) []string {
+	seen := make(map[string]struct{}, len(instances))
+	userIDs := make([]string, 0, len(instances))
+	for _, inst := range instances {
+		if inst.UserID == "" {
+			continue
+		}
+		if _, ok := seen[inst.UserID]; ok {
+			continue
+		}
+		seen[inst.UserID] = struct{}{}
+		userIDs = append(userIDs, inst.UserID)
+	}
+	return userIDs
+}
+
+// loadOwnerInstances fetches the instances flagged by column ("is_wanted"
+// or "is_for_trade") of every owner in one query, grouped by owner.
+func loadOwnerInstances(userIDs []string, column string) (map[string][]PokemonInstance, error) {
+	out := make(map[string][]PokemonInstance, len(userIDs))
+	if len(userIDs) == 0 {
+		return out, nil
+	}
+	var rows []PokemonInstance
+	if err := db.Model(&PokemonInstance{}).
+		Where("instances.user_id IN ? AND "+column+" = ?", userIDs, true).
+		Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		out[row.UserID] = append(out[row.UserID], row)
+	}
+	return out, nil
+}
+
+// loadMatchedWantedIDs returns which of the owners' wanted instances one of
+// userID's trade instances matches.
+func loadMatchedWantedIDs(userID string, ownerIDs []string) map[string]bool {
+	return loadMatchedIDs("wanted_instance_id", "trade_user_id", "wanted_user_id", userID, ownerIDs)
+}
+
+// loadMatchedTradeIDs returns which of the owners' trade instances match
+// one of userID's wanted instances.
+func loadMatchedTradeIDs(userID string, ownerIDs []string) map[string]bool {
+	return loadMatchedIDs("trade_instance_id", "wanted_user_id", "trade_user_id", userID, ownerIDs)
+}
+
+// loadMatchedIDs reads the instance ids in column of the pairs between
+// userID and the owners. Failures only leave the match flags false.
+func loadMatchedIDs(column, userColumn, ownerColumn, userID string, ownerIDs []string) map[string]bool {
+	out := make(map[string]bool)
+	if userID == "" || len(ownerIDs) == 0 {
+		return out
+	}
+	var ids []string
+	if err := db.Table("trade_matches m").
+		Distinct("m."+column).
+		Where("m."+userColumn+" = ? AND m."+ownerColumn+" IN ?", userID, ownerIDs).
+		Where(tradeMatchInRangeSQL).
+		Pluck("m."+column, &ids).Error; err != nil {
+		logrus.Warnf("Failed to load trade matches: %v", err)
+		return out
+	}
+	for _, id := range ids {
+		out[id] = true
+	}
+	return out
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMatchExistsSQL_QuotesInstanceIDsInJSONPaths(t *testing.T) {
+	for name, sql := range map[string]string{"trade": tradeMatchExistsSQL, "wanted": wantedMatchExistsSQL} {
+		if strings.Contains(sql, `'$."'`) || !strings.Contains(sql, "CONCAT('$.', JSON_QUOTE(m.") {
+			t.Fatalf("expected %s match ids quoted with JSON_QUOTE: %s", name, sql)
+		}
+	}
+}
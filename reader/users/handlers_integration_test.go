@@ -56,8 +56,20 @@ func newHandlerTestApp(authUserID string) *fiber.App {
 	app.Put("/api/update-user/:user_id", UpdateUserHandler)
 	app.Put("/api/users/update-user/:user_id", UpdateUserHandler)
 	app.Get("/api/users/:user_id/overview", GetUserOverviewHandler)
+	app.Get("/api/users/:user_id/tags", GetUserTagsHandler)
+	app.Get("/api/users/:user_id/notifications", GetNotificationsHandler)
+	app.Post("/api/users/:user_id/notifications/read", MarkNotificationsReadHandler)
+	app.Post("/api/users/:user_id/notifications/dismiss", DismissNotificationsHandler)
+	app.Get("/api/users/:user_id/notification-preferences", GetNotificationPreferencesHandler)
+	app.Put("/api/users/:user_id/notification-preferences", UpdateNotificationPreferencesHandler)
+	app.Get("/api/users/:user_id/saved-searches", ListSavedSearchesHandler)
+	app.Post("/api/users/:user_id/saved-searches", CreateSavedSearchHandler)
+	app.Put("/api/users/:user_id/saved-searches/:search_id", UpdateSavedSearchHandler)
+	app.Delete("/api/users/:user_id/saved-searches/:search_id", DeleteSavedSearchHandler)
 	app.Get("/api/instances/by-username/:username", GetInstancesByUsername)
 	app.Get("/api/users/instances/by-username/:username", GetInstancesByUsername)
+	app.Get("/api/trades/dust-preview", GetTradeDustPreviewHandler)
+	app.Get("/api/trades/:trade_id/messages", GetTradeMessagesHandler)
 
 	// Public endpoints used in tests.
 	app.Get("/api/public/users/:username", GetPublicSnapshotByUsername)
@@ -224,6 +236,11 @@ func TestGetPublicSnapshotByUsername_UsesUserIDForInstanceLookup(t *testing.T) {
 		WithArgs("user-abc").
 		WillReturnRows(sqlmock.NewRows([]string{"instance_id", "user_id", "pokemon_id", "shiny", "lucky", "shadow", "purified", "date_added", "last_update", "disabled", "is_traded", "mega", "dynamax", "gigantamax", "crown", "is_fused", "is_caught", "is_for_trade", "is_wanted", "most_wanted", "mirror", "pref_lucky", "registered", "favorite"}))
 
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `trainer_reputation` WHERE user_id = ? LIMIT ?")).
+		WithArgs("user-abc", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "rating_average", "rating_count", "trades_completed", "trades_cancelled", "completion_rate", "cancellation_rate"}).
+			AddRow("user-abc", 4.5, 2, 3, 1, 0.75, 0.25))
+
 	req := makeJSONRequest(t, http.MethodGet, "/api/public/users/adam", nil)
 	resp, err := app.Test(req, -1)
 	if err != nil {
@@ -233,6 +250,66 @@ func TestGetPublicSnapshotByUsername_UsesUserIDForInstanceLookup(t *testing.T) {
 		t.Fatalf("unexpected status: got %d, want %d", resp.StatusCode, http.StatusOK)
 	}
 
+	var body map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("decode body failed: %v", err)
+	}
+	reputation, _ := body["reputation"].(map[string]any)
+	if reputation["rating_average"] != 4.5 || reputation["rating_count"] != float64(2) {
+		t.Fatalf("unexpected reputation: %v", body["reputation"])
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestGetPublicSnapshotByUsername_BlursLocation(t *testing.T) {
+	mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	app := newHandlerTestApp("irrelevant")
+	columns := []string{"user_id", "username", "app_joined_at", "allow_location", "latitude", "longitude"}
+
+	for _, tc := range []struct {
+		allow bool
+		want  bool
+	}{{allow: false}, {allow: true, want: true}} {
+		mock.ExpectQuery("SELECT user_id, username, pokemon_go_name, team, trainer_level, total_xp,").
+			WithArgs("adam").
+			WillReturnRows(sqlmock.NewRows(columns).AddRow("user-abc", "Adam", time.Now(), tc.allow, 52.520008, 13.404954))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `instances` WHERE user_id = ?")).
+			WithArgs("user-abc").
+			WillReturnRows(sqlmock.NewRows([]string{"instance_id"}))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `trainer_reputation` WHERE user_id = ? LIMIT ?")).
+			WithArgs("user-abc", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+
+		resp, err := app.Test(makeJSONRequest(t, http.MethodGet, "/api/public/users/adam", nil), -1)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		var body struct {
+			User map[string]any `json:"user"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("decode body failed: %v", err)
+		}
+		if _, ok := body.User["latitude"]; ok {
+			t.Fatalf("expected no stored coordinates, got %v", body.User)
+		}
+		location, _ := body.User["location"].(map[string]any)
+		if !tc.want {
+			if location != nil {
+				t.Fatalf("expected no location without allow_location, got %v", location)
+			}
+			continue
+		}
+		if location["cell"] != "u33dc" || location["latitude"] == 52.520008 {
+			t.Fatalf("expected a blurred location, got %v", location)
+		}
+	}
+
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Fatalf("unmet sqlmock expectations: %v", err)
 	}
@@ -264,6 +341,63 @@ func TestGetUserOverviewHandler_RejectsUserMismatch(t *testing.T) {
 	}
 }
 
+func TestGetUserTagsHandler_ListsTagsWithCounts(t *testing.T) {
+	mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	app := newHandlerTestApp("user-1")
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `tags` WHERE user_id = ? AND deleted_at IS NULL ORDER BY parent, sort, name")).
+		WithArgs("user-1").
+		WillReturnRows(sqlmock.NewRows([]string{"tag_id", "user_id", "parent", "parent_tag_id", "name", "color", "sort"}).
+			AddRow("tag-fav", "user-1", "caught", nil, "Favorite", "#facc15", 10).
+			AddRow("tag-hundo", "user-1", "caught", "tag-fav", "Hundos", nil, 11))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT tag_id, COUNT(*) AS count FROM `instance_tags` WHERE user_id = ? GROUP BY `tag_id`")).
+		WithArgs("user-1").
+		WillReturnRows(sqlmock.NewRows([]string{"tag_id", "count"}).AddRow("tag-hundo", 3))
+
+	req := makeJSONRequest(t, http.MethodGet, "/api/users/user-1/tags", nil)
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: got %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	var body struct {
+		Tags []map[string]any `json:"tags"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(body.Tags) != 2 {
+		t.Fatalf("expected 2 tags, got %d", len(body.Tags))
+	}
+	if body.Tags[0]["instance_count"] != float64(0) || body.Tags[1]["instance_count"] != float64(3) {
+		t.Fatalf("unexpected counts: %v", body.Tags)
+	}
+	if body.Tags[1]["parent_tag_id"] != "tag-fav" {
+		t.Fatalf("expected nested tag, got %v", body.Tags[1])
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet sql expectations: %v", err)
+	}
+}
+
+func TestGetUserTagsHandler_RejectsUserMismatch(t *testing.T) {
+	app := newHandlerTestApp("user-auth")
+	req := makeJSONRequest(t, http.MethodGet, "/api/users/user-other/tags", nil)
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("unexpected status: got %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+}
+
 func TestGetInstancesByUsername_Found_CaseInsensitive(t *testing.T) {
 	mock, cleanup := setupMockDB(t)
 	defer cleanup()
@@ -332,3 +466,434 @@ func TestGetInstancesByUsername_NotFound(t *testing.T) {
 		t.Fatalf("unmet sqlmock expectations: %v", err)
 	}
 }
+
+func TestGetTradeDustPreviewHandler_LegendaryNewEntry(t *testing.T) {
+	mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	prevFetch, prevCache := fetchSpeciesRarityFn, rarityCache
+	fetchSpeciesRarityFn = func() (map[int]string, error) {
+		return map[int]string{150: "legendary", 25: "standard"}, nil
+	}
+	rarityCache = &speciesRarityCache{}
+	defer func() { fetchSpeciesRarityFn, rarityCache = prevFetch, prevCache }()
+
+	app := newHandlerTestApp("u-1")
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `trade_dust_costs` WHERE friendship_level = ? LIMIT ?")).
+		WithArgs("Great", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"friendship_level", "regular", "special_or_new", "special_and_new"}).
+			AddRow("Great", 100, 16000, 800000))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `instances` WHERE instance_id IN (?,?)")).
+		WithArgs("inst-a", "inst-b").
+		WillReturnRows(sqlmock.NewRows([]string{"instance_id", "user_id", "variant_id", "pokemon_id", "shiny"}).
+			AddRow("inst-a", "u-1", "0150-default", 150, false).
+			AddRow("inst-b", "u-2", "0025-default", 25, false))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `registrations` WHERE user_id IN (?,?) AND SUBSTRING_INDEX(variant_id, '-', 1) IN (?,?)")).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "variant_id"}).
+			AddRow("u-1", "0025-default"))
+
+	req := makeJSONRequest(t, http.MethodGet, "/api/trades/dust-preview?proposed=inst-a&accepting=inst-b&friendship_level=Great", nil)
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: got %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	var body map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("decode body failed: %v", err)
+	}
+	if body["trade_dust_cost"] != float64(800000) {
+		t.Fatalf("unexpected cost: %v", body["trade_dust_cost"])
+	}
+	if body["is_special_trade"] != true || body["is_registered_trade"] != false {
+		t.Fatalf("unexpected flags: %v", body)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestGetTradeDustPreviewHandler_RejectsUnknownFriendshipLevel(t *testing.T) {
+	mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	app := newHandlerTestApp("u-1")
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `trade_dust_costs` WHERE friendship_level = ? LIMIT ?")).
+		WithArgs("Bestest", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"friendship_level"}))
+
+	req := makeJSONRequest(t, http.MethodGet, "/api/trades/dust-preview?proposed=inst-a&accepting=inst-b&friendship_level=Bestest", nil)
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unexpected status: got %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestGetTradeDustPreviewHandler_RequiresBothSides(t *testing.T) {
+	app := newHandlerTestApp("u-1")
+
+	req := makeJSONRequest(t, http.MethodGet, "/api/trades/dust-preview?proposed=inst-a", nil)
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unexpected status: got %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestGetNotificationsHandler_PagesNewestFirst(t *testing.T) {
+	mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	app := newHandlerTestApp("user-1")
+	created := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `notifications` WHERE (user_id = ? AND dismissed_at IS NULL) AND read_at IS NULL AND notification_id < ? ORDER BY notification_id DESC LIMIT ?")).
+		WithArgs("user-1", uint64(10), 3).
+		WillReturnRows(sqlmock.NewRows([]string{"notification_id", "user_id", "notification_type", "actor_username", "trade_id", "payload", "created_at"}).
+			AddRow(9, "user-1", "trade_proposed", "bob", "t-1", `{"trade_status":"proposed"}`, created).
+			AddRow(7, "user-1", "trade_rated", "bob", "t-0", `{"score":5}`, created).
+			AddRow(4, "user-1", "most_wanted_match", "cara", nil, nil, created))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `notifications` WHERE user_id = ? AND dismissed_at IS NULL AND read_at IS NULL")).
+		WithArgs("user-1").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(5))
+
+	req := makeJSONRequest(t, http.MethodGet, "/api/users/user-1/notifications?unread=true&before=10&limit=2", nil)
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: got %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	var body struct {
+		Notifications []map[string]any `json:"notifications"`
+		UnreadCount   int              `json:"unread_count"`
+		NextBefore    *string          `json:"next_before"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(body.Notifications) != 2 || body.UnreadCount != 5 {
+		t.Fatalf("unexpected page: %+v", body)
+	}
+	if body.NextBefore == nil || *body.NextBefore != "7" {
+		t.Fatalf("expected next_before=7, got %v", body.NextBefore)
+	}
+	first := body.Notifications[0]
+	if first["notification_id"] != "9" || first["data"].(map[string]any)["trade_status"] != "proposed" {
+		t.Fatalf("unexpected first notification: %v", first)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet sql expectations: %v", err)
+	}
+}
+
+func TestMarkNotificationsReadHandler_OnlyOwnUnreadRows(t *testing.T) {
+	mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	app := newHandlerTestApp("user-1")
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `notifications` SET `read_at`=? WHERE (user_id = ? AND read_at IS NULL) AND notification_id IN (?,?)")).
+		WithArgs(sqlmock.AnyArg(), "user-1", uint64(3), uint64(4)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	req := makeJSONRequest(t, http.MethodPost, "/api/users/user-1/notifications/read", map[string]any{"ids": []string{"3", "4"}})
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: got %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	var body map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if body["updated"] != float64(2) {
+		t.Fatalf("expected 2 updated rows, got %v", body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet sql expectations: %v", err)
+	}
+}
+
+func TestDismissNotificationsHandler_RequiresSelection(t *testing.T) {
+	app := newHandlerTestApp("user-1")
+
+	for _, body := range []any{map[string]any{}, map[string]any{"ids": []string{"x"}}} {
+		req := makeJSONRequest(t, http.MethodPost, "/api/users/user-1/notifications/dismiss", body)
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("unexpected status for %v: got %d, want %d", body, resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestUpdateNotificationPreferencesHandler_UpsertsAndDefaults(t *testing.T) {
+	mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	app := newHandlerTestApp("user-1")
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `notification_preferences` (`user_id`,`notification_type`,`enabled`) VALUES (?,?,?) ON DUPLICATE KEY UPDATE `enabled`=VALUES(`enabled`)")).
+		WithArgs("user-1", "trade_rated", false).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `notification_preferences` WHERE user_id = ?")).
+		WithArgs("user-1").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "notification_type", "enabled"}).
+			AddRow("user-1", "trade_rated", false))
+
+	req := makeJSONRequest(t, http.MethodPut, "/api/users/user-1/notification-preferences",
+		map[string]any{"preferences": map[string]bool{"trade_rated": false}})
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: got %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	var body struct {
+		Preferences map[string]bool `json:"preferences"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if body.Preferences["trade_rated"] || !body.Preferences["trade_proposed"] || len(body.Preferences) != len(notificationTypes) {
+		t.Fatalf("unexpected preferences: %v", body.Preferences)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet sql expectations: %v", err)
+	}
+
+	req = makeJSONRequest(t, http.MethodPut, "/api/users/user-1/notification-preferences",
+		map[string]any{"preferences": map[string]bool{"chat_message": false}})
+	resp, err = app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected unknown types to be rejected, got %d", resp.StatusCode)
+	}
+}
+
+func TestGetTradeMessagesHandler_PartiesOnly(t *testing.T) {
+	mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	created := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	tradeRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"trade_id", "user_id_proposed", "username_proposed", "user_id_accepting", "username_accepting", "trade_status"}).
+			AddRow("t-1", "user-1", "ash", "user-2", "misty", "pending")
+	}
+	tradeQuery := regexp.QuoteMeta("SELECT * FROM `trades` WHERE trade_id = ? ORDER BY `trades`.`trade_id` LIMIT ?")
+
+	mock.ExpectQuery(tradeQuery).WithArgs("t-1", 1).WillReturnRows(tradeRows())
+	resp, err := newHandlerTestApp("user-3").Test(makeJSONRequest(t, http.MethodGet, "/api/trades/t-1/messages", nil), -1)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected outsiders to get 403, got %d", resp.StatusCode)
+	}
+
+	mock.ExpectQuery(tradeQuery).WithArgs("t-1", 1).WillReturnRows(tradeRows())
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `trade_messages` WHERE (trade_id = ? AND hidden_at IS NULL) AND message_id < ? ORDER BY message_id DESC LIMIT ?")).
+		WithArgs("t-1", uint64(20), 3).
+		WillReturnRows(sqlmock.NewRows([]string{"message_id", "trade_id", "client_message_id", "sender_user_id", "sender_username", "recipient_user_id", "body", "created_at"}).
+			AddRow(15, "t-1", "c-3", "user-1", "ash", "user-2", "see you there", created).
+			AddRow(12, "t-1", "c-2", "user-2", "misty", "user-1", "which gym?", created).
+			AddRow(9, "t-1", "c-1", "user-1", "ash", "user-2", "hi", created))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `trade_messages` WHERE trade_id = ? AND recipient_user_id = ? AND read_at IS NULL AND hidden_at IS NULL")).
+		WithArgs("t-1", "user-2").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+
+	resp, err = newHandlerTestApp("user-2").Test(makeJSONRequest(t, http.MethodGet, "/api/trades/t-1/messages?before=20&limit=2", nil), -1)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: got %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	var body struct {
+		Messages    []map[string]any `json:"messages"`
+		UnreadCount int              `json:"unread_count"`
+		NextBefore  *string          `json:"next_before"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(body.Messages) != 2 || body.UnreadCount != 2 {
+		t.Fatalf("unexpected page: %+v", body)
+	}
+	if body.NextBefore == nil || *body.NextBefore != "12" {
+		t.Fatalf("expected next_before=12, got %v", body.NextBefore)
+	}
+	first := body.Messages[0]
+	if first["message_id"] != "15" || first["sender_username"] != "ash" {
+		t.Fatalf("unexpected first message: %v", first)
+	}
+	if _, ok := first["recipient_user_id"]; ok {
+		t.Fatalf("expected user ids to stay private, got %v", first)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet sql expectations: %v", err)
+	}
+}
+
+/* -------------------------------------------------------------------------- */
+/*  /api/users/:user_id/saved-searches  (protected)                            */
+/* -------------------------------------------------------------------------- */
+
+func TestCreateSavedSearchHandler_NormalizesParams(t *testing.T) {
+	mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	app := newHandlerTestApp("user-1")
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `saved_searches` WHERE user_id = ?")).
+		WithArgs("user-1").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `saved_searches`")).
+		WithArgs("user-1", "Shiny Gible", 443, `{"fast_move_id":"1,2","min_iv_percent":"82.2","pokemon_id":"443","shiny":"true"}`, false, 60, nil, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(7, 1))
+	mock.ExpectCommit()
+
+	req := makeJSONRequest(t, http.MethodPost, "/api/users/user-1/saved-searches", map[string]any{
+		"name":   " Shiny Gible ",
+		"params": map[string]any{"pokemon_id": 443, "shiny": "true", "costume_id": nil, "min_iv_percent": 82.2, "fast_move_id": "1, 2"},
+	})
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("unexpected status: got %d, want %d", resp.StatusCode, http.StatusCreated)
+	}
+	var body map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if body["search_id"] != "7" || body["notify_every_minutes"] != float64(60) {
+		t.Fatalf("unexpected saved search: %v", body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet sql expectations: %v", err)
+	}
+}
+
+func TestCreateSavedSearchHandler_RejectsInvalidAndOverLimit(t *testing.T) {
+	mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	app := newHandlerTestApp("user-1")
+
+	for _, body := range []map[string]any{
+		{"name": "No species", "params": map[string]any{"shiny": true}},
+		{"name": "Bad key", "params": map[string]any{"pokemon_id": 1, "cursor": "abc"}},
+		{"name": "Half a location", "params": map[string]any{"pokemon_id": 1, "latitude": 52.5}},
+		{"name": "Bad sort", "params": map[string]any{"pokemon_id": 1, "sort": "random"}},
+		{"name": "Two species", "params": map[string]any{"pokemon_id": "1,4"}},
+		{"name": "Sizes", "params": map[string]any{"pokemon_id": 1, "size": "xxl"}},
+		{"name": "Bad day", "params": map[string]any{"pokemon_id": 1, "caught_from": "May 1"}},
+		{"name": "Bad costume", "params": map[string]any{"pokemon_id": 1, "costume_id": "any,3"}},
+		{"name": "Too often", "params": map[string]any{"pokemon_id": 1}, "notify_every_minutes": 1},
+		{"name": "", "params": map[string]any{"pokemon_id": 1}},
+	} {
+		resp, err := app.Test(makeJSONRequest(t, http.MethodPost, "/api/users/user-1/saved-searches", body), -1)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("unexpected status for %v: got %d, want %d", body, resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `saved_searches` WHERE user_id = ?")).
+		WithArgs("user-1").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(maxSavedSearches))
+	resp, err := app.Test(makeJSONRequest(t, http.MethodPost, "/api/users/user-1/saved-searches",
+		map[string]any{"name": "One more", "params": map[string]any{"pokemon_id": 1}}), -1)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("unexpected status: got %d, want %d", resp.StatusCode, http.StatusConflict)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet sql expectations: %v", err)
+	}
+}
+
+func TestUpdateSavedSearchHandler_Mutes(t *testing.T) {
+	mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	app := newHandlerTestApp("user-1")
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `saved_searches` WHERE search_id = ? AND user_id = ? ORDER BY `saved_searches`.`search_id` LIMIT ?")).
+		WithArgs(7, "user-1", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"search_id", "user_id", "name", "pokemon_id", "params", "muted", "notify_every_minutes"}).
+			AddRow(7, "user-1", "Gible", 443, `{"pokemon_id":"443"}`, false, 60))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `saved_searches` SET `name`=?,`pokemon_id`=?,`params`=?,`muted`=?,`notify_every_minutes`=?,`updated_at`=? WHERE `search_id` = ?")).
+		WithArgs("Gible", 443, `{"pokemon_id":"443"}`, true, 60, sqlmock.AnyArg(), 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	resp, err := app.Test(makeJSONRequest(t, http.MethodPut, "/api/users/user-1/saved-searches/7", map[string]any{"muted": true}), -1)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: got %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet sql expectations: %v", err)
+	}
+}
+
+func TestDeleteSavedSearchHandler_NotFound(t *testing.T) {
+	mock, cleanup := setupMockDB(t)
+	defer cleanup()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `saved_searches` WHERE search_id = ? AND user_id = ?")).
+		WithArgs(7, "user-1").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	resp, err := newHandlerTestApp("user-1").Test(makeJSONRequest(t, http.MethodDelete, "/api/users/user-1/saved-searches/7", nil), -1)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("unexpected status: got %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet sql expectations: %v", err)
+	}
+}
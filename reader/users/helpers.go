@@ -14,3 +14,11 @@ func lastUpdateToTime(v int64) time.Time {
 	}
 	return time.Unix(v, 0)
 }
+
+// derefString returns the pointed-to string, or "" for nil.
+func derefString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,74 @@
+// location_privacy.go
+package main
+
+import "math"
+
+// A trainer's stored coordinates are never shown to others. Trainers who
+// opted in (allow_location) are shown at the centre of their geohash cell of
+// locationCellPrecision characters, about 4.9 km square; the search service
+// blurs the same way.
+
+const (
+	locationCellPrecision = 5
+	// locationCellDegrees is the side of such a cell: its 13 longitude and
+	// 12 latitude bits make both 360/2^13 = 180/2^12 degrees.
+	locationCellDegrees = 360.0 / 8192
+)
+
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// PublicLocation is where other trainers see a trainer.
+type PublicLocation struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Cell      string  `json:"cell"`
+}
+
+// blurCoordinate snaps v, offset to be non-negative, to the centre of its
+// cell.
+func blurCoordinate(v, offset float64) float64 {
+	return (math.Floor((v+offset)/locationCellDegrees)+0.5)*locationCellDegrees - offset
+}
+
+// locationCell is the geohash of the cell holding (lat, lng).
+func locationCell(lat, lng float64) string {
+	latLo, latHi, lngLo, lngHi := -90.0, 90.0, -180.0, 180.0
+	out := make([]byte, 0, locationCellPrecision)
+	bits, ch := 0, 0
+	for even := true; len(out) < locationCellPrecision; even = !even {
+		ch <<= 1
+		if even {
+			if mid := (lngLo + lngHi) / 2; lng >= mid {
+				ch |= 1
+				lngLo = mid
+			} else {
+				lngHi = mid
+			}
+		} else {
+			if mid := (latLo + latHi) / 2; lat >= mid {
+				ch |= 1
+				latLo = mid
+			} else {
+				latHi = mid
+			}
+		}
+		if bits++; bits == 5 {
+			out = append(out, geohashAlphabet[ch])
+			bits, ch = 0, 0
+		}
+	}
+	return string(out)
+}
+
+// publicLocation blurs a trainer's location; nil unless they opted in and
+// have one.
+func publicLocation(allow bool, lat, lng *float64) *PublicLocation {
+	if !allow || lat == nil || lng == nil {
+		return nil
+	}
+	return &PublicLocation{
+		Latitude:  blurCoordinate(*lat, 90),
+		Longitude: blurCoordinate(*lng, 180),
+		Cell:      locationCell(*lat, *lng),
+	}
+}
@@ -107,12 +107,36 @@ func newApp() *fiber.App {
 	protectedLimiter := newRateLimiter()
 	// Canonical paths.
 	app.Get("/api/users/:user_id/overview", verifyJWT, protectedLimiter, GetUserOverviewHandler)
+	app.Get("/api/users/:user_id/tags", verifyJWT, protectedLimiter, GetUserTagsHandler)
+	app.Get("/api/users/:user_id/notifications", verifyJWT, protectedLimiter, GetNotificationsHandler)
+	app.Post("/api/users/:user_id/notifications/read", verifyJWT, protectedLimiter, MarkNotificationsReadHandler)
+	app.Post("/api/users/:user_id/notifications/dismiss", verifyJWT, protectedLimiter, DismissNotificationsHandler)
+	app.Get("/api/users/:user_id/notification-preferences", verifyJWT, protectedLimiter, GetNotificationPreferencesHandler)
+	app.Put("/api/users/:user_id/notification-preferences", verifyJWT, protectedLimiter, UpdateNotificationPreferencesHandler)
+	app.Get("/api/users/:user_id/saved-searches", verifyJWT, protectedLimiter, ListSavedSearchesHandler)
+	app.Post("/api/users/:user_id/saved-searches", verifyJWT, protectedLimiter, CreateSavedSearchHandler)
+	app.Put("/api/users/:user_id/saved-searches/:search_id", verifyJWT, protectedLimiter, UpdateSavedSearchHandler)
+	app.Delete("/api/users/:user_id/saved-searches/:search_id", verifyJWT, protectedLimiter, DeleteSavedSearchHandler)
 	app.Put("/api/users/:user_id", verifyJWT, protectedLimiter, UpdateUserHandler)
 	// Compatibility paths for current frontend/nginx behavior.
 	app.Get("/api/:user_id/overview", verifyJWT, protectedLimiter, GetUserOverviewHandler)
+	app.Get("/api/:user_id/tags", verifyJWT, protectedLimiter, GetUserTagsHandler)
+	app.Get("/api/:user_id/notifications", verifyJWT, protectedLimiter, GetNotificationsHandler)
+	app.Post("/api/:user_id/notifications/read", verifyJWT, protectedLimiter, MarkNotificationsReadHandler)
+	app.Post("/api/:user_id/notifications/dismiss", verifyJWT, protectedLimiter, DismissNotificationsHandler)
+	app.Get("/api/:user_id/notification-preferences", verifyJWT, protectedLimiter, GetNotificationPreferencesHandler)
+	app.Put("/api/:user_id/notification-preferences", verifyJWT, protectedLimiter, UpdateNotificationPreferencesHandler)
+	app.Get("/api/:user_id/saved-searches", verifyJWT, protectedLimiter, ListSavedSearchesHandler)
+	app.Post("/api/:user_id/saved-searches", verifyJWT, protectedLimiter, CreateSavedSearchHandler)
+	app.Put("/api/:user_id/saved-searches/:search_id", verifyJWT, protectedLimiter, UpdateSavedSearchHandler)
+	app.Delete("/api/:user_id/saved-searches/:search_id", verifyJWT, protectedLimiter, DeleteSavedSearchHandler)
 	app.Put("/api/:user_id", verifyJWT, protectedLimiter, UpdateUserHandler)
 	app.Put("/api/update-user/:user_id", verifyJWT, protectedLimiter, UpdateUserHandler)
 	app.Put("/api/users/update-user/:user_id", verifyJWT, protectedLimiter, UpdateUserHandler)
+	app.Get("/api/trades/dust-preview", verifyJWT, protectedLimiter, GetTradeDustPreviewHandler)
+	app.Get("/api/users/trades/dust-preview", verifyJWT, protectedLimiter, GetTradeDustPreviewHandler)
+	app.Get("/api/trades/:trade_id/messages", verifyJWT, protectedLimiter, GetTradeMessagesHandler)
+	app.Get("/api/users/trades/:trade_id/messages", verifyJWT, protectedLimiter, GetTradeMessagesHandler)
 
 	return app
 }
@@ -63,6 +63,9 @@ type User struct {
 	Latitude      *float64 `gorm:"column:latitude"           json:"latitude,omitempty"`
 	Longitude     *float64 `gorm:"column:longitude"          json:"longitude,omitempty"`
 
+	// Opt-in for the online / recently active indicator.
+	SharePresence bool `gorm:"column:share_presence" json:"share_presence"`
+
 	// Highlights
 	Highlight1InstanceID *string `gorm:"column:highlight1_instance_id" json:"highlight1_instance_id,omitempty"`
 	Highlight2InstanceID *string `gorm:"column:highlight2_instance_id" json:"highlight2_instance_id,omitempty"`
@@ -85,6 +88,12 @@ type PublicUser struct {
 	PogoStartedOn *time.Time `json:"pogo_started_on,omitempty"`
 	AppJoinedAt   time.Time  `json:"app_joined_at"`
 
+	// Blurred; see location_privacy.go.
+	Location      *PublicLocation `gorm:"-" json:"location"`
+	AllowLocation bool            `json:"-"`
+	Latitude      *float64        `json:"-"`
+	Longitude     *float64        `json:"-"`
+
 	Highlight1 *string `json:"highlight1_instance_id,omitempty"`
 	Highlight2 *string `json:"highlight2_instance_id,omitempty"`
 	Highlight3 *string `json:"highlight3_instance_id,omitempty"`
@@ -212,6 +221,9 @@ type Trade struct {
 	TradeCompletedDate               *time.Time `gorm:"column:trade_completed_date"`
 	TradeCancelledDate               *time.Time `gorm:"column:trade_cancelled_date"`
 	TradeCancelledBy                 *string    `gorm:"column:trade_cancelled_by" json:"trade_cancelled_by"`
+	TradeExpiredDate                 *time.Time `gorm:"column:trade_expired_date" json:"trade_expired_date"`
+	CounterOfTradeID                 *string    `gorm:"column:counter_of_trade_id" json:"counter_of_trade_id"`
+	TradeCycleID                     *string    `gorm:"column:trade_cycle_id" json:"trade_cycle_id"`
 	IsSpecialTrade                   bool       `gorm:"column:is_special_trade" json:"is_special_trade"`
 	IsRegisteredTrade                bool       `gorm:"column:is_registered_trade" json:"is_registered_trade"`
 	IsLuckyTrade                     bool       `gorm:"column:is_lucky_trade" json:"is_lucky_trade"`
@@ -226,3 +238,125 @@ type Trade struct {
 func (Trade) TableName() string {
 	return "trades"
 }
+
+// TradeItem maps one instance on one side of a multi-Pokemon trade.
+type TradeItem struct {
+	TradeID    string `gorm:"column:trade_id;primaryKey" json:"trade_id"`
+	InstanceID string `gorm:"column:instance_id;primaryKey" json:"instance_id"`
+	Side       string `gorm:"column:side" json:"side"`
+	Position   int    `gorm:"column:position" json:"position"`
+}
+
+func (TradeItem) TableName() string {
+	return "trade_items"
+}
+
+// ---------------- trainer_reputation ----------------
+
+// TrainerReputation is the per-trainer rollup of trade ratings and outcomes
+// maintained by storage. Rates are nil until the trainer has concluded a trade.
+type TrainerReputation struct {
+	UserID           string   `gorm:"column:user_id;primaryKey" json:"-"`
+	RatingAverage    *float64 `gorm:"column:rating_average" json:"rating_average"`
+	RatingCount      int64    `gorm:"column:rating_count" json:"rating_count"`
+	TradesCompleted  int64    `gorm:"column:trades_completed" json:"trades_completed"`
+	TradesCancelled  int64    `gorm:"column:trades_cancelled" json:"trades_cancelled"`
+	CompletionRate   *float64 `gorm:"column:completion_rate" json:"completion_rate"`
+	CancellationRate *float64 `gorm:"column:cancellation_rate" json:"cancellation_rate"`
+}
+
+func (TrainerReputation) TableName() string {
+	return "trainer_reputation"
+}
+
+// ---------------- tags ----------------
+
+// Tag is a user's tag definition. Parent is the bucket (caught, trade or
+// wanted); ParentTagID nests it under another tag of the same bucket.
+type Tag struct {
+	TagID       string     `gorm:"column:tag_id;primaryKey" json:"tag_id"`
+	UserID      string     `gorm:"column:user_id" json:"-"`
+	Parent      string     `gorm:"column:parent" json:"parent"`
+	ParentTagID *string    `gorm:"column:parent_tag_id" json:"parent_tag_id"`
+	Name        string     `gorm:"column:name" json:"name"`
+	Color       *string    `gorm:"column:color" json:"color"`
+	Sort        *int       `gorm:"column:sort" json:"sort"`
+	CreatedAt   *time.Time `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt   *time.Time `gorm:"column:updated_at" json:"updated_at"`
+	DeletedAt   *time.Time `gorm:"column:deleted_at" json:"deleted_at"`
+}
+
+func (Tag) TableName() string {
+	return "tags"
+}
+
+// ---------------- notifications ----------------
+
+// Notification is an inbox row written by storage. The reader only marks
+// rows read or dismissed.
+type Notification struct {
+	NotificationID   uint64     `gorm:"column:notification_id;primaryKey" json:"-"`
+	UserID           string     `gorm:"column:user_id" json:"-"`
+	NotificationType string     `gorm:"column:notification_type" json:"notification_type"`
+	ActorUsername    *string    `gorm:"column:actor_username" json:"actor_username"`
+	TradeID          *string    `gorm:"column:trade_id" json:"trade_id"`
+	InstanceID       *string    `gorm:"column:instance_id" json:"instance_id"`
+	Payload          *string    `gorm:"column:payload" json:"-"`
+	CreatedAt        time.Time  `gorm:"column:created_at" json:"created_at"`
+	ReadAt           *time.Time `gorm:"column:read_at" json:"read_at"`
+	DismissedAt      *time.Time `gorm:"column:dismissed_at" json:"-"`
+}
+
+func (Notification) TableName() string {
+	return "notifications"
+}
+
+// TradeMessage is one chat message between the two sides of a trade.
+// Storage writes it; reader only lists it.
+type TradeMessage struct {
+	MessageID       uint64     `gorm:"column:message_id;primaryKey" json:"-"`
+	TradeID         string     `gorm:"column:trade_id" json:"trade_id"`
+	ClientMessageID string     `gorm:"column:client_message_id" json:"client_message_id"`
+	SenderUserID    string     `gorm:"column:sender_user_id" json:"-"`
+	SenderUsername  string     `gorm:"column:sender_username" json:"sender_username"`
+	RecipientUserID string     `gorm:"column:recipient_user_id" json:"-"`
+	Body            string     `gorm:"column:body" json:"body"`
+	CreatedAt       time.Time  `gorm:"column:created_at" json:"created_at"`
+	ReadAt          *time.Time `gorm:"column:read_at" json:"read_at"`
+	HiddenAt        *time.Time `gorm:"column:hidden_at" json:"-"`
+}
+
+func (TradeMessage) TableName() string {
+	return "trade_messages"
+}
+
+// NotificationPreference switches one notification type off or back on.
+// Types without a row are enabled.
+type NotificationPreference struct {
+	UserID           string `gorm:"column:user_id;primaryKey"`
+	NotificationType string `gorm:"column:notification_type;primaryKey"`
+	Enabled          bool   `gorm:"column:enabled"`
+}
+
+func (NotificationPreference) TableName() string {
+	return "notification_preferences"
+}
+
+// SavedSearch is a /searchPokemon query a trainer keeps. Storage reads it
+// to alert them when a new listing matches.
+type SavedSearch struct {
+	SearchID           uint64     `gorm:"column:search_id;primaryKey;autoIncrement"`
+	UserID             string     `gorm:"column:user_id"`
+	Name               string     `gorm:"column:name"`
+	PokemonID          int        `gorm:"column:pokemon_id"`
+	Params             string     `gorm:"column:params"`
+	Muted              bool       `gorm:"column:muted"`
+	NotifyEveryMinutes int        `gorm:"column:notify_every_minutes"`
+	LastNotifiedAt     *time.Time `gorm:"column:last_notified_at"`
+	CreatedAt          time.Time  `gorm:"column:created_at"`
+	UpdatedAt          time.Time  `gorm:"column:updated_at"`
+}
+
+func (SavedSearch) TableName() string {
+	return "saved_searches"
+}
@@ -0,0 +1,264 @@
+// notifications_handler.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	defaultNotificationsLimit = 50
+	maxNotificationsLimit     = 200
+	// maxNotificationIDs caps one read or dismiss request.
+	maxNotificationIDs = 500
+)
+
+// notificationTypes are the types storage writes, in display order.
+var notificationTypes = []string{
+	"trade_proposed",
+	"trade_accepted",
+	"trade_cancelled",
+	"trade_completed",
+	"trade_rated",
+	"most_wanted_match",
+	"saved_search_match",
+}
+
+/* -------------------------------------------------------------------------- */
+/*  GET /api/users/:user_id/notifications  (protected)                         */
+/* -------------------------------------------------------------------------- */
+
+// GetNotificationsHandler lists the caller's inbox, newest first. Dismissed
+// rows are never listed; unread=true narrows to unread ones. Pages are cut
+// with before=<notification_id> from the previous page's next_before.
+func GetNotificationsHandler(c *fiber.Ctx) error {
+	userID := c.Params("user_id")
+	if tokenID, _ := c.Locals("user_id").(string); tokenID != userID {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "User mismatch"})
+	}
+
+	limit := defaultNotificationsLimit
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "limit must be a positive integer"})
+		}
+		if n > maxNotificationsLimit {
+			n = maxNotificationsLimit
+		}
+		limit = n
+	}
+
+	q := db.Where("user_id = ? AND dismissed_at IS NULL", userID)
+	if c.Query("unread") == "true" {
+		q = q.Where("read_at IS NULL")
+	}
+	if raw := c.Query("before"); raw != "" {
+		before, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid before cursor"})
+		}
+		q = q.Where("notification_id < ?", before)
+	}
+
+	var rows []Notification
+	if err := q.Order("notification_id DESC").Limit(limit + 1).Find(&rows).Error; err != nil {
+		logrus.Errorf("Failed to retrieve notifications for user %s: %v", userID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve notifications"})
+	}
+	var nextBefore *string
+	if len(rows) > limit {
+		rows = rows[:limit]
+		cursor := strconv.FormatUint(rows[limit-1].NotificationID, 10)
+		nextBefore = &cursor
+	}
+
+	var unread int64
+	if err := db.Model(&Notification{}).
+		Where("user_id = ? AND dismissed_at IS NULL AND read_at IS NULL", userID).
+		Count(&unread).Error; err != nil {
+		logrus.Errorf("Failed to count unread notifications for user %s: %v", userID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve notifications"})
+	}
+
+	out := make([]fiber.Map, 0, len(rows))
+	for _, n := range rows {
+		data := map[string]interface{}{}
+		if n.Payload != nil && *n.Payload != "" {
+			if err := json.Unmarshal([]byte(*n.Payload), &data); err != nil {
+				logrus.Warnf("Ignoring malformed payload of notification %d: %v", n.NotificationID, err)
+			}
+		}
+		out = append(out, fiber.Map{
+			"notification_id":   strconv.FormatUint(n.NotificationID, 10),
+			"notification_type": n.NotificationType,
+			"actor_username":    n.ActorUsername,
+			"trade_id":          n.TradeID,
+			"instance_id":       n.InstanceID,
+			"data":              data,
+			"created_at":        n.CreatedAt,
+			"read_at":           n.ReadAt,
+		})
+	}
+	return c.JSON(fiber.Map{
+		"notifications": out,
+		"unread_count":  unread,
+		"next_before":   nextBefore,
+	})
+}
+
+// notificationSelection is the body of read and dismiss requests: either
+// explicit ids or all=true for the whole inbox.
+type notificationSelection struct {
+	IDs []string `json:"ids"`
+	All bool     `json:"all"`
+}
+
+var errNotificationSelection = errors.New("provide ids or all=true")
+
+func parseNotificationSelection(c *fiber.Ctx) ([]uint64, bool, error) {
+	var body notificationSelection
+	if err := c.BodyParser(&body); err != nil {
+		return nil, false, errNotificationSelection
+	}
+	if body.All {
+		return nil, true, nil
+	}
+	if len(body.IDs) == 0 || len(body.IDs) > maxNotificationIDs {
+		return nil, false, errNotificationSelection
+	}
+	ids := make([]uint64, 0, len(body.IDs))
+	for _, raw := range body.IDs {
+		id, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return nil, false, errNotificationSelection
+		}
+		ids = append(ids, id)
+	}
+	return ids, false, nil
+}
+
+/* -------------------------------------------------------------------------- */
+/*  POST /api/users/:user_id/notifications/read  (protected)                   */
+/* -------------------------------------------------------------------------- */
+
+// MarkNotificationsReadHandler marks the selected notifications read.
+// Already-read rows keep their original read_at.
+func MarkNotificationsReadHandler(c *fiber.Ctx) error {
+	return updateNotifications(c, "read_at IS NULL", func(now time.Time) map[string]interface{} {
+		return map[string]interface{}{"read_at": now}
+	})
+}
+
+/* -------------------------------------------------------------------------- */
+/*  POST /api/users/:user_id/notifications/dismiss  (protected)                */
+/* -------------------------------------------------------------------------- */
+
+// DismissNotificationsHandler removes the selected notifications from the
+// inbox. Dismissing also marks them read, so they leave the unread count.
+func DismissNotificationsHandler(c *fiber.Ctx) error {
+	return updateNotifications(c, "dismissed_at IS NULL", func(now time.Time) map[string]interface{} {
+		return map[string]interface{}{
+			"dismissed_at": now,
+			"read_at":      gorm.Expr("COALESCE(read_at, ?)", now),
+		}
+	})
+}
+
+func updateNotifications(c *fiber.Ctx, pending string, changes func(time.Time) map[string]interface{}) error {
+	userID := c.Params("user_id")
+	if tokenID, _ := c.Locals("user_id").(string); tokenID != userID {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "User mismatch"})
+	}
+
+	ids, all, err := parseNotificationSelection(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	q := db.Model(&Notification{}).Where("user_id = ? AND "+pending, userID)
+	if !all {
+		q = q.Where("notification_id IN ?", ids)
+	}
+	res := q.Updates(changes(time.Now().UTC()))
+	if res.Error != nil {
+		logrus.Errorf("Failed to update notifications for user %s: %v", userID, res.Error)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update notifications"})
+	}
+	return c.JSON(fiber.Map{"updated": res.RowsAffected})
+}
+
+/* -------------------------------------------------------------------------- */
+/*  GET|PUT /api/users/:user_id/notification-preferences  (protected)          */
+/* -------------------------------------------------------------------------- */
+
+// GetNotificationPreferencesHandler returns every notification type with
+// whether the caller receives it.
+func GetNotificationPreferencesHandler(c *fiber.Ctx) error {
+	userID := c.Params("user_id")
+	if tokenID, _ := c.Locals("user_id").(string); tokenID != userID {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "User mismatch"})
+	}
+	return respondNotificationPreferences(c, userID)
+}
+
+// UpdateNotificationPreferencesHandler stores the types in the request body,
+// e.g. {"preferences": {"trade_rated": false}}. Types not mentioned keep
+// their current setting.
+func UpdateNotificationPreferencesHandler(c *fiber.Ctx) error {
+	userID := c.Params("user_id")
+	if tokenID, _ := c.Locals("user_id").(string); tokenID != userID {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "User mismatch"})
+	}
+
+	var body struct {
+		Preferences map[string]bool `json:"preferences"`
+	}
+	if err := c.BodyParser(&body); err != nil || len(body.Preferences) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "preferences are required"})
+	}
+
+	rows := make([]NotificationPreference, 0, len(body.Preferences))
+	for _, t := range notificationTypes {
+		if enabled, ok := body.Preferences[t]; ok {
+			rows = append(rows, NotificationPreference{UserID: userID, NotificationType: t, Enabled: enabled})
+		}
+	}
+	if len(rows) != len(body.Preferences) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Unknown notification type"})
+	}
+
+	if err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "notification_type"}},
+		DoUpdates: clause.AssignmentColumns([]string{"enabled"}),
+	}).Create(&rows).Error; err != nil {
+		logrus.Errorf("Failed to store notification preferences for user %s: %v", userID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update notification preferences"})
+	}
+	return respondNotificationPreferences(c, userID)
+}
+
+func respondNotificationPreferences(c *fiber.Ctx, userID string) error {
+	var rows []NotificationPreference
+	if err := db.Where("user_id = ?", userID).Find(&rows).Error; err != nil {
+		logrus.Errorf("Failed to retrieve notification preferences for user %s: %v", userID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve notification preferences"})
+	}
+	prefs := make(fiber.Map, len(notificationTypes))
+	for _, t := range notificationTypes {
+		prefs[t] = true
+	}
+	for _, row := range rows {
+		if _, known := prefs[row.NotificationType]; known {
+			prefs[row.NotificationType] = row.Enabled
+		}
+	}
+	return c.JSON(fiber.Map{"preferences": prefs})
+}
@@ -5,6 +5,7 @@ import (
 	"strings"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
 )
 
 // GET /api/public/users/:username
@@ -16,7 +17,7 @@ func GetPublicSnapshotByUsername(c *fiber.Ctx) error {
 	if err := db.
 		Table("users").
 		Select(`user_id, username, pokemon_go_name, team, trainer_level, total_xp,
-		        pogo_started_on, app_joined_at,
+		        pogo_started_on, app_joined_at, allow_location, latitude, longitude,
 		        highlight1_instance_id  AS highlight1,
 		        highlight2_instance_id  AS highlight2,
 		        highlight3_instance_id  AS highlight3,
@@ -28,6 +29,8 @@ func GetPublicSnapshotByUsername(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "User not found"})
 	}
 
+	u.Location = publicLocation(u.AllowLocation, u.Latitude, u.Longitude)
+
 	// fetch that trainer's instances only
 	var inst []PokemonInstance
 	if err := db.Where("user_id = ?", u.UserID).Find(&inst).Error; err != nil {
@@ -39,8 +42,16 @@ func GetPublicSnapshotByUsername(c *fiber.Ctx) error {
 		out[in.InstanceID] = instanceToMap(in)
 	}
 
+	// Reputation is informational; a trainer without one (or a failed
+	// lookup) gets the empty rollup rather than a failed snapshot.
+	var reputation TrainerReputation
+	if err := db.Where("user_id = ?", u.UserID).Limit(1).Find(&reputation).Error; err != nil {
+		logrus.Warnf("Failed to load reputation for user %s: %v", u.UserID, err)
+	}
+
 	return c.JSON(fiber.Map{
-		"user":      u,
-		"instances": out,
+		"user":       u,
+		"instances":  out,
+		"reputation": reputation,
 	})
 }
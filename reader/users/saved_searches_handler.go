@@ -0,0 +1,348 @@
+// saved_searches_handler.go
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const (
+	maxSavedSearches          = 20
+	maxSavedSearchName        = 100
+	defaultNotifyEveryMinutes = 60
+	minNotifyEveryMinutes     = 5
+	maxNotifyEveryMinutes     = 7 * 24 * 60
+)
+
+// savedSearchParamKinds lists the /searchPokemon parameters a saved search
+// keeps, by how they are checked. cursor only pages a live search and is
+// not kept; saved searches are for one species, so pokemon_id takes no
+// list and size (which needs the species catalog) is not kept either.
+var savedSearchParamKinds = map[string]string{
+	"pokemon_id":           "int",
+	"costume_id":           "costume",
+	"fast_move_id":         "ids",
+	"charged_move_ids":     "ids",
+	"charged_move_1_id":    "int",
+	"charged_move_2_id":    "int",
+	"attack_iv":            "int",
+	"defense_iv":           "int",
+	"stamina_iv":           "int",
+	"background_id":        "int",
+	"friendship_level":     "int",
+	"limit":                "int",
+	"min_attack_iv":        "int",
+	"min_defense_iv":       "int",
+	"min_stamina_iv":       "int",
+	"min_cp":               "int",
+	"max_cp":               "int",
+	"min_iv_percent":       "float",
+	"max_iv_percent":       "float",
+	"min_level":            "float",
+	"max_level":            "float",
+	"caught_from":          "date",
+	"caught_to":            "date",
+	"lucky":                "bool",
+	"purified":             "bool",
+	"traded":               "bool",
+	"shiny":                "bool",
+	"shadow":               "bool",
+	"dynamax":              "bool",
+	"gigantamax":           "bool",
+	"already_registered":   "bool",
+	"pref_lucky":           "bool",
+	"only_matching_trades": "bool",
+	"trade_in_wanted_list": "bool",
+	"latitude":             "float",
+	"longitude":            "float",
+	"range_km":             "float",
+	"ownership":            "enum",
+	"gender":               "enum",
+	"sort":                 "enum",
+}
+
+var savedSearchParamEnums = map[string][]string{
+	"ownership": {"trade", "caught", "wanted"},
+	"gender":    {"Male", "Female", "Any", "Genderless"},
+	"sort":      {"distance", "iv_total", "cp", "updated", "reputation"},
+}
+
+// normalizeSavedSearchParams checks params the way /searchPokemon would
+// and returns them as the query strings it takes, with the pokemon_id the
+// search is for. Null values are dropped.
+func normalizeSavedSearchParams(params map[string]interface{}) (map[string]string, int, error) {
+	out := make(map[string]string, len(params))
+	for key, raw := range params {
+		kind, ok := savedSearchParamKinds[key]
+		if !ok {
+			return nil, 0, fmt.Errorf("unknown search parameter %q", key)
+		}
+		if raw == nil {
+			continue
+		}
+		var value string
+		switch v := raw.(type) {
+		case string:
+			value = strings.TrimSpace(v)
+		case float64:
+			value = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			value = strconv.FormatBool(v)
+		default:
+			return nil, 0, fmt.Errorf("invalid %s", key)
+		}
+		if value == "" || value == "null" {
+			continue
+		}
+
+		switch kind {
+		case "int":
+			if _, err := strconv.Atoi(value); err != nil {
+				return nil, 0, fmt.Errorf("invalid %s", key)
+			}
+		case "ids", "costume":
+			parts := strings.Split(value, ",")
+			for i, p := range parts {
+				parts[i] = strings.TrimSpace(p)
+				if kind == "costume" && (parts[i] == "null" || (parts[i] == "any" && len(parts) == 1)) {
+					continue
+				}
+				if _, err := strconv.Atoi(parts[i]); err != nil {
+					return nil, 0, fmt.Errorf("invalid %s", key)
+				}
+			}
+			value = strings.Join(parts, ",")
+		case "date":
+			if _, err := time.Parse("2006-01-02", value); err != nil {
+				return nil, 0, fmt.Errorf("invalid %s", key)
+			}
+		case "bool":
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, 0, fmt.Errorf("invalid %s", key)
+			}
+			value = strconv.FormatBool(b)
+		case "float":
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+				return nil, 0, fmt.Errorf("invalid %s", key)
+			}
+		case "enum":
+			valid := false
+			for _, allowed := range savedSearchParamEnums[key] {
+				if value == allowed {
+					valid = true
+					break
+				}
+			}
+			if !valid {
+				return nil, 0, fmt.Errorf("invalid %s", key)
+			}
+		}
+		out[key] = value
+	}
+
+	pokemonID, err := strconv.Atoi(out["pokemon_id"])
+	if err != nil || pokemonID <= 0 {
+		return nil, 0, errors.New("pokemon_id is required")
+	}
+	_, hasLat := out["latitude"]
+	_, hasLng := out["longitude"]
+	if hasLat != hasLng {
+		return nil, 0, errors.New("latitude and longitude go together")
+	}
+	if hasLat {
+		lat, _ := strconv.ParseFloat(out["latitude"], 64)
+		lng, _ := strconv.ParseFloat(out["longitude"], 64)
+		if lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+			return nil, 0, errors.New("latitude or longitude out of range")
+		}
+	}
+	if r, ok := out["range_km"]; ok {
+		if km, _ := strconv.ParseFloat(r, 64); km <= 0 {
+			return nil, 0, errors.New("range_km must be positive")
+		}
+	}
+	return out, pokemonID, nil
+}
+
+// savedSearchBody is the body of create and update requests. Fields left
+// out of an update keep their value.
+type savedSearchBody struct {
+	Name               *string                `json:"name"`
+	Params             map[string]interface{} `json:"params"`
+	Muted              *bool                  `json:"muted"`
+	NotifyEveryMinutes *int                   `json:"notify_every_minutes"`
+}
+
+// apply copies the body onto s, checking each field it sets.
+func (b savedSearchBody) apply(s *SavedSearch) error {
+	if b.Name != nil {
+		name := strings.TrimSpace(*b.Name)
+		if name == "" || len([]rune(name)) > maxSavedSearchName {
+			return fmt.Errorf("name must be 1-%d characters", maxSavedSearchName)
+		}
+		s.Name = name
+	}
+	if b.Params != nil {
+		params, pokemonID, err := normalizeSavedSearchParams(b.Params)
+		if err != nil {
+			return err
+		}
+		raw, _ := json.Marshal(params)
+		s.Params = string(raw)
+		s.PokemonID = pokemonID
+	}
+	if b.Muted != nil {
+		s.Muted = *b.Muted
+	}
+	if b.NotifyEveryMinutes != nil {
+		if n := *b.NotifyEveryMinutes; n < minNotifyEveryMinutes || n > maxNotifyEveryMinutes {
+			return fmt.Errorf("notify_every_minutes must be %d-%d", minNotifyEveryMinutes, maxNotifyEveryMinutes)
+		}
+		s.NotifyEveryMinutes = *b.NotifyEveryMinutes
+	}
+	return nil
+}
+
+func savedSearchPayload(s SavedSearch) fiber.Map {
+	params := map[string]string{}
+	if err := json.Unmarshal([]byte(s.Params), &params); err != nil {
+		logrus.Warnf("Ignoring malformed params of saved search %d: %v", s.SearchID, err)
+	}
+	return fiber.Map{
+		"search_id":            strconv.FormatUint(s.SearchID, 10),
+		"name":                 s.Name,
+		"params":               params,
+		"muted":                s.Muted,
+		"notify_every_minutes": s.NotifyEveryMinutes,
+		"last_notified_at":     s.LastNotifiedAt,
+		"created_at":           s.CreatedAt,
+		"updated_at":           s.UpdatedAt,
+	}
+}
+
+/* -------------------------------------------------------------------------- */
+/*  GET|POST /api/users/:user_id/saved-searches  (protected)                   */
+/* -------------------------------------------------------------------------- */
+
+// ListSavedSearchesHandler lists the caller's saved searches, oldest first.
+func ListSavedSearchesHandler(c *fiber.Ctx) error {
+	userID := c.Params("user_id")
+	if tokenID, _ := c.Locals("user_id").(string); tokenID != userID {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "User mismatch"})
+	}
+
+	var rows []SavedSearch
+	if err := db.Where("user_id = ?", userID).Order("search_id").Find(&rows).Error; err != nil {
+		logrus.Errorf("Failed to retrieve saved searches for user %s: %v", userID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve saved searches"})
+	}
+	out := make([]fiber.Map, 0, len(rows))
+	for _, s := range rows {
+		out = append(out, savedSearchPayload(s))
+	}
+	return c.JSON(fiber.Map{"saved_searches": out})
+}
+
+// CreateSavedSearchHandler saves a search. name and params are required;
+// alerts default to on, at most once an hour.
+func CreateSavedSearchHandler(c *fiber.Ctx) error {
+	userID := c.Params("user_id")
+	if tokenID, _ := c.Locals("user_id").(string); tokenID != userID {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "User mismatch"})
+	}
+
+	var body savedSearchBody
+	if err := c.BodyParser(&body); err != nil || body.Name == nil || body.Params == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "name and params are required"})
+	}
+	s := SavedSearch{UserID: userID, NotifyEveryMinutes: defaultNotifyEveryMinutes}
+	if err := body.apply(&s); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var count int64
+	if err := db.Model(&SavedSearch{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+		logrus.Errorf("Failed to count saved searches for user %s: %v", userID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to save search"})
+	}
+	if count >= maxSavedSearches {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": fmt.Sprintf("At most %d saved searches", maxSavedSearches)})
+	}
+	if err := db.Create(&s).Error; err != nil {
+		logrus.Errorf("Failed to save search for user %s: %v", userID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to save search"})
+	}
+	return c.Status(fiber.StatusCreated).JSON(savedSearchPayload(s))
+}
+
+/* -------------------------------------------------------------------------- */
+/*  PUT|DELETE /api/users/:user_id/saved-searches/:search_id  (protected)      */
+/* -------------------------------------------------------------------------- */
+
+// UpdateSavedSearchHandler edits a saved search, e.g. {"muted": true}.
+// Changing params does not reset the alert throttle.
+func UpdateSavedSearchHandler(c *fiber.Ctx) error {
+	userID := c.Params("user_id")
+	if tokenID, _ := c.Locals("user_id").(string); tokenID != userID {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "User mismatch"})
+	}
+	searchID, err := strconv.ParseUint(c.Params("search_id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Saved search not found"})
+	}
+
+	var body savedSearchBody
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid body"})
+	}
+
+	var s SavedSearch
+	if err := db.Where("search_id = ? AND user_id = ?", searchID, userID).First(&s).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Saved search not found"})
+		}
+		logrus.Errorf("Failed to load saved search %d: %v", searchID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update saved search"})
+	}
+	if err := body.apply(&s); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := db.Model(&s).Select("name", "pokemon_id", "params", "muted", "notify_every_minutes").Updates(&s).Error; err != nil {
+		logrus.Errorf("Failed to update saved search %d: %v", searchID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update saved search"})
+	}
+	return c.JSON(savedSearchPayload(s))
+}
+
+// DeleteSavedSearchHandler removes a saved search and its alerts.
+func DeleteSavedSearchHandler(c *fiber.Ctx) error {
+	userID := c.Params("user_id")
+	if tokenID, _ := c.Locals("user_id").(string); tokenID != userID {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "User mismatch"})
+	}
+	searchID, err := strconv.ParseUint(c.Params("search_id"), 10, 64)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Saved search not found"})
+	}
+
+	res := db.Where("search_id = ? AND user_id = ?", searchID, userID).Delete(&SavedSearch{})
+	if res.Error != nil {
+		logrus.Errorf("Failed to delete saved search %d: %v", searchID, res.Error)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete saved search"})
+	}
+	if res.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Saved search not found"})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
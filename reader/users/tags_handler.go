@@ -0,0 +1,66 @@
+// tags_handler.go
+package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+)
+
+/* -------------------------------------------------------------------------- */
+/*  GET /api/users/:user_id/tags  (protected)                                  */
+/* -------------------------------------------------------------------------- */
+
+// GetUserTagsHandler lists the caller's tag definitions with how many
+// instances carry each tag. Soft-deleted tags are omitted unless
+// include_deleted=true, which devices use to catch up on deletions.
+func GetUserTagsHandler(c *fiber.Ctx) error {
+	userID := c.Params("user_id")
+
+	if tokenID, _ := c.Locals("user_id").(string); tokenID != userID {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "User mismatch"})
+	}
+
+	q := db.Where("user_id = ?", userID)
+	if c.Query("include_deleted") != "true" {
+		q = q.Where("deleted_at IS NULL")
+	}
+	var tags []Tag
+	if err := q.Order("parent, sort, name").Find(&tags).Error; err != nil {
+		logrus.Errorf("Failed to retrieve tags for user %s: %v", userID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve tags"})
+	}
+
+	var counts []struct {
+		TagID string
+		Count int64
+	}
+	if err := db.Table("instance_tags").
+		Select("tag_id, COUNT(*) AS count").
+		Where("user_id = ?", userID).
+		Group("tag_id").
+		Scan(&counts).Error; err != nil {
+		logrus.Errorf("Failed to count tagged instances for user %s: %v", userID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve tags"})
+	}
+	countByTag := make(map[string]int64, len(counts))
+	for _, row := range counts {
+		countByTag[row.TagID] = row.Count
+	}
+
+	out := make([]fiber.Map, 0, len(tags))
+	for _, t := range tags {
+		out = append(out, fiber.Map{
+			"tag_id":         t.TagID,
+			"parent":         t.Parent,
+			"parent_tag_id":  t.ParentTagID,
+			"name":           t.Name,
+			"color":          t.Color,
+			"sort":           t.Sort,
+			"created_at":     t.CreatedAt,
+			"updated_at":     t.UpdatedAt,
+			"deleted_at":     t.DeletedAt,
+			"instance_count": countByTag[t.TagID],
+		})
+	}
+	return c.JSON(fiber.Map{"tags": out})
+}
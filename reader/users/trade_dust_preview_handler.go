@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// tradeDustCosts is one friendship level's row of trade_dust_costs: stardust
+// per in-game trade. Storage writes the table from the costs it prices
+// stored trades with, so previews and stored trades cannot disagree.
+type tradeDustCosts struct {
+	FriendshipLevel string `gorm:"column:friendship_level"`
+	Regular         int    `gorm:"column:regular"`
+	SpecialOrNew    int    `gorm:"column:special_or_new"`
+	SpecialAndNew   int    `gorm:"column:special_and_new"`
+}
+
+func (tradeDustCosts) TableName() string {
+	return "trade_dust_costs"
+}
+
+// cost prices one in-game trade.
+func (c tradeDustCosts) cost(special, newEntry bool) int {
+	switch {
+	case special && newEntry:
+		return c.SpecialAndNew
+	case special || newEntry:
+		return c.SpecialOrNew
+	default:
+		return c.Regular
+	}
+}
+
+// speciesRarityCache holds species rarity from the pokemon data service,
+// fetched lazily on the first preview and refreshed after it goes stale.
+// The fetch never runs under mu, and only one runs at a time.
+type speciesRarityCache struct {
+	mu         sync.Mutex
+	rarity     map[int]string
+	loadedAt   time.Time
+	refreshing bool
+}
+
+var rarityCache = &speciesRarityCache{}
+
+// Package var so tests can stub the catalog without an HTTP server.
+var fetchSpeciesRarityFn = fetchSpeciesRarity
+
+func pokemonAPIURL() string {
+	if v := strings.TrimSpace(os.Getenv("POKEMON_API_URL")); v != "" {
+		return strings.TrimRight(v, "/")
+	}
+	return "http://pokemon_data_container:3001"
+}
+
+func fetchSpeciesRarity() (map[int]string, error) {
+	url := pokemonAPIURL() + "/pokemon/pokemons"
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: status %d", url, resp.StatusCode)
+	}
+
+	var rows []struct {
+		PokemonID int     `json:"pokemon_id"`
+		Rarity    *string `json:"rarity"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&rows); err != nil {
+		return nil, err
+	}
+	out := make(map[int]string, len(rows))
+	for _, row := range rows {
+		if row.PokemonID != 0 && row.Rarity != nil {
+			out[row.PokemonID] = strings.ToLower(strings.TrimSpace(*row.Rarity))
+		}
+	}
+	return out, nil
+}
+
+// snapshot returns the cached rarity map. A stale map is served while it is
+// refreshed in the background; only the very first load is waited for, and
+// previews that arrive during it go without the catalog. A failed refresh
+// keeps serving the previous map; ok is false only when none exists.
+func (c *speciesRarityCache) snapshot() (map[int]string, bool) {
+	ttl := time.Duration(readEnvInt("CATALOG_REFRESH_MINUTES", 360)) * time.Minute
+
+	c.mu.Lock()
+	rarity := c.rarity
+	if (rarity != nil && time.Since(c.loadedAt) < ttl) || c.refreshing {
+		c.mu.Unlock()
+		return rarity, rarity != nil
+	}
+	c.refreshing = true
+	c.mu.Unlock()
+
+	if rarity != nil {
+		go c.refresh()
+		return rarity, true
+	}
+	c.refresh()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rarity, c.rarity != nil
+}
+
+// refresh fetches the catalog outside the lock and stores it.
+func (c *speciesRarityCache) refresh() {
+	rarity, err := fetchSpeciesRarityFn()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refreshing = false
+	if err != nil {
+		logrus.Warnf("Failed to refresh species catalog: %v", err)
+		return
+	}
+	c.rarity = rarity
+	c.loadedAt = time.Now()
+}
+
+func isSpecialRarity(rarity string) bool {
+	return strings.Contains(rarity, "legendary") ||
+		strings.Contains(rarity, "mythic") ||
+		strings.Contains(rarity, "ultra beast")
+}
+
+// speciesKey is the pokedex prefix of a variant id ("0001-default" -> "0001").
+func speciesKey(variantID string) string {
+	variantID = strings.TrimSpace(variantID)
+	if i := strings.Index(variantID, "-"); i >= 0 {
+		return variantID[:i]
+	}
+	return variantID
+}
+
+func splitIDList(raw string) []string {
+	seen := map[string]struct{}{}
+	out := []string{}
+	for _, part := range strings.Split(raw, ",") {
+		id := strings.TrimSpace(part)
+		if id == "" {
+			continue
+		}
+		if _, dup := seen[id]; dup {
+			continue
+		}
+		seen[id] = struct{}{}
+		out = append(out, id)
+	}
+	return out
+}
+
+// GET /api/trades/dust-preview?proposed=<ids>&accepting=<ids>&friendship_level=<level>
+//
+// Prices a prospective trade the way storage will once it is proposed, so the
+// UI can show the cost up front. Ids are comma separated; bundles are paired
+// by position and every pair is one in-game trade.
+func GetTradeDustPreviewHandler(c *fiber.Ctx) error {
+	proposedIDs := splitIDList(c.Query("proposed"))
+	acceptingIDs := splitIDList(c.Query("accepting"))
+	if len(proposedIDs) == 0 || len(acceptingIDs) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "proposed and accepting instance ids are required"})
+	}
+	if len(proposedIDs)+len(acceptingIDs) > 50 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "too many instances"})
+	}
+
+	level := strings.TrimSpace(c.Query("friendship_level"))
+	if level == "" {
+		level = "Good"
+	}
+	var costs tradeDustCosts
+	if err := db.Where("friendship_level = ?", level).Take(&costs).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "friendship_level must be one of Good, Great, Ultra, Best"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve trade dust costs"})
+	}
+
+	ids := append(append([]string{}, proposedIDs...), acceptingIDs...)
+	var instances []PokemonInstance
+	if err := db.Where("instance_id IN ?", ids).Find(&instances).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve instances"})
+	}
+	byID := make(map[string]PokemonInstance, len(instances))
+	for _, in := range instances {
+		byID[in.InstanceID] = in
+	}
+	for _, id := range ids {
+		if _, ok := byID[id]; !ok {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Instance not found", "instance_id": id})
+		}
+	}
+
+	// Each side receives the other's Pokemon; owners come from the instances.
+	proposerID := byID[proposedIDs[0]].UserID
+	accepterID := byID[acceptingIDs[0]].UserID
+
+	species := make([]string, 0, len(instances))
+	for _, in := range instances {
+		if key := speciesKey(derefString(in.VariantID)); key != "" {
+			species = append(species, key)
+		}
+	}
+	var regs []Registration
+	if err := db.Where("user_id IN ? AND SUBSTRING_INDEX(variant_id, '-', 1) IN ?",
+		[]string{proposerID, accepterID}, species).Find(&regs).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve registrations"})
+	}
+	registered := map[string]map[string]bool{proposerID: {}, accepterID: {}}
+	for _, r := range regs {
+		if registered[r.UserID] != nil {
+			registered[r.UserID][speciesKey(r.VariantID)] = true
+		}
+	}
+
+	rarity, catalogAvailable := rarityCache.snapshot()
+	special := func(in PokemonInstance) bool {
+		return in.Shiny || isSpecialRarity(rarity[in.PokemonID])
+	}
+	newEntry := func(in PokemonInstance, receiverID string) bool {
+		return !registered[receiverID][speciesKey(derefString(in.VariantID))]
+	}
+
+	n := len(proposedIDs)
+	if len(acceptingIDs) > n {
+		n = len(acceptingIDs)
+	}
+	total := 0
+	isSpecialTrade := false
+	isRegisteredTrade := true
+	pairs := make([]fiber.Map, 0, n)
+	for i := 0; i < n; i++ {
+		pair := fiber.Map{}
+		pairSpecial, pairNew := false, false
+		if i < len(proposedIDs) {
+			in := byID[proposedIDs[i]]
+			pair["pokemon_instance_id_user_proposed"] = in.InstanceID
+			pairSpecial = pairSpecial || special(in)
+			pairNew = pairNew || newEntry(in, accepterID)
+		}
+		if i < len(acceptingIDs) {
+			in := byID[acceptingIDs[i]]
+			pair["pokemon_instance_id_user_accepting"] = in.InstanceID
+			pairSpecial = pairSpecial || special(in)
+			pairNew = pairNew || newEntry(in, proposerID)
+		}
+		cost := costs.cost(pairSpecial, pairNew)
+		pair["is_special_trade"] = pairSpecial
+		pair["is_new_entry"] = pairNew
+		pair["trade_dust_cost"] = cost
+		pairs = append(pairs, pair)
+
+		total += cost
+		isSpecialTrade = isSpecialTrade || pairSpecial
+		isRegisteredTrade = isRegisteredTrade && !pairNew
+	}
+
+	return c.JSON(fiber.Map{
+		"trade_friendship_level": level,
+		"trade_dust_cost":        total,
+		"is_special_trade":       isSpecialTrade,
+		"is_registered_trade":    isRegisteredTrade,
+		"pairs":                  pairs,
+		// False when legendary/mythical status could not be checked.
+		"catalog_available": catalogAvailable,
+	})
+}
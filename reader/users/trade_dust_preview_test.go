@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSpeciesRarityCache_RefreshesOutsideTheLock(t *testing.T) {
+	release := make(chan struct{})
+	prevFetch := fetchSpeciesRarityFn
+	fetchSpeciesRarityFn = func() (map[int]string, error) {
+		<-release
+		return map[int]string{150: "legendary"}, nil
+	}
+	defer func() { fetchSpeciesRarityFn = prevFetch }()
+
+	cache := &speciesRarityCache{rarity: map[int]string{1: "common"}, loadedAt: time.Now().Add(-1000 * time.Hour)}
+	done := make(chan map[int]string, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			rarity, _ := cache.snapshot()
+			done <- rarity
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case rarity := <-done:
+			if rarity[1] != "common" {
+				t.Fatalf("expected the stale catalog while refreshing, got %v", rarity)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected previews not to wait for the refresh")
+		}
+	}
+
+	close(release)
+	deadline := time.Now().Add(time.Second)
+	for {
+		if rarity, _ := cache.snapshot(); rarity[150] == "legendary" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the refreshed catalog to be served")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
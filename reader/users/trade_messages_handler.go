@@ -0,0 +1,113 @@
+// trade_messages_handler.go
+package main
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultTradeMessagesLimit = 50
+	maxTradeMessagesLimit     = 100
+)
+
+/* -------------------------------------------------------------------------- */
+/*  GET /api/trades/:trade_id/messages  (protected)                            */
+/* -------------------------------------------------------------------------- */
+
+// GetTradeMessagesHandler lists a trade's chat, newest first, to either side
+// of the trade. Messages hidden by moderation are left out. Pages are cut
+// with before=<message_id> from the previous page's next_before; unread_count
+// is the caller's unread messages in this trade.
+func GetTradeMessagesHandler(c *fiber.Ctx) error {
+	tradeID := c.Params("trade_id")
+	userID, _ := c.Locals("user_id").(string)
+	username, _ := c.Locals("username").(string)
+
+	limit := defaultTradeMessagesLimit
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "limit must be a positive integer"})
+		}
+		if n > maxTradeMessagesLimit {
+			n = maxTradeMessagesLimit
+		}
+		limit = n
+	}
+	var before uint64
+	if raw := c.Query("before"); raw != "" {
+		n, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid before cursor"})
+		}
+		before = n
+	}
+
+	var trade Trade
+	if err := db.Where("trade_id = ?", tradeID).First(&trade).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Trade not found"})
+		}
+		logrus.Errorf("Failed to load trade %s: %v", tradeID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve messages"})
+	}
+	if !isTradeParty(trade, userID, username) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Not a party to this trade"})
+	}
+
+	q := db.Where("trade_id = ? AND hidden_at IS NULL", tradeID)
+	if before > 0 {
+		q = q.Where("message_id < ?", before)
+	}
+	var rows []TradeMessage
+	if err := q.Order("message_id DESC").Limit(limit + 1).Find(&rows).Error; err != nil {
+		logrus.Errorf("Failed to retrieve messages for trade %s: %v", tradeID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve messages"})
+	}
+	var nextBefore *string
+	if len(rows) > limit {
+		rows = rows[:limit]
+		cursor := strconv.FormatUint(rows[limit-1].MessageID, 10)
+		nextBefore = &cursor
+	}
+
+	var unread int64
+	if err := db.Model(&TradeMessage{}).
+		Where("trade_id = ? AND recipient_user_id = ? AND read_at IS NULL AND hidden_at IS NULL", tradeID, userID).
+		Count(&unread).Error; err != nil {
+		logrus.Errorf("Failed to count unread messages for trade %s: %v", tradeID, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve messages"})
+	}
+
+	out := make([]fiber.Map, 0, len(rows))
+	for _, m := range rows {
+		out = append(out, fiber.Map{
+			"message_id":        strconv.FormatUint(m.MessageID, 10),
+			"trade_id":          m.TradeID,
+			"client_message_id": m.ClientMessageID,
+			"sender_username":   m.SenderUsername,
+			"body":              m.Body,
+			"created_at":        m.CreatedAt,
+			"read_at":           m.ReadAt,
+		})
+	}
+	return c.JSON(fiber.Map{
+		"messages":     out,
+		"unread_count": unread,
+		"next_before":  nextBefore,
+	})
+}
+
+// isTradeParty reports whether the caller is on either side of trade,
+// matching by username for trades stored without user ids.
+func isTradeParty(trade Trade, userID, username string) bool {
+	if userID != "" && (userID == trade.UserIDProposed || userID == trade.UserIDAccepting) {
+		return true
+	}
+	return username != "" && (username == trade.UsernameProposed || username == trade.UsernameAccepting)
+}
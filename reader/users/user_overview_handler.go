@@ -98,6 +98,39 @@ func GetUserOverviewHandler(c *fiber.Ctx) error {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to retrieve trades"})
 	}
 
+	/* ------------------------------------------------------- trade bundles */
+	sides := make(map[string]map[string][]string, len(trades))
+	if len(trades) > 0 {
+		tradeIDs := make([]string, 0, len(trades))
+		for _, t := range trades {
+			tradeIDs = append(tradeIDs, t.TradeID)
+		}
+		var items []TradeItem
+		if err := db.Where("trade_id IN ?", tradeIDs).Order("trade_id, side, position").
+			Find(&items).Error; err != nil {
+			logrus.Errorf("Failed to retrieve trade items for user %s: %v", userID, err)
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to retrieve trades"})
+		}
+		for _, it := range items {
+			if sides[it.TradeID] == nil {
+				sides[it.TradeID] = map[string][]string{}
+			}
+			sides[it.TradeID][it.Side] = append(sides[it.TradeID][it.Side], it.InstanceID)
+		}
+	}
+	// Trades created before bundles only have the single-instance columns.
+	for _, t := range trades {
+		if sides[t.TradeID] == nil {
+			sides[t.TradeID] = map[string][]string{}
+		}
+		if len(sides[t.TradeID]["proposed"]) == 0 && t.PokemonInstanceIDUserProposed != "" {
+			sides[t.TradeID]["proposed"] = []string{t.PokemonInstanceIDUserProposed}
+		}
+		if len(sides[t.TradeID]["accepting"]) == 0 && t.PokemonInstanceIDUserAccepting != "" {
+			sides[t.TradeID]["accepting"] = []string{t.PokemonInstanceIDUserAccepting}
+		}
+	}
+
 	/* --------------------------------------------------------- registrations */
 	var regs []Registration
 	if err := db.Where("user_id = ?", userID).Find(&regs).Error; err != nil {
@@ -121,11 +154,15 @@ func GetUserOverviewHandler(c *fiber.Ctx) error {
 
 	relatedIDs := make(map[string]struct{}, len(trades)*2)
 	for _, t := range trades {
-		if t.UserIDProposed == userID && t.PokemonInstanceIDUserAccepting != "" {
-			relatedIDs[t.PokemonInstanceIDUserAccepting] = struct{}{}
+		if t.UserIDProposed == userID {
+			for _, id := range sides[t.TradeID]["accepting"] {
+				relatedIDs[id] = struct{}{}
+			}
 		}
-		if t.UserIDAccepting == userID && t.PokemonInstanceIDUserProposed != "" {
-			relatedIDs[t.PokemonInstanceIDUserProposed] = struct{}{}
+		if t.UserIDAccepting == userID {
+			for _, id := range sides[t.TradeID]["proposed"] {
+				relatedIDs[id] = struct{}{}
+			}
 		}
 	}
 	var related []PokemonInstance
@@ -154,9 +191,14 @@ func GetUserOverviewHandler(c *fiber.Ctx) error {
 			"trade_proposal_date":                t.TradeProposalDate, "trade_accepted_date": t.TradeAcceptedDate,
 			"trade_completed_date": t.TradeCompletedDate, "trade_cancelled_date": t.TradeCancelledDate,
 			"trade_cancelled_by": t.TradeCancelledBy, "trade_dust_cost": t.TradeDustCost,
-			"trade_friendship_level":    t.TradeFriendshipLevel,
-			"user_1_trade_satisfaction": t.User1TradeSatisfaction,
-			"user_2_trade_satisfaction": t.User2TradeSatisfaction,
+			"trade_expired_date":                  t.TradeExpiredDate,
+			"counter_of_trade_id":                 t.CounterOfTradeID,
+			"trade_cycle_id":                      t.TradeCycleID,
+			"pokemon_instance_ids_user_proposed":  sides[t.TradeID]["proposed"],
+			"pokemon_instance_ids_user_accepting": sides[t.TradeID]["accepting"],
+			"trade_friendship_level":              t.TradeFriendshipLevel,
+			"user_1_trade_satisfaction":           t.User1TradeSatisfaction,
+			"user_2_trade_satisfaction":           t.User2TradeSatisfaction,
 		}
 		if t.LastUpdate != nil {
 			if ut := lastUpdateToTime(*t.LastUpdate); ut.After(latest) {
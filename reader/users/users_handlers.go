@@ -25,6 +25,8 @@ type UpdateUserRequest struct {
 	Latitude      *float64 `json:"latitude"`
 	Longitude     *float64 `json:"longitude"`
 
+	SharePresence *bool `json:"share_presence"`
+
 	// highlights
 	Highlight1 *string `json:"highlight1_instance_id"`
 	Highlight2 *string `json:"highlight2_instance_id"`
@@ -119,6 +121,10 @@ func UpdateUserHandler(c *fiber.Ctx) error {
 		update["longitude"] = req.Longitude
 	}
 
+	if req.SharePresence != nil {
+		update["share_presence"] = *req.SharePresence
+	}
+
 	// highlights
 	if req.Highlight1 != nil {
 		update["highlight1_instance_id"] = req.Highlight1
@@ -13,12 +13,28 @@ import (
 
 const maxUpdatesPerRequest = 5000
 
+// maxTradeMessagesPerRequest caps chat entries (messages, read receipts,
+// reports) per request; storage enforces the per-user rate limits.
+const maxTradeMessagesPerRequest = 20
+
+// maxTradeCycleProposalsPerRequest caps trade cycle proposals per request;
+// each one creates three or four trades.
+const maxTradeCycleProposalsPerRequest = 5
+
 var kafkaProducerFunc = produceToKafka
 
 type BatchedUpdatesRequest struct {
 	Location       map[string]any `json:"location"`
 	PokemonUpdates []any          `json:"pokemonUpdates"`
 	TradeUpdates   []any          `json:"tradeUpdates"`
+	TradeRatings   []any          `json:"tradeRatings"`
+	TagUpdates     []any          `json:"tagUpdates"`
+
+	TradeMessages       []any `json:"tradeMessages"`
+	TradeMessageReads   []any `json:"tradeMessageReads"`
+	TradeMessageReports []any `json:"tradeMessageReports"`
+
+	TradeCycleProposals []any `json:"tradeCycleProposals"`
 }
 
 func handleBatchedUpdates(c *fiber.Ctx) error {
@@ -70,16 +86,59 @@ func handleBatchedUpdates(c *fiber.Ctx) error {
 	if requestData.TradeUpdates == nil {
 		requestData.TradeUpdates = []any{}
 	}
+	if requestData.TradeRatings == nil {
+		requestData.TradeRatings = []any{}
+	}
+	if requestData.TagUpdates == nil {
+		requestData.TagUpdates = []any{}
+	}
+	if requestData.TradeMessages == nil {
+		requestData.TradeMessages = []any{}
+	}
+	if requestData.TradeMessageReads == nil {
+		requestData.TradeMessageReads = []any{}
+	}
+	if requestData.TradeMessageReports == nil {
+		requestData.TradeMessageReports = []any{}
+	}
+	if requestData.TradeCycleProposals == nil {
+		requestData.TradeCycleProposals = []any{}
+	}
 
-	if len(requestData.PokemonUpdates) > maxUpdatesPerRequest || len(requestData.TradeUpdates) > maxUpdatesPerRequest {
+	if len(requestData.PokemonUpdates) > maxUpdatesPerRequest ||
+		len(requestData.TradeUpdates) > maxUpdatesPerRequest ||
+		len(requestData.TradeRatings) > maxUpdatesPerRequest ||
+		len(requestData.TagUpdates) > maxUpdatesPerRequest {
 		logger.WithFields(map[string]interface{}{
 			"trace_id": traceID,
 			"user_id":  userID,
 			"pokemon":  len(requestData.PokemonUpdates),
 			"trade":    len(requestData.TradeUpdates),
+			"ratings":  len(requestData.TradeRatings),
+			"tags":     len(requestData.TagUpdates),
 		}).Warn("Rejected oversized updates batch")
 		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{"message": "Too many updates in a single request"})
 	}
+	if len(requestData.TradeMessages) > maxTradeMessagesPerRequest ||
+		len(requestData.TradeMessageReads) > maxTradeMessagesPerRequest ||
+		len(requestData.TradeMessageReports) > maxTradeMessagesPerRequest {
+		logger.WithFields(map[string]interface{}{
+			"trace_id": traceID,
+			"user_id":  userID,
+			"messages": len(requestData.TradeMessages),
+			"reads":    len(requestData.TradeMessageReads),
+			"reports":  len(requestData.TradeMessageReports),
+		}).Warn("Rejected oversized trade message batch")
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{"message": "Too many trade messages in a single request"})
+	}
+	if len(requestData.TradeCycleProposals) > maxTradeCycleProposalsPerRequest {
+		logger.WithFields(map[string]interface{}{
+			"trace_id": traceID,
+			"user_id":  userID,
+			"cycles":   len(requestData.TradeCycleProposals),
+		}).Warn("Rejected oversized trade cycle batch")
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{"message": "Too many trade cycle proposals in a single request"})
+	}
 
 	// Prepare data to send to Kafka
 	data := map[string]interface{}{
@@ -90,6 +149,14 @@ func handleBatchedUpdates(c *fiber.Ctx) error {
 		"location":       requestData.Location,
 		"pokemonUpdates": requestData.PokemonUpdates,
 		"tradeUpdates":   requestData.TradeUpdates,
+		"tradeRatings":   requestData.TradeRatings,
+		"tagUpdates":     requestData.TagUpdates,
+
+		"tradeMessages":       requestData.TradeMessages,
+		"tradeMessageReads":   requestData.TradeMessageReads,
+		"tradeMessageReports": requestData.TradeMessageReports,
+
+		"tradeCycleProposals": requestData.TradeCycleProposals,
 	}
 
 	message, err := json.Marshal(data)
@@ -59,6 +59,18 @@ func TestHandleBatchedUpdates_Success(t *testing.T) {
 	if got["user_id"] != "user-1" {
 		t.Fatalf("expected user_id=user-1, got %v", got["user_id"])
 	}
+	if ratings, ok := got["tradeRatings"].([]any); !ok || len(ratings) != 0 {
+		t.Fatalf("expected tradeRatings to default to an empty array, got %v", got["tradeRatings"])
+	}
+	if tags, ok := got["tagUpdates"].([]any); !ok || len(tags) != 0 {
+		t.Fatalf("expected tagUpdates to default to an empty array, got %v", got["tagUpdates"])
+	}
+	if msgs, ok := got["tradeMessages"].([]any); !ok || len(msgs) != 0 {
+		t.Fatalf("expected tradeMessages to default to an empty array, got %v", got["tradeMessages"])
+	}
+	if cycles, ok := got["tradeCycleProposals"].([]any); !ok || len(cycles) != 0 {
+		t.Fatalf("expected tradeCycleProposals to default to an empty array, got %v", got["tradeCycleProposals"])
+	}
 }
 
 func TestHandleBatchedUpdates_RejectsMalformedJSON(t *testing.T) {
@@ -147,3 +159,50 @@ func TestHandleBatchedUpdates_RejectsHugeBatch(t *testing.T) {
 		t.Fatal("kafka producer should not be called on oversized batch")
 	}
 }
+
+func TestHandleBatchedUpdates_RejectsTooManyTradeMessages(t *testing.T) {
+	jwtSecret = "test-secret"
+	token := newAccessTokenForTest(t, jwt.SigningMethodHS256, AccessTokenClaims{
+		UserID:   "user-1",
+		Username: "ash",
+		DeviceID: "device-1",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(1 * time.Hour)),
+		},
+	})
+
+	called := false
+	prev := kafkaProducerFunc
+	kafkaProducerFunc = func(data []byte) error {
+		called = true
+		return nil
+	}
+	t.Cleanup(func() { kafkaProducerFunc = prev })
+
+	messages := make([]map[string]any, maxTradeMessagesPerRequest+1)
+	for i := range messages {
+		messages[i] = map[string]any{"trade_id": "t-1", "body": "see you at the gym"}
+	}
+	raw, err := json.Marshal(map[string]any{"tradeMessages": messages})
+	if err != nil {
+		t.Fatalf("marshal test payload: %v", err)
+	}
+
+	app := fiber.New(fiber.Config{ErrorHandler: errorHandler})
+	app.Post("/api/batchedUpdates", handleBatchedUpdates)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/batchedUpdates", strings.NewReader(string(raw)))
+	req.Header.Set("Content-Type", "application/json")
+	req.AddCookie(&http.Cookie{Name: "accessToken", Value: token})
+
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("app.Test: %v", err)
+	}
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected status 413, got %d", resp.StatusCode)
+	}
+	if called {
+		t.Fatal("kafka producer should not be called on an oversized chat batch")
+	}
+}
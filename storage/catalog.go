@@ -0,0 +1,99 @@
+// catalog.go
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// speciesCatalog caches the rarity of every species served by the pokemon
+// data service. Storage only needs to know which species make a trade special.
+type speciesCatalog struct {
+	mu     sync.RWMutex
+	rarity map[int]string
+}
+
+var catalog = &speciesCatalog{}
+
+// Package var so tests can stub the catalog without an HTTP server.
+var fetchSpeciesRarityFn = fetchSpeciesRarity
+
+func fetchSpeciesRarity(baseURL string) (map[int]string, error) {
+	url := strings.TrimRight(baseURL, "/") + "/pokemon/pokemons"
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: status %d", url, resp.StatusCode)
+	}
+
+	var rows []struct {
+		PokemonID int     `json:"pokemon_id"`
+		Rarity    *string `json:"rarity"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&rows); err != nil {
+		return nil, fmt.Errorf("decode catalog: %w", err)
+	}
+
+	out := make(map[int]string, len(rows))
+	for _, row := range rows {
+		if row.PokemonID == 0 || row.Rarity == nil {
+			continue
+		}
+		out[row.PokemonID] = strings.ToLower(strings.TrimSpace(*row.Rarity))
+	}
+	return out, nil
+}
+
+// RefreshSpeciesCatalog reloads species rarity. On failure the previous
+// snapshot is kept, so a catalog outage never blocks trade processing.
+func RefreshSpeciesCatalog() {
+	rarity, err := fetchSpeciesRarityFn(AppConfig.Catalog.PokemonAPIURL)
+	if err != nil {
+		logrus.Warnf("Failed to refresh species catalog: %v", err)
+		return
+	}
+	catalog.mu.Lock()
+	catalog.rarity = rarity
+	catalog.mu.Unlock()
+	logrus.Infof("Species catalog loaded (%d species)", len(rarity))
+}
+
+// isSpecialSpecies reports whether a species is legendary, mythical or an
+// ultra beast. Unknown species (or an empty catalog) count as regular.
+func isSpecialSpecies(pokemonID int) bool {
+	catalog.mu.RLock()
+	rarity := catalog.rarity[pokemonID]
+	catalog.mu.RUnlock()
+	return isSpecialRarity(rarity)
+}
+
+func isSpecialRarity(rarity string) bool {
+	rarity = strings.ToLower(rarity)
+	return strings.Contains(rarity, "legendary") ||
+		strings.Contains(rarity, "mythic") ||
+		strings.Contains(rarity, "ultra beast")
+}
+
+func speciesCatalogLoaded() bool {
+	catalog.mu.RLock()
+	defer catalog.mu.RUnlock()
+	return len(catalog.rarity) > 0
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Every write to instances and trades takes the next value of one global
+// change sequence, so readers can sync with "everything after N" instead of
+// client timestamps. The counter row is locked until the writing
+// transaction commits, so sequence order is also commit order: once a
+// reader sees N committed, nothing at or below N can still appear.
+
+const changeSeqBackfillBatch = 5000
+
+// changeSeqTables are the tables stamped with change_seq, with the
+// (owner, change_seq) indexes the sync reads use.
+var changeSeqTables = []struct {
+	Table   string
+	Indexes map[string]string
+}{
+	{Table: "instances", Indexes: map[string]string{
+		"idx_instances_user_change": "(user_id, change_seq)",
+	}},
+	{Table: "trades", Indexes: map[string]string{
+		"idx_trades_proposed_change":  "(user_id_proposed, change_seq)",
+		"idx_trades_accepting_change": "(user_id_accepting, change_seq)",
+	}},
+}
+
+const createChangeSequenceTableSQL = `
+CREATE TABLE IF NOT EXISTS change_sequence (
+  id    TINYINT UNSIGNED NOT NULL PRIMARY KEY,
+  value BIGINT UNSIGNED NOT NULL
+)`
+
+// changeSeqTriggerSQL is the trigger stamping table's rows on event
+// (INSERT or UPDATE). LAST_INSERT_ID(expr) hands the incremented value to
+// the next statement without a second read; MySQL restores the session's
+// own LAST_INSERT_ID when the trigger ends.
+func changeSeqTriggerSQL(table, event string) string {
+	return fmt.Sprintf(`
+CREATE TRIGGER IF NOT EXISTS %s
+BEFORE %s ON %s
+FOR EACH ROW
+BEGIN
+  UPDATE change_sequence SET value = LAST_INSERT_ID(value + 1) WHERE id = 1;
+  SET NEW.change_seq = LAST_INSERT_ID();
+END`, changeSeqTriggerName(table, event), event, table)
+}
+
+func changeSeqTriggerName(table, event string) string {
+	return fmt.Sprintf("%s_change_seq_%s", table, strings.ToLower(event))
+}
+
+func indexExists(tableName, indexName string) (bool, error) {
+	var count int64
+	if err := DB.Raw(
+		`SELECT COUNT(*)
+		   FROM information_schema.statistics
+		  WHERE table_schema = DATABASE()
+		    AND table_name = ?
+		    AND index_name = ?`,
+		tableName,
+		indexName,
+	).Scan(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// ensureChangeSequenceSchema adds the sequence, the change_seq columns,
+// their indexes and the triggers. Rows written before the triggers existed
+// keep change_seq 0 until backfillChangeSeq stamps them.
+func ensureChangeSequenceSchema() error {
+	if err := DB.Exec(createChangeSequenceTableSQL).Error; err != nil {
+		return fmt.Errorf("create change_sequence: %w", err)
+	}
+	if err := DB.Exec("INSERT IGNORE INTO change_sequence (id, value) VALUES (1, 0)").Error; err != nil {
+		return fmt.Errorf("seed change_sequence: %w", err)
+	}
+
+	for _, t := range changeSeqTables {
+		if err := addMissingColumns(t.Table, []addedColumn{
+			{Name: "change_seq", Definition: "BIGINT UNSIGNED NOT NULL DEFAULT 0"},
+		}); err != nil {
+			return err
+		}
+		for name, cols := range t.Indexes {
+			exists, err := indexExists(t.Table, name)
+			if err != nil {
+				return fmt.Errorf("check index %s: %w", name, err)
+			}
+			if exists {
+				continue
+			}
+			if err := DB.Exec(fmt.Sprintf("CREATE INDEX %s ON %s %s", name, t.Table, cols)).Error; err != nil {
+				return fmt.Errorf("create index %s: %w", name, err)
+			}
+			logrus.Infof("Added index %s on %s", name, t.Table)
+		}
+		for _, event := range []string{"INSERT", "UPDATE"} {
+			if err := DB.Exec(changeSeqTriggerSQL(t.Table, event)).Error; err != nil {
+				return fmt.Errorf("create trigger %s: %w", changeSeqTriggerName(t.Table, event), err)
+			}
+		}
+	}
+	return nil
+}
+
+// backfillChangeSeq stamps rows that predate the triggers, in batches so no
+// statement holds the sequence lock for long. The update trigger does the
+// stamping. Readers simply see these rows as new changes.
+func backfillChangeSeq() {
+	for _, t := range changeSeqTables {
+		total := int64(0)
+		for {
+			res := DB.Exec(fmt.Sprintf("UPDATE %s SET change_seq = 0 WHERE change_seq = 0 LIMIT %d", t.Table, changeSeqBackfillBatch))
+			if res.Error != nil {
+				logrus.Errorf("Failed to backfill %s.change_seq: %v", t.Table, res.Error)
+				break
+			}
+			total += res.RowsAffected
+			if res.RowsAffected < changeSeqBackfillBatch {
+				break
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		if total > 0 {
+			logrus.Infof("Backfilled change_seq on %d %s rows", total, t.Table)
+		}
+	}
+}
@@ -19,11 +19,40 @@ type EventsConfig struct {
 	Topic         string `yaml:"topic"`
 	MaxRetries    int    `yaml:"max_retries"`
 	RetryInterval int    `yaml:"retry_interval"`
+	// StorageTopic carries changes made by storage itself (scheduler jobs,
+	// derived state) so the events service can fan them out over SSE.
+	StorageTopic string `yaml:"storage_topic"`
+}
+
+// TradesConfig controls how long trades may sit idle before the scheduler
+// steps in. All durations are in hours.
+type TradesConfig struct {
+	ProposalTTLHours  int `yaml:"proposal_ttl_hours"`
+	PendingTTLHours   int `yaml:"pending_ttl_hours"`
+	ReminderLeadHours int `yaml:"reminder_lead_hours"`
+}
+
+// CatalogConfig points at the pokemon data service, which is the source of
+// species rarity used when deriving trade costs.
+type CatalogConfig struct {
+	PokemonAPIURL  string `yaml:"pokemon_api_url"`
+	RefreshMinutes int    `yaml:"refresh_minutes"`
+}
+
+// ChatConfig limits per-trade chat and names the topic that message reports
+// are published to for moderation.
+type ChatConfig struct {
+	MessagesPerMinute       int    `yaml:"messages_per_minute"`         // per sender, across trades
+	MessagesPerTradePerHour int    `yaml:"messages_per_trade_per_hour"` // per sender and trade
+	ReportTopic             string `yaml:"report_topic"`
 }
 
 type Config struct {
-	Version string       `yaml:"version"`
-	Events  EventsConfig `yaml:"events"`
+	Version string        `yaml:"version"`
+	Events  EventsConfig  `yaml:"events"`
+	Trades  TradesConfig  `yaml:"trades"`
+	Catalog CatalogConfig `yaml:"catalog"`
+	Chat    ChatConfig    `yaml:"chat"`
 }
 
 var (
@@ -83,6 +112,33 @@ func applyConfigDefaultsAndEnv(cfg *Config, getenv func(string) string) {
 	if cfg.Events.RetryInterval <= 0 {
 		cfg.Events.RetryInterval = 3
 	}
+	if cfg.Events.StorageTopic == "" {
+		cfg.Events.StorageTopic = "storageUpdates"
+	}
+	if cfg.Trades.ProposalTTLHours <= 0 {
+		cfg.Trades.ProposalTTLHours = 7 * 24
+	}
+	if cfg.Trades.PendingTTLHours <= 0 {
+		cfg.Trades.PendingTTLHours = 14 * 24
+	}
+	if cfg.Trades.ReminderLeadHours <= 0 {
+		cfg.Trades.ReminderLeadHours = 24
+	}
+	if cfg.Catalog.PokemonAPIURL == "" {
+		cfg.Catalog.PokemonAPIURL = "http://pokemon_data_container:3001"
+	}
+	if cfg.Catalog.RefreshMinutes <= 0 {
+		cfg.Catalog.RefreshMinutes = 6 * 60
+	}
+	if cfg.Chat.MessagesPerMinute <= 0 {
+		cfg.Chat.MessagesPerMinute = 10
+	}
+	if cfg.Chat.MessagesPerTradePerHour <= 0 {
+		cfg.Chat.MessagesPerTradePerHour = 60
+	}
+	if cfg.Chat.ReportTopic == "" {
+		cfg.Chat.ReportTopic = "tradeMessageReports"
+	}
 
 	// Prefer explicit Kafka variables.
 	if v := strings.TrimSpace(getenv("KAFKA_HOSTNAME")); v != "" {
@@ -103,6 +159,33 @@ func applyConfigDefaultsAndEnv(cfg *Config, getenv func(string) string) {
 	if v := parsePositiveIntEnv("KAFKA_RETRY_INTERVAL", getenv); v > 0 {
 		cfg.Events.RetryInterval = v
 	}
+	if v := strings.TrimSpace(getenv("KAFKA_STORAGE_TOPIC")); v != "" {
+		cfg.Events.StorageTopic = v
+	}
+	if v := parsePositiveIntEnv("TRADE_PROPOSAL_TTL_HOURS", getenv); v > 0 {
+		cfg.Trades.ProposalTTLHours = v
+	}
+	if v := parsePositiveIntEnv("TRADE_PENDING_TTL_HOURS", getenv); v > 0 {
+		cfg.Trades.PendingTTLHours = v
+	}
+	if v := parsePositiveIntEnv("TRADE_REMINDER_LEAD_HOURS", getenv); v > 0 {
+		cfg.Trades.ReminderLeadHours = v
+	}
+	if v := strings.TrimSpace(getenv("POKEMON_API_URL")); v != "" {
+		cfg.Catalog.PokemonAPIURL = v
+	}
+	if v := parsePositiveIntEnv("CATALOG_REFRESH_MINUTES", getenv); v > 0 {
+		cfg.Catalog.RefreshMinutes = v
+	}
+	if v := parsePositiveIntEnv("CHAT_MESSAGES_PER_MINUTE", getenv); v > 0 {
+		cfg.Chat.MessagesPerMinute = v
+	}
+	if v := parsePositiveIntEnv("CHAT_MESSAGES_PER_TRADE_PER_HOUR", getenv); v > 0 {
+		cfg.Chat.MessagesPerTradePerHour = v
+	}
+	if v := strings.TrimSpace(getenv("KAFKA_MESSAGE_REPORT_TOPIC")); v != "" {
+		cfg.Chat.ReportTopic = v
+	}
 }
 
 func parsePositiveIntEnv(key string, getenv func(string) string) int {
@@ -21,6 +21,18 @@ func TestApplyConfigDefaultsAndEnv_Defaults(t *testing.T) {
 	if cfg.Events.RetryInterval != 3 {
 		t.Fatalf("expected default retry interval 3, got %d", cfg.Events.RetryInterval)
 	}
+	if cfg.Events.StorageTopic != "storageUpdates" {
+		t.Fatalf("expected default storage topic storageUpdates, got %q", cfg.Events.StorageTopic)
+	}
+	if cfg.Trades.ProposalTTLHours != 168 || cfg.Trades.PendingTTLHours != 336 || cfg.Trades.ReminderLeadHours != 24 {
+		t.Fatalf("unexpected trade lifecycle defaults: %+v", cfg.Trades)
+	}
+	if cfg.Catalog.PokemonAPIURL != "http://pokemon_data_container:3001" || cfg.Catalog.RefreshMinutes != 360 {
+		t.Fatalf("unexpected catalog defaults: %+v", cfg.Catalog)
+	}
+	if cfg.Chat.MessagesPerMinute != 10 || cfg.Chat.MessagesPerTradePerHour != 60 || cfg.Chat.ReportTopic != "tradeMessageReports" {
+		t.Fatalf("unexpected chat defaults: %+v", cfg.Chat)
+	}
 }
 
 func TestApplyConfigDefaultsAndEnv_Overrides(t *testing.T) {
@@ -34,11 +46,15 @@ func TestApplyConfigDefaultsAndEnv_Overrides(t *testing.T) {
 		},
 	}
 	env := map[string]string{
-		"KAFKA_HOSTNAME":       "kafka-internal",
-		"KAFKA_PORT":           "9092",
-		"KAFKA_TOPIC":          "batchedUpdates",
-		"KAFKA_MAX_RETRIES":    "9",
-		"KAFKA_RETRY_INTERVAL": "7",
+		"KAFKA_HOSTNAME":           "kafka-internal",
+		"KAFKA_PORT":               "9092",
+		"KAFKA_TOPIC":              "batchedUpdates",
+		"KAFKA_MAX_RETRIES":        "9",
+		"KAFKA_RETRY_INTERVAL":     "7",
+		"KAFKA_STORAGE_TOPIC":      "storageEvents",
+		"TRADE_PROPOSAL_TTL_HOURS": "12",
+		"POKEMON_API_URL":          "http://pokemon:3001",
+		"CHAT_MESSAGES_PER_MINUTE": "3",
 	}
 
 	applyConfigDefaultsAndEnv(&cfg, envFromMap(env))
@@ -58,6 +74,18 @@ func TestApplyConfigDefaultsAndEnv_Overrides(t *testing.T) {
 	if cfg.Events.RetryInterval != 7 {
 		t.Fatalf("expected retry interval override, got %d", cfg.Events.RetryInterval)
 	}
+	if cfg.Events.StorageTopic != "storageEvents" {
+		t.Fatalf("expected storage topic override, got %q", cfg.Events.StorageTopic)
+	}
+	if cfg.Trades.ProposalTTLHours != 12 {
+		t.Fatalf("expected proposal ttl override, got %d", cfg.Trades.ProposalTTLHours)
+	}
+	if cfg.Catalog.PokemonAPIURL != "http://pokemon:3001" {
+		t.Fatalf("expected pokemon api url override, got %q", cfg.Catalog.PokemonAPIURL)
+	}
+	if cfg.Chat.MessagesPerMinute != 3 {
+		t.Fatalf("expected chat rate override, got %d", cfg.Chat.MessagesPerMinute)
+	}
 }
 
 func TestApplyConfigDefaultsAndEnv_HostIPFallback(t *testing.T) {
@@ -0,0 +1,25 @@
+// location_privacy.go
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Saved search alerts locate a lister the way search does: only when they
+// opted in (users.allow_location), and then at the centre of their geohash
+// cell of five characters, about 4.9 km square. The search service blurs
+// the same way.
+
+// locationCellDegrees is the side of such a cell: its 13 longitude and 12
+// latitude bits make both 360/2^13 = 180/2^12 degrees.
+const locationCellDegrees = 360.0 / 8192
+
+// blurredPointSQL is the centre of the cell holding a POINT(longitude,
+// latitude) column.
+func blurredPointSQL(column string) string {
+	side := strconv.FormatFloat(locationCellDegrees, 'f', -1, 64)
+	return fmt.Sprintf("POINT((FLOOR((ST_X(%[1]s) + 180) / %[2]s) + 0.5) * %[2]s - 180, "+
+		"(FLOOR((ST_Y(%[1]s) + 90) / %[2]s) + 0.5) * %[2]s - 90)", column, side)
+}
@@ -4,6 +4,7 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"os/signal"
 	"strings"
@@ -31,10 +32,43 @@ func main() {
 	if err := resolveInstanceSchema(); err != nil {
 		logrus.Fatalf("Failed to validate instances schema: %v", err)
 	}
+	if err := ensureTradesSchema(); err != nil {
+		logrus.Fatalf("Failed to prepare trades schema: %v", err)
+	}
+	if err := ensureTradeDustCostsSchema(); err != nil {
+		logrus.Fatalf("Failed to prepare trade dust costs: %v", err)
+	}
+	if err := ensureTagsSchema(); err != nil {
+		logrus.Fatalf("Failed to prepare tags schema: %v", err)
+	}
+	if err := ensureNotificationsSchema(); err != nil {
+		logrus.Fatalf("Failed to prepare notifications schema: %v", err)
+	}
+	if err := ensureUserLocationSchema(); err != nil {
+		logrus.Fatalf("Failed to prepare user location schema: %v", err)
+	}
+	if err := ensurePresenceSchema(); err != nil {
+		logrus.Fatalf("Failed to prepare presence schema: %v", err)
+	}
+	if err := ensureTradeMessagesSchema(); err != nil {
+		logrus.Fatalf("Failed to prepare trade messages schema: %v", err)
+	}
+	if err := ensureSavedSearchesSchema(); err != nil {
+		logrus.Fatalf("Failed to prepare saved searches schema: %v", err)
+	}
+	if err := ensureChangeSequenceSchema(); err != nil {
+		logrus.Fatalf("Failed to prepare change sequence: %v", err)
+	}
+	if err := ensureTradeMatchesSchema(); err != nil {
+		logrus.Fatalf("Failed to prepare trade matches schema: %v", err)
+	}
+	go backfillChangeSeq()
 
 	// 4) Start observability server + Kafka Consumer
 	ctx, cancel := context.WithCancel(context.Background())
 	go startObservabilityServer(ctx)
+	initStorageProducer()
+	go RefreshSpeciesCatalog()
 	go StartConsumer(ctx)
 
 	// 5) Scheduler
@@ -56,6 +90,25 @@ func main() {
 	if err != nil {
 		logrus.Fatalf("Failed to schedule ReprocessFailedMessages: %v", err)
 	}
+	// Remind, expire and auto-cancel idle trades every 15 minutes
+	_, err = c.AddFunc("@every 15m", ExpireStaleTrades)
+	if err != nil {
+		logrus.Fatalf("Failed to schedule ExpireStaleTrades: %v", err)
+	}
+	// Keep species rarity (used for trade dust costs) current
+	_, err = c.AddFunc(fmt.Sprintf("@every %dm", AppConfig.Catalog.RefreshMinutes), RefreshSpeciesCatalog)
+	if err != nil {
+		logrus.Fatalf("Failed to schedule RefreshSpeciesCatalog: %v", err)
+	}
+	// Keep the trade match index in step with instance changes
+	_, err = c.AddFunc("@every 10s", IndexTradeMatches)
+	if err != nil {
+		logrus.Fatalf("Failed to schedule IndexTradeMatches: %v", err)
+	}
+	_, err = c.AddFunc("@every 1h", SweepTradeMatches)
+	if err != nil {
+		logrus.Fatalf("Failed to schedule SweepTradeMatches: %v", err)
+	}
 	c.Start()
 
 	logrus.Info("Backup scheduler started. Scheduled jobs are running.")
@@ -68,6 +121,7 @@ func main() {
 	logrus.Info("Shutdown signal received...")
 	cancel()
 	c.Stop()
+	closeStorageProducer()
 	logrus.Info("All background services stopped. Exiting now.")
 }
 
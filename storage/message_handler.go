@@ -53,20 +53,40 @@ func HandleMessage(data map[string]interface{}) error {
 		}
 	}
 
-	// 2) Process Pokemon updates with messageTraceID
+	// 2) Tag changes first, so Pokemon in this batch can use new tags
+	appliedTags, rejectedTags := parseAndApplyTagUpdates(data, userID)
+
+	// 3) Process Pokemon updates with messageTraceID
 	createdCount, updatedCount, deletedCount, err := parseAndUpsertPokemon(data, userID, messageTraceID)
 	if err != nil {
 		logrus.Errorf("Failed parsing/upserting Pokémon for user %s: %v", userID, err)
 	}
 
-	// 3) Process Trades
+	// 4) Process Trades
 	createdTrades, updatedTrades, droppedTrades, errTrades := parseAndUpsertTrades(data)
 	if errTrades != nil {
 		logrus.Errorf("Failed parsing/upserting Trades: %v", errTrades)
 	}
 
-	// 4) Log summary
+	// 5) Trade cycles proposed by the sender, one leg trade per hop
+	proposedCycles, rejectedCycles := parseAndProposeTradeCycles(data, userID, username)
+
+	// 6) Ratings on completed trades, attributed to the sender
+	appliedRatings, rejectedRatings := parseAndApplyTradeRatings(data, userID, username)
+
+	// 7) Trade chat: messages, read receipts and reports from the sender
+	sentMessages, rejectedMessages := parseAndApplyTradeMessages(data, userID, username, messageTraceID)
+	appliedReads, rejectedReads := parseAndApplyTradeMessageReads(data, userID, username)
+	appliedReports, rejectedReports := parseAndApplyTradeMessageReports(data, userID, username)
+
+	// 8) Log summary
 	actions := []string{}
+	if appliedTags > 0 {
+		actions = append(actions, fmt.Sprintf("applied %d tag updates", appliedTags))
+	}
+	if rejectedTags > 0 {
+		actions = append(actions, fmt.Sprintf("rejected %d tag updates", rejectedTags))
+	}
 	if createdCount > 0 {
 		actions = append(actions, fmt.Sprintf("created %d Pokémon", createdCount))
 	}
@@ -86,6 +106,36 @@ func HandleMessage(data map[string]interface{}) error {
 	if droppedTrades > 0 {
 		actions = append(actions, fmt.Sprintf("dropped %d trades", droppedTrades))
 	}
+	if proposedCycles > 0 {
+		actions = append(actions, fmt.Sprintf("proposed %d trade cycles", proposedCycles))
+	}
+	if rejectedCycles > 0 {
+		actions = append(actions, fmt.Sprintf("rejected %d trade cycles", rejectedCycles))
+	}
+	if appliedRatings > 0 {
+		actions = append(actions, fmt.Sprintf("rated %d trades", appliedRatings))
+	}
+	if rejectedRatings > 0 {
+		actions = append(actions, fmt.Sprintf("rejected %d ratings", rejectedRatings))
+	}
+	if sentMessages > 0 {
+		actions = append(actions, fmt.Sprintf("sent %d trade messages", sentMessages))
+	}
+	if rejectedMessages > 0 {
+		actions = append(actions, fmt.Sprintf("rejected %d trade messages", rejectedMessages))
+	}
+	if appliedReads > 0 {
+		actions = append(actions, fmt.Sprintf("marked %d trade chats read", appliedReads))
+	}
+	if rejectedReads > 0 {
+		actions = append(actions, fmt.Sprintf("rejected %d read receipts", rejectedReads))
+	}
+	if appliedReports > 0 {
+		actions = append(actions, fmt.Sprintf("reported %d trade messages", appliedReports))
+	}
+	if rejectedReports > 0 {
+		actions = append(actions, fmt.Sprintf("rejected %d message reports", rejectedReports))
+	}
 
 	summary := "no changes"
 	if len(actions) > 0 {
@@ -130,14 +130,22 @@ type Trade struct {
 	TradeCompletedDate               *time.Time `gorm:"column:trade_completed_date"`
 	TradeCancelledDate               *time.Time `gorm:"column:trade_cancelled_date"`
 	TradeCancelledBy                 *string    `gorm:"column:trade_cancelled_by"`
+	TradeExpiredDate                 *time.Time `gorm:"column:trade_expired_date"`
+	TradeReminderSentDate            *time.Time `gorm:"column:trade_reminder_sent_date"`
+	CounterOfTradeID                 *string    `gorm:"column:counter_of_trade_id"`
+	TradeCycleID                     *string    `gorm:"column:trade_cycle_id"`
 	IsSpecialTrade                   bool       `gorm:"column:is_special_trade"`
 	IsRegisteredTrade                bool       `gorm:"column:is_registered_trade"`
 	IsLuckyTrade                     bool       `gorm:"column:is_lucky_trade"`
 	TradeDustCost                    *int       `gorm:"column:trade_dust_cost"`
+	ClientTradeDustCost              *int       `gorm:"column:client_trade_dust_cost"`
+	TradeTermsMismatch               bool       `gorm:"column:trade_terms_mismatch"`
 	TradeFriendshipLevel             string     `gorm:"column:trade_friendship_level"`
-	User1TradeSatisfaction           bool       `gorm:"column:user_1_trade_satisfaction"`
-	User2TradeSatisfaction           bool       `gorm:"column:user_2_trade_satisfaction"`
-	LastUpdate                       int64      `gorm:"column:last_update;default:0"`
+	// 1-5 scores mirrored from trade_ratings: user 1 is the proposer's
+	// rating of the accepter, user 2 the accepter's rating of the proposer.
+	User1TradeSatisfaction *int  `gorm:"column:user_1_trade_satisfaction"`
+	User2TradeSatisfaction *int  `gorm:"column:user_2_trade_satisfaction"`
+	LastUpdate             int64 `gorm:"column:last_update;default:0"`
 }
 
 func (Trade) TableName() string {
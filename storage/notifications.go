@@ -0,0 +1,307 @@
+// notifications.go
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	notificationTradeProposed   = "trade_proposed"
+	notificationTradeAccepted   = "trade_accepted"
+	notificationTradeCancelled  = "trade_cancelled"
+	notificationTradeCompleted  = "trade_completed"
+	notificationTradeRated      = "trade_rated"
+	notificationMostWantedMatch = "most_wanted_match"
+)
+
+// maxMostWantedRecipients caps how many trainers one listing can notify.
+const maxMostWantedRecipients = 100
+
+// tradeNotificationTypes maps the status a trade moves into to the
+// notification its parties get. Every way a trade ends short of completion
+// is a cancellation; countered proposals are covered by the counter-offer's
+// own trade_proposed.
+var tradeNotificationTypes = map[string]string{
+	"proposed":  notificationTradeProposed,
+	"pending":   notificationTradeAccepted,
+	"completed": notificationTradeCompleted,
+	"cancelled": notificationTradeCancelled,
+	"denied":    notificationTradeCancelled,
+	"expired":   notificationTradeCancelled,
+}
+
+// Notification mirrors the "notifications" table: one inbox row per
+// recipient. Reader marks rows read or dismissed; storage only inserts.
+type Notification struct {
+	NotificationID   uint64     `gorm:"column:notification_id;primaryKey;autoIncrement"`
+	UserID           string     `gorm:"column:user_id"`
+	NotificationType string     `gorm:"column:notification_type"`
+	ActorUsername    *string    `gorm:"column:actor_username"`
+	TradeID          *string    `gorm:"column:trade_id"`
+	InstanceID       *string    `gorm:"column:instance_id"`
+	Payload          string     `gorm:"column:payload;type:json"`
+	DedupeKey        string     `gorm:"column:dedupe_key"`
+	CreatedAt        time.Time  `gorm:"column:created_at;autoCreateTime"`
+	ReadAt           *time.Time `gorm:"column:read_at"`
+	DismissedAt      *time.Time `gorm:"column:dismissed_at"`
+
+	// Recipient's username and the decoded payload, for the live event.
+	Username string                 `gorm:"-"`
+	Data     map[string]interface{} `gorm:"-"`
+}
+
+func (Notification) TableName() string {
+	return "notifications"
+}
+
+// NotificationPreference mirrors "notification_preferences". A missing row
+// means the type is enabled.
+type NotificationPreference struct {
+	UserID           string    `gorm:"column:user_id;primaryKey"`
+	NotificationType string    `gorm:"column:notification_type;primaryKey"`
+	Enabled          bool      `gorm:"column:enabled"`
+	UpdatedAt        time.Time `gorm:"column:updated_at;autoUpdateTime"`
+}
+
+func (NotificationPreference) TableName() string {
+	return "notification_preferences"
+}
+
+// listedInstance is an instance that has just been put up for trade.
+type listedInstance struct {
+	InstanceID string
+	VariantID  string
+	PokemonID  int
+}
+
+// newNotification builds an unsaved row. The dedupe key makes reprocessed
+// messages and repeated transitions insert nothing the second time.
+func newNotification(userID, username, notificationType, actor, dedupeKey string, data map[string]interface{}) Notification {
+	n := Notification{
+		UserID:           userID,
+		Username:         username,
+		NotificationType: notificationType,
+		DedupeKey:        dedupeKey,
+		Data:             data,
+	}
+	if actor != "" {
+		n.ActorUsername = &actor
+	}
+	if raw, err := json.Marshal(data); err == nil {
+		n.Payload = string(raw)
+	} else {
+		n.Payload = "{}"
+	}
+	return n
+}
+
+// tradeNotifications builds the notifications for a trade moving into
+// status. The side that made the change is not notified; when neither side
+// did (the expiry scheduler), both are.
+func tradeNotifications(trade Trade, status, actorUserID string) []Notification {
+	notificationType, ok := tradeNotificationTypes[status]
+	if !ok {
+		return nil
+	}
+
+	actor := systemActor
+	switch {
+	case actorUserID == "":
+	case actorUserID == trade.UserIDProposed:
+		actor = trade.UsernameProposed
+	case actorUserID == trade.UserIDAccepting:
+		actor = trade.UsernameAccepting
+	}
+
+	var out []Notification
+	parties := [][2]string{
+		{trade.UserIDProposed, trade.UsernameProposed},
+		{trade.UserIDAccepting, trade.UsernameAccepting},
+	}
+	for _, party := range parties {
+		recipientID, recipient := party[0], party[1]
+		if recipientID == "" || recipientID == actorUserID {
+			continue
+		}
+		data := map[string]interface{}{
+			"trade_id":           trade.TradeID,
+			"trade_status":       status,
+			"username_proposed":  trade.UsernameProposed,
+			"username_accepting": trade.UsernameAccepting,
+		}
+		if notificationType == notificationTradeCancelled {
+			data["reason"] = status
+		}
+		if trade.CounterOfTradeID != nil {
+			data["counter_of_trade_id"] = *trade.CounterOfTradeID
+		}
+		if trade.TradeCycleID != nil {
+			data["trade_cycle_id"] = *trade.TradeCycleID
+		}
+		n := newNotification(recipientID, recipient, notificationType, actor,
+			fmt.Sprintf("trade:%s:%s:%s", trade.TradeID, status, recipientID), data)
+		tradeID := trade.TradeID
+		n.TradeID = &tradeID
+		out = append(out, n)
+	}
+	return out
+}
+
+// ratingNotifications tells the rated trainer about a new score.
+func ratingNotifications(trade Trade, raterID, raterUsername string, score int) []Notification {
+	recipientID, recipient := trade.UserIDAccepting, trade.UsernameAccepting
+	if raterID == trade.UserIDAccepting {
+		recipientID, recipient = trade.UserIDProposed, trade.UsernameProposed
+	}
+	if recipientID == "" || recipientID == raterID {
+		return nil
+	}
+	n := newNotification(recipientID, recipient, notificationTradeRated, raterUsername,
+		fmt.Sprintf("rated:%s:%s", trade.TradeID, recipientID),
+		map[string]interface{}{"trade_id": trade.TradeID, "score": score})
+	tradeID := trade.TradeID
+	n.TradeID = &tradeID
+	return []Notification{n}
+}
+
+// mostWantedNotifications tells each wanter that lister has put a variant
+// they marked most wanted up for trade. Each instance notifies a trainer
+// at most once, however often it is relisted.
+func mostWantedNotifications(lister string, inst listedInstance, wanters []User) []Notification {
+	var out []Notification
+	for _, w := range wanters {
+		if w.UserID == "" {
+			continue
+		}
+		n := newNotification(w.UserID, w.Username, notificationMostWantedMatch, lister,
+			fmt.Sprintf("most_wanted:%s:%s", inst.InstanceID, w.UserID),
+			map[string]interface{}{
+				"instance_id": inst.InstanceID,
+				"variant_id":  inst.VariantID,
+				"pokemon_id":  inst.PokemonID,
+				"username":    lister,
+			})
+		instanceID := inst.InstanceID
+		n.InstanceID = &instanceID
+		out = append(out, n)
+	}
+	return out
+}
+
+// notifyMostWantedListings looks up the trainers who most want each newly
+// listed variant and notifies them.
+func notifyMostWantedListings(listerID string, listed []listedInstance) {
+	if len(listed) == 0 {
+		return
+	}
+	var lister User
+	if err := DB.Where("user_id = ?", listerID).First(&lister).Error; err != nil {
+		logrus.Warnf("Skipping most-wanted notifications for user %s: %v", listerID, err)
+		return
+	}
+
+	var notes []Notification
+	for _, inst := range listed {
+		var wanters []User
+		if err := DB.Table("instances").
+			Select("DISTINCT users.user_id, users.username").
+			Joins("JOIN users ON users.user_id = instances.user_id").
+			Where("instances.variant_id = ? AND instances.most_wanted = ? AND instances.user_id <> ?",
+				inst.VariantID, true, listerID).
+			Limit(maxMostWantedRecipients).
+			Scan(&wanters).Error; err != nil {
+			logrus.Warnf("Failed to find most-wanted matches for instance %s: %v", inst.InstanceID, err)
+			continue
+		}
+		notes = append(notes, mostWantedNotifications(lister.Username, inst, wanters)...)
+	}
+	recordNotifications(notes)
+}
+
+type notificationPreferenceKey struct {
+	UserID           string
+	NotificationType string
+}
+
+// mutedNotificationTypes returns the (user, type) pairs switched off among
+// the given recipients.
+func mutedNotificationTypes(userIDs []string) (map[notificationPreferenceKey]bool, error) {
+	var prefs []NotificationPreference
+	if err := DB.Where("user_id IN ? AND enabled = ?", userIDs, false).Find(&prefs).Error; err != nil {
+		return nil, err
+	}
+	muted := make(map[notificationPreferenceKey]bool, len(prefs))
+	for _, p := range prefs {
+		muted[notificationPreferenceKey{p.UserID, p.NotificationType}] = true
+	}
+	return muted, nil
+}
+
+// recordNotifications stores notes the recipients have not muted and pushes
+// each newly inserted row to its recipient. Failures are logged and never
+// fail the batch that caused them.
+func recordNotifications(notes []Notification) {
+	if len(notes) == 0 {
+		return
+	}
+
+	seen := make(map[string]bool)
+	var userIDs []string
+	for _, n := range notes {
+		if !seen[n.UserID] {
+			seen[n.UserID] = true
+			userIDs = append(userIDs, n.UserID)
+		}
+	}
+	muted, err := mutedNotificationTypes(userIDs)
+	if err != nil {
+		// Better an unwanted notification than a lost one.
+		logrus.Warnf("Failed to load notification preferences: %v", err)
+	}
+
+	for _, n := range notes {
+		if muted[notificationPreferenceKey{n.UserID, n.NotificationType}] {
+			continue
+		}
+		res := DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&n)
+		if res.Error != nil {
+			logrus.Warnf("Failed to store %s notification for user %s: %v", n.NotificationType, n.UserID, res.Error)
+			continue
+		}
+		if res.RowsAffected == 0 {
+			continue
+		}
+		publishNotification(n)
+	}
+}
+
+// publishNotification sends a stored notification to the recipient's open
+// connections. The event carries no trade updates, so it reaches the
+// recipient only.
+func publishNotification(n Notification) {
+	event := newStorageEvent(n.UserID, n.Username, "notification_created")
+	event["notifications"] = []interface{}{notificationPayload(n)}
+	if err := publishStorageEventFn(event); err != nil {
+		logrus.Warnf("Failed to publish notification %d for user %s: %v", n.NotificationID, n.UserID, err)
+	}
+}
+
+// notificationPayload renders a notification the way reader lists it.
+func notificationPayload(n Notification) map[string]interface{} {
+	return map[string]interface{}{
+		"notification_id":   fmt.Sprintf("%d", n.NotificationID),
+		"notification_type": n.NotificationType,
+		"actor_username":    n.ActorUsername,
+		"trade_id":          n.TradeID,
+		"instance_id":       n.InstanceID,
+		"data":              n.Data,
+		"created_at":        n.CreatedAt.UTC(),
+		"read_at":           nil,
+	}
+}
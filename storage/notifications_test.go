@@ -0,0 +1,95 @@
+package main
+
+import "testing"
+
+func TestTradeNotifications_SkipTheActingSide(t *testing.T) {
+	trade := Trade{
+		TradeID:           "t-1",
+		UserIDProposed:    "u-1",
+		UsernameProposed:  "alice",
+		UserIDAccepting:   "u-2",
+		UsernameAccepting: "bob",
+	}
+
+	proposed := tradeNotifications(trade, "proposed", "u-1")
+	if len(proposed) != 1 || proposed[0].UserID != "u-2" || proposed[0].NotificationType != notificationTradeProposed {
+		t.Fatalf("expected only the accepting side to hear about the proposal, got %+v", proposed)
+	}
+	if *proposed[0].ActorUsername != "alice" || *proposed[0].TradeID != "t-1" {
+		t.Fatalf("unexpected actor or trade: %+v", proposed[0])
+	}
+
+	accepted := tradeNotifications(trade, "pending", "u-2")
+	if len(accepted) != 1 || accepted[0].UserID != "u-1" || accepted[0].NotificationType != notificationTradeAccepted {
+		t.Fatalf("expected only the proposer to hear about the acceptance, got %+v", accepted)
+	}
+
+	denied := tradeNotifications(trade, "denied", "u-2")
+	if len(denied) != 1 || denied[0].NotificationType != notificationTradeCancelled || denied[0].Data["reason"] != "denied" {
+		t.Fatalf("expected a cancellation with the status as reason, got %+v", denied)
+	}
+
+	expired := tradeNotifications(trade, "expired", "")
+	if len(expired) != 2 || *expired[0].ActorUsername != systemActor {
+		t.Fatalf("expected both sides to hear about a scheduler expiry, got %+v", expired)
+	}
+	if expired[0].DedupeKey == expired[1].DedupeKey {
+		t.Fatalf("expected a dedupe key per recipient, got %q", expired[0].DedupeKey)
+	}
+
+	for _, status := range []string{"countered", "deleted", "mystery"} {
+		if got := tradeNotifications(trade, status, "u-1"); len(got) != 0 {
+			t.Fatalf("expected no notifications for %s, got %+v", status, got)
+		}
+	}
+}
+
+func TestRatingNotifications_GoToTheRatee(t *testing.T) {
+	trade := Trade{TradeID: "t-2", UserIDProposed: "u-1", UsernameProposed: "alice", UserIDAccepting: "u-2", UsernameAccepting: "bob"}
+
+	got := ratingNotifications(trade, "u-2", "bob", 4)
+	if len(got) != 1 || got[0].UserID != "u-1" || got[0].Data["score"] != 4 || *got[0].ActorUsername != "bob" {
+		t.Fatalf("expected the proposer to be told about the score, got %+v", got)
+	}
+}
+
+func TestMostWantedNotifications_OnePerWanter(t *testing.T) {
+	inst := listedInstance{InstanceID: "i-1", VariantID: "0025-default", PokemonID: 25}
+	got := mostWantedNotifications("alice", inst, []User{{UserID: "u-2", Username: "bob"}, {UserID: ""}})
+	if len(got) != 1 || got[0].UserID != "u-2" || got[0].NotificationType != notificationMostWantedMatch {
+		t.Fatalf("unexpected most-wanted notifications: %+v", got)
+	}
+	if *got[0].InstanceID != "i-1" || got[0].DedupeKey != "most_wanted:i-1:u-2" {
+		t.Fatalf("unexpected instance or dedupe key: %+v", got[0])
+	}
+}
+
+func TestPublishNotification_TargetsRecipient(t *testing.T) {
+	prev := publishStorageEventFn
+	t.Cleanup(func() { publishStorageEventFn = prev })
+
+	var captured map[string]interface{}
+	publishStorageEventFn = func(payload map[string]interface{}) error {
+		captured = payload
+		return nil
+	}
+
+	n := tradeNotifications(Trade{TradeID: "t-3", UserIDProposed: "u-1", UsernameProposed: "alice", UserIDAccepting: "u-2", UsernameAccepting: "bob"}, "proposed", "u-1")[0]
+	n.NotificationID = 42
+	publishNotification(n)
+
+	if captured["user_id"] != "u-2" || captured["event"] != "notification_created" {
+		t.Fatalf("expected an event addressed to the recipient, got %#v", captured)
+	}
+	if _, ok := captured["tradeUpdates"]; ok {
+		t.Fatalf("notification events must not fan out as trade updates")
+	}
+	items, ok := captured["notifications"].([]interface{})
+	if !ok || len(items) != 1 {
+		t.Fatalf("expected one notification, got %#v", captured["notifications"])
+	}
+	item := items[0].(map[string]interface{})
+	if item["notification_id"] != "42" || item["notification_type"] != notificationTradeProposed {
+		t.Fatalf("unexpected notification payload: %#v", item)
+	}
+}
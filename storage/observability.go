@@ -55,6 +55,14 @@ var (
 		[]string{"result"},
 	)
 
+	tradeTermsMismatchesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "storage_trade_terms_mismatches_total",
+			Help: "Trades whose client-reported terms disagreed with the derived ones, by field.",
+		},
+		[]string{"field"},
+	)
+
 	kafkaConsumerReady = prometheus.NewGauge(
 		prometheus.GaugeOpts{
 			Name: "storage_kafka_consumer_ready",
@@ -70,6 +78,7 @@ func registerObservabilityMetrics() {
 		registerCollector(kafkaMessagesTotal)
 		registerCollector(kafkaMessageDurationSeconds)
 		registerCollector(kafkaConsumerReady)
+		registerCollector(tradeTermsMismatchesTotal)
 		kafkaConsumerReady.Set(0)
 	})
 }
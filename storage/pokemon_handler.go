@@ -16,6 +16,11 @@ import (
 
 func parseAndUpsertPokemon(data map[string]interface{}, userID string, messageTraceID string) (createdCount, updatedCount, deletedCount int, err error) {
 	pokemonUpdates, _ := data["pokemonUpdates"].([]interface{})
+	// Instances put up for trade in this batch, for most-wanted matches.
+	var listed []listedInstance
+	// Instances left for trade by this batch, new or updated, for saved
+	// search alerts.
+	var forTrade []string
 	for _, p := range pokemonUpdates {
 		pm, ok := p.(map[string]interface{})
 		if !ok {
@@ -91,6 +96,9 @@ func parseAndUpsertPokemon(data map[string]interface{}, userID string, messageTr
 				if errReg := syncRegistrationForVariant(DB, userID, variantForRegistration); errReg != nil {
 					logrus.Warnf("Failed to sync registration after delete for user %s variant %s: %v", userID, variantForRegistration, errReg)
 				}
+				if errMatch := cleanupTradeMatches(DB, instanceID); errMatch != nil {
+					logrus.Warnf("Failed to clean trade_matches for deleted instance %s: %v", instanceID, errMatch)
+				}
 			}
 			continue
 		}
@@ -277,6 +285,12 @@ func parseAndUpsertPokemon(data map[string]interface{}, userID string, messageTr
 			}
 			updatedCount++
 		}
+		if isForTrade && !existingInstance.IsForTrade && variantForRegistration != "" {
+			listed = append(listed, listedInstance{InstanceID: instanceID, VariantID: variantForRegistration, PokemonID: pokemonID})
+		}
+		if isForTrade {
+			forTrade = append(forTrade, instanceID)
+		}
 
 		if errReg := syncRegistrationForVariant(DB, userID, variantForRegistration); errReg != nil {
 			logrus.Warnf("Failed to sync registrations for user %s variant %s: %v", userID, variantForRegistration, errReg)
@@ -296,5 +310,7 @@ func parseAndUpsertPokemon(data map[string]interface{}, userID string, messageTr
 			logrus.Warnf("Failed to sync instance_tags for instance %s: %v", instanceID, errTags)
 		}
 	}
+	notifyMostWantedListings(userID, listed)
+	notifySavedSearchMatches(userID, forTrade)
 	return
 }
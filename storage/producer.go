@@ -0,0 +1,160 @@
+// producer.go
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+// storageEventDeviceID marks messages that originate from storage rather than
+// from a user device, so no connected client is skipped as the "sender".
+const storageEventDeviceID = "storage"
+
+var (
+	storageWriter *kafka.Writer
+	// reportWriter publishes trade message reports for moderation.
+	reportWriter    *kafka.Writer
+	storageWriterMu sync.RWMutex
+)
+
+// Package var so tests can capture published events without Kafka.
+var publishStorageEventFn = publishStorageEvent
+
+func initStorageProducer() {
+	events := AppConfig.Events
+	if events.StorageTopic == "" {
+		logrus.Warn("Storage events topic is not configured; storage-originated updates will not be published.")
+		return
+	}
+
+	storageWriterMu.Lock()
+	defer storageWriterMu.Unlock()
+
+	storageWriter = newTopicWriter(events.StorageTopic)
+	logrus.Infof("Storage producer configured for topic %s", events.StorageTopic)
+	if topic := AppConfig.Chat.ReportTopic; topic != "" {
+		reportWriter = newTopicWriter(topic)
+		logrus.Infof("Message reports are published to topic %s", topic)
+	}
+}
+
+func newTopicWriter(topic string) *kafka.Writer {
+	events := AppConfig.Events
+	return &kafka.Writer{
+		Addr:         kafka.TCP(fmt.Sprintf("%s:%s", events.Hostname, events.Port)),
+		Topic:        topic,
+		Balancer:     &kafka.LeastBytes{},
+		BatchTimeout: 10 * time.Millisecond,
+		RequiredAcks: kafka.RequireOne,
+		Transport: &kafka.Transport{
+			DialTimeout: 10 * time.Second,
+			IdleTimeout: 5 * time.Minute,
+		},
+	}
+}
+
+func closeStorageProducer() {
+	storageWriterMu.Lock()
+	defer storageWriterMu.Unlock()
+	for _, w := range []*kafka.Writer{storageWriter, reportWriter} {
+		if w == nil {
+			continue
+		}
+		if err := w.Close(); err != nil {
+			logrus.Errorf("Failed to close storage producer: %v", err)
+		}
+	}
+	storageWriter, reportWriter = nil, nil
+}
+
+// publishStorageEvent gzips and writes a batchedUpdates-shaped payload to the
+// storage events topic.
+func publishStorageEvent(payload map[string]interface{}) error {
+	storageWriterMu.RLock()
+	w := storageWriter
+	storageWriterMu.RUnlock()
+	if w == nil {
+		return errors.New("storage producer not initialized")
+	}
+
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal storage event: %w", err)
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(raw); err != nil {
+		return fmt.Errorf("compress storage event: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("compress storage event: %w", err)
+	}
+
+	key := fmt.Sprintf("%v", payload["user_id"])
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return w.WriteMessages(ctx, kafka.Message{
+		Key:   []byte(key),
+		Value: buf.Bytes(),
+		Time:  time.Now().UTC(),
+	})
+}
+
+// newStorageEvent builds the envelope shared by every storage-originated
+// message. The shape mirrors receiver's batchedUpdates so the events service
+// can reuse its trade fan-out logic.
+func newStorageEvent(userID, username, event string) map[string]interface{} {
+	return map[string]interface{}{
+		"user_id":   userID,
+		"username":  username,
+		"device_id": storageEventDeviceID,
+		"trace_id":  fmt.Sprintf("storage-%d", time.Now().UnixNano()),
+		"source":    "storage",
+		"event":     event,
+	}
+}
+
+// tradeUpdatePayload renders a trade the way clients send it in tradeUpdates.
+func tradeUpdatePayload(t Trade) map[string]interface{} {
+	return map[string]interface{}{
+		"key":       t.TradeID,
+		"operation": "update",
+		"tradeData": map[string]interface{}{
+			"trade_id":                            t.TradeID,
+			"trade_status":                        t.TradeStatus,
+			"username_proposed":                   t.UsernameProposed,
+			"username_accepting":                  t.UsernameAccepting,
+			"pokemon_instance_id_user_proposed":   t.PokemonInstanceIDUserProposed,
+			"pokemon_instance_id_user_accepting":  t.PokemonInstanceIDUserAccepting,
+			"trade_proposal_date":                 t.TradeProposalDate,
+			"trade_accepted_date":                 t.TradeAcceptedDate,
+			"trade_completed_date":                t.TradeCompletedDate,
+			"trade_cancelled_date":                t.TradeCancelledDate,
+			"trade_cancelled_by":                  t.TradeCancelledBy,
+			"trade_expired_date":                  t.TradeExpiredDate,
+			"counter_of_trade_id":                 t.CounterOfTradeID,
+			"trade_cycle_id":                      t.TradeCycleID,
+			"is_special_trade":                    t.IsSpecialTrade,
+			"is_registered_trade":                 t.IsRegisteredTrade,
+			"is_lucky_trade":                      t.IsLuckyTrade,
+			"trade_dust_cost":                     t.TradeDustCost,
+			"trade_friendship_level":              t.TradeFriendshipLevel,
+			"user_proposed_completion_confirmed":  t.UserProposedCompletionConfirmed,
+			"user_accepting_completion_confirmed": t.UserAcceptingCompletionConfirmed,
+			"user_1_trade_satisfaction":           t.User1TradeSatisfaction,
+			"user_2_trade_satisfaction":           t.User2TradeSatisfaction,
+			"last_update":                         t.LastUpdate,
+		},
+	}
+}
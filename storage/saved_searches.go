@@ -0,0 +1,508 @@
+// saved_searches.go
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Saved searches are written by the users service: a name, the parameters
+// /searchPokemon takes and alert settings. Storage checks every instance a
+// batch leaves up for trade against the saved searches for its species,
+// running each search's conditions over just those instances, and notifies
+// each owner whose search finds any, at most once per search every
+// notify_every_minutes.
+
+const notificationSavedSearchMatch = "saved_search_match"
+
+// savedSearchAlertInstances caps the instance ids one alert lists.
+const savedSearchAlertInstances = 10
+
+const createSavedSearchesTableSQL = `
+CREATE TABLE IF NOT EXISTS saved_searches (
+  search_id            BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  user_id              VARCHAR(255) NOT NULL,
+  name                 VARCHAR(100) NOT NULL,
+  pokemon_id           INT NOT NULL,
+  params               JSON NOT NULL,
+  muted                TINYINT(1) NOT NULL DEFAULT 0,
+  notify_every_minutes INT NOT NULL DEFAULT 60,
+  last_notified_at     DATETIME(6) NULL,
+  created_at           DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  updated_at           DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6) ON UPDATE CURRENT_TIMESTAMP(6),
+  KEY idx_saved_searches_user (user_id, search_id),
+  KEY idx_saved_searches_pokemon (pokemon_id, muted)
+)`
+
+func ensureSavedSearchesSchema() error {
+	if err := DB.Exec(createSavedSearchesTableSQL).Error; err != nil {
+		return fmt.Errorf("create saved_searches: %w", err)
+	}
+	return nil
+}
+
+// savedSearch is a saved search with its owner's username.
+type savedSearch struct {
+	SearchID uint64 `gorm:"column:search_id"`
+	UserID   string `gorm:"column:user_id"`
+	Username string `gorm:"column:username"`
+	Name     string `gorm:"column:name"`
+	Params   string `gorm:"column:params"`
+}
+
+// savedSearchQuery is the part of a saved search's parameters an alert
+// checks, read the way /searchPokemon reads them. Nil fields do not filter.
+type savedSearchQuery struct {
+	PokemonID  int
+	Shiny      *bool
+	Shadow     *bool
+	Dynamax    *bool
+	Gigantamax *bool
+	// CostumeIDs match listed costumes; CostumeNone also keeps
+	// costume-less instances. Without ids only costume-less instances
+	// match, as in search, unless CostumeAny is set.
+	CostumeIDs        []int
+	CostumeNone       bool
+	CostumeAny        bool
+	Gender            *string
+	AlreadyRegistered *bool
+	AttackIV          *int
+	DefenseIV         *int
+	StaminaIV         *int
+	BackgroundID      *int
+	PrefLucky         *bool
+	FriendshipLevel   *int
+	FastMoveIDs       []int
+	ChargedMove1ID    *int
+	ChargedMove2ID    *int
+	ChargedMoveIDs    []int
+	MinAttackIV       *int
+	MinDefenseIV      *int
+	MinStaminaIV      *int
+	// MinIVTotal and MaxIVTotal come from min_iv_percent / max_iv_percent.
+	MinIVTotal *int
+	MaxIVTotal *int
+	MinCP      *int
+	MaxCP      *int
+	MinLevel   *float64
+	MaxLevel   *float64
+	// CaughtFrom is inclusive, CaughtBefore the day after caught_to.
+	CaughtFrom   *time.Time
+	CaughtBefore *time.Time
+	Lucky        *bool
+	Purified     *bool
+	Traded       *bool
+	// Near is set with latitude and longitude; RangeKM defaults to 5.
+	Near    *[2]float64
+	RangeKM float64
+	// OnlyMatchingTrades needs a trade_matches pair from the owner to the
+	// instance's lister.
+	OnlyMatchingTrades bool
+}
+
+var errSavedSearchOwnership = errors.New("saved search is not for trade listings")
+
+// parseSavedSearchQuery reads stored parameters. Searches over caught or
+// wanted instances never alert and return errSavedSearchOwnership.
+func parseSavedSearchQuery(raw string) (savedSearchQuery, error) {
+	q := savedSearchQuery{RangeKM: 5}
+	var params map[string]string
+	if err := json.Unmarshal([]byte(raw), &params); err != nil {
+		return q, err
+	}
+	if o := params["ownership"]; o != "" && o != "trade" {
+		return q, errSavedSearchOwnership
+	}
+
+	var err error
+	optInt := func(key string) *int {
+		v, ok := params[key]
+		if !ok || v == "" || v == "null" || err != nil {
+			return nil
+		}
+		n, convErr := strconv.Atoi(v)
+		if convErr != nil {
+			err = fmt.Errorf("%s: %w", key, convErr)
+			return nil
+		}
+		return &n
+	}
+	optBool := func(key string) *bool {
+		v, ok := params[key]
+		if !ok || v == "" || err != nil {
+			return nil
+		}
+		b, convErr := strconv.ParseBool(v)
+		if convErr != nil {
+			err = fmt.Errorf("%s: %w", key, convErr)
+			return nil
+		}
+		return &b
+	}
+
+	pokemonID := optInt("pokemon_id")
+	if pokemonID == nil && err == nil {
+		return q, errors.New("pokemon_id is required")
+	}
+	if pokemonID != nil {
+		q.PokemonID = *pokemonID
+	}
+	q.Shiny = optBool("shiny")
+	q.Shadow = optBool("shadow")
+	q.Dynamax = optBool("dynamax")
+	q.Gigantamax = optBool("gigantamax")
+	optFloat := func(key string) *float64 {
+		v, ok := params[key]
+		if !ok || v == "" || err != nil {
+			return nil
+		}
+		f, convErr := strconv.ParseFloat(v, 64)
+		if convErr != nil {
+			err = fmt.Errorf("%s: %w", key, convErr)
+			return nil
+		}
+		return &f
+	}
+	optIDs := func(key string) []int {
+		v, ok := params[key]
+		if !ok || v == "" || err != nil {
+			return nil
+		}
+		var ids []int
+		for _, part := range strings.Split(v, ",") {
+			n, convErr := strconv.Atoi(strings.TrimSpace(part))
+			if convErr != nil {
+				err = fmt.Errorf("%s: %w", key, convErr)
+				return nil
+			}
+			ids = append(ids, n)
+		}
+		return ids
+	}
+	optDay := func(key string) *time.Time {
+		v, ok := params[key]
+		if !ok || v == "" || err != nil {
+			return nil
+		}
+		t, convErr := time.Parse("2006-01-02", v)
+		if convErr != nil {
+			err = fmt.Errorf("%s: %w", key, convErr)
+			return nil
+		}
+		return &t
+	}
+
+	switch v := params["costume_id"]; v {
+	case "", "null":
+		q.CostumeNone = true
+	case "any":
+		q.CostumeAny = true
+	default:
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part == "null" {
+				q.CostumeNone = true
+				continue
+			}
+			n, convErr := strconv.Atoi(part)
+			if convErr != nil {
+				return q, fmt.Errorf("costume_id: %w", convErr)
+			}
+			q.CostumeIDs = append(q.CostumeIDs, n)
+		}
+	}
+	if g := params["gender"]; g == "Male" || g == "Female" {
+		q.Gender = &g
+	}
+	q.AlreadyRegistered = optBool("already_registered")
+	q.AttackIV = optInt("attack_iv")
+	q.DefenseIV = optInt("defense_iv")
+	q.StaminaIV = optInt("stamina_iv")
+	q.BackgroundID = optInt("background_id")
+	q.PrefLucky = optBool("pref_lucky")
+	if fl := optInt("friendship_level"); fl != nil && *fl > 0 {
+		q.FriendshipLevel = fl
+	}
+	q.FastMoveIDs = optIDs("fast_move_id")
+	q.ChargedMove1ID = optInt("charged_move_1_id")
+	q.ChargedMove2ID = optInt("charged_move_2_id")
+	q.ChargedMoveIDs = optIDs("charged_move_ids")
+	q.MinAttackIV = optInt("min_attack_iv")
+	q.MinDefenseIV = optInt("min_defense_iv")
+	q.MinStaminaIV = optInt("min_stamina_iv")
+	if pct := optFloat("min_iv_percent"); pct != nil {
+		total := int(math.Ceil(*pct*45/100 - 1e-9))
+		q.MinIVTotal = &total
+	}
+	if pct := optFloat("max_iv_percent"); pct != nil {
+		total := int(math.Floor(*pct*45/100 + 1e-9))
+		q.MaxIVTotal = &total
+	}
+	q.MinCP = optInt("min_cp")
+	q.MaxCP = optInt("max_cp")
+	q.MinLevel = optFloat("min_level")
+	q.MaxLevel = optFloat("max_level")
+	q.CaughtFrom = optDay("caught_from")
+	if to := optDay("caught_to"); to != nil {
+		before := to.AddDate(0, 0, 1)
+		q.CaughtBefore = &before
+	}
+	q.Lucky = optBool("lucky")
+	q.Purified = optBool("purified")
+	q.Traded = optBool("traded")
+	if params["size"] != "" {
+		return q, errors.New("size is not supported in saved searches")
+	}
+	if omt := optBool("only_matching_trades"); omt != nil {
+		q.OnlyMatchingTrades = *omt
+	}
+	if params["latitude"] != "" && params["longitude"] != "" {
+		lat, latErr := strconv.ParseFloat(params["latitude"], 64)
+		lng, lngErr := strconv.ParseFloat(params["longitude"], 64)
+		if latErr != nil || lngErr != nil {
+			return q, errors.New("invalid latitude or longitude")
+		}
+		q.Near = &[2]float64{lat, lng}
+		if r := params["range_km"]; r != "" {
+			if q.RangeKM, err = strconv.ParseFloat(r, 64); err != nil {
+				return q, fmt.Errorf("range_km: %w", err)
+			}
+		}
+	}
+	return q, err
+}
+
+// savedSearchCond is one parameterized WHERE condition on instances.
+type savedSearchCond struct {
+	SQL  string
+	Args []interface{}
+}
+
+const savedSearchIVTotalExpr = "(instances.attack_iv + instances.defense_iv + instances.stamina_iv)"
+
+// savedSearchTradeMatchSQL is search's only_matching_trades condition: the
+// lister wants something the owner offers, unless the instance's
+// not_wanted_list rules that wanted instance out, within the pair's
+// max_distance_km between both trainers' blurred locations.
+var savedSearchTradeMatchSQL = `EXISTS (SELECT 1 FROM trade_matches m
+  WHERE m.wanted_user_id = instances.user_id AND m.trade_user_id = ?
+    AND COALESCE(JSON_CONTAINS_PATH(instances.not_wanted_list, 'one', CONCAT('$.', JSON_QUOTE(m.wanted_instance_id))), 0) = 0
+    AND (m.max_distance_km IS NULL OR EXISTS (SELECT 1 FROM users tu JOIN users wu ON wu.user_id = m.wanted_user_id
+      WHERE tu.user_id = m.trade_user_id AND tu.allow_location = 1 AND wu.allow_location = 1
+        AND tu.latitude IS NOT NULL AND tu.longitude IS NOT NULL AND wu.latitude IS NOT NULL AND wu.longitude IS NOT NULL
+        AND ST_Distance_Sphere(` + blurredPointSQL("tu.location") + `, ` + blurredPointSQL("wu.location") + `) <= m.max_distance_km * 1000)))`
+
+// savedSearchInRangeSQL keeps instances whose lister shares a location
+// with its blurred cell centre within range_km of the search's point, as
+// search measures it.
+var savedSearchInRangeSQL = `EXISTS (SELECT 1 FROM users lister
+  WHERE lister.user_id = instances.user_id AND lister.allow_location = 1
+    AND lister.latitude IS NOT NULL AND lister.longitude IS NOT NULL
+    AND ST_Distance_Sphere(` + blurredPointSQL("lister.location") + `, POINT(?, ?)) / 1000 <= ?)`
+
+// conds returns the WHERE conditions /searchPokemon applies for q, with
+// ownerID as the searching trainer. They read like search's own, so a
+// saved search alerts on exactly the listings it would find.
+func (q savedSearchQuery) conds(ownerID string) []savedSearchCond {
+	var out []savedSearchCond
+	add := func(sql string, args ...interface{}) {
+		out = append(out, savedSearchCond{SQL: sql, Args: args})
+	}
+
+	add("instances.is_for_trade = ?", true)
+	add("instances.pokemon_id = ?", q.PokemonID)
+	switch {
+	case q.CostumeAny:
+	case len(q.CostumeIDs) > 0 && q.CostumeNone:
+		add("(instances.costume_id IS NULL OR instances.costume_id IN ?)", q.CostumeIDs)
+	case len(q.CostumeIDs) > 0:
+		add("instances.costume_id IN ?", q.CostumeIDs)
+	default:
+		add("instances.costume_id IS NULL")
+	}
+	if len(q.FastMoveIDs) > 0 {
+		add("instances.fast_move_id IN ?", q.FastMoveIDs)
+	}
+	if len(q.ChargedMoveIDs) > 0 {
+		add("(instances.charged_move1_id IN ? OR instances.charged_move2_id IN ?)", q.ChargedMoveIDs, q.ChargedMoveIDs)
+	}
+	for _, f := range []struct {
+		col string
+		v   *bool
+	}{
+		{"shiny", q.Shiny}, {"shadow", q.Shadow}, {"dynamax", q.Dynamax}, {"gigantamax", q.Gigantamax},
+		{"registered", q.AlreadyRegistered}, {"pref_lucky", q.PrefLucky},
+		{"lucky", q.Lucky}, {"purified", q.Purified}, {"is_traded", q.Traded},
+	} {
+		if f.v != nil {
+			add("instances."+f.col+" = ?", *f.v)
+		}
+	}
+	if q.Gender != nil {
+		add("instances.gender = ?", *q.Gender)
+	}
+	for _, f := range []struct {
+		cond string
+		v    *int
+	}{
+		{"instances.attack_iv = ?", q.AttackIV}, {"instances.defense_iv = ?", q.DefenseIV},
+		{"instances.stamina_iv = ?", q.StaminaIV}, {"instances.location_card = ?", q.BackgroundID},
+		{"instances.friendship_level = ?", q.FriendshipLevel},
+		{"instances.attack_iv >= ?", q.MinAttackIV}, {"instances.defense_iv >= ?", q.MinDefenseIV},
+		{"instances.stamina_iv >= ?", q.MinStaminaIV},
+		{savedSearchIVTotalExpr + " >= ?", q.MinIVTotal}, {savedSearchIVTotalExpr + " <= ?", q.MaxIVTotal},
+		{"instances.cp >= ?", q.MinCP}, {"instances.cp <= ?", q.MaxCP},
+	} {
+		if f.v != nil {
+			add(f.cond, *f.v)
+		}
+	}
+	if q.MinLevel != nil {
+		add("instances.level >= ?", *q.MinLevel)
+	}
+	if q.MaxLevel != nil {
+		add("instances.level <= ?", *q.MaxLevel)
+	}
+	if q.CaughtFrom != nil {
+		add("instances.date_caught >= ?", *q.CaughtFrom)
+	}
+	if q.CaughtBefore != nil {
+		add("instances.date_caught < ?", *q.CaughtBefore)
+	}
+	switch m1, m2 := q.ChargedMove1ID, q.ChargedMove2ID; {
+	case m1 != nil && m2 != nil:
+		add("((instances.charged_move1_id = ? AND instances.charged_move2_id = ?) OR (instances.charged_move1_id = ? AND instances.charged_move2_id = ?))",
+			*m1, *m2, *m2, *m1)
+	case m1 != nil || m2 != nil:
+		m := m1
+		if m == nil {
+			m = m2
+		}
+		add("(instances.charged_move1_id = ? OR instances.charged_move2_id = ?)", *m, *m)
+	}
+	if q.Near != nil {
+		add(savedSearchInRangeSQL, q.Near[1], q.Near[0], q.RangeKM)
+	}
+	if q.OnlyMatchingTrades {
+		add("instances.user_id <> ?", ownerID)
+		add(savedSearchTradeMatchSQL, ownerID)
+	}
+	return out
+}
+
+// matchingInstanceIDs runs q as ownerID over instanceIDs and returns the
+// ids it finds, in id order.
+func matchingInstanceIDs(q savedSearchQuery, ownerID string, instanceIDs []string) ([]string, error) {
+	query := DB.Table("instances").Where("instances.instance_id IN ?", instanceIDs)
+	for _, c := range q.conds(ownerID) {
+		query = query.Where(c.SQL, c.Args...)
+	}
+	var ids []string
+	err := query.Order("instances.instance_id").Pluck("instances.instance_id", &ids).Error
+	return ids, err
+}
+
+// savedSearchNotification builds the alert for one search and the
+// instances of one lister it matched.
+func savedSearchNotification(s savedSearch, lister string, pokemonID int, instanceIDs []string) Notification {
+	listed := instanceIDs
+	if len(listed) > savedSearchAlertInstances {
+		listed = listed[:savedSearchAlertInstances]
+	}
+	n := newNotification(s.UserID, s.Username, notificationSavedSearchMatch, lister,
+		fmt.Sprintf("saved_search:%d:%s", s.SearchID, instanceIDs[0]),
+		map[string]interface{}{
+			"saved_search_id": strconv.FormatUint(s.SearchID, 10),
+			"name":            s.Name,
+			"pokemon_id":      pokemonID,
+			"username":        lister,
+			"instance_ids":    listed,
+			"match_count":     len(instanceIDs),
+		})
+	first := instanceIDs[0]
+	n.InstanceID = &first
+	return n
+}
+
+// notifySavedSearchMatches checks the instances lister left up for trade
+// in one batch against the saved searches for their species. Failures are
+// logged and never fail the batch.
+func notifySavedSearchMatches(listerID string, instanceIDs []string) {
+	if len(instanceIDs) == 0 {
+		return
+	}
+	var listed []struct {
+		InstanceID string `gorm:"column:instance_id"`
+		PokemonID  int    `gorm:"column:pokemon_id"`
+	}
+	if err := DB.Table("instances").Select("instance_id, pokemon_id").
+		Where("instance_id IN ? AND is_for_trade = ?", instanceIDs, true).Find(&listed).Error; err != nil {
+		logrus.Warnf("Skipping saved search alerts for user %s: %v", listerID, err)
+		return
+	}
+	if len(listed) == 0 {
+		return
+	}
+	var lister string
+	if err := DB.Table("users").Where("user_id = ?", listerID).Pluck("username", &lister).Error; err != nil || lister == "" {
+		logrus.Warnf("Skipping saved search alerts for user %s: %v", listerID, err)
+		return
+	}
+
+	ids := make([]string, 0, len(listed))
+	species := make([]int, 0, len(listed))
+	for _, l := range listed {
+		ids = append(ids, l.InstanceID)
+		species = append(species, l.PokemonID)
+	}
+	var searches []savedSearch
+	if err := DB.Table("saved_searches s").
+		Select("s.search_id, s.user_id, u.username, s.name, s.params").
+		Joins("JOIN users u ON u.user_id = s.user_id").
+		Where("s.pokemon_id IN ? AND s.muted = 0 AND s.user_id <> ?", species, listerID).
+		Find(&searches).Error; err != nil {
+		logrus.Warnf("Failed to load saved searches for user %s's listings: %v", listerID, err)
+		return
+	}
+
+	now := time.Now().UTC()
+	var notes []Notification
+	for _, s := range searches {
+		q, err := parseSavedSearchQuery(s.Params)
+		if err != nil {
+			if !errors.Is(err, errSavedSearchOwnership) {
+				logrus.Warnf("Skipping saved search %d: %v", s.SearchID, err)
+			}
+			continue
+		}
+		matched, err := matchingInstanceIDs(q, s.UserID, ids)
+		if err != nil {
+			logrus.Warnf("Failed to run saved search %d: %v", s.SearchID, err)
+			continue
+		}
+		if len(matched) == 0 {
+			continue
+		}
+		// Claim the alert slot; a search alerted within its interval stays quiet.
+		res := DB.Exec(`UPDATE saved_searches SET last_notified_at = ?
+			 WHERE search_id = ? AND muted = 0
+			   AND (last_notified_at IS NULL OR last_notified_at <= DATE_SUB(?, INTERVAL notify_every_minutes MINUTE))`,
+			now, s.SearchID, now)
+		if res.Error != nil {
+			logrus.Warnf("Failed to throttle saved search %d: %v", s.SearchID, res.Error)
+			continue
+		}
+		if res.RowsAffected == 0 {
+			continue
+		}
+		notes = append(notes, savedSearchNotification(s, lister, q.PokemonID, matched))
+	}
+	recordNotifications(notes)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestParseSavedSearchQuery(t *testing.T) {
+	q, err := parseSavedSearchQuery(`{"pokemon_id":"443","shiny":"true","costume_id":"null","gender":"Any","latitude":"52.5","longitude":"13.4","range_km":"10","only_matching_trades":"true","sort":"distance"}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if q.PokemonID != 443 || q.Shiny == nil || !*q.Shiny || !q.CostumeNone || q.CostumeIDs != nil || q.Gender != nil {
+		t.Fatalf("unexpected query: %+v", q)
+	}
+	if q.Near == nil || q.RangeKM != 10 || !q.OnlyMatchingTrades {
+		t.Fatalf("unexpected location or matching: %+v", q)
+	}
+
+	if _, err := parseSavedSearchQuery(`{"pokemon_id":"1","ownership":"wanted"}`); !errors.Is(err, errSavedSearchOwnership) {
+		t.Fatalf("expected wanted searches to be skipped, got %v", err)
+	}
+	for _, bad := range []string{`{"shiny":"true"}`, `{"pokemon_id":"x"}`, `{"pokemon_id":"1","shadow":"maybe"}`, `not json`,
+		`{"pokemon_id":"1","costume_id":"3,x"}`, `{"pokemon_id":"1","caught_from":"May 1"}`, `{"pokemon_id":"1","size":"xxl"}`} {
+		if _, err := parseSavedSearchQuery(bad); err == nil {
+			t.Fatalf("expected %s to be rejected", bad)
+		}
+	}
+}
+
+func TestSavedSearchQueryConds(t *testing.T) {
+	q, err := parseSavedSearchQuery(`{"pokemon_id":"443","shiny":"true","costume_id":"null,5","charged_move_1_id":"20",` +
+		`"min_iv_percent":"82.2","caught_to":"2024-01-31","latitude":"52.5","longitude":"13.4","range_km":"10","only_matching_trades":"true"}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conds := map[string][]interface{}{}
+	for _, c := range q.conds("u-owner") {
+		conds[c.SQL] = c.Args
+	}
+	for sql, args := range map[string][]interface{}{
+		"instances.is_for_trade = ?":                                         {true},
+		"instances.pokemon_id = ?":                                           {443},
+		"(instances.costume_id IS NULL OR instances.costume_id IN ?)":        {[]int{5}},
+		"instances.shiny = ?":                                                {true},
+		"(instances.charged_move1_id = ? OR instances.charged_move2_id = ?)": {20, 20},
+		savedSearchIVTotalExpr + " >= ?":                                     {37},
+		"instances.date_caught < ?":                                          {time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		savedSearchInRangeSQL:                                                {13.4, 52.5, 10.0},
+		"instances.user_id <> ?":                                             {"u-owner"},
+		savedSearchTradeMatchSQL:                                             {"u-owner"},
+	} {
+		got, ok := conds[sql]
+		if !ok || fmt.Sprint(got) != fmt.Sprint(args) {
+			t.Fatalf("expected %q with %v, got %v (present=%v)", sql, args, got, ok)
+		}
+	}
+	if len(conds) != 10 {
+		t.Fatalf("expected only the saved filters, got %d conditions", len(conds))
+	}
+
+	one, two := 1, 2
+	both := savedSearchQuery{PokemonID: 1, CostumeAny: true, ChargedMove1ID: &one, ChargedMove2ID: &two}.conds("u-owner")
+	if len(both) != 3 || fmt.Sprint(both[2].Args) != "[1 2 2 1]" {
+		t.Fatalf("expected both charged moves in either order, got %+v", both)
+	}
+}
+
+func TestSavedSearchSQL_MatchesSearchPrivacyAndLists(t *testing.T) {
+	for _, want := range []string{
+		"JSON_QUOTE(m.wanted_instance_id)", "m.max_distance_km", "tu.allow_location = 1 AND wu.allow_location = 1",
+		blurredPointSQL("tu.location"), blurredPointSQL("wu.location"),
+	} {
+		if !strings.Contains(savedSearchTradeMatchSQL, want) {
+			t.Fatalf("expected the trade match condition to contain %q", want)
+		}
+	}
+	if !strings.Contains(savedSearchInRangeSQL, "lister.allow_location = 1") ||
+		!strings.Contains(savedSearchInRangeSQL, blurredPointSQL("lister.location")) {
+		t.Fatalf("expected the range to use the lister's blurred, shared location")
+	}
+}
+
+func TestMatchingInstanceIDs(t *testing.T) {
+	mock := setupMockDB(t)
+	q := savedSearchQuery{PokemonID: 443, CostumeAny: true, OnlyMatchingTrades: true}
+	mock.ExpectQuery("SELECT `instances`.`instance_id` FROM `instances` WHERE instances.instance_id IN \\(\\?,\\?\\) AND "+
+		"instances.is_for_trade = \\? AND instances.pokemon_id = \\? AND instances.user_id <> \\? AND \\(EXISTS \\(SELECT 1 FROM trade_matches m.*not_wanted_list.*"+
+		"ORDER BY instances.instance_id").
+		WithArgs("i-1", "i-2", true, 443, "u-owner", "u-owner").
+		WillReturnRows(sqlmock.NewRows([]string{"instance_id"}).AddRow("i-2"))
+
+	ids, err := matchingInstanceIDs(q, "u-owner", []string{"i-1", "i-2"})
+	if err != nil || len(ids) != 1 || ids[0] != "i-2" {
+		t.Fatalf("expected only i-2, got %v err=%v", ids, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestSavedSearchNotification(t *testing.T) {
+	ids := []string{"i1", "i2", "i3", "i4", "i5", "i6", "i7", "i8", "i9", "i10", "i11"}
+	n := savedSearchNotification(savedSearch{SearchID: 7, UserID: "u1", Username: "ash", Name: "Gible"}, "misty", 443, ids)
+	if n.NotificationType != notificationSavedSearchMatch || n.DedupeKey != "saved_search:7:i1" || n.Username != "ash" {
+		t.Fatalf("unexpected notification: %+v", n)
+	}
+	if n.InstanceID == nil || *n.InstanceID != "i1" || n.ActorUsername == nil || *n.ActorUsername != "misty" {
+		t.Fatalf("unexpected instance or actor: %+v", n)
+	}
+	if listed := n.Data["instance_ids"].([]string); len(listed) != savedSearchAlertInstances || n.Data["match_count"] != 11 {
+		t.Fatalf("expected 10 listed ids of 11, got %v / %v", listed, n.Data["match_count"])
+	}
+}
@@ -75,6 +75,27 @@ func columnExists(tableName, columnName string) (bool, error) {
 	return count > 0, nil
 }
 
+// columnType is the COLUMN_TYPE of table.column, e.g. "tinyint(1)", or ""
+// when the column does not exist.
+func columnType(tableName, columnName string) (string, error) {
+	var types []string
+	if err := DB.Raw(
+		`SELECT COLUMN_TYPE
+		   FROM information_schema.columns
+		  WHERE table_schema = DATABASE()
+		    AND table_name = ?
+		    AND column_name = ?`,
+		tableName,
+		columnName,
+	).Scan(&types).Error; err != nil {
+		return "", err
+	}
+	if len(types) == 0 {
+		return "", nil
+	}
+	return strings.ToLower(types[0]), nil
+}
+
 func loadInstanceColumns() error {
 	type row struct {
 		ColumnName string `gorm:"column:COLUMN_NAME"`
@@ -114,3 +135,240 @@ func filterInstanceColumns(fields map[string]interface{}) map[string]interface{}
 	}
 	return out
 }
+
+// addedColumn is a column storage adds to an existing table on startup.
+type addedColumn struct {
+	Name       string
+	Definition string
+}
+
+// tradeAddedColumns are added to trades on startup when missing.
+var tradeAddedColumns = []addedColumn{
+	// Owned by the expiry scheduler; never written from client payloads.
+	{Name: "trade_expired_date", Definition: "DATETIME NULL"},
+	{Name: "trade_reminder_sent_date", Definition: "DATETIME NULL"},
+	// Links a counter-offer to the proposal it replaces.
+	{Name: "counter_of_trade_id", Definition: "VARCHAR(255) NULL"},
+	// What the client claimed before storage derived the cost itself.
+	{Name: "client_trade_dust_cost", Definition: "INT NULL"},
+	{Name: "trade_terms_mismatch", Definition: "TINYINT(1) NOT NULL DEFAULT 0"},
+	// Links the legs of a trade cycle; set by storage only.
+	{Name: "trade_cycle_id", Definition: "VARCHAR(255) NULL"},
+}
+
+const createTradeItemsTableSQL = `
+CREATE TABLE IF NOT EXISTS trade_items (
+  trade_id    VARCHAR(255) NOT NULL,
+  instance_id VARCHAR(255) NOT NULL,
+  side        ENUM('proposed', 'accepting') NOT NULL,
+  position    INT NOT NULL DEFAULT 0,
+  created_at  DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  PRIMARY KEY (trade_id, instance_id),
+  KEY idx_trade_items_instance (instance_id)
+)`
+
+const createTradeRatingsTableSQL = `
+CREATE TABLE IF NOT EXISTS trade_ratings (
+  trade_id      VARCHAR(255) NOT NULL,
+  rater_user_id VARCHAR(255) NOT NULL,
+  ratee_user_id VARCHAR(255) NOT NULL,
+  score         TINYINT NOT NULL,
+  comment       VARCHAR(500) NULL,
+  created_at    DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  PRIMARY KEY (trade_id, rater_user_id),
+  KEY idx_trade_ratings_ratee (ratee_user_id)
+)`
+
+const createTrainerReputationTableSQL = `
+CREATE TABLE IF NOT EXISTS trainer_reputation (
+  user_id           VARCHAR(255) NOT NULL PRIMARY KEY,
+  rating_average    DOUBLE NULL,
+  rating_count      INT NOT NULL DEFAULT 0,
+  trades_completed  INT NOT NULL DEFAULT 0,
+  trades_cancelled  INT NOT NULL DEFAULT 0,
+  completion_rate   DOUBLE NULL,
+  cancellation_rate DOUBLE NULL,
+  updated_at        DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)
+)`
+
+const createNotificationsTableSQL = `
+CREATE TABLE IF NOT EXISTS notifications (
+  notification_id   BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  user_id           VARCHAR(255) NOT NULL,
+  notification_type VARCHAR(32) NOT NULL,
+  actor_username    VARCHAR(255) NULL,
+  trade_id          VARCHAR(255) NULL,
+  instance_id       VARCHAR(255) NULL,
+  payload           JSON NULL,
+  dedupe_key        VARCHAR(512) NOT NULL,
+  created_at        DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  read_at           DATETIME(6) NULL,
+  dismissed_at      DATETIME(6) NULL,
+  UNIQUE KEY uq_notifications_dedupe (dedupe_key),
+  KEY idx_notifications_inbox (user_id, dismissed_at, notification_id)
+)`
+
+const createNotificationPreferencesTableSQL = `
+CREATE TABLE IF NOT EXISTS notification_preferences (
+  user_id           VARCHAR(255) NOT NULL,
+  notification_type VARCHAR(32) NOT NULL,
+  enabled           TINYINT(1) NOT NULL DEFAULT 1,
+  updated_at        DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  PRIMARY KEY (user_id, notification_type)
+)`
+
+// userAddedColumns are added to users on startup when missing.
+var userAddedColumns = []addedColumn{
+	// Opt-in for showing online / recently active to other trainers.
+	{Name: "share_presence", Definition: "TINYINT(1) NOT NULL DEFAULT 0"},
+}
+
+// userLocationColumn mirrors users.latitude/longitude as a point, so
+// proximity searches can use a SPATIAL index (which needs NOT NULL and an
+// SRID). Users without a location sit at (0, 0); searches still require
+// latitude and longitude to be set.
+var userLocationColumn = addedColumn{
+	Name:       "location",
+	Definition: "POINT SRID 0 GENERATED ALWAYS AS (POINT(COALESCE(longitude, 0), COALESCE(latitude, 0))) STORED NOT NULL",
+}
+
+// user_presence is written by the events replicas: one row per user and
+// replica, heartbeated while the user has live connections there.
+const createUserPresenceTableSQL = `
+CREATE TABLE IF NOT EXISTS user_presence (
+  user_id      VARCHAR(255) NOT NULL,
+  instance_id  VARCHAR(255) NOT NULL,
+  connections  INT NOT NULL DEFAULT 0,
+  last_seen_at DATETIME(6) NOT NULL,
+  PRIMARY KEY (user_id, instance_id),
+  KEY idx_user_presence_seen (last_seen_at)
+)`
+
+// tagAddedColumns are added to tags on startup when missing.
+var tagAddedColumns = []addedColumn{
+	// Nests a custom tag under another tag of the same bucket.
+	{Name: "parent_tag_id", Definition: "VARCHAR(255) NULL"},
+	{Name: "updated_at", Definition: "DATETIME(6) NULL"},
+	{Name: "deleted_at", Definition: "DATETIME(6) NULL"},
+}
+
+func addMissingColumns(table string, cols []addedColumn) error {
+	for _, col := range cols {
+		exists, err := columnExists(table, col.Name)
+		if err != nil {
+			return fmt.Errorf("check %s.%s: %w", table, col.Name, err)
+		}
+		if exists {
+			continue
+		}
+		if err := DB.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, col.Name, col.Definition)).Error; err != nil {
+			return fmt.Errorf("add %s.%s: %w", table, col.Name, err)
+		}
+		logrus.Infof("Added %s.%s column", table, col.Name)
+	}
+	return nil
+}
+
+func ensureTradesSchema() error {
+	if err := addMissingColumns("trades", tradeAddedColumns); err != nil {
+		return err
+	}
+	exists, err := indexExists("trades", "idx_trades_cycle")
+	if err != nil {
+		return fmt.Errorf("check index idx_trades_cycle: %w", err)
+	}
+	if !exists {
+		if err := DB.Exec("CREATE INDEX idx_trades_cycle ON trades (trade_cycle_id)").Error; err != nil {
+			return fmt.Errorf("create index idx_trades_cycle: %w", err)
+		}
+		logrus.Infof("Added index idx_trades_cycle on trades")
+	}
+	if err := DB.Exec(createTradeItemsTableSQL).Error; err != nil {
+		return fmt.Errorf("create trade_items: %w", err)
+	}
+	if err := DB.Exec(createTradeRatingsTableSQL).Error; err != nil {
+		return fmt.Errorf("create trade_ratings: %w", err)
+	}
+	if err := DB.Exec(createTrainerReputationTableSQL).Error; err != nil {
+		return fmt.Errorf("create trainer_reputation: %w", err)
+	}
+	return migrateTradeSatisfactionColumns()
+}
+
+// migrateTradeSatisfactionColumns turns the legacy thumbs-up flags in
+// trades.user_N_trade_satisfaction into the rater's 1-5 score. A legacy
+// true is not a rating, so each column is rewritten from trade_ratings (NULL
+// where that side has not rated) before the column type changes; a restart
+// between the two steps just reruns the rewrite.
+func migrateTradeSatisfactionColumns() error {
+	typ, err := columnType("trades", "user_1_trade_satisfaction")
+	if err != nil {
+		return fmt.Errorf("check trades.user_1_trade_satisfaction: %w", err)
+	}
+	if typ != "tinyint(1)" {
+		return nil
+	}
+	if err := DB.Exec(migrateTradeSatisfactionSQL).Error; err != nil {
+		return fmt.Errorf("rewrite trade satisfaction from trade_ratings: %w", err)
+	}
+	if err := DB.Exec(`ALTER TABLE trades
+		MODIFY user_1_trade_satisfaction TINYINT UNSIGNED NULL,
+		MODIFY user_2_trade_satisfaction TINYINT UNSIGNED NULL`).Error; err != nil {
+		return fmt.Errorf("widen trade satisfaction columns: %w", err)
+	}
+	logrus.Infof("Migrated trades.user_1/2_trade_satisfaction to 1-5 scores")
+	return nil
+}
+
+const migrateTradeSatisfactionSQL = `
+UPDATE trades t
+   SET t.user_1_trade_satisfaction = (
+         SELECT r.score FROM trade_ratings r
+          WHERE r.trade_id = t.trade_id AND r.rater_user_id = t.user_id_proposed),
+       t.user_2_trade_satisfaction = (
+         SELECT r.score FROM trade_ratings r
+          WHERE r.trade_id = t.trade_id AND r.rater_user_id = t.user_id_accepting)
+ WHERE t.user_1_trade_satisfaction IS NOT NULL
+    OR t.user_2_trade_satisfaction IS NOT NULL`
+
+func ensureTagsSchema() error {
+	return addMissingColumns("tags", tagAddedColumns)
+}
+
+func ensureNotificationsSchema() error {
+	if err := DB.Exec(createNotificationsTableSQL).Error; err != nil {
+		return fmt.Errorf("create notifications: %w", err)
+	}
+	if err := DB.Exec(createNotificationPreferencesTableSQL).Error; err != nil {
+		return fmt.Errorf("create notification_preferences: %w", err)
+	}
+	return nil
+}
+
+func ensureUserLocationSchema() error {
+	if err := addMissingColumns("users", []addedColumn{userLocationColumn}); err != nil {
+		return err
+	}
+	exists, err := indexExists("users", "idx_users_location")
+	if err != nil {
+		return fmt.Errorf("check index idx_users_location: %w", err)
+	}
+	if exists {
+		return nil
+	}
+	if err := DB.Exec("CREATE SPATIAL INDEX idx_users_location ON users (location)").Error; err != nil {
+		return fmt.Errorf("create index idx_users_location: %w", err)
+	}
+	logrus.Infof("Added index idx_users_location on users")
+	return nil
+}
+
+func ensurePresenceSchema() error {
+	if err := addMissingColumns("users", userAddedColumns); err != nil {
+		return err
+	}
+	if err := DB.Exec(createUserPresenceTableSQL).Error; err != nil {
+		return fmt.Errorf("create user_presence: %w", err)
+	}
+	return nil
+}
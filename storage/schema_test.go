@@ -1,6 +1,11 @@
 package main
 
-import "testing"
+import (
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
 
 func TestRequiredMissingInstanceColumns(t *testing.T) {
 	prevColumns := instanceColumns
@@ -96,3 +101,49 @@ func TestRequiredMissingInstanceColumnsDetectsIsCaught(t *testing.T) {
 		t.Fatalf("expected is_caught to be reported missing, got %#v", missing)
 	}
 }
+
+func TestChangeSeqTriggerSQL(t *testing.T) {
+	sql := changeSeqTriggerSQL("trades", "UPDATE")
+	for _, want := range []string{
+		"CREATE TRIGGER IF NOT EXISTS trades_change_seq_update",
+		"BEFORE UPDATE ON trades",
+		"SET value = LAST_INSERT_ID(value + 1) WHERE id = 1",
+		"SET NEW.change_seq = LAST_INSERT_ID()",
+	} {
+		if !strings.Contains(sql, want) {
+			t.Fatalf("expected %q in trigger:\n%s", want, sql)
+		}
+	}
+}
+
+func TestMigrateTradeSatisfactionColumns_RewritesLegacyFlags(t *testing.T) {
+	mock := setupMockDB(t)
+	mock.ExpectQuery(`SELECT COLUMN_TYPE`).
+		WithArgs("trades", "user_1_trade_satisfaction").
+		WillReturnRows(sqlmock.NewRows([]string{"COLUMN_TYPE"}).AddRow("tinyint(1)"))
+	mock.ExpectExec(`UPDATE trades t\s+SET t.user_1_trade_satisfaction = \(\s+SELECT r.score FROM trade_ratings r`).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(`ALTER TABLE trades\s+MODIFY user_1_trade_satisfaction TINYINT UNSIGNED NULL`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if err := migrateTradeSatisfactionColumns(); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestMigrateTradeSatisfactionColumns_SkipsScoreColumns(t *testing.T) {
+	mock := setupMockDB(t)
+	mock.ExpectQuery(`SELECT COLUMN_TYPE`).
+		WithArgs("trades", "user_1_trade_satisfaction").
+		WillReturnRows(sqlmock.NewRows([]string{"COLUMN_TYPE"}).AddRow("tinyint unsigned"))
+
+	if err := migrateTradeSatisfactionColumns(); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
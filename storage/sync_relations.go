@@ -162,7 +162,7 @@ func filterValidUserTagIDs(db *gorm.DB, userID string, tagIDs []string) ([]strin
 	var valid []string
 	if err := db.
 		Table("tags").
-		Where("user_id = ? AND tag_id IN ?", userID, tagIDs).
+		Where("user_id = ? AND tag_id IN ? AND deleted_at IS NULL", userID, tagIDs).
 		Pluck("tag_id", &valid).
 		Error; err != nil {
 		return nil, err
@@ -0,0 +1,314 @@
+// tag_updates.go
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const maxTagNameLength = 64
+
+var (
+	errTagNotFound     = errors.New("tag not found")
+	errTagNotOwned     = errors.New("tag belongs to another user")
+	errTagSystem       = errors.New("system tags cannot be renamed, nested or deleted")
+	errTagReservedName = errors.New("name is reserved for a system tag")
+	errTagBucketChange = errors.New("a tag cannot move to another parent bucket")
+	errTagBadParentTag = errors.New("parent tag must be another live tag in the same bucket")
+	errTagCycle        = errors.New("nesting would create a cycle")
+)
+
+var tagColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// Tag mirrors the "tags" table. Parent is the bucket (caught, trade or
+// wanted); ParentTagID nests a custom tag under another tag of that bucket.
+type Tag struct {
+	TagID       string     `gorm:"column:tag_id;primaryKey"`
+	UserID      string     `gorm:"column:user_id"`
+	Parent      string     `gorm:"column:parent"`
+	ParentTagID *string    `gorm:"column:parent_tag_id"`
+	Name        string     `gorm:"column:name"`
+	Color       *string    `gorm:"column:color"`
+	Sort        *int       `gorm:"column:sort"`
+	CreatedAt   time.Time  `gorm:"column:created_at"`
+	UpdatedAt   *time.Time `gorm:"column:updated_at"`
+	DeletedAt   *time.Time `gorm:"column:deleted_at"`
+}
+
+func (Tag) TableName() string {
+	return "tags"
+}
+
+// tagUpdateInput is one entry of the batched "tagUpdates" array. Update only
+// touches the fields present in tagData, so rename, recolor, reorder and
+// nesting are all partial updates.
+type tagUpdateInput struct {
+	Operation   string
+	TagID       string
+	Parent      *string
+	Name        *string
+	Color       *string
+	HasColor    bool
+	Sort        *int
+	ParentTagID *string
+	HasParent   bool
+}
+
+func isTagBucket(parent string) bool {
+	switch parent {
+	case "caught", "trade", "wanted":
+		return true
+	}
+	return false
+}
+
+func parseTagUpdate(raw interface{}) (tagUpdateInput, error) {
+	obj, ok := raw.(map[string]interface{})
+	if !ok {
+		return tagUpdateInput{}, errors.New("tag update is not an object")
+	}
+	tagData, _ := obj["tagData"].(map[string]interface{})
+	if tagData == nil {
+		tagData = map[string]interface{}{}
+	}
+
+	in := tagUpdateInput{Operation: strings.ToLower(strings.TrimSpace(fmt.Sprintf("%v", obj["operation"])))}
+	switch in.Operation {
+	case "create", "update", "delete":
+	default:
+		return tagUpdateInput{}, fmt.Errorf("unknown operation %q", in.Operation)
+	}
+
+	in.TagID = strings.TrimSpace(fmt.Sprintf("%v", tagData["tag_id"]))
+	if !isPresentID(in.TagID) {
+		in.TagID = strings.TrimSpace(fmt.Sprintf("%v", obj["key"]))
+	}
+	if !isPresentID(in.TagID) {
+		return tagUpdateInput{}, errors.New("missing tag_id")
+	}
+	if in.Operation == "delete" {
+		return in, nil
+	}
+
+	if v, ok := tagData["parent"]; ok && v != nil {
+		parent := strings.ToLower(strings.TrimSpace(fmt.Sprintf("%v", v)))
+		if !isTagBucket(parent) {
+			return tagUpdateInput{}, fmt.Errorf("parent must be caught, trade or wanted, got %q", parent)
+		}
+		in.Parent = &parent
+	}
+	if v, ok := tagData["name"]; ok && v != nil {
+		name := strings.TrimSpace(fmt.Sprintf("%v", v))
+		if name == "" {
+			return tagUpdateInput{}, errors.New("name cannot be empty")
+		}
+		if len([]rune(name)) > maxTagNameLength {
+			return tagUpdateInput{}, fmt.Errorf("name longer than %d characters", maxTagNameLength)
+		}
+		in.Name = &name
+	}
+	if v, ok := tagData["color"]; ok {
+		in.HasColor = true
+		if color := parseNullableString(v); color != nil {
+			if !tagColorPattern.MatchString(*color) {
+				return tagUpdateInput{}, fmt.Errorf("color must look like #rrggbb, got %q", *color)
+			}
+			in.Color = color
+		}
+	}
+	if v, ok := tagData["sort"]; ok && v != nil {
+		sort := parseNullableInt(v)
+		if sort == nil {
+			return tagUpdateInput{}, errors.New("sort must be an integer")
+		}
+		in.Sort = sort
+	}
+	if v, ok := tagData["parent_tag_id"]; ok {
+		in.HasParent = true
+		if id := strings.TrimSpace(fmt.Sprintf("%v", v)); isPresentID(id) {
+			if id == in.TagID {
+				return tagUpdateInput{}, errTagCycle
+			}
+			in.ParentTagID = &id
+		}
+	}
+
+	if in.Operation == "create" && (in.Parent == nil || in.Name == nil) {
+		return tagUpdateInput{}, errors.New("create requires parent and name")
+	}
+	return in, nil
+}
+
+// isSystemTag reports whether parent/name is one of the default tags storage
+// maintains itself.
+func isSystemTag(parent, name string) bool {
+	for _, def := range defaultSystemTagDefs {
+		if def.Parent == parent && def.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// tagNestingCycles reports whether hanging tagID under newParentID would make
+// tagID its own ancestor. parents maps each tag to its current parent tag.
+func tagNestingCycles(parents map[string]string, tagID, newParentID string) bool {
+	seen := map[string]bool{}
+	for id := newParentID; id != ""; id = parents[id] {
+		if id == tagID || seen[id] {
+			return true
+		}
+		seen[id] = true
+	}
+	return false
+}
+
+// parseAndApplyTagUpdates stores the sender's tag changes. It runs before
+// Pokemon updates so instances in the same batch can reference new tags.
+func parseAndApplyTagUpdates(data map[string]interface{}, userID string) (applied, rejected int) {
+	items, _ := data["tagUpdates"].([]interface{})
+	if len(items) == 0 {
+		return 0, 0
+	}
+	if err := ensureDefaultSystemTagsForUser(DB, userID); err != nil {
+		logrus.Warnf("Failed to ensure system tags for user %s: %v", userID, err)
+	}
+
+	for _, raw := range items {
+		in, err := parseTagUpdate(raw)
+		if err != nil {
+			logrus.Warnf("Skipping tag update from user %s: %v", userID, err)
+			rejected++
+			continue
+		}
+		if err := DB.Transaction(func(tx *gorm.DB) error {
+			return applyTagUpdate(tx, userID, in)
+		}); err != nil {
+			logrus.Warnf("Rejected %s of tag %s by user %s: %v", in.Operation, in.TagID, userID, err)
+			rejected++
+			continue
+		}
+		applied++
+	}
+	return applied, rejected
+}
+
+func applyTagUpdate(tx *gorm.DB, userID string, in tagUpdateInput) error {
+	var existing []Tag
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("tag_id = ?", in.TagID).Limit(1).Find(&existing).Error; err != nil {
+		return err
+	}
+	if len(existing) > 0 && existing[0].UserID != userID {
+		return errTagNotOwned
+	}
+
+	now := time.Now().UTC()
+	if in.Operation == "delete" {
+		if len(existing) == 0 || existing[0].DeletedAt != nil {
+			return nil
+		}
+		return deleteTag(tx, existing[0], now)
+	}
+
+	var tag Tag
+	switch {
+	case len(existing) > 0:
+		// A create for a known id is a client retry or a restore of a
+		// deleted tag; both land as an update.
+		tag = existing[0]
+		if tag.DeletedAt != nil && in.Operation == "update" {
+			return errTagNotFound
+		}
+		if in.Parent != nil && *in.Parent != tag.Parent {
+			return errTagBucketChange
+		}
+		system := isSystemTag(tag.Parent, tag.Name)
+		if system && ((in.Name != nil && *in.Name != tag.Name) || (in.HasParent && in.ParentTagID != nil)) {
+			return errTagSystem
+		}
+		tag.DeletedAt = nil
+	case in.Operation == "update":
+		return errTagNotFound
+	default:
+		tag = Tag{TagID: in.TagID, UserID: userID, Parent: *in.Parent, CreatedAt: now}
+	}
+
+	if in.Name != nil {
+		if *in.Name != tag.Name && isSystemTag(tag.Parent, *in.Name) {
+			return errTagReservedName
+		}
+		tag.Name = *in.Name
+	}
+	if in.HasColor {
+		tag.Color = in.Color
+	}
+	if in.Sort != nil {
+		tag.Sort = in.Sort
+	}
+	if in.HasParent {
+		if in.ParentTagID != nil {
+			if err := checkTagNesting(tx, tag, *in.ParentTagID); err != nil {
+				return err
+			}
+		}
+		tag.ParentTagID = in.ParentTagID
+	}
+	tag.UpdatedAt = &now
+
+	return tx.Save(&tag).Error
+}
+
+// checkTagNesting validates hanging tag under parentTagID.
+func checkTagNesting(tx *gorm.DB, tag Tag, parentTagID string) error {
+	var rows []Tag
+	if err := tx.Select("tag_id, parent, parent_tag_id, deleted_at").
+		Where("user_id = ?", tag.UserID).Find(&rows).Error; err != nil {
+		return err
+	}
+
+	parents := make(map[string]string, len(rows))
+	var parent *Tag
+	for i := range rows {
+		if rows[i].ParentTagID != nil {
+			parents[rows[i].TagID] = *rows[i].ParentTagID
+		}
+		if rows[i].TagID == parentTagID {
+			parent = &rows[i]
+		}
+	}
+	if parent == nil || parent.DeletedAt != nil || parent.Parent != tag.Parent {
+		return errTagBadParentTag
+	}
+	if tagNestingCycles(parents, tag.TagID, parentTagID) {
+		return errTagCycle
+	}
+	return nil
+}
+
+// deleteTag soft-deletes tag, hands its children to its own parent and
+// drops its instance memberships. System tags are recreated on demand, so
+// they cannot be deleted.
+func deleteTag(tx *gorm.DB, tag Tag, now time.Time) error {
+	if isSystemTag(tag.Parent, tag.Name) {
+		return errTagSystem
+	}
+	if err := tx.Model(&Tag{}).
+		Where("user_id = ? AND parent_tag_id = ?", tag.UserID, tag.TagID).
+		Updates(map[string]interface{}{"parent_tag_id": tag.ParentTagID, "updated_at": now}).Error; err != nil {
+		return fmt.Errorf("reparent children: %w", err)
+	}
+	if err := tx.Where("tag_id = ?", tag.TagID).Delete(&InstanceTag{}).Error; err != nil {
+		return fmt.Errorf("drop memberships: %w", err)
+	}
+	return tx.Model(&Tag{}).Where("tag_id = ?", tag.TagID).
+		Updates(map[string]interface{}{"deleted_at": now, "updated_at": now}).Error
+}
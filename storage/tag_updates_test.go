@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseTagUpdate_Create(t *testing.T) {
+	in, err := parseTagUpdate(map[string]interface{}{
+		"key":       "tag-1",
+		"operation": "create",
+		"tagData": map[string]interface{}{
+			"parent":        "caught",
+			"name":          "  Hundos  ",
+			"color":         "#A1b2C3",
+			"sort":          float64(5),
+			"parent_tag_id": "tag-0",
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if in.TagID != "tag-1" || *in.Parent != "caught" || *in.Name != "Hundos" || *in.Color != "#A1b2C3" || *in.Sort != 5 {
+		t.Fatalf("unexpected input: %+v", in)
+	}
+	if !in.HasParent || in.ParentTagID == nil || *in.ParentTagID != "tag-0" {
+		t.Fatalf("expected parent tag tag-0, got %+v", in)
+	}
+
+	if _, err := parseTagUpdate(map[string]interface{}{
+		"operation": "create",
+		"tagData":   map[string]interface{}{"tag_id": "tag-1", "name": "No bucket"},
+	}); err == nil {
+		t.Fatalf("expected create without parent to be rejected")
+	}
+}
+
+func TestParseTagUpdate_PartialUpdate(t *testing.T) {
+	in, err := parseTagUpdate(map[string]interface{}{
+		"operation": "update",
+		"tagData":   map[string]interface{}{"tag_id": "tag-1", "color": nil, "parent_tag_id": nil},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if in.Name != nil || in.Sort != nil {
+		t.Fatalf("expected untouched fields to stay nil, got %+v", in)
+	}
+	if !in.HasColor || in.Color != nil || !in.HasParent || in.ParentTagID != nil {
+		t.Fatalf("expected explicit nulls to clear color and un-nest, got %+v", in)
+	}
+}
+
+func TestParseTagUpdate_Rejects(t *testing.T) {
+	cases := map[string]map[string]interface{}{
+		"unknown op":  {"operation": "merge", "tagData": map[string]interface{}{"tag_id": "t"}},
+		"missing id":  {"operation": "delete"},
+		"bad bucket":  {"operation": "update", "tagData": map[string]interface{}{"tag_id": "t", "parent": "boxes"}},
+		"empty name":  {"operation": "update", "tagData": map[string]interface{}{"tag_id": "t", "name": "  "}},
+		"long name":   {"operation": "update", "tagData": map[string]interface{}{"tag_id": "t", "name": strings.Repeat("x", maxTagNameLength+1)}},
+		"bad color":   {"operation": "update", "tagData": map[string]interface{}{"tag_id": "t", "color": "red"}},
+		"bad sort":    {"operation": "update", "tagData": map[string]interface{}{"tag_id": "t", "sort": "first"}},
+		"self parent": {"operation": "update", "tagData": map[string]interface{}{"tag_id": "t", "parent_tag_id": "t"}},
+	}
+	for name, raw := range cases {
+		if _, err := parseTagUpdate(raw); err == nil {
+			t.Fatalf("%s: expected rejection", name)
+		}
+	}
+}
+
+func TestTagNestingCycles(t *testing.T) {
+	parents := map[string]string{"b": "a", "c": "b"}
+	if !tagNestingCycles(parents, "a", "c") {
+		t.Fatalf("expected a under c to cycle")
+	}
+	if tagNestingCycles(parents, "d", "c") {
+		t.Fatalf("expected d under c to be fine")
+	}
+	if !tagNestingCycles(map[string]string{"x": "y", "y": "x"}, "z", "x") {
+		t.Fatalf("expected existing loop to be reported")
+	}
+}
+
+func TestIsSystemTag(t *testing.T) {
+	if !isSystemTag("wanted", "Most Wanted") || isSystemTag("caught", "Most Wanted") {
+		t.Fatalf("unexpected system tag detection")
+	}
+}
@@ -0,0 +1,410 @@
+// trade_cycles.go
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// A trade cycle links three or four trades so that each member gives one
+// instance to the next: A gives to B, B to C and C back to A. The search
+// service finds cycles in trade_matches; a member proposes one through
+// tradeCycleProposals and storage creates one leg trade per hop. A leg is
+// one-sided: the receiver proposes it, the giver accepts it, and only the
+// giver's instance changes owner on completion. Legs only complete once
+// every leg of the cycle is pending, and a leg that ends any other way
+// breaks the rest of the cycle. Once one leg completed, a giver has already
+// handed over their instance, so the cycle can no longer break: its open
+// legs can only complete.
+
+const (
+	minTradeCycleLegs     = 3
+	maxTradeCycleLegs     = 4
+	maxTradeCycleIDLength = 64
+)
+
+var (
+	errCycleID        = errors.New("missing or overlong trade_cycle_id")
+	errCycleLength    = fmt.Errorf("a cycle has %d to %d legs", minTradeCycleLegs, maxTradeCycleLegs)
+	errCycleLeg       = errors.New("a leg needs trade_instance_id and wanted_instance_id")
+	errCycleRepeated  = errors.New("an instance appears in more than one leg")
+	errCycleNoMatch   = errors.New("a leg is not a trade match")
+	errCycleChain     = errors.New("legs do not chain into a cycle")
+	errCycleMembers   = errors.New("a trainer appears in the cycle more than once")
+	errCycleNotMember = errors.New("sender is not a member of the cycle")
+	errCycleExcluded  = errors.New("a not_trade_list or not_wanted_list rules a leg out")
+	errCycleExists    = errors.New("cycle already proposed")
+)
+
+// tradeCycleLegInput is one hop: the owner of TradeInstanceID gives it to
+// the owner of WantedInstanceID, who wants it through that instance.
+type tradeCycleLegInput struct {
+	TradeInstanceID  string
+	WantedInstanceID string
+}
+
+// tradeCycleProposal is one entry of the batched "tradeCycleProposals"
+// array. Leg i's receiver gives in leg i+1, and the last leg's receiver
+// gives in the first.
+type tradeCycleProposal struct {
+	CycleID string
+	Legs    []tradeCycleLegInput
+}
+
+func parseTradeCycleProposal(raw interface{}) (tradeCycleProposal, error) {
+	obj, ok := raw.(map[string]interface{})
+	if !ok {
+		return tradeCycleProposal{}, errors.New("proposal is not an object")
+	}
+	cycleID := strings.TrimSpace(fmt.Sprintf("%v", obj["trade_cycle_id"]))
+	if !isPresentID(cycleID) || len(cycleID) > maxTradeCycleIDLength {
+		return tradeCycleProposal{}, errCycleID
+	}
+	legs, _ := obj["legs"].([]interface{})
+	if len(legs) < minTradeCycleLegs || len(legs) > maxTradeCycleLegs {
+		return tradeCycleProposal{}, errCycleLength
+	}
+
+	in := tradeCycleProposal{CycleID: cycleID}
+	seen := make(map[string]bool, 2*len(legs))
+	for _, rawLeg := range legs {
+		leg, _ := rawLeg.(map[string]interface{})
+		tradeID := strings.TrimSpace(fmt.Sprintf("%v", leg["trade_instance_id"]))
+		wantedID := strings.TrimSpace(fmt.Sprintf("%v", leg["wanted_instance_id"]))
+		if !isPresentID(tradeID) || !isPresentID(wantedID) {
+			return tradeCycleProposal{}, errCycleLeg
+		}
+		if seen[tradeID] || seen[wantedID] {
+			return tradeCycleProposal{}, errCycleRepeated
+		}
+		seen[tradeID], seen[wantedID] = true, true
+		in.Legs = append(in.Legs, tradeCycleLegInput{TradeInstanceID: tradeID, WantedInstanceID: wantedID})
+	}
+	return in, nil
+}
+
+// chainTradeCycle checks that matches, in leg order, hand each instance to
+// the trainer who gives in the next leg, that nobody is in the cycle twice
+// and that senderID is in it.
+func chainTradeCycle(matches []TradeMatch, senderID string) error {
+	members := make(map[string]bool, len(matches))
+	for i, m := range matches {
+		next := matches[(i+1)%len(matches)]
+		if m.WantedUserID != next.TradeUserID || m.TradeUserID == m.WantedUserID {
+			return errCycleChain
+		}
+		if members[m.TradeUserID] {
+			return errCycleMembers
+		}
+		members[m.TradeUserID] = true
+	}
+	if !members[senderID] {
+		return errCycleNotMember
+	}
+	return nil
+}
+
+// jsonObjectHasKey reports whether raw, a JSON object such as
+// not_trade_list, has key. Unparseable lists exclude nothing.
+func jsonObjectHasKey(raw, key string) bool {
+	var obj map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &obj); err != nil {
+		return false
+	}
+	_, ok := obj[key]
+	return ok
+}
+
+// cycleMemberAllows reports whether a member may give give in return for
+// wanted, the instance through which they receive in the previous leg: the
+// same check search applies to direct matches, from both lists.
+func cycleMemberAllows(give, wanted PokemonInstance) bool {
+	return !jsonObjectHasKey(give.NotWantedList, wanted.InstanceID) &&
+		!jsonObjectHasKey(wanted.NotTradeList, give.InstanceID)
+}
+
+// tradeCycleLegID names leg i (0-based) of a cycle, so a redelivered
+// proposal maps onto the same trades.
+func tradeCycleLegID(cycleID string, i int) string {
+	return fmt.Sprintf("%s:%d", cycleID, i+1)
+}
+
+// tradeCycleReady reports whether leg tradeID may complete: every other
+// leg of its cycle must be pending or completed.
+func tradeCycleReady(legs []Trade, tradeID string) bool {
+	for _, leg := range legs {
+		if leg.TradeID == tradeID {
+			continue
+		}
+		if leg.TradeStatus != "pending" && leg.TradeStatus != "completed" {
+			return false
+		}
+	}
+	return true
+}
+
+// errTradeCycleCommitted rejects ending a leg whose cycle has a completed leg.
+var errTradeCycleCommitted = errors.New("a leg of its cycle already completed")
+
+// tradeCycleCommitted reports whether any leg of the cycle completed.
+func tradeCycleCommitted(tx *gorm.DB, cycleID string) (bool, error) {
+	var count int64
+	if err := tx.Model(&Trade{}).
+		Where("trade_cycle_id = ? AND trade_status = ?", cycleID, "completed").
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// checkTradeCycleLegMayEnd returns errTradeCycleCommitted when leg, a
+// proposed or pending leg, would end other than by completing although its
+// cycle is committed. Other trades always may.
+func checkTradeCycleLegMayEnd(tx *gorm.DB, leg Trade, nextStatus string) error {
+	if leg.TradeCycleID == nil || nextStatus == leg.TradeStatus || nextStatus == "completed" {
+		return nil
+	}
+	if leg.TradeStatus != "proposed" && leg.TradeStatus != "pending" {
+		return nil
+	}
+	committed, err := tradeCycleCommitted(tx, *leg.TradeCycleID)
+	if err != nil {
+		return err
+	}
+	if committed {
+		return errTradeCycleCommitted
+	}
+	return nil
+}
+
+/* -------------------------------------------------------------------------- */
+/*  Proposals                                                                 */
+/* -------------------------------------------------------------------------- */
+
+// parseAndProposeTradeCycles creates the legs of each proposed cycle the
+// sender is a member of.
+func parseAndProposeTradeCycles(data map[string]interface{}, senderID, senderUsername string) (proposed, rejected int) {
+	items, _ := data["tradeCycleProposals"].([]interface{})
+	for _, raw := range items {
+		in, err := parseTradeCycleProposal(raw)
+		if err != nil {
+			logrus.Warnf("Skipping trade cycle proposal from %s: %v", senderUsername, err)
+			rejected++
+			continue
+		}
+
+		legs, err := proposeTradeCycle(in, senderID)
+		if err != nil {
+			logrus.Warnf("Rejected trade cycle %s from %s: %v", in.CycleID, senderUsername, err)
+			rejected++
+			continue
+		}
+		proposed++
+		publishTradeCycle(legs, "trade_cycle_proposed")
+		for _, leg := range legs {
+			recordNotifications(tradeNotifications(leg, leg.TradeStatus, senderID))
+		}
+	}
+	return proposed, rejected
+}
+
+// proposeTradeCycle validates a cycle against trade_matches and the
+// members' instances, then stores one proposed leg per hop.
+func proposeTradeCycle(in tradeCycleProposal, senderID string) ([]Trade, error) {
+	var legs []Trade
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var existing int64
+		if err := tx.Model(&Trade{}).Where("trade_cycle_id = ?", in.CycleID).Count(&existing).Error; err != nil {
+			return err
+		}
+		if existing > 0 {
+			return errCycleExists
+		}
+
+		matches := make([]TradeMatch, len(in.Legs))
+		instanceIDs := make([]string, 0, 2*len(in.Legs))
+		tradeIDs := make([]string, 0, len(in.Legs))
+		for i, leg := range in.Legs {
+			if err := tx.Where("trade_instance_id = ? AND wanted_instance_id = ?",
+				leg.TradeInstanceID, leg.WantedInstanceID).First(&matches[i]).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return errCycleNoMatch
+				}
+				return err
+			}
+			instanceIDs = append(instanceIDs, leg.TradeInstanceID, leg.WantedInstanceID)
+			tradeIDs = append(tradeIDs, leg.TradeInstanceID)
+		}
+		if err := chainTradeCycle(matches, senderID); err != nil {
+			return err
+		}
+
+		// Lock the instances so they cannot change hands while the legs
+		// are written.
+		var locked []PokemonInstance
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("instance_id IN ?", instanceIDs).Find(&locked).Error; err != nil {
+			return err
+		}
+		byID := make(map[string]PokemonInstance, len(locked))
+		for _, inst := range locked {
+			byID[inst.InstanceID] = inst
+		}
+		for i, leg := range in.Legs {
+			give, ok := byID[leg.TradeInstanceID]
+			if !ok || !give.IsForTrade || give.UserID != matches[i].TradeUserID {
+				return errCycleNoMatch
+			}
+			// The giver receives in the previous leg.
+			prev := in.Legs[(i+len(in.Legs)-1)%len(in.Legs)]
+			wanted, ok := byID[prev.WantedInstanceID]
+			if !ok || !cycleMemberAllows(give, wanted) {
+				return errCycleExcluded
+			}
+		}
+		if err := validatePokemonAvailability(tx, nil, tradeIDs, ""); err != nil {
+			return err
+		}
+
+		userIDs := make([]string, 0, len(matches))
+		for _, m := range matches {
+			userIDs = append(userIDs, m.TradeUserID)
+		}
+		var users []User
+		if err := tx.Where("user_id IN ?", userIDs).Find(&users).Error; err != nil {
+			return err
+		}
+		usernames := make(map[string]string, len(users))
+		for _, u := range users {
+			usernames[u.UserID] = u.Username
+		}
+
+		now := time.Now()
+		cycleID := in.CycleID
+		for i, m := range matches {
+			leg := Trade{
+				TradeID:                        tradeCycleLegID(in.CycleID, i),
+				UserIDProposed:                 m.WantedUserID,
+				UsernameProposed:               usernames[m.WantedUserID],
+				UserIDAccepting:                m.TradeUserID,
+				UsernameAccepting:              usernames[m.TradeUserID],
+				PokemonInstanceIDUserAccepting: m.TradeInstanceID,
+				TradeStatus:                    "proposed",
+				TradeProposalDate:              &now,
+				TradeFriendshipLevel:           "Good",
+				TradeCycleID:                   &cycleID,
+				LastUpdate:                     now.UnixMilli(),
+			}
+			accepting := []string{m.TradeInstanceID}
+			priceTrade(tx, &leg, nil, accepting)
+			if err := tx.Create(&leg).Error; err != nil {
+				return err
+			}
+			if err := syncTradeItems(tx, leg.TradeID, nil, accepting); err != nil {
+				return err
+			}
+			legs = append(legs, leg)
+		}
+		return nil
+	})
+	return legs, err
+}
+
+/* -------------------------------------------------------------------------- */
+/*  Breaking                                                                  */
+/* -------------------------------------------------------------------------- */
+
+// cancelOpenCycleLegs ends the legs of a cycle that are still open once
+// one of them will not happen: proposed legs are denied and pending ones
+// cancelled, both by systemActor. A committed cycle is left alone.
+func cancelOpenCycleLegs(tx *gorm.DB, cycleID, brokenTradeID string, now time.Time) ([]Trade, error) {
+	committed, err := tradeCycleCommitted(tx, cycleID)
+	if err != nil || committed {
+		return nil, err
+	}
+
+	var legs []Trade
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("trade_cycle_id = ? AND trade_id <> ? AND trade_status IN ?",
+			cycleID, brokenTradeID, []string{"proposed", "pending"}).
+		Find(&legs).Error; err != nil {
+		return nil, err
+	}
+
+	by := systemActor
+	for i := range legs {
+		leg := &legs[i]
+		updates := map[string]interface{}{
+			"trade_cancelled_by": by,
+			"last_update":        now.UnixMilli(),
+		}
+		if leg.TradeStatus == "proposed" {
+			leg.TradeStatus = "denied"
+		} else {
+			leg.TradeStatus = "cancelled"
+			leg.TradeCancelledDate = &now
+			updates["trade_cancelled_date"] = now
+		}
+		updates["trade_status"] = leg.TradeStatus
+		leg.TradeCancelledBy = &by
+		leg.LastUpdate = now.UnixMilli()
+		if err := tx.Model(&Trade{}).Where("trade_id = ?", leg.TradeID).Updates(updates).Error; err != nil {
+			return nil, err
+		}
+	}
+	return legs, nil
+}
+
+// breakTradeCycle ends the rest of leg's cycle after leg was denied,
+// cancelled, deleted or expired, and tells every member.
+func breakTradeCycle(leg Trade) {
+	if leg.TradeCycleID == nil {
+		return
+	}
+	var legs []Trade
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		legs, err = cancelOpenCycleLegs(tx, *leg.TradeCycleID, leg.TradeID, time.Now())
+		return err
+	})
+	if err != nil {
+		logrus.Errorf("Failed to break trade cycle %s after trade %s: %v", *leg.TradeCycleID, leg.TradeID, err)
+		return
+	}
+	if len(legs) == 0 {
+		return
+	}
+	logrus.Infof("Trade %s broke cycle %s; ended %d open legs", leg.TradeID, *leg.TradeCycleID, len(legs))
+	publishTradeCycle(legs, "trade_cycle_broken")
+	for _, l := range legs {
+		if l.TradeStatus == "cancelled" {
+			refreshReputationForTrade(l)
+		}
+		recordNotifications(tradeNotifications(l, l.TradeStatus, ""))
+	}
+}
+
+// publishTradeCycle sends the legs in one event; events fans each leg out
+// to both of its sides.
+func publishTradeCycle(legs []Trade, eventName string) {
+	if len(legs) == 0 {
+		return
+	}
+	event := newStorageEvent(legs[0].UserIDProposed, legs[0].UsernameProposed, eventName)
+	updates := make([]interface{}, 0, len(legs))
+	for _, leg := range legs {
+		updates = append(updates, tradeUpdatePayload(leg))
+	}
+	event["tradeUpdates"] = updates
+	if err := publishStorageEventFn(event); err != nil {
+		logrus.Warnf("Failed to publish %s event for cycle %s: %v", eventName, *legs[0].TradeCycleID, err)
+	}
+}
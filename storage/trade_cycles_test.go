@@ -0,0 +1,234 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func cycleLegs(pairs ...[2]string) []interface{} {
+	legs := make([]interface{}, 0, len(pairs))
+	for _, p := range pairs {
+		legs = append(legs, map[string]interface{}{"trade_instance_id": p[0], "wanted_instance_id": p[1]})
+	}
+	return legs
+}
+
+func TestParseTradeCycleProposal(t *testing.T) {
+	in, err := parseTradeCycleProposal(map[string]interface{}{
+		"trade_cycle_id": " c-1 ",
+		"legs":           cycleLegs([2]string{"a", "wb"}, [2]string{"b", "wc"}, [2]string{"c", "wa"}),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if in.CycleID != "c-1" || len(in.Legs) != 3 || in.Legs[2].WantedInstanceID != "wa" {
+		t.Fatalf("unexpected proposal: %+v", in)
+	}
+
+	for _, raw := range []interface{}{
+		"not an object",
+		map[string]interface{}{"legs": cycleLegs([2]string{"a", "wb"}, [2]string{"b", "wc"}, [2]string{"c", "wa"})},
+		map[string]interface{}{"trade_cycle_id": strings.Repeat("c", maxTradeCycleIDLength+1),
+			"legs": cycleLegs([2]string{"a", "wb"}, [2]string{"b", "wc"}, [2]string{"c", "wa"})},
+		map[string]interface{}{"trade_cycle_id": "c-1", "legs": cycleLegs([2]string{"a", "wb"}, [2]string{"b", "wa"})},
+		map[string]interface{}{"trade_cycle_id": "c-1", "legs": cycleLegs([2]string{"a", "wb"}, [2]string{"b", "wc"}, [2]string{"c", ""})},
+		map[string]interface{}{"trade_cycle_id": "c-1", "legs": cycleLegs([2]string{"a", "wb"}, [2]string{"a", "wc"}, [2]string{"c", "wa"})},
+		map[string]interface{}{"trade_cycle_id": "c-1", "legs": cycleLegs(
+			[2]string{"a", "1"}, [2]string{"b", "2"}, [2]string{"c", "3"}, [2]string{"d", "4"}, [2]string{"e", "5"})},
+	} {
+		if _, err := parseTradeCycleProposal(raw); err == nil {
+			t.Fatalf("expected %#v to be rejected", raw)
+		}
+	}
+}
+
+func TestChainTradeCycle(t *testing.T) {
+	hop := func(from, to string) TradeMatch { return TradeMatch{TradeUserID: from, WantedUserID: to} }
+
+	if err := chainTradeCycle([]TradeMatch{hop("a", "b"), hop("b", "c"), hop("c", "a")}, "b"); err != nil {
+		t.Fatalf("expected a valid cycle, got %v", err)
+	}
+	if err := chainTradeCycle([]TradeMatch{hop("a", "b"), hop("c", "d"), hop("d", "a")}, "a"); err != errCycleChain {
+		t.Fatalf("expected a broken chain, got %v", err)
+	}
+	if err := chainTradeCycle([]TradeMatch{hop("a", "b"), hop("b", "a"), hop("a", "b"), hop("b", "a")}, "a"); err != errCycleMembers {
+		t.Fatalf("expected a repeated member, got %v", err)
+	}
+	if err := chainTradeCycle([]TradeMatch{hop("a", "b"), hop("b", "c"), hop("c", "a")}, "d"); err != errCycleNotMember {
+		t.Fatalf("expected an outside sender to be rejected, got %v", err)
+	}
+}
+
+func TestCycleMemberAllows(t *testing.T) {
+	give := PokemonInstance{InstanceID: "give", NotWantedList: `{}`}
+	wanted := PokemonInstance{InstanceID: "wanted", NotTradeList: `{}`}
+	if !cycleMemberAllows(give, wanted) {
+		t.Fatalf("expected empty lists to allow the leg")
+	}
+
+	give.NotWantedList = `{"wanted": true}`
+	if cycleMemberAllows(give, wanted) {
+		t.Fatalf("expected not_wanted_list to rule the leg out")
+	}
+	give.NotWantedList = `not json`
+	wanted.NotTradeList = `{"give": true}`
+	if cycleMemberAllows(give, wanted) {
+		t.Fatalf("expected not_trade_list to rule the leg out")
+	}
+}
+
+func TestTradeCycleReady(t *testing.T) {
+	legs := []Trade{
+		{TradeID: tradeCycleLegID("c", 0), TradeStatus: "pending"},
+		{TradeID: tradeCycleLegID("c", 1), TradeStatus: "completed"},
+		{TradeID: tradeCycleLegID("c", 2), TradeStatus: "proposed"},
+	}
+	if legs[0].TradeID != "c:1" {
+		t.Fatalf("unexpected leg id %q", legs[0].TradeID)
+	}
+	if tradeCycleReady(legs, "c:1") {
+		t.Fatalf("expected a proposed leg to hold the cycle back")
+	}
+	if !tradeCycleReady(legs, "c:3") {
+		t.Fatalf("expected the last proposed leg itself not to count")
+	}
+}
+
+func TestPublishTradeCycle_CarriesEveryLeg(t *testing.T) {
+	prev := publishStorageEventFn
+	t.Cleanup(func() { publishStorageEventFn = prev })
+
+	var captured map[string]interface{}
+	publishStorageEventFn = func(payload map[string]interface{}) error {
+		captured = payload
+		return nil
+	}
+
+	cycleID := "c-2"
+	legs := []Trade{
+		{TradeID: "c-2:1", TradeStatus: "denied", UserIDProposed: "u-2", UsernameProposed: "bob", UsernameAccepting: "alice", TradeCycleID: &cycleID},
+		{TradeID: "c-2:2", TradeStatus: "cancelled", UserIDProposed: "u-3", UsernameProposed: "carol", UsernameAccepting: "bob", TradeCycleID: &cycleID},
+	}
+	publishTradeCycle(legs, "trade_cycle_broken")
+
+	if captured == nil || captured["event"] != "trade_cycle_broken" {
+		t.Fatalf("expected the broken event, got %#v", captured)
+	}
+	updates, ok := captured["tradeUpdates"].([]interface{})
+	if !ok || len(updates) != 2 {
+		t.Fatalf("expected two trade updates, got %#v", captured["tradeUpdates"])
+	}
+	data := updates[1].(map[string]interface{})["tradeData"].(map[string]interface{})
+	if data["trade_status"] != "cancelled" || *(data["trade_cycle_id"].(*string)) != cycleID {
+		t.Fatalf("unexpected trade data: %#v", data)
+	}
+}
+
+func expectCycleCompletedCount(mock sqlmock.Sqlmock, cycleID string, completed int) {
+	mock.ExpectQuery("SELECT count\\(\\*\\) FROM `trades` WHERE trade_cycle_id = \\? AND trade_status = \\?").
+		WithArgs(cycleID, "completed").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(completed))
+}
+
+func TestCheckTradeCycleLegMayEnd(t *testing.T) {
+	mock := setupMockDB(t)
+	cycleID := "c-3"
+	leg := Trade{TradeID: "c-3:2", TradeStatus: "pending", TradeCycleID: &cycleID}
+
+	expectCycleCompletedCount(mock, cycleID, 1)
+	if err := checkTradeCycleLegMayEnd(DB, leg, "cancelled"); !errors.Is(err, errTradeCycleCommitted) {
+		t.Fatalf("expected a committed cycle to keep its leg, got %v", err)
+	}
+	expectCycleCompletedCount(mock, cycleID, 0)
+	if err := checkTradeCycleLegMayEnd(DB, leg, "deleted"); err != nil {
+		t.Fatalf("expected an open cycle to let its leg end, got %v", err)
+	}
+	for _, next := range []string{"completed", "pending"} {
+		if err := checkTradeCycleLegMayEnd(DB, leg, next); err != nil {
+			t.Fatalf("expected %s to be allowed without a lookup, got %v", next, err)
+		}
+	}
+	if err := checkTradeCycleLegMayEnd(DB, Trade{TradeID: "t-1", TradeStatus: "pending"}, "cancelled"); err != nil {
+		t.Fatalf("expected a plain trade to be left alone, got %v", err)
+	}
+	done := Trade{TradeID: "c-3:1", TradeStatus: "completed", TradeCycleID: &cycleID}
+	if err := checkTradeCycleLegMayEnd(DB, done, "deleted"); err != nil {
+		t.Fatalf("expected a completed leg to be deletable, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestCancelOpenCycleLegs_LeavesCommittedCycle(t *testing.T) {
+	mock := setupMockDB(t)
+	expectCycleCompletedCount(mock, "c-4", 1)
+
+	legs, err := cancelOpenCycleLegs(DB, "c-4", "c-4:1", time.Now())
+	if err != nil || len(legs) != 0 {
+		t.Fatalf("expected no legs to be ended, got %v err=%v", legs, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestApplyTradeLifecycleAction_KeepsCommittedCycleLeg(t *testing.T) {
+	mock := setupMockDB(t)
+	cfg := testTradesConfig()
+	accepted := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	sent := accepted.Add(61 * time.Hour)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT \\* FROM `trades` WHERE trade_id = \\? .*FOR UPDATE").
+		WithArgs("c-5:2", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"trade_id", "trade_status", "trade_cycle_id", "trade_accepted_date", "trade_reminder_sent_date"}).
+			AddRow("c-5:2", "pending", "c-5", accepted, sent))
+	expectCycleCompletedCount(mock, "c-5", 1)
+	mock.ExpectCommit()
+
+	_, applied, err := applyTradeLifecycleAction("c-5:2", tradeActionCancel, accepted.Add(73*time.Hour), cfg)
+	if err != nil || applied {
+		t.Fatalf("expected the leg not to be auto-cancelled, applied=%v err=%v", applied, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+// Bob received Alice's instance in leg 1; cancelling leg 2, where Bob gives
+// to Carol, must not break the cycle and leave Alice with nothing.
+func TestParseAndUpsertTrades_KeepsLegOfCommittedCycle(t *testing.T) {
+	mock := setupMockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT \\* FROM `trades` WHERE trade_id = \\? .*FOR UPDATE").
+		WithArgs("c-6:2", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"trade_id", "trade_status", "trade_cycle_id", "last_update"}).
+			AddRow("c-6:2", "pending", "c-6", 100))
+	expectCycleCompletedCount(mock, "c-6", 1)
+	mock.ExpectCommit()
+
+	_, updated, dropped, err := parseAndUpsertTrades(map[string]interface{}{
+		"user_id": "u-bob",
+		"tradeUpdates": []interface{}{map[string]interface{}{
+			"operation": "updateTrade",
+			"tradeData": map[string]interface{}{
+				"trade_id":           "c-6:2",
+				"trade_status":       "cancelled",
+				"username_proposed":  "",
+				"username_accepting": "",
+				"last_update":        200,
+			},
+		}},
+	})
+	if err != nil || updated != 0 || dropped != 0 {
+		t.Fatalf("expected the cancel to be skipped, updated=%d dropped=%d err=%v", updated, dropped, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
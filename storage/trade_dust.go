@@ -0,0 +1,286 @@
+// trade_dust.go
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// Stardust per in-game trade by friendship level. A trade is "special" when
+// either Pokemon is shiny or a legendary/mythical/ultra beast species, and a
+// "new entry" when the receiver has never registered that species. These
+// are the only copy: ensureTradeDustCostsSchema writes them to
+// trade_dust_costs, which the users service prices its previews from.
+const regularTradeDustCost = 100
+
+// tradeFriendshipLevels are the levels the tables below price.
+var tradeFriendshipLevels = []string{"Good", "Great", "Ultra", "Best"}
+
+var specialOrNewTradeDustCost = map[string]int{
+	"Good":  20000,
+	"Great": 16000,
+	"Ultra": 1600,
+	"Best":  800,
+}
+
+var specialAndNewTradeDustCost = map[string]int{
+	"Good":  1000000,
+	"Great": 800000,
+	"Ultra": 80000,
+	"Best":  40000,
+}
+
+// tradeDustCost prices a single one-for-one trade.
+func tradeDustCost(friendshipLevel string, special, newEntry bool) int {
+	if _, ok := specialOrNewTradeDustCost[friendshipLevel]; !ok {
+		friendshipLevel = "Good"
+	}
+	switch {
+	case special && newEntry:
+		return specialAndNewTradeDustCost[friendshipLevel]
+	case special || newEntry:
+		return specialOrNewTradeDustCost[friendshipLevel]
+	default:
+		return regularTradeDustCost
+	}
+}
+
+const createTradeDustCostsTableSQL = `
+CREATE TABLE IF NOT EXISTS trade_dust_costs (
+  friendship_level VARCHAR(16) NOT NULL PRIMARY KEY,
+  regular          INT NOT NULL,
+  special_or_new   INT NOT NULL,
+  special_and_new  INT NOT NULL
+)`
+
+const upsertTradeDustCostSQL = `
+INSERT INTO trade_dust_costs (friendship_level, regular, special_or_new, special_and_new)
+VALUES (?, ?, ?, ?)
+ON DUPLICATE KEY UPDATE regular = VALUES(regular), special_or_new = VALUES(special_or_new),
+  special_and_new = VALUES(special_and_new)`
+
+// ensureTradeDustCostsSchema creates trade_dust_costs and rewrites its rows
+// from the tables above, so a changed cost reaches the previews on deploy.
+func ensureTradeDustCostsSchema() error {
+	if err := DB.Exec(createTradeDustCostsTableSQL).Error; err != nil {
+		return fmt.Errorf("create trade_dust_costs: %w", err)
+	}
+	for _, level := range tradeFriendshipLevels {
+		if err := DB.Exec(upsertTradeDustCostSQL, level, regularTradeDustCost,
+			specialOrNewTradeDustCost[level], specialAndNewTradeDustCost[level]).Error; err != nil {
+			return fmt.Errorf("write trade_dust_costs %s: %w", level, err)
+		}
+	}
+	return nil
+}
+
+// tradeTerms are the server-derived values clients used to send themselves.
+// IsLuckyTrade is not here: lucky trades are random in-game, so the client's
+// value is kept as reported.
+type tradeTerms struct {
+	IsSpecialTrade    bool
+	IsRegisteredTrade bool
+	TradeDustCost     int
+}
+
+// tradePair is one in-game trade within a (possibly bundled) trade.
+type tradePair struct {
+	Special  bool
+	NewEntry bool
+}
+
+// sumTradeTerms totals the pairs of a bundle. Every pair is its own in-game
+// trade, so costs add up; the flags describe the bundle as a whole.
+func sumTradeTerms(friendshipLevel string, pairs []tradePair) tradeTerms {
+	terms := tradeTerms{IsRegisteredTrade: true}
+	for _, p := range pairs {
+		if p.Special {
+			terms.IsSpecialTrade = true
+		}
+		if p.NewEntry {
+			terms.IsRegisteredTrade = false
+		}
+		terms.TradeDustCost += tradeDustCost(friendshipLevel, p.Special, p.NewEntry)
+	}
+	return terms
+}
+
+// speciesKey is the pokedex prefix of a variant id ("0001-default" -> "0001"),
+// which is what the receiver needs registered for the trade to be cheap.
+func speciesKey(variantID string) string {
+	variantID = strings.TrimSpace(variantID)
+	if i := strings.Index(variantID, "-"); i >= 0 {
+		return variantID[:i]
+	}
+	return variantID
+}
+
+// deriveTradeTerms prices a trade from stored instances and registrations.
+// Items are paired by position; an unpaired item is priced on its own.
+// The client's is_registered_trade is only consulted when an instance or the
+// receiver is unknown to storage.
+func deriveTradeTerms(tx *gorm.DB, trade Trade, proposedIDs, acceptingIDs []string) (tradeTerms, error) {
+	ids := append(append([]string{}, proposedIDs...), acceptingIDs...)
+	byID := make(map[string]PokemonInstance, len(ids))
+	if len(ids) > 0 {
+		var instances []PokemonInstance
+		if err := tx.Where("instance_id IN ?", ids).Find(&instances).Error; err != nil {
+			return tradeTerms{}, err
+		}
+		for _, inst := range instances {
+			byID[inst.InstanceID] = inst
+		}
+	}
+
+	registered, err := loadRegisteredSpecies(tx, trade.UserIDProposed, trade.UserIDAccepting, byID)
+	if err != nil {
+		return tradeTerms{}, err
+	}
+
+	// newEntry reports whether receiverID lacks the species of instanceID.
+	newEntry := func(instanceID, receiverID string) bool {
+		inst, ok := byID[instanceID]
+		if !ok || receiverID == "" {
+			return !trade.IsRegisteredTrade
+		}
+		return !registered[receiverID][speciesKey(normalizeOptionalString(inst.VariantID))]
+	}
+	special := func(instanceID string) bool {
+		inst, ok := byID[instanceID]
+		return ok && (inst.Shiny || isSpecialSpecies(inst.PokemonID))
+	}
+
+	n := len(proposedIDs)
+	if len(acceptingIDs) > n {
+		n = len(acceptingIDs)
+	}
+	pairs := make([]tradePair, 0, n)
+	for i := 0; i < n; i++ {
+		var p tradePair
+		if i < len(proposedIDs) {
+			p.Special = p.Special || special(proposedIDs[i])
+			p.NewEntry = p.NewEntry || newEntry(proposedIDs[i], trade.UserIDAccepting)
+		}
+		if i < len(acceptingIDs) {
+			p.Special = p.Special || special(acceptingIDs[i])
+			p.NewEntry = p.NewEntry || newEntry(acceptingIDs[i], trade.UserIDProposed)
+		}
+		pairs = append(pairs, p)
+	}
+	return sumTradeTerms(trade.TradeFriendshipLevel, pairs), nil
+}
+
+// loadRegisteredSpecies returns, per user, the species keys they have
+// registered among the species involved in the trade.
+func loadRegisteredSpecies(tx *gorm.DB, proposerID, accepterID string, instances map[string]PokemonInstance) (map[string]map[string]bool, error) {
+	out := map[string]map[string]bool{}
+	users := make([]string, 0, 2)
+	for _, id := range []string{proposerID, accepterID} {
+		if strings.TrimSpace(id) != "" {
+			users = append(users, id)
+			out[id] = map[string]bool{}
+		}
+	}
+	species := make([]string, 0, len(instances))
+	for _, inst := range instances {
+		if key := speciesKey(normalizeOptionalString(inst.VariantID)); key != "" {
+			species = append(species, key)
+		}
+	}
+	if len(users) == 0 || len(species) == 0 {
+		return out, nil
+	}
+
+	var rows []Registration
+	if err := tx.Where("user_id IN ? AND SUBSTRING_INDEX(variant_id, '-', 1) IN ?", users, species).
+		Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		out[row.UserID][speciesKey(row.VariantID)] = true
+	}
+	return out, nil
+}
+
+// priceTrade replaces the client-reported terms on trade with derived ones
+// and reports whether the client had them wrong. Until the species catalog
+// has loaded, legendary status is unknown, so the client's values are kept
+// rather than flagging false mismatches.
+func priceTrade(tx *gorm.DB, trade *Trade, proposedIDs, acceptingIDs []string) bool {
+	if len(proposedIDs) == 0 && len(acceptingIDs) == 0 {
+		return false
+	}
+	if !speciesCatalogLoaded() {
+		logrus.Warnf("Species catalog not loaded; keeping client-reported terms for trade %s", trade.TradeID)
+		return false
+	}
+	terms, err := deriveTradeTerms(tx, *trade, proposedIDs, acceptingIDs)
+	if err != nil {
+		logrus.Errorf("Failed to derive terms for trade %s; keeping client-reported terms: %v", trade.TradeID, err)
+		return false
+	}
+	applyDerivedTradeTerms(trade, terms)
+	return trade.TradeTermsMismatch
+}
+
+// publishRepricedTrade pushes the corrected terms to both sides; the copy
+// already fanned out from the client carries the wrong ones.
+func publishRepricedTrade(trade Trade) {
+	event := newStorageEvent(trade.UserIDProposed, trade.UsernameProposed, "trade_repriced")
+	event["tradeUpdates"] = []interface{}{tradeUpdatePayload(trade)}
+	if err := publishStorageEventFn(event); err != nil {
+		logrus.Warnf("Failed to publish repriced event for trade %s: %v", trade.TradeID, err)
+	}
+}
+
+// keepStoredTradeTerms stops a later status update from overwriting terms
+// that were priced while the bundle was still negotiable.
+func keepStoredTradeTerms(updates *Trade, existing Trade) {
+	updates.IsSpecialTrade = existing.IsSpecialTrade
+	updates.IsRegisteredTrade = existing.IsRegisteredTrade
+	updates.TradeDustCost = existing.TradeDustCost
+	updates.ClientTradeDustCost = existing.ClientTradeDustCost
+	updates.TradeTermsMismatch = existing.TradeTermsMismatch
+}
+
+// applyDerivedTradeTerms overwrites the client-reported terms on a trade with
+// the derived ones, keeping the client's cost for auditing. A disagreement is
+// logged, counted and flagged on the row.
+func applyDerivedTradeTerms(trade *Trade, terms tradeTerms) {
+	trade.ClientTradeDustCost = trade.TradeDustCost
+
+	var mismatched []string
+	if trade.TradeDustCost != nil && *trade.TradeDustCost != terms.TradeDustCost {
+		mismatched = append(mismatched, "trade_dust_cost")
+	}
+	if trade.IsSpecialTrade != terms.IsSpecialTrade {
+		mismatched = append(mismatched, "is_special_trade")
+	}
+	if trade.IsRegisteredTrade != terms.IsRegisteredTrade {
+		mismatched = append(mismatched, "is_registered_trade")
+	}
+	for _, field := range mismatched {
+		tradeTermsMismatchesTotal.WithLabelValues(field).Inc()
+	}
+	if len(mismatched) > 0 {
+		logrus.Warnf("Trade %s: client terms disagree with derived terms on %s (client cost %v, derived %d)",
+			trade.TradeID, strings.Join(mismatched, ", "), intPtrValue(trade.TradeDustCost), terms.TradeDustCost)
+	}
+
+	cost := terms.TradeDustCost
+	trade.TradeDustCost = &cost
+	trade.IsSpecialTrade = terms.IsSpecialTrade
+	trade.IsRegisteredTrade = terms.IsRegisteredTrade
+	trade.TradeTermsMismatch = len(mismatched) > 0
+}
+
+func intPtrValue(v *int) interface{} {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
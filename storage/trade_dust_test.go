@@ -0,0 +1,108 @@
+package main
+
+import (
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestTradeDustCost_Table(t *testing.T) {
+	cases := []struct {
+		level    string
+		special  bool
+		newEntry bool
+		want     int
+	}{
+		{"Good", false, false, 100},
+		{"Best", false, false, 100},
+		{"Good", true, false, 20000},
+		{"Great", false, true, 16000},
+		{"Ultra", true, false, 1600},
+		{"Best", false, true, 800},
+		{"Good", true, true, 1000000},
+		{"Great", true, true, 800000},
+		{"Ultra", true, true, 80000},
+		{"Best", true, true, 40000},
+		{"bogus", true, false, 20000},
+	}
+	for _, tc := range cases {
+		if got := tradeDustCost(tc.level, tc.special, tc.newEntry); got != tc.want {
+			t.Fatalf("tradeDustCost(%q, %v, %v) = %d, want %d", tc.level, tc.special, tc.newEntry, got, tc.want)
+		}
+	}
+}
+
+func TestEnsureTradeDustCostsSchema_WritesEveryLevel(t *testing.T) {
+	mock := setupMockDB(t)
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS trade_dust_costs`).WillReturnResult(sqlmock.NewResult(0, 0))
+	for _, row := range [][]interface{}{
+		{"Good", 100, 20000, 1000000},
+		{"Great", 100, 16000, 800000},
+		{"Ultra", 100, 1600, 80000},
+		{"Best", 100, 800, 40000},
+	} {
+		mock.ExpectExec(`INSERT INTO trade_dust_costs`).
+			WithArgs(row[0], row[1], row[2], row[3]).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+
+	if err := ensureTradeDustCostsSchema(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet expectations: %v", err)
+	}
+}
+
+func TestSumTradeTerms_BundleAddsPairCosts(t *testing.T) {
+	terms := sumTradeTerms("Ultra", []tradePair{
+		{},
+		{Special: true},
+		{NewEntry: true},
+	})
+	if terms.TradeDustCost != 100+1600+1600 {
+		t.Fatalf("unexpected bundle cost %d", terms.TradeDustCost)
+	}
+	if !terms.IsSpecialTrade || terms.IsRegisteredTrade {
+		t.Fatalf("unexpected bundle flags: %+v", terms)
+	}
+}
+
+func TestSpeciesKeyAndRarity(t *testing.T) {
+	if got := speciesKey("0150-default"); got != "0150" {
+		t.Fatalf("expected species 0150, got %q", got)
+	}
+	for _, rarity := range []string{"Legendary", "mythic", "Mythical", "Ultra Beast"} {
+		if !isSpecialRarity(rarity) {
+			t.Fatalf("expected %q to be special", rarity)
+		}
+	}
+	for _, rarity := range []string{"", "standard", "regional"} {
+		if isSpecialRarity(rarity) {
+			t.Fatalf("expected %q to be regular", rarity)
+		}
+	}
+}
+
+func TestApplyDerivedTradeTerms_FlagsMismatchAndKeepsClientCost(t *testing.T) {
+	clientCost := 100
+	trade := Trade{TradeID: "t-1", TradeDustCost: &clientCost, IsRegisteredTrade: true}
+	applyDerivedTradeTerms(&trade, tradeTerms{IsSpecialTrade: true, IsRegisteredTrade: true, TradeDustCost: 20000})
+
+	if !trade.TradeTermsMismatch {
+		t.Fatalf("expected mismatch to be flagged")
+	}
+	if trade.TradeDustCost == nil || *trade.TradeDustCost != 20000 || !trade.IsSpecialTrade {
+		t.Fatalf("expected derived terms to win, got %+v", trade)
+	}
+	if trade.ClientTradeDustCost == nil || *trade.ClientTradeDustCost != 100 {
+		t.Fatalf("expected client cost to be kept, got %v", trade.ClientTradeDustCost)
+	}
+
+	agreed := 100
+	trade = Trade{TradeID: "t-2", TradeDustCost: &agreed, IsRegisteredTrade: true}
+	applyDerivedTradeTerms(&trade, tradeTerms{IsRegisteredTrade: true, TradeDustCost: 100})
+	if trade.TradeTermsMismatch {
+		t.Fatalf("expected no mismatch when client agrees")
+	}
+}
@@ -0,0 +1,239 @@
+// trade_expiry.go
+
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// systemActor is recorded in trade_cancelled_by when storage cancels a trade.
+const systemActor = "system"
+
+type tradeLifecycleAction string
+
+const (
+	tradeActionNone   tradeLifecycleAction = ""
+	tradeActionRemind tradeLifecycleAction = "remind"
+	tradeActionExpire tradeLifecycleAction = "expire"
+	tradeActionCancel tradeLifecycleAction = "cancel"
+)
+
+// tradeReferenceTime returns the moment a trade entered its current status,
+// falling back to last_update when the client never sent the date.
+func tradeReferenceTime(t Trade) (time.Time, bool) {
+	var ref *time.Time
+	switch t.TradeStatus {
+	case "proposed":
+		ref = t.TradeProposalDate
+	case "pending":
+		ref = t.TradeAcceptedDate
+	default:
+		return time.Time{}, false
+	}
+	if ref != nil && !ref.IsZero() {
+		return *ref, true
+	}
+	if t.LastUpdate > 0 {
+		return time.UnixMilli(t.LastUpdate), true
+	}
+	return time.Time{}, false
+}
+
+// tradeDeadline is when the scheduler gives up on a trade in its current status.
+func tradeDeadline(t Trade, cfg TradesConfig) (time.Time, bool) {
+	ref, ok := tradeReferenceTime(t)
+	if !ok {
+		return time.Time{}, false
+	}
+	ttl := cfg.ProposalTTLHours
+	if t.TradeStatus == "pending" {
+		ttl = cfg.PendingTTLHours
+	}
+	return ref.Add(time.Duration(ttl) * time.Hour), true
+}
+
+// decideTradeLifecycleAction picks what the scheduler should do with a trade
+// at time now. Pending trades where either side already confirmed completion
+// are left alone; they only need the other side to follow through.
+func decideTradeLifecycleAction(t Trade, now time.Time, cfg TradesConfig) tradeLifecycleAction {
+	deadline, ok := tradeDeadline(t, cfg)
+	if !ok {
+		return tradeActionNone
+	}
+
+	if !now.Before(deadline) {
+		switch t.TradeStatus {
+		case "proposed":
+			return tradeActionExpire
+		case "pending":
+			if !t.UserProposedCompletionConfirmed && !t.UserAcceptingCompletionConfirmed {
+				return tradeActionCancel
+			}
+		}
+	}
+
+	if t.TradeReminderSentDate == nil {
+		remindAt := deadline.Add(-time.Duration(cfg.ReminderLeadHours) * time.Hour)
+		if !now.Before(remindAt) {
+			return tradeActionRemind
+		}
+	}
+	return tradeActionNone
+}
+
+// ExpireStaleTrades is the scheduler job that reminds, expires and
+// auto-cancels idle trades, then publishes each change for SSE fan-out.
+func ExpireStaleTrades() {
+	cfg := AppConfig.Trades
+	now := time.Now()
+
+	// Only trades old enough to need a reminder can need anything else.
+	minTTL := cfg.ProposalTTLHours
+	if cfg.PendingTTLHours < minTTL {
+		minTTL = cfg.PendingTTLHours
+	}
+	horizon := now.Add(-time.Duration(minTTL-cfg.ReminderLeadHours) * time.Hour)
+
+	var candidates []Trade
+	if err := DB.
+		Where("trade_status IN ?", []string{"proposed", "pending"}).
+		Where(`(trade_status = 'proposed' AND COALESCE(trade_proposal_date, FROM_UNIXTIME(last_update / 1000)) <= ?)
+			OR (trade_status = 'pending' AND COALESCE(trade_accepted_date, FROM_UNIXTIME(last_update / 1000)) <= ?)`,
+			horizon, horizon).
+		Find(&candidates).Error; err != nil {
+		logrus.Errorf("Failed to load trades for expiry check: %v", err)
+		return
+	}
+
+	var reminded, expired, cancelled int
+	for _, candidate := range candidates {
+		action := decideTradeLifecycleAction(candidate, now, cfg)
+		if action == tradeActionNone {
+			continue
+		}
+
+		trade, applied, err := applyTradeLifecycleAction(candidate.TradeID, action, now, cfg)
+		if err != nil {
+			logrus.Errorf("Failed to %s trade %s: %v", action, candidate.TradeID, err)
+			continue
+		}
+		if !applied {
+			continue
+		}
+
+		switch action {
+		case tradeActionRemind:
+			reminded++
+		case tradeActionExpire:
+			expired++
+		case tradeActionCancel:
+			cancelled++
+		}
+		publishTradeLifecycleEvent(trade, action, cfg)
+		if action == tradeActionCancel {
+			refreshReputationForTrade(trade)
+		}
+		if action != tradeActionRemind {
+			recordNotifications(tradeNotifications(trade, trade.TradeStatus, ""))
+			breakTradeCycle(trade)
+		}
+	}
+
+	if reminded+expired+cancelled > 0 {
+		logrus.Infof("Trade expiry: reminded %d, expired %d, auto-cancelled %d", reminded, expired, cancelled)
+	}
+}
+
+// applyTradeLifecycleAction re-reads the trade under a row lock so a client
+// update racing the scheduler wins if it landed first.
+func applyTradeLifecycleAction(tradeID string, action tradeLifecycleAction, now time.Time, cfg TradesConfig) (Trade, bool, error) {
+	var trade Trade
+	applied := false
+
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("trade_id = ?", tradeID).First(&trade).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+		if decideTradeLifecycleAction(trade, now, cfg) != action {
+			return nil
+		}
+		if action != tradeActionRemind {
+			if err := checkTradeCycleLegMayEnd(tx, trade, "cancelled"); err != nil {
+				if errors.Is(err, errTradeCycleCommitted) {
+					return nil
+				}
+				return err
+			}
+		}
+
+		updates := map[string]interface{}{}
+		switch action {
+		case tradeActionRemind:
+			updates["trade_reminder_sent_date"] = now
+			trade.TradeReminderSentDate = &now
+		case tradeActionExpire:
+			updates["trade_status"] = "expired"
+			updates["trade_expired_date"] = now
+			updates["last_update"] = now.UnixMilli()
+			trade.TradeStatus = "expired"
+			trade.TradeExpiredDate = &now
+			trade.LastUpdate = now.UnixMilli()
+		case tradeActionCancel:
+			by := systemActor
+			updates["trade_status"] = "cancelled"
+			updates["trade_cancelled_date"] = now
+			updates["trade_cancelled_by"] = by
+			updates["last_update"] = now.UnixMilli()
+			trade.TradeStatus = "cancelled"
+			trade.TradeCancelledDate = &now
+			trade.TradeCancelledBy = &by
+			trade.LastUpdate = now.UnixMilli()
+		}
+
+		if err := tx.Model(&Trade{}).Where("trade_id = ?", tradeID).Updates(updates).Error; err != nil {
+			return err
+		}
+		applied = true
+		return nil
+	})
+	return trade, applied, err
+}
+
+func publishTradeLifecycleEvent(trade Trade, action tradeLifecycleAction, cfg TradesConfig) {
+	var event map[string]interface{}
+	switch action {
+	case tradeActionRemind:
+		event = newStorageEvent(trade.UserIDProposed, trade.UsernameProposed, "trade_expiry_reminder")
+		reminder := map[string]interface{}{
+			"trade_id":           trade.TradeID,
+			"trade_status":       trade.TradeStatus,
+			"username_proposed":  trade.UsernameProposed,
+			"username_accepting": trade.UsernameAccepting,
+		}
+		if deadline, ok := tradeDeadline(trade, cfg); ok {
+			reminder["expires_at"] = deadline.UTC()
+		}
+		event["tradeReminders"] = []interface{}{reminder}
+	case tradeActionExpire:
+		event = newStorageEvent(trade.UserIDProposed, trade.UsernameProposed, "trade_expired")
+		event["tradeUpdates"] = []interface{}{tradeUpdatePayload(trade)}
+	case tradeActionCancel:
+		event = newStorageEvent(trade.UserIDProposed, trade.UsernameProposed, "trade_auto_cancelled")
+		event["tradeUpdates"] = []interface{}{tradeUpdatePayload(trade)}
+	default:
+		return
+	}
+
+	if err := publishStorageEventFn(event); err != nil {
+		logrus.Warnf("Failed to publish %s event for trade %s: %v", action, trade.TradeID, err)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func testTradesConfig() TradesConfig {
+	return TradesConfig{ProposalTTLHours: 48, PendingTTLHours: 72, ReminderLeadHours: 12}
+}
+
+func TestDecideTradeLifecycleAction_ProposedTimeline(t *testing.T) {
+	cfg := testTradesConfig()
+	proposed := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	trade := Trade{TradeID: "t-1", TradeStatus: "proposed", TradeProposalDate: &proposed}
+
+	if got := decideTradeLifecycleAction(trade, proposed.Add(24*time.Hour), cfg); got != tradeActionNone {
+		t.Fatalf("expected no action before reminder window, got %q", got)
+	}
+	if got := decideTradeLifecycleAction(trade, proposed.Add(40*time.Hour), cfg); got != tradeActionRemind {
+		t.Fatalf("expected remind inside reminder window, got %q", got)
+	}
+
+	sent := proposed.Add(40 * time.Hour)
+	trade.TradeReminderSentDate = &sent
+	if got := decideTradeLifecycleAction(trade, proposed.Add(41*time.Hour), cfg); got != tradeActionNone {
+		t.Fatalf("expected no second reminder, got %q", got)
+	}
+	if got := decideTradeLifecycleAction(trade, proposed.Add(48*time.Hour), cfg); got != tradeActionExpire {
+		t.Fatalf("expected expire at deadline, got %q", got)
+	}
+}
+
+func TestDecideTradeLifecycleAction_PendingCancelsOnlyWithoutConfirmations(t *testing.T) {
+	cfg := testTradesConfig()
+	accepted := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	sent := accepted.Add(61 * time.Hour)
+	trade := Trade{
+		TradeID:               "t-2",
+		TradeStatus:           "pending",
+		TradeAcceptedDate:     &accepted,
+		TradeReminderSentDate: &sent,
+	}
+	after := accepted.Add(73 * time.Hour)
+
+	if got := decideTradeLifecycleAction(trade, after, cfg); got != tradeActionCancel {
+		t.Fatalf("expected cancel for unconfirmed pending trade, got %q", got)
+	}
+
+	trade.UserAcceptingCompletionConfirmed = true
+	if got := decideTradeLifecycleAction(trade, after, cfg); got != tradeActionNone {
+		t.Fatalf("expected no action once one side confirmed, got %q", got)
+	}
+}
+
+func TestDecideTradeLifecycleAction_RemindsAgainAfterAcceptance(t *testing.T) {
+	cfg := testTradesConfig()
+	proposed := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	reminded := proposed.Add(40 * time.Hour)
+	trade := Trade{TradeID: "t-5", TradeStatus: "proposed", TradeProposalDate: &proposed, TradeReminderSentDate: &reminded}
+
+	// Accepting is a status change, so the update writes the cleared
+	// reminder date instead of keeping the proposal's.
+	for _, col := range tradeUpdateOmit("proposed", "pending") {
+		if col == "trade_reminder_sent_date" {
+			t.Fatalf("expected proposed -> pending to clear trade_reminder_sent_date")
+		}
+	}
+	accepted := proposed.Add(44 * time.Hour)
+	trade.TradeStatus = "pending"
+	trade.TradeAcceptedDate = &accepted
+	trade.TradeReminderSentDate = nil
+
+	if got := decideTradeLifecycleAction(trade, accepted.Add(61*time.Hour), cfg); got != tradeActionRemind {
+		t.Fatalf("expected a reminder before the pending deadline, got %q", got)
+	}
+
+	// An update that keeps the status keeps the reminder date.
+	found := false
+	for _, col := range tradeUpdateOmit("pending", "pending") {
+		found = found || col == "trade_reminder_sent_date"
+	}
+	if !found {
+		t.Fatalf("expected a same-status update to leave trade_reminder_sent_date alone")
+	}
+}
+
+func TestDecideTradeLifecycleAction_FallsBackToLastUpdate(t *testing.T) {
+	cfg := testTradesConfig()
+	last := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	trade := Trade{TradeID: "t-3", TradeStatus: "proposed", LastUpdate: last.UnixMilli()}
+	sent := last
+	trade.TradeReminderSentDate = &sent
+
+	if got := decideTradeLifecycleAction(trade, last.Add(49*time.Hour), cfg); got != tradeActionExpire {
+		t.Fatalf("expected expire using last_update fallback, got %q", got)
+	}
+}
+
+func TestDecideTradeLifecycleAction_IgnoresTerminalStatuses(t *testing.T) {
+	cfg := testTradesConfig()
+	for _, status := range []string{"completed", "cancelled", "denied", "expired"} {
+		trade := Trade{TradeID: "t-" + status, TradeStatus: status, LastUpdate: 1}
+		if got := decideTradeLifecycleAction(trade, time.Now(), cfg); got != tradeActionNone {
+			t.Fatalf("expected no action for %s trade, got %q", status, got)
+		}
+	}
+}
+
+func TestIsValidTransition_ExpiredIsTerminal(t *testing.T) {
+	if isValidTransition("proposed", "expired") {
+		t.Fatalf("expected clients not to expire trades")
+	}
+	if isValidTransition("expired", "pending") {
+		t.Fatalf("expected expired -> pending to be rejected")
+	}
+}
+
+func TestPublishTradeLifecycleEvent_ExpireCarriesTradeUpdate(t *testing.T) {
+	prev := publishStorageEventFn
+	t.Cleanup(func() { publishStorageEventFn = prev })
+
+	var captured map[string]interface{}
+	publishStorageEventFn = func(payload map[string]interface{}) error {
+		captured = payload
+		return nil
+	}
+
+	trade := Trade{
+		TradeID:           "t-4",
+		TradeStatus:       "expired",
+		UserIDProposed:    "u-1",
+		UsernameProposed:  "alice",
+		UsernameAccepting: "bob",
+	}
+	publishTradeLifecycleEvent(trade, tradeActionExpire, testTradesConfig())
+
+	if captured == nil {
+		t.Fatalf("expected event to be published")
+	}
+	if captured["device_id"] != storageEventDeviceID {
+		t.Fatalf("expected storage device id, got %v", captured["device_id"])
+	}
+	updates, ok := captured["tradeUpdates"].([]interface{})
+	if !ok || len(updates) != 1 {
+		t.Fatalf("expected one trade update, got %#v", captured["tradeUpdates"])
+	}
+	item := updates[0].(map[string]interface{})
+	data := item["tradeData"].(map[string]interface{})
+	if data["trade_status"] != "expired" || data["username_accepting"] != "bob" {
+		t.Fatalf("unexpected trade data: %#v", data)
+	}
+}
@@ -17,13 +17,17 @@ import (
 // TRADE VALIDATION
 // ---------------------
 
-// validTransitions defines allowed next statuses from a given current status.
+// validTransitions defines allowed next statuses from a given current status
+// in client trade payloads. "expired" is only ever set by the expiry
+// scheduler, so no client transition leads to it.
 var validTransitions = map[string][]string{
-	"proposed":  {"deleted", "denied", "pending"},
+	"proposed":  {"deleted", "denied", "pending", "countered"},
 	"pending":   {"cancelled", "completed"},
 	"cancelled": {"proposed"},
 	"denied":    {}, // once denied, no further updates allowed
 	"completed": {}, // once completed, no further updates allowed
+	"expired":   {}, // set by the expiry scheduler; propose a new trade instead
+	"countered": {}, // replaced by a counter-offer linked via counter_of_trade_id
 }
 
 // isValidTransition checks if we can go from oldStatus to newStatus.
@@ -45,13 +49,16 @@ func isValidTransition(oldStatus, newStatus string) bool {
 	return false
 }
 
-// isPokemonInPendingTrade checks if a Pokemon instance is currently involved in any pending trades
+// isPokemonInPendingTrade checks if a Pokemon instance is currently involved in any pending trades,
+// either through the single-instance columns or as part of a bundle.
 func isPokemonInPendingTrade(tx *gorm.DB, instanceID string, excludeTradeID string) (bool, error) {
 	var count int64
+	// Exclude current trade when checking updates
 	err := tx.Model(&Trade{}).
-		Where("trade_status = ? AND (pokemon_instance_id_user_proposed = ? OR pokemon_instance_id_user_accepting = ?)",
-							"pending", instanceID, instanceID).
-		Not("trade_id = ?", excludeTradeID). // Exclude current trade when checking updates
+		Where("trade_status = ? AND (pokemon_instance_id_user_proposed = ? OR pokemon_instance_id_user_accepting = ? OR "+
+			"trade_id IN (SELECT trade_id FROM trade_items WHERE instance_id = ?))",
+			"pending", instanceID, instanceID, instanceID).
+		Not("trade_id = ?", excludeTradeID).
 		Count(&count).Error
 
 	if err != nil {
@@ -62,35 +69,130 @@ func isPokemonInPendingTrade(tx *gorm.DB, instanceID string, excludeTradeID stri
 	return count > 0, nil
 }
 
-// validatePokemonAvailability checks if both Pokemon instances are available for trading
-func validatePokemonAvailability(tx *gorm.DB, proposedInstanceID, acceptingInstanceID, tradeID string) error {
+// validatePokemonAvailability checks if every Pokemon instance on both sides is available for trading
+func validatePokemonAvailability(tx *gorm.DB, proposedInstanceIDs, acceptingInstanceIDs []string, tradeID string) error {
 	// Check proposed Pokemon
-	if isPending, err := isPokemonInPendingTrade(tx, proposedInstanceID, tradeID); err != nil {
-		return err
-	} else if isPending {
-		return fmt.Errorf("proposed Pokemon %s is already in a pending trade", proposedInstanceID)
+	for _, id := range proposedInstanceIDs {
+		if isPending, err := isPokemonInPendingTrade(tx, id, tradeID); err != nil {
+			return err
+		} else if isPending {
+			return fmt.Errorf("proposed Pokemon %s is already in a pending trade", id)
+		}
 	}
 
 	// Check accepting Pokemon
-	if isPending, err := isPokemonInPendingTrade(tx, acceptingInstanceID, tradeID); err != nil {
-		return err
-	} else if isPending {
-		return fmt.Errorf("accepting Pokemon %s is already in a pending trade", acceptingInstanceID)
+	for _, id := range acceptingInstanceIDs {
+		if isPending, err := isPokemonInPendingTrade(tx, id, tradeID); err != nil {
+			return err
+		} else if isPending {
+			return fmt.Errorf("accepting Pokemon %s is already in a pending trade", id)
+		}
 	}
 
 	return nil
 }
 
+// applyCounterOffer links a new proposal to the one it answers and retires
+// the original. Only the receiving side of a proposal may counter it, so the
+// counter must swap the usernames of the original.
+func applyCounterOffer(tx *gorm.DB, counter Trade) (Trade, error) {
+	var original Trade
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("trade_id = ?", *counter.CounterOfTradeID).First(&original).Error; err != nil {
+		return original, fmt.Errorf("original trade %s: %w", *counter.CounterOfTradeID, err)
+	}
+	if original.TradeStatus != "proposed" {
+		return original, fmt.Errorf("original trade %s is %s, only proposed trades can be countered",
+			original.TradeID, original.TradeStatus)
+	}
+	if original.TradeCycleID != nil {
+		return original, fmt.Errorf("trade %s is a leg of cycle %s and cannot be countered",
+			original.TradeID, *original.TradeCycleID)
+	}
+	if counter.UsernameProposed != original.UsernameAccepting || counter.UsernameAccepting != original.UsernameProposed {
+		return original, fmt.Errorf("counter-offer %s does not come from the receiver of trade %s",
+			counter.TradeID, original.TradeID)
+	}
+
+	nowTs := time.Now().UnixMilli()
+	if err := tx.Model(&Trade{}).Where("trade_id = ?", original.TradeID).Updates(map[string]interface{}{
+		"trade_status": "countered",
+		"last_update":  nowTs,
+	}).Error; err != nil {
+		return original, err
+	}
+	original.TradeStatus = "countered"
+	original.LastUpdate = nowTs
+	return original, nil
+}
+
+// publishCounteredTrade tells both sides that a proposal was replaced.
+func publishCounteredTrade(original Trade) {
+	event := newStorageEvent(original.UserIDProposed, original.UsernameProposed, "trade_countered")
+	event["tradeUpdates"] = []interface{}{tradeUpdatePayload(original)}
+	if err := publishStorageEventFn(event); err != nil {
+		logrus.Warnf("Failed to publish countered event for trade %s: %v", original.TradeID, err)
+	}
+}
+
+// publishTradeStatusChanged announces a status change storage accepted from
+// a trainer, who is the event's user. Trade pushes are only sent for it,
+// never for the client's own, unvalidated payload.
+func publishTradeStatusChanged(trade Trade, senderID string) {
+	event := newStorageEvent(senderID, "", "trade_status_changed")
+	event["tradeUpdates"] = []interface{}{tradeUpdatePayload(trade)}
+	if err := publishStorageEventFn(event); err != nil {
+		logrus.Warnf("Failed to publish status change of trade %s: %v", trade.TradeID, err)
+	}
+}
+
 // ---------------------
 // TRADES
 // ---------------------
 
+// tradeUpdateOmit lists the columns a client update leaves alone. A status
+// change clears trade_reminder_sent_date rather than keeping it, so a trade
+// reminded while proposed is reminded again before its pending deadline.
+func tradeUpdateOmit(oldStatus, newStatus string) []string {
+	omit := []string{"trade_expired_date", "counter_of_trade_id", "trade_cycle_id",
+		"user_1_trade_satisfaction", "user_2_trade_satisfaction"}
+	if oldStatus == newStatus {
+		omit = append(omit, "trade_reminder_sent_date")
+	}
+	return omit
+}
+
+// cancelledBy is the trade_cancelled_by to store for an update sent by
+// senderID: the stored value once set, the sender's username when the
+// update cancels, and nil otherwise. The payload's own trade_cancelled_by is
+// never used, since cancellations count against a trainer's reputation.
+func cancelledBy(existing *Trade, updates Trade, senderID string) *string {
+	if existing != nil && existing.TradeCancelledBy != nil {
+		return existing.TradeCancelledBy
+	}
+	if updates.TradeStatus != "cancelled" {
+		return nil
+	}
+	var name string
+	switch senderID {
+	case updates.UserIDProposed:
+		name = updates.UsernameProposed
+	case updates.UserIDAccepting:
+		name = updates.UsernameAccepting
+	default:
+		return nil
+	}
+	return &name
+}
+
 // parseAndUpsertTrades processes incoming trade updates in a transactional manner
 // and enforces valid status transitions, chronological updates, etc.
 func parseAndUpsertTrades(data map[string]interface{}) (createdTrades, updatedTrades, droppedTrades int, err error) {
 	tradeUpdates, _ := data["tradeUpdates"].([]interface{})
 	// Parse nullable TraceID
 	traceID := parseNullableString(data["trace_id"])
+	// The sender is not notified about changes they made themselves.
+	senderID := fmt.Sprintf("%v", data["user_id"])
 
 	for _, t := range tradeUpdates {
 		tradeObj, ok := t.(map[string]interface{})
@@ -125,9 +227,6 @@ func parseAndUpsertTrades(data map[string]interface{}) (createdTrades, updatedTr
 		tradeCompletedDate := parseOptionalTime(fmt.Sprintf("%v", tradeData["trade_completed_date"]))
 		tradeCancelledDate := parseOptionalTime(fmt.Sprintf("%v", tradeData["trade_cancelled_date"]))
 
-		// Nullable fields for cancelled details
-		tradeCancelledBy := parseNullableString(tradeData["trade_cancelled_by"])
-
 		// Boolean and integer fields
 		isSpecialTrade := parseOptionalBool(tradeData["is_special_trade"])
 		isRegisteredTrade := parseOptionalBool(tradeData["is_registered_trade"])
@@ -163,14 +262,22 @@ func parseAndUpsertTrades(data map[string]interface{}) (createdTrades, updatedTr
 			friendshipLevel = "Good"
 		}
 
-		// Satisfaction ratings
-		user1TradeSatisfaction := parseOptionalBool(tradeData["user_1_trade_satisfaction"])
-		user2TradeSatisfaction := parseOptionalBool(tradeData["user_2_trade_satisfaction"])
-
 		// Additional fields
 		pokemonInstanceIDUserProposed := fmt.Sprintf("%v", tradeData["pokemon_instance_id_user_proposed"])
 		pokemonInstanceIDUserAccepting := fmt.Sprintf("%v", tradeData["pokemon_instance_id_user_accepting"])
 
+		// Bundles: the single-instance columns always mirror the first item
+		// on each side so older readers keep working.
+		proposedItems, acceptingItems := resolveTradeSides(tradeData,
+			pokemonInstanceIDUserProposed, pokemonInstanceIDUserAccepting)
+		if len(proposedItems) > 0 {
+			pokemonInstanceIDUserProposed = proposedItems[0]
+		}
+		if len(acceptingItems) > 0 {
+			pokemonInstanceIDUserAccepting = acceptingItems[0]
+		}
+		counterOfTradeID := parseNullableString(tradeData["counter_of_trade_id"])
+
 		// Parse last_update into an int64
 		rawLastUpdate := fmt.Sprintf("%v", tradeData["last_update"])
 		var parsedLastUpdate int64
@@ -197,22 +304,28 @@ func parseAndUpsertTrades(data map[string]interface{}) (createdTrades, updatedTr
 			TradeAcceptedDate:  tradeAcceptedDate,
 			TradeCompletedDate: tradeCompletedDate,
 			TradeCancelledDate: tradeCancelledDate,
-			TradeCancelledBy:   tradeCancelledBy,
 
 			IsSpecialTrade:                   isSpecialTrade,
 			IsRegisteredTrade:                isRegisteredTrade,
 			IsLuckyTrade:                     isLuckyTrade,
 			TradeDustCost:                    tradeDustCost,
 			TradeFriendshipLevel:             friendshipLevel,
-			User1TradeSatisfaction:           user1TradeSatisfaction,
-			User2TradeSatisfaction:           user2TradeSatisfaction,
 			UserProposedCompletionConfirmed:  userProposedCompletionConfirmed, // New field
 			UserAcceptingCompletionConfirmed: userAcceptingCompletionConfirmed,
 
+			CounterOfTradeID: counterOfTradeID,
+
 			TraceID:    traceID,
 			LastUpdate: parsedLastUpdate,
 		}
 
+		var counteredTrade *Trade
+		// Cycle legs that ended without completing; their cycles break.
+		var brokenLegs []Trade
+		repriced := false
+		concluded := false
+		notifyStatus := ""
+
 		// Use a transaction so we can lock the row to avoid race conditions.
 		txErr := DB.Transaction(func(tx *gorm.DB) error {
 			var existingTrade Trade
@@ -223,10 +336,7 @@ func parseAndUpsertTrades(data map[string]interface{}) (createdTrades, updatedTr
 			if errors.Is(findErr, gorm.ErrRecordNotFound) {
 				// If trade not found: only create if not "deleted".
 				if tradeStatus == "proposed" {
-					if err := validatePokemonAvailability(tx,
-						updates.PokemonInstanceIDUserProposed,
-						updates.PokemonInstanceIDUserAccepting,
-						tradeID); err != nil {
+					if err := validatePokemonAvailability(tx, proposedItems, acceptingItems, tradeID); err != nil {
 						logrus.Warnf("Trade creation failed: %v", err)
 						return nil // Skip creation but don't fail the transaction
 					}
@@ -235,12 +345,35 @@ func parseAndUpsertTrades(data map[string]interface{}) (createdTrades, updatedTr
 					logrus.Infof("[DEBUG] Trade %s incoming status is 'deleted'; skipping creation.", tradeID)
 					return nil
 				}
+				if tradeStatus == "expired" {
+					logrus.Warnf("Trade %s: only the expiry scheduler sets 'expired'; skipping creation.", tradeID)
+					return nil
+				}
+				if err := validateTradeItemOwners(tx, updates, proposedItems, acceptingItems); err != nil {
+					logrus.Warnf("Trade %s rejected: %v", tradeID, err)
+					return nil // Skip creation but don't fail the transaction
+				}
+				if tradeStatus == "proposed" && updates.CounterOfTradeID != nil {
+					original, err := applyCounterOffer(tx, updates)
+					if err != nil {
+						logrus.Warnf("Counter-offer %s rejected: %v", tradeID, err)
+						return nil // Skip creation but don't fail the transaction
+					}
+					counteredTrade = &original
+				}
+				repriced = priceTrade(tx, &updates, proposedItems, acceptingItems)
+				updates.TradeCancelledBy = cancelledBy(nil, updates, senderID)
 				// Otherwise, insert new trade
 				if createErr := tx.Create(&updates).Error; createErr != nil {
 					logrus.Errorf("Failed to create Trade %s: %v", tradeID, createErr)
 					return createErr
 				}
+				if err := syncTradeItems(tx, tradeID, proposedItems, acceptingItems); err != nil {
+					logrus.Errorf("Failed to store items for Trade %s: %v", tradeID, err)
+					return err
+				}
 				createdTrades++
+				notifyStatus = updates.TradeStatus
 				logrus.Infof("Created new Trade record %s with status=%s", tradeID, updates.TradeStatus)
 				return nil
 			}
@@ -250,6 +383,14 @@ func parseAndUpsertTrades(data map[string]interface{}) (createdTrades, updatedTr
 				return findErr
 			}
 
+			if err := checkTradeCycleLegMayEnd(tx, existingTrade, tradeStatus); err != nil {
+				if errors.Is(err, errTradeCycleCommitted) {
+					logrus.Warnf("Trade %s cannot become %s: %v", tradeID, tradeStatus, err)
+					return nil // Skip update but don't fail the transaction
+				}
+				return err
+			}
+
 			// If incoming is "deleted", physically remove the row.
 			if tradeStatus == "deleted" {
 				logrus.Infof("[DEBUG] Deleting Trade %s because incoming status is 'deleted'.", tradeID)
@@ -257,6 +398,13 @@ func parseAndUpsertTrades(data map[string]interface{}) (createdTrades, updatedTr
 					logrus.Errorf("Failed to delete Trade %s: %v", tradeID, delErr)
 					return delErr
 				}
+				if delErr := tx.Delete(&TradeItem{}, "trade_id = ?", tradeID).Error; delErr != nil {
+					logrus.Errorf("Failed to delete items for Trade %s: %v", tradeID, delErr)
+					return delErr
+				}
+				if existingTrade.TradeCycleID != nil {
+					brokenLegs = append(brokenLegs, existingTrade)
+				}
 				droppedTrades++
 				return nil
 			}
@@ -275,12 +423,32 @@ func parseAndUpsertTrades(data map[string]interface{}) (createdTrades, updatedTr
 				return nil
 			}
 
+			// The trainers never change, and only a proposal may change the
+			// bundle. Every other update, acceptance included, works on the
+			// bundle as stored, whatever the payload lists.
+			updates.UserIDProposed, updates.UsernameProposed = existingTrade.UserIDProposed, existingTrade.UsernameProposed
+			updates.UserIDAccepting, updates.UsernameAccepting = existingTrade.UserIDAccepting, existingTrade.UsernameAccepting
+			updates.TradeCancelledBy = cancelledBy(&existingTrade, updates, senderID)
+			if updates.TradeStatus != "proposed" {
+				stored, storedAccepting, err := loadTradeSides(tx, existingTrade)
+				if err != nil {
+					logrus.Errorf("Failed to load items for Trade %s: %v", tradeID, err)
+					return err
+				}
+				proposedItems, acceptingItems = stored, storedAccepting
+				updates.PokemonInstanceIDUserProposed = existingTrade.PokemonInstanceIDUserProposed
+				updates.PokemonInstanceIDUserAccepting = existingTrade.PokemonInstanceIDUserAccepting
+			}
+			if updates.TradeStatus == "proposed" || (existingTrade.TradeStatus == "proposed" && updates.TradeStatus == "pending") {
+				if err := validateTradeItemOwners(tx, updates, proposedItems, acceptingItems); err != nil {
+					logrus.Warnf("Trade %s update rejected: %v", tradeID, err)
+					return nil // Skip update but don't fail the transaction
+				}
+			}
+
 			// For existing trades transitioning to "pending", validate Pokemon availability
 			if existingTrade.TradeStatus != "pending" && updates.TradeStatus == "pending" {
-				if err := validatePokemonAvailability(tx,
-					updates.PokemonInstanceIDUserProposed,
-					updates.PokemonInstanceIDUserAccepting,
-					tradeID); err != nil {
+				if err := validatePokemonAvailability(tx, proposedItems, acceptingItems, tradeID); err != nil {
 					logrus.Warnf("Cannot transition trade to pending: %v", err)
 					return nil // Skip update but don't fail the transaction
 				}
@@ -293,32 +461,61 @@ func parseAndUpsertTrades(data map[string]interface{}) (createdTrades, updatedTr
 						tradeID, updates.UserProposedCompletionConfirmed, updates.UserAcceptingCompletionConfirmed)
 					return nil // Skip update but don't fail the transaction
 				}
+				// A cycle leg waits until every leg of its cycle is accepted.
+				if existingTrade.TradeCycleID != nil {
+					var legs []Trade
+					if err := tx.Where("trade_cycle_id = ?", *existingTrade.TradeCycleID).Find(&legs).Error; err != nil {
+						return err
+					}
+					if !tradeCycleReady(legs, tradeID) {
+						logrus.Warnf("Cannot complete trade %s: cycle %s has legs that are not pending yet",
+							tradeID, *existingTrade.TradeCycleID)
+						return nil
+					}
+				}
 			}
 
 			// *** STORE OLD STATUS BEFORE UPDATING ***
 			oldStatus := existingTrade.TradeStatus
+			updates.TradeCycleID = existingTrade.TradeCycleID
+
+			// Terms are re-priced while the bundle can still change and
+			// frozen from then on.
+			if updates.TradeStatus == "proposed" || (oldStatus == "proposed" && updates.TradeStatus == "pending") {
+				repriced = priceTrade(tx, &updates, proposedItems, acceptingItems)
+			} else {
+				keepStoredTradeTerms(&updates, existingTrade)
+			}
 
-			// Perform the Trade record update
+			// Perform the Trade record update. Scheduler-owned columns and
+			// ratings (set through tradeRatings) are never taken from
+			// client trade payloads.
+			updates.TradeReminderSentDate = nil
 			if errUpdate := tx.Model(&existingTrade).
 				Select("*").
+				Omit(tradeUpdateOmit(oldStatus, updates.TradeStatus)...).
 				Updates(&updates).Error; errUpdate != nil {
 				logrus.Errorf("Failed to update Trade %s: %v", tradeID, errUpdate)
 				return errUpdate
 			}
 
+			// The bundle is negotiable while proposed and frozen from then on.
+			if updates.TradeStatus == "proposed" {
+				if err := syncTradeItems(tx, tradeID, proposedItems, acceptingItems); err != nil {
+					logrus.Errorf("Failed to store items for Trade %s: %v", tradeID, err)
+					return err
+				}
+			}
+
 			// *** NEW: Drop other trades if transitioning from proposed to pending ***
 			if oldStatus == "proposed" && updates.TradeStatus == "pending" {
+				allItems := append(append([]string{}, proposedItems...), acceptingItems...)
 				var conflicts []Trade
 				err := tx.Where("trade_status = ? AND trade_id <> ? AND ("+
-					"pokemon_instance_id_user_proposed = ? OR "+
-					"pokemon_instance_id_user_accepting = ? OR "+
-					"pokemon_instance_id_user_proposed = ? OR "+
-					"pokemon_instance_id_user_accepting = ?)",
-					"proposed", tradeID,
-					updates.PokemonInstanceIDUserProposed,
-					updates.PokemonInstanceIDUserProposed,
-					updates.PokemonInstanceIDUserAccepting,
-					updates.PokemonInstanceIDUserAccepting).
+					"pokemon_instance_id_user_proposed IN ? OR "+
+					"pokemon_instance_id_user_accepting IN ? OR "+
+					"trade_id IN (SELECT trade_id FROM trade_items WHERE instance_id IN ?))",
+					"proposed", tradeID, allItems, allItems, allItems).
 					Find(&conflicts).Error
 				if err != nil {
 					logrus.Errorf("Error finding conflicting trades for Trade %s: %v", tradeID, err)
@@ -328,6 +525,10 @@ func parseAndUpsertTrades(data map[string]interface{}) (createdTrades, updatedTr
 						if delErr := tx.Delete(&Trade{}, "trade_id = ?", conflictTrade.TradeID).Error; delErr != nil {
 							logrus.Errorf("Failed to delete conflicting Trade %s: %v", conflictTrade.TradeID, delErr)
 						} else {
+							_ = tx.Delete(&TradeItem{}, "trade_id = ?", conflictTrade.TradeID).Error
+							if conflictTrade.TradeCycleID != nil {
+								brokenLegs = append(brokenLegs, conflictTrade)
+							}
 							droppedTrades++
 						}
 					}
@@ -338,155 +539,61 @@ func parseAndUpsertTrades(data map[string]interface{}) (createdTrades, updatedTr
 			// Now handle the "pending" → "completed" swap logic
 			// ---------------------------------------------------------
 			if oldStatus == "pending" && updates.TradeStatus == "completed" {
-
-				// 1) Get the two instance IDs from the updated trade data
-				proposedInstanceID := updates.PokemonInstanceIDUserProposed
-				acceptingInstanceID := updates.PokemonInstanceIDUserAccepting
-
-				if proposedInstanceID == "" || acceptingInstanceID == "" {
-					logrus.Warnf("Cannot swap instances for Trade %s because instance IDs are missing.", tradeID)
-					return nil
-				}
-
-				// 2) Fetch the two instances from DB, using "instance_id" as the column
-				var proposedInstance, acceptingInstance PokemonInstance
-				if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
-					Where("instance_id = ?", proposedInstanceID).
-					First(&proposedInstance).Error; err != nil {
-					logrus.Errorf("Failed to load Proposed instance %s: %v", proposedInstanceID, err)
-					return err
-				}
-
-				if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
-					Where("instance_id = ?", acceptingInstanceID).
-					First(&acceptingInstance).Error; err != nil {
-					logrus.Errorf("Failed to load Accepting instance %s: %v", acceptingInstanceID, err)
-					return err
-				}
-
-				// 3) Swap user IDs
-				oldProposedUserID := proposedInstance.UserID
-				oldAcceptingUserID := acceptingInstance.UserID
-				proposedInstance.UserID = oldAcceptingUserID
-				acceptingInstance.UserID = oldProposedUserID
-
-				// 4) Update ownership state
-				proposedInstance.IsCaught = true
-				proposedInstance.IsForTrade = false
-				proposedInstance.IsWanted = false
-				proposedInstance.MostWanted = false
-
-				acceptingInstance.IsCaught = true
-				acceptingInstance.IsForTrade = false
-				acceptingInstance.IsWanted = false
-				acceptingInstance.MostWanted = false
-
-				// 5) Update last_update for both instances
-				nowTs := time.Now().UnixMilli()
-				proposedInstance.LastUpdate = nowTs
-				acceptingInstance.LastUpdate = nowTs
-
-				// 6) Save changes
-				proposedUpdates := map[string]interface{}{
-					"user_id":      proposedInstance.UserID,
-					"is_caught":    proposedInstance.IsCaught,
-					"is_for_trade": proposedInstance.IsForTrade,
-					"is_wanted":    proposedInstance.IsWanted,
-					"most_wanted":  proposedInstance.MostWanted,
-					"registered":   true,
-					"last_update":  nowTs,
-				}
-				if instanceHasColumn("is_traded") {
-					proposedUpdates["is_traded"] = true
-				}
-				proposedUpdates = filterInstanceColumns(proposedUpdates)
-
-				if err := tx.Model(&PokemonInstance{}).
-					Where("instance_id = ?", proposedInstanceID).
-					Updates(proposedUpdates).Error; err != nil {
-					logrus.Errorf("Failed to update proposedInstance %s after trade completion: %v", proposedInstanceID, err)
+				// Use the bundle frozen when the trade went pending.
+				proposedIDs, acceptingIDs, err := loadTradeSides(tx, updates)
+				if err != nil {
+					logrus.Errorf("Failed to load items for Trade %s: %v", tradeID, err)
 					return err
 				}
-
-				acceptingUpdates := map[string]interface{}{
-					"user_id":      acceptingInstance.UserID,
-					"is_caught":    acceptingInstance.IsCaught,
-					"is_for_trade": acceptingInstance.IsForTrade,
-					"is_wanted":    acceptingInstance.IsWanted,
-					"most_wanted":  acceptingInstance.MostWanted,
-					"registered":   true,
-					"last_update":  nowTs,
-				}
-				if instanceHasColumn("is_traded") {
-					acceptingUpdates["is_traded"] = true
-				}
-				acceptingUpdates = filterInstanceColumns(acceptingUpdates)
-
-				if err := tx.Model(&PokemonInstance{}).
-					Where("instance_id = ?", acceptingInstanceID).
-					Updates(acceptingUpdates).Error; err != nil {
-					logrus.Errorf("Failed to update acceptingInstance %s after trade completion: %v", acceptingInstanceID, err)
-					return err
+				// Cycle legs are one-sided; other trades need both sides.
+				if len(proposedIDs)+len(acceptingIDs) == 0 ||
+					(updates.TradeCycleID == nil && (len(proposedIDs) == 0 || len(acceptingIDs) == 0)) {
+					logrus.Warnf("Cannot swap instances for Trade %s because instance IDs are missing.", tradeID)
+					return nil
 				}
 
-				proposedVariant := normalizeOptionalString(proposedInstance.VariantID)
-				acceptingVariant := normalizeOptionalString(acceptingInstance.VariantID)
-
-				if err := syncRegistrationForVariant(tx, oldProposedUserID, proposedVariant); err != nil {
-					logrus.Errorf("Failed to sync registration for old proposed owner %s variant %s: %v", oldProposedUserID, proposedVariant, err)
-					return err
-				}
-				if err := syncRegistrationForVariant(tx, proposedInstance.UserID, proposedVariant); err != nil {
-					logrus.Errorf("Failed to sync registration for new proposed owner %s variant %s: %v", proposedInstance.UserID, proposedVariant, err)
-					return err
-				}
-				if err := syncRegistrationForVariant(tx, oldAcceptingUserID, acceptingVariant); err != nil {
-					logrus.Errorf("Failed to sync registration for old accepting owner %s variant %s: %v", oldAcceptingUserID, acceptingVariant, err)
-					return err
-				}
-				if err := syncRegistrationForVariant(tx, acceptingInstance.UserID, acceptingVariant); err != nil {
-					logrus.Errorf("Failed to sync registration for new accepting owner %s variant %s: %v", acceptingInstance.UserID, acceptingVariant, err)
+				if err := transferTradeItems(tx, updates, proposedIDs, acceptingIDs); err != nil {
+					logrus.Errorf("Failed to swap instances for Trade %s: %v", tradeID, err)
 					return err
 				}
 
-				if err := syncInstanceTagsForInstance(
-					tx,
-					proposedInstance.UserID,
-					proposedInstanceID,
-					proposedInstance.CaughtTags,
-					proposedInstance.TradeTags,
-					proposedInstance.WantedTags,
-					proposedInstance.Favorite,
-					proposedInstance.IsForTrade,
-					proposedInstance.IsWanted,
-					proposedInstance.MostWanted,
-				); err != nil {
-					logrus.Errorf("Failed to sync instance_tags for proposed instance %s: %v", proposedInstanceID, err)
-					return err
-				}
-				if err := syncInstanceTagsForInstance(
-					tx,
-					acceptingInstance.UserID,
-					acceptingInstanceID,
-					acceptingInstance.CaughtTags,
-					acceptingInstance.TradeTags,
-					acceptingInstance.WantedTags,
-					acceptingInstance.Favorite,
-					acceptingInstance.IsForTrade,
-					acceptingInstance.IsWanted,
-					acceptingInstance.MostWanted,
-				); err != nil {
-					logrus.Errorf("Failed to sync instance_tags for accepting instance %s: %v", acceptingInstanceID, err)
-					return err
-				}
+				logrus.Infof("Successfully swapped traded instances %s (%d <-> %d)", tradeID, len(proposedIDs), len(acceptingIDs))
+			}
 
-				logrus.Infof("Successfully swapped traded instances %s", tradeID)
+			if oldStatus != updates.TradeStatus &&
+				(updates.TradeStatus == "completed" || updates.TradeStatus == "cancelled") {
+				concluded = true
+			}
+			if oldStatus != updates.TradeStatus {
+				notifyStatus = updates.TradeStatus
+			}
+			if oldStatus != updates.TradeStatus && updates.TradeCycleID != nil &&
+				(updates.TradeStatus == "denied" || updates.TradeStatus == "cancelled") {
+				brokenLegs = append(brokenLegs, updates)
 			}
 
 			updatedTrades++
 			return nil
 		})
 
+		if txErr == nil && counteredTrade != nil {
+			publishCounteredTrade(*counteredTrade)
+		}
+		if txErr == nil && repriced {
+			publishRepricedTrade(updates)
+		}
+		if txErr == nil && concluded {
+			refreshReputationForTrade(updates)
+		}
+		if txErr == nil && notifyStatus != "" {
+			publishTradeStatusChanged(updates, senderID)
+			recordNotifications(tradeNotifications(updates, notifyStatus, senderID))
+		}
+		if txErr == nil {
+			for _, leg := range brokenLegs {
+				breakTradeCycle(leg)
+			}
+		}
 		if txErr != nil {
 			// If the transaction itself failed, bubble that up or keep going
 			logrus.Errorf("Transaction error for Trade %s: %v", tradeID, txErr)
@@ -0,0 +1,50 @@
+package main
+
+import "testing"
+
+func TestPublishTradeStatusChanged_NamesTheSender(t *testing.T) {
+	prev := publishStorageEventFn
+	t.Cleanup(func() { publishStorageEventFn = prev })
+
+	var captured map[string]interface{}
+	publishStorageEventFn = func(payload map[string]interface{}) error {
+		captured = payload
+		return nil
+	}
+
+	publishTradeStatusChanged(Trade{TradeID: "t-9", TradeStatus: "pending", UsernameProposed: "ash", UsernameAccepting: "misty"}, "u-misty")
+
+	if captured == nil || captured["source"] != "storage" || captured["event"] != "trade_status_changed" || captured["user_id"] != "u-misty" {
+		t.Fatalf("unexpected event %#v", captured)
+	}
+	updates, _ := captured["tradeUpdates"].([]interface{})
+	if len(updates) != 1 {
+		t.Fatalf("expected one trade update, got %#v", captured["tradeUpdates"])
+	}
+	data := updates[0].(map[string]interface{})["tradeData"].(map[string]interface{})
+	if data["trade_id"] != "t-9" || data["trade_status"] != "pending" {
+		t.Fatalf("unexpected trade data %#v", data)
+	}
+}
+
+func TestCancelledBy_IsTheSendersSide(t *testing.T) {
+	trade := Trade{TradeStatus: "cancelled", UserIDProposed: "u-ash", UsernameProposed: "ash", UserIDAccepting: "u-misty", UsernameAccepting: "misty"}
+
+	if got := cancelledBy(nil, trade, "u-misty"); got == nil || *got != "misty" {
+		t.Fatalf("expected the accepting sender, got %v", got)
+	}
+	if got := cancelledBy(&Trade{TradeStatus: "pending"}, trade, "u-ash"); got == nil || *got != "ash" {
+		t.Fatalf("expected the proposing sender, got %v", got)
+	}
+	if got := cancelledBy(nil, trade, "u-brock"); got != nil {
+		t.Fatalf("expected no canceller for a sender outside the trade, got %q", *got)
+	}
+	trade.TradeStatus = "pending"
+	if got := cancelledBy(nil, trade, "u-ash"); got != nil {
+		t.Fatalf("expected no canceller when the update does not cancel, got %q", *got)
+	}
+	system := systemActor
+	if got := cancelledBy(&Trade{TradeStatus: "cancelled", TradeCancelledBy: &system}, trade, "u-ash"); got == nil || *got != systemActor {
+		t.Fatalf("expected the stored canceller kept, got %v", got)
+	}
+}
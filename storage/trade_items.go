@@ -0,0 +1,258 @@
+// trade_items.go
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	tradeSideProposed  = "proposed"
+	tradeSideAccepting = "accepting"
+)
+
+// TradeItem mirrors the "trade_items" table. A trade holds one row per
+// instance on each side; the legacy single-instance columns on trades keep
+// pointing at the first item of each side.
+type TradeItem struct {
+	TradeID    string    `gorm:"column:trade_id;primaryKey"`
+	InstanceID string    `gorm:"column:instance_id;primaryKey"`
+	Side       string    `gorm:"column:side"`
+	Position   int       `gorm:"column:position"`
+	CreatedAt  time.Time `gorm:"column:created_at;autoCreateTime"`
+}
+
+func (TradeItem) TableName() string {
+	return "trade_items"
+}
+
+// parseStringList accepts a JSON array of ids and returns the trimmed,
+// de-duplicated non-empty entries in their original order.
+func parseStringList(value interface{}) []string {
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil
+	}
+	seen := make(map[string]struct{}, len(items))
+	out := make([]string, 0, len(items))
+	for _, item := range items {
+		if item == nil {
+			continue
+		}
+		id := strings.TrimSpace(fmt.Sprintf("%v", item))
+		if id == "" {
+			continue
+		}
+		if _, dup := seen[id]; dup {
+			continue
+		}
+		seen[id] = struct{}{}
+		out = append(out, id)
+	}
+	return out
+}
+
+// resolveTradeSides reads the bundle lists from tradeData, falling back to
+// the single-instance columns for clients that do not send bundles.
+func resolveTradeSides(tradeData map[string]interface{}, proposedID, acceptingID string) (proposed, accepting []string) {
+	proposed = parseStringList(tradeData["pokemon_instance_ids_user_proposed"])
+	accepting = parseStringList(tradeData["pokemon_instance_ids_user_accepting"])
+	if len(proposed) == 0 && isPresentID(proposedID) {
+		proposed = []string{proposedID}
+	}
+	if len(accepting) == 0 && isPresentID(acceptingID) {
+		accepting = []string{acceptingID}
+	}
+	return proposed, accepting
+}
+
+func isPresentID(id string) bool {
+	id = strings.TrimSpace(id)
+	return id != "" && id != "<nil>"
+}
+
+// syncTradeItems replaces the stored bundle for a trade.
+func syncTradeItems(tx *gorm.DB, tradeID string, proposed, accepting []string) error {
+	if err := tx.Where("trade_id = ?", tradeID).Delete(&TradeItem{}).Error; err != nil {
+		return err
+	}
+
+	rows := make([]TradeItem, 0, len(proposed)+len(accepting))
+	for i, id := range proposed {
+		rows = append(rows, TradeItem{TradeID: tradeID, InstanceID: id, Side: tradeSideProposed, Position: i})
+	}
+	for i, id := range accepting {
+		rows = append(rows, TradeItem{TradeID: tradeID, InstanceID: id, Side: tradeSideAccepting, Position: i})
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&rows).Error
+}
+
+// loadTradeSides returns the stored bundle for a trade, falling back to the
+// single-instance columns for trades created before bundles existed.
+func loadTradeSides(tx *gorm.DB, trade Trade) (proposed, accepting []string, err error) {
+	var rows []TradeItem
+	if err := tx.Where("trade_id = ?", trade.TradeID).Order("side, position").Find(&rows).Error; err != nil {
+		return nil, nil, err
+	}
+	for _, row := range rows {
+		if row.Side == tradeSideProposed {
+			proposed = append(proposed, row.InstanceID)
+		} else {
+			accepting = append(accepting, row.InstanceID)
+		}
+	}
+	if len(proposed) == 0 && isPresentID(trade.PokemonInstanceIDUserProposed) {
+		proposed = []string{trade.PokemonInstanceIDUserProposed}
+	}
+	if len(accepting) == 0 && isPresentID(trade.PokemonInstanceIDUserAccepting) {
+		accepting = []string{trade.PokemonInstanceIDUserAccepting}
+	}
+	return proposed, accepting, nil
+}
+
+// checkTradeItemOwners requires every instance on each side to belong to
+// that side's trainer. owners maps instance IDs to their current user_id.
+func checkTradeItemOwners(owners map[string]string, proposerID, accepterID string, proposed, accepting []string) error {
+	sides := []struct {
+		name, ownerID string
+		ids           []string
+	}{
+		{tradeSideProposed, proposerID, proposed},
+		{tradeSideAccepting, accepterID, accepting},
+	}
+	for _, side := range sides {
+		for _, id := range side.ids {
+			ownerID, ok := owners[id]
+			if !ok {
+				return fmt.Errorf("trade instance %s not found", id)
+			}
+			if side.ownerID == "" || ownerID != side.ownerID {
+				return fmt.Errorf("%s instance %s does not belong to user %q", side.name, id, side.ownerID)
+			}
+		}
+	}
+	return nil
+}
+
+// validateTradeItemOwners checks the bundle of trade against the current
+// owners of its instances.
+func validateTradeItemOwners(tx *gorm.DB, trade Trade, proposed, accepting []string) error {
+	ids := append(append([]string{}, proposed...), accepting...)
+	if len(ids) == 0 {
+		return nil
+	}
+	var rows []PokemonInstance
+	if err := tx.Select("instance_id", "user_id").Where("instance_id IN ?", ids).Find(&rows).Error; err != nil {
+		return fmt.Errorf("load trade instance owners: %w", err)
+	}
+	owners := make(map[string]string, len(rows))
+	for _, row := range rows {
+		owners[row.InstanceID] = row.UserID
+	}
+	return checkTradeItemOwners(owners, trade.UserIDProposed, trade.UserIDAccepting, proposed, accepting)
+}
+
+// transferTradeItems moves every instance on each side of a completed trade
+// to the other trainer. All instances are locked up front so the swap is
+// all-or-nothing within the caller's transaction.
+func transferTradeItems(tx *gorm.DB, trade Trade, proposedIDs, acceptingIDs []string) error {
+	lockIDs := append(append([]string{}, proposedIDs...), acceptingIDs...)
+	var locked []PokemonInstance
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("instance_id IN ?", lockIDs).
+		Find(&locked).Error; err != nil {
+		return fmt.Errorf("lock trade instances: %w", err)
+	}
+	byID := make(map[string]PokemonInstance, len(locked))
+	owners := make(map[string]string, len(locked))
+	for _, inst := range locked {
+		byID[inst.InstanceID] = inst
+		owners[inst.InstanceID] = inst.UserID
+	}
+
+	// Each side still has to belong to its trainer; its instances go to the
+	// trainer on the other side.
+	proposerID, accepterID := trade.UserIDProposed, trade.UserIDAccepting
+	if err := checkTradeItemOwners(owners, proposerID, accepterID, proposedIDs, acceptingIDs); err != nil {
+		return err
+	}
+
+	nowTs := time.Now().UnixMilli()
+	for _, id := range proposedIDs {
+		if err := transferInstanceOwnership(tx, byID[id], accepterID, nowTs); err != nil {
+			return err
+		}
+	}
+	for _, id := range acceptingIDs {
+		if err := transferInstanceOwnership(tx, byID[id], proposerID, nowTs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func transferInstanceOwnership(tx *gorm.DB, instance PokemonInstance, newOwnerID string, nowTs int64) error {
+	oldOwnerID := instance.UserID
+	instance.UserID = newOwnerID
+	instance.IsCaught = true
+	instance.IsForTrade = false
+	instance.IsWanted = false
+	instance.MostWanted = false
+
+	fields := map[string]interface{}{
+		"user_id":      instance.UserID,
+		"is_caught":    instance.IsCaught,
+		"is_for_trade": instance.IsForTrade,
+		"is_wanted":    instance.IsWanted,
+		"most_wanted":  instance.MostWanted,
+		"registered":   true,
+		"last_update":  nowTs,
+	}
+	if instanceHasColumn("is_traded") {
+		fields["is_traded"] = true
+	}
+	fields = filterInstanceColumns(fields)
+
+	if err := tx.Model(&PokemonInstance{}).
+		Where("instance_id = ?", instance.InstanceID).
+		Updates(fields).Error; err != nil {
+		logrus.Errorf("Failed to update instance %s after trade completion: %v", instance.InstanceID, err)
+		return err
+	}
+
+	variant := normalizeOptionalString(instance.VariantID)
+	if err := syncRegistrationForVariant(tx, oldOwnerID, variant); err != nil {
+		logrus.Errorf("Failed to sync registration for old owner %s variant %s: %v", oldOwnerID, variant, err)
+		return err
+	}
+	if err := syncRegistrationForVariant(tx, newOwnerID, variant); err != nil {
+		logrus.Errorf("Failed to sync registration for new owner %s variant %s: %v", newOwnerID, variant, err)
+		return err
+	}
+
+	if err := syncInstanceTagsForInstance(
+		tx,
+		instance.UserID,
+		instance.InstanceID,
+		instance.CaughtTags,
+		instance.TradeTags,
+		instance.WantedTags,
+		instance.Favorite,
+		instance.IsForTrade,
+		instance.IsWanted,
+		instance.MostWanted,
+	); err != nil {
+		logrus.Errorf("Failed to sync instance_tags for instance %s: %v", instance.InstanceID, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestParseStringList_TrimsAndDeduplicates(t *testing.T) {
+	got := parseStringList([]interface{}{" a ", "b", "a", "", nil, 3})
+	want := []string{"a", "b", "3"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %#v, got %#v", want, got)
+	}
+	if parseStringList("not-a-list") != nil {
+		t.Fatalf("expected nil for non-list input")
+	}
+}
+
+func TestResolveTradeSides_PrefersBundles(t *testing.T) {
+	tradeData := map[string]interface{}{
+		"pokemon_instance_ids_user_proposed":  []interface{}{"p-1", "p-2"},
+		"pokemon_instance_ids_user_accepting": []interface{}{"a-1"},
+	}
+	proposed, accepting := resolveTradeSides(tradeData, "legacy-p", "legacy-a")
+	if !reflect.DeepEqual(proposed, []string{"p-1", "p-2"}) {
+		t.Fatalf("unexpected proposed side: %#v", proposed)
+	}
+	if !reflect.DeepEqual(accepting, []string{"a-1"}) {
+		t.Fatalf("unexpected accepting side: %#v", accepting)
+	}
+}
+
+func TestResolveTradeSides_FallsBackToSingleColumns(t *testing.T) {
+	proposed, accepting := resolveTradeSides(map[string]interface{}{}, "p-1", "<nil>")
+	if !reflect.DeepEqual(proposed, []string{"p-1"}) {
+		t.Fatalf("unexpected proposed side: %#v", proposed)
+	}
+	if len(accepting) != 0 {
+		t.Fatalf("expected empty accepting side for <nil> id, got %#v", accepting)
+	}
+}
+
+func TestIsValidTransition_CounteredIsTerminal(t *testing.T) {
+	if !isValidTransition("proposed", "countered") {
+		t.Fatalf("expected proposed -> countered to be valid")
+	}
+	if isValidTransition("countered", "pending") {
+		t.Fatalf("expected countered -> pending to be rejected")
+	}
+}
+
+func setupMockDB(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+	sqlDB, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherRegexp))
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	gdb, err := gorm.Open(mysql.New(mysql.Config{Conn: sqlDB, SkipInitializeWithVersion: true}),
+		&gorm.Config{SkipDefaultTransaction: true, Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("failed to open gorm over sqlmock: %v", err)
+	}
+	prev := DB
+	DB = gdb
+	t.Cleanup(func() {
+		DB = prev
+		_ = sqlDB.Close()
+	})
+	return mock
+}
+
+func TestCheckTradeItemOwners(t *testing.T) {
+	owners := map[string]string{"p-1": "alice", "p-2": "alice", "a-1": "bob", "x-1": "carol"}
+	if err := checkTradeItemOwners(owners, "alice", "bob", []string{"p-1", "p-2"}, []string{"a-1"}); err != nil {
+		t.Fatalf("expected a valid bundle, got %v", err)
+	}
+	for name, tc := range map[string][2][]string{
+		"third party on a side": {{"p-1", "x-1"}, {"a-1"}},
+		"swapped sides":         {{"a-1"}, {"p-1"}},
+		"unknown instance":      {{"p-1"}, {"missing"}},
+	} {
+		if err := checkTradeItemOwners(owners, "alice", "bob", tc[0], tc[1]); err == nil {
+			t.Fatalf("%s: expected the bundle to be rejected", name)
+		}
+	}
+	if err := checkTradeItemOwners(owners, "", "bob", []string{"p-1"}, nil); err == nil {
+		t.Fatalf("expected a side without a trainer to be rejected")
+	}
+}
+
+func TestApplyCounterOffer(t *testing.T) {
+	mock := setupMockDB(t)
+	tradeCols := []string{"trade_id", "trade_status", "username_proposed", "username_accepting"}
+	original := "t-1"
+
+	mock.ExpectQuery("SELECT \\* FROM `trades` WHERE trade_id = \\? .*FOR UPDATE").
+		WithArgs(original, 1).
+		WillReturnRows(sqlmock.NewRows(tradeCols).AddRow(original, "proposed", "alice", "bob"))
+	if _, err := applyCounterOffer(DB, Trade{TradeID: "t-2", CounterOfTradeID: &original,
+		UsernameProposed: "carol", UsernameAccepting: "alice"}); err == nil {
+		t.Fatalf("expected a counter from outside the trade to be rejected")
+	}
+
+	mock.ExpectQuery("SELECT \\* FROM `trades` WHERE trade_id = \\? .*FOR UPDATE").
+		WithArgs(original, 1).
+		WillReturnRows(sqlmock.NewRows(tradeCols).AddRow(original, "pending", "alice", "bob"))
+	if _, err := applyCounterOffer(DB, Trade{TradeID: "t-2", CounterOfTradeID: &original,
+		UsernameProposed: "bob", UsernameAccepting: "alice"}); err == nil {
+		t.Fatalf("expected a pending trade not to be countered")
+	}
+
+	mock.ExpectQuery("SELECT \\* FROM `trades` WHERE trade_id = \\? .*FOR UPDATE").
+		WithArgs(original, 1).
+		WillReturnRows(sqlmock.NewRows(tradeCols).AddRow(original, "proposed", "alice", "bob"))
+	mock.ExpectExec("UPDATE `trades` SET .*`trade_status`=\\?.* WHERE trade_id = \\?").
+		WithArgs(sqlmock.AnyArg(), "countered", original).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	got, err := applyCounterOffer(DB, Trade{TradeID: "t-2", CounterOfTradeID: &original,
+		UsernameProposed: "bob", UsernameAccepting: "alice"})
+	if err != nil || got.TradeStatus != "countered" {
+		t.Fatalf("expected the original to be countered, got %q err=%v", got.TradeStatus, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestTransferTradeItems_RejectsForeignInstance(t *testing.T) {
+	mock := setupMockDB(t)
+	mock.ExpectQuery("SELECT \\* FROM `instances` WHERE instance_id IN \\(\\?,\\?\\) FOR UPDATE").
+		WithArgs("p-1", "a-1").
+		WillReturnRows(sqlmock.NewRows([]string{"instance_id", "user_id"}).
+			AddRow("p-1", "alice").AddRow("a-1", "carol"))
+
+	trade := Trade{TradeID: "t-1", UserIDProposed: "alice", UserIDAccepting: "bob"}
+	if err := transferTradeItems(DB, trade, []string{"p-1"}, []string{"a-1"}); err == nil {
+		t.Fatalf("expected a third party's instance to block the transfer")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestTransferTradeItems_SwapsSides(t *testing.T) {
+	prevColumns := instanceColumns
+	t.Cleanup(func() { instanceColumns = prevColumns })
+	instanceColumns = map[string]bool{"user_id": true, "is_caught": true, "is_for_trade": true,
+		"is_wanted": true, "most_wanted": true, "registered": true, "last_update": true}
+
+	mock := setupMockDB(t)
+	mock.ExpectQuery("SELECT \\* FROM `instances` WHERE instance_id IN \\(\\?,\\?\\) FOR UPDATE").
+		WithArgs("p-1", "a-1").
+		WillReturnRows(sqlmock.NewRows([]string{"instance_id", "user_id"}).
+			AddRow("p-1", "alice").AddRow("a-1", "bob"))
+	for _, move := range [][2]string{{"p-1", "bob"}, {"a-1", "alice"}} {
+		mock.ExpectExec("UPDATE `instances` SET .*`user_id`=\\? WHERE instance_id = \\?").
+			WithArgs(true, false, false, sqlmock.AnyArg(), false, true, move[1], move[0]).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("DELETE FROM `instance_tags` WHERE instance_id = \\?").
+			WithArgs(move[0]).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+
+	trade := Trade{TradeID: "t-1", UserIDProposed: "alice", UserIDAccepting: "bob"}
+	if err := transferTradeItems(DB, trade, []string{"p-1"}, []string{"a-1"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet sqlmock expectations: %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+// trade_match_filters.go
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Trainers narrow their matches with a "match" object inside an instance's
+// filter column: wanted_filters on a wanted instance, trade_filters on one
+// offered for trade. The other keys of those columns are the client's list
+// filters and are ignored here.
+//
+//	{"match": {"min_attack_iv": 10, "lucky_only": true, "backgrounds": ["11"], "max_distance_km": 25}}
+//
+// Every key is optional and is checked against the other instance of a
+// pair; a key whose field the other instance leaves unset does not pass.
+// backgrounds lists the accepted location cards and, on a wanted instance,
+// replaces the usual rule that both location cards are equal.
+// trade_filters may not use the IV, lucky or friendship keys: the wanted
+// instance they would be checked against is a wish, which leaves its IVs,
+// lucky flag and friendship level unset.
+// max_distance_km is kept on the pair and checked by search against where
+// both trainers currently are.
+
+const matchFilterKey = "match"
+
+var (
+	errMatchFilterIV         = errors.New("IV minimums must be 0-15")
+	errMatchFilterIVTotal    = errors.New("min_iv_total must be 0-45")
+	errMatchFilterDistance   = errors.New("max_distance_km must be positive")
+	errMatchFilterFriendship = errors.New("min_friendship_level must be 0-4")
+	errMatchFilterTradeSide  = errors.New("trade_filters cannot set IV, lucky or friendship keys: a wanted instance has none")
+)
+
+// matchFilter is the parsed "match" object.
+type matchFilter struct {
+	MinAttackIV        *int           `json:"min_attack_iv"`
+	MinDefenseIV       *int           `json:"min_defense_iv"`
+	MinStaminaIV       *int           `json:"min_stamina_iv"`
+	MinIVTotal         *int           `json:"min_iv_total"`
+	LuckyOnly          bool           `json:"lucky_only"`
+	NoShadow           bool           `json:"no_shadow"` // rejects shadow and purified
+	Backgrounds        backgroundList `json:"backgrounds"`
+	MaxDistanceKM      *float64       `json:"max_distance_km"`
+	MinFriendshipLevel *int           `json:"min_friendship_level"`
+}
+
+// backgroundList accepts location cards as strings or numbers.
+type backgroundList []string
+
+func (b *backgroundList) UnmarshalJSON(data []byte) error {
+	var raw []interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	out := make(backgroundList, 0, len(raw))
+	for _, v := range raw {
+		switch t := v.(type) {
+		case string:
+			if s := strings.TrimSpace(t); s != "" {
+				out = append(out, s)
+			}
+		case float64:
+			out = append(out, strconv.FormatFloat(t, 'f', -1, 64))
+		default:
+			return fmt.Errorf("background %v is not a string or number", v)
+		}
+	}
+	*b = out
+	return nil
+}
+
+// parseMatchFilter reads the "match" object of a filter column. A column
+// without one yields the zero filter, which lets everything through.
+func parseMatchFilter(raw *string) (matchFilter, error) {
+	var f matchFilter
+	if raw == nil || strings.TrimSpace(*raw) == "" {
+		return f, nil
+	}
+	var column map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(*raw), &column); err != nil {
+		return f, err
+	}
+	obj, ok := column[matchFilterKey]
+	if !ok || string(obj) == "null" {
+		return f, nil
+	}
+	if err := json.Unmarshal(obj, &f); err != nil {
+		return matchFilter{}, err
+	}
+	if err := f.validate(); err != nil {
+		return matchFilter{}, err
+	}
+	return f, nil
+}
+
+// parseTradeMatchFilter is parseMatchFilter for trade_filters, which also
+// refuses the keys a wanted instance cannot satisfy.
+func parseTradeMatchFilter(raw *string) (matchFilter, error) {
+	f, err := parseMatchFilter(raw)
+	if err != nil {
+		return f, err
+	}
+	if f.MinAttackIV != nil || f.MinDefenseIV != nil || f.MinStaminaIV != nil || f.MinIVTotal != nil ||
+		f.LuckyOnly || f.MinFriendshipLevel != nil {
+		return matchFilter{}, errMatchFilterTradeSide
+	}
+	return f, nil
+}
+
+func (f matchFilter) validate() error {
+	for _, iv := range []*int{f.MinAttackIV, f.MinDefenseIV, f.MinStaminaIV} {
+		if iv != nil && (*iv < 0 || *iv > 15) {
+			return errMatchFilterIV
+		}
+	}
+	if f.MinIVTotal != nil && (*f.MinIVTotal < 0 || *f.MinIVTotal > 45) {
+		return errMatchFilterIVTotal
+	}
+	if f.MaxDistanceKM != nil && *f.MaxDistanceKM <= 0 {
+		return errMatchFilterDistance
+	}
+	if f.MinFriendshipLevel != nil && (*f.MinFriendshipLevel < 0 || *f.MinFriendshipLevel > 4) {
+		return errMatchFilterFriendship
+	}
+	return nil
+}
+
+// allows reports whether other passes every key but max_distance_km.
+func (f matchFilter) allows(other matchInstance) bool {
+	if !atLeast(other.AttackIV, f.MinAttackIV) || !atLeast(other.DefenseIV, f.MinDefenseIV) ||
+		!atLeast(other.StaminaIV, f.MinStaminaIV) || !atLeast(other.FriendshipLevel, f.MinFriendshipLevel) {
+		return false
+	}
+	if f.MinIVTotal != nil {
+		if other.AttackIV == nil || other.DefenseIV == nil || other.StaminaIV == nil ||
+			*other.AttackIV+*other.DefenseIV+*other.StaminaIV < *f.MinIVTotal {
+			return false
+		}
+	}
+	if f.LuckyOnly && !other.Lucky {
+		return false
+	}
+	if f.NoShadow && (other.Shadow || other.Purified) {
+		return false
+	}
+	return len(f.Backgrounds) == 0 || f.allowsBackground(other.LocationCard)
+}
+
+func (f matchFilter) allowsBackground(card *string) bool {
+	if card == nil {
+		return false
+	}
+	for _, b := range f.Backgrounds {
+		if b == *card {
+			return true
+		}
+	}
+	return false
+}
+
+func atLeast(v, min *int) bool {
+	return min == nil || (v != nil && *v >= *min)
+}
+
+// pairMaxDistanceKM is the tighter of the two sides' max_distance_km, or
+// nil when neither sets one.
+func pairMaxDistanceKM(a, b matchFilter) *float64 {
+	switch {
+	case a.MaxDistanceKM == nil:
+		return b.MaxDistanceKM
+	case b.MaxDistanceKM == nil || *a.MaxDistanceKM <= *b.MaxDistanceKM:
+		return a.MaxDistanceKM
+	default:
+		return b.MaxDistanceKM
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import "testing"
+
+func TestParseMatchFilter(t *testing.T) {
+	raw := `{"communityDayFilter": true, "match": {"min_attack_iv": 10, "lucky_only": true, "backgrounds": ["11", 12], "max_distance_km": 25}}`
+	f, err := parseMatchFilter(&raw)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if f.MinAttackIV == nil || *f.MinAttackIV != 10 || !f.LuckyOnly || f.MaxDistanceKM == nil || *f.MaxDistanceKM != 25 {
+		t.Fatalf("unexpected filter: %+v", f)
+	}
+	if len(f.Backgrounds) != 2 || f.Backgrounds[0] != "11" || f.Backgrounds[1] != "12" {
+		t.Fatalf("unexpected backgrounds: %v", f.Backgrounds)
+	}
+
+	listOnly := `{"communityDayFilter": true}`
+	if f, err := parseMatchFilter(&listOnly); err != nil || f.LuckyOnly || f.Backgrounds != nil {
+		t.Fatalf("expected the zero filter without a match key, got %+v err=%v", f, err)
+	}
+	if _, err := parseMatchFilter(nil); err != nil {
+		t.Fatalf("expected a nil column to parse, got %v", err)
+	}
+
+	for _, bad := range []string{
+		`{"match": {"min_attack_iv": 16}}`,
+		`{"match": {"min_iv_total": 46}}`,
+		`{"match": {"max_distance_km": 0}}`,
+		`{"match": {"min_friendship_level": 5}}`,
+		`{"match": {"backgrounds": [true]}}`,
+		`{"match": "lucky"}`,
+	} {
+		if _, err := parseMatchFilter(&bad); err == nil {
+			t.Fatalf("expected %s to be rejected", bad)
+		}
+	}
+}
+
+func TestParseTradeMatchFilter_RejectsWantedSideKeys(t *testing.T) {
+	ok := `{"match": {"no_shadow": true, "backgrounds": ["11"], "max_distance_km": 10}}`
+	if f, err := parseTradeMatchFilter(&ok); err != nil || !f.NoShadow || len(f.Backgrounds) != 1 {
+		t.Fatalf("expected shadow, background and distance keys to parse, got %+v err=%v", f, err)
+	}
+	for _, bad := range []string{
+		`{"match": {"min_attack_iv": 10}}`,
+		`{"match": {"min_defense_iv": 0}}`,
+		`{"match": {"min_stamina_iv": 1}}`,
+		`{"match": {"min_iv_total": 30}}`,
+		`{"match": {"lucky_only": true}}`,
+		`{"match": {"min_friendship_level": 2}}`,
+	} {
+		if _, err := parseTradeMatchFilter(&bad); err != errMatchFilterTradeSide {
+			t.Fatalf("expected %s to be rejected on trade_filters, got %v", bad, err)
+		}
+		if _, err := parseMatchFilter(&bad); err != nil {
+			t.Fatalf("expected %s to stay valid on wanted_filters, got %v", bad, err)
+		}
+	}
+}
+
+func TestMatchFilterAllows(t *testing.T) {
+	intp := func(v int) *int { return &v }
+	strp := func(v string) *string { return &v }
+
+	good := matchInstance{AttackIV: intp(15), DefenseIV: intp(14), StaminaIV: intp(13), Lucky: true, FriendshipLevel: intp(4), LocationCard: strp("11")}
+	f := matchFilter{MinAttackIV: intp(12), MinIVTotal: intp(40), LuckyOnly: true, NoShadow: true, Backgrounds: backgroundList{"11"}, MinFriendshipLevel: intp(3)}
+	if !f.allows(good) {
+		t.Fatalf("expected %+v to pass %+v", good, f)
+	}
+
+	for name, mutate := range map[string]func(*matchInstance){
+		"unknown IV":   func(m *matchInstance) { m.AttackIV = nil },
+		"low IV total": func(m *matchInstance) { m.StaminaIV = intp(5) },
+		"not lucky":    func(m *matchInstance) { m.Lucky = false },
+		"shadow":       func(m *matchInstance) { m.Shadow = true },
+		"purified":     func(m *matchInstance) { m.Purified = true },
+		"background":   func(m *matchInstance) { m.LocationCard = nil },
+		"friendship":   func(m *matchInstance) { m.FriendshipLevel = intp(2) },
+	} {
+		other := good
+		mutate(&other)
+		if f.allows(other) {
+			t.Fatalf("expected the filter to reject: %s", name)
+		}
+	}
+
+	if !(matchFilter{}).allows(matchInstance{}) {
+		t.Fatalf("expected the zero filter to pass everything")
+	}
+}
+
+func TestTradeMatchReason_Filters(t *testing.T) {
+	strp := func(v string) *string { return &v }
+	ten, twenty := 10.0, 20.0
+
+	trade := matchInstance{PokemonID: 1, LocationCard: strp("12")}
+	wanted := matchInstance{PokemonID: 1, LocationCard: strp("11")}
+	if got := tradeMatchReason(trade, wanted); got != "" {
+		t.Fatalf("expected different location cards to mismatch, got %q", got)
+	}
+	wanted.wantedFilter = matchFilter{Backgrounds: backgroundList{"11", "12"}}
+	if got := tradeMatchReason(trade, wanted); got != tradeMatchCompatible {
+		t.Fatalf("expected listed backgrounds to be accepted, got %q", got)
+	}
+	wanted.wantedFilter.LuckyOnly = true
+	if got := tradeMatchReason(trade, wanted); got != "" {
+		t.Fatalf("expected lucky_only to reject a non-lucky offer, got %q", got)
+	}
+
+	// A realistic wanted instance: a wish without IVs, lucky flag or
+	// friendship level. The offer's trade_filters can still narrow by
+	// shadow and background, and an IV key there is dropped with a warning
+	// rather than failing every pair.
+	offered := matchInstance{InstanceID: "i-trade", PokemonID: 1, LocationCard: strp("11"),
+		TradeFilters: strp(`{"match": {"min_attack_iv": 10, "backgrounds": ["11"]}}`)}
+	wish := matchInstance{InstanceID: "i-wanted", PokemonID: 1, LocationCard: strp("11")}
+	pair := []matchInstance{offered, wish}
+	parseMatchFilters(pair)
+	if pair[0].tradeFilter.MinAttackIV != nil {
+		t.Fatalf("expected the IV key on trade_filters to be refused, got %+v", pair[0].tradeFilter)
+	}
+	if got := tradeMatchReason(pair[0], pair[1]); got != tradeMatchCompatible {
+		t.Fatalf("expected the offer to match a wanted instance without IVs, got %q", got)
+	}
+	pair[0].tradeFilter = matchFilter{Backgrounds: backgroundList{"11"}}
+	if got := tradeMatchReason(pair[0], pair[1]); got != tradeMatchCompatible {
+		t.Fatalf("expected a background key on trade_filters to pass, got %q", got)
+	}
+	pair[0].tradeFilter = matchFilter{NoShadow: true}
+	pair[1].Purified = true
+	if got := tradeMatchReason(pair[0], pair[1]); got != "" {
+		t.Fatalf("expected no_shadow on trade_filters to reject a purified wish, got %q", got)
+	}
+
+	if d := pairMaxDistanceKM(matchFilter{MaxDistanceKM: &twenty}, matchFilter{MaxDistanceKM: &ten}); d == nil || *d != 10 {
+		t.Fatalf("expected the tighter distance, got %v", d)
+	}
+	if d := pairMaxDistanceKM(matchFilter{}, matchFilter{}); d != nil {
+		t.Fatalf("expected no distance limit, got %v", *d)
+	}
+}
@@ -0,0 +1,387 @@
+// trade_matches.go
+
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// trade_matches pairs every instance offered for trade with every instance
+// another trainer wants that it satisfies, so search can answer "who wants
+// what I have" with one indexed lookup. The indexer follows the instances
+// change feed (change_seq): each changed instance has its pairs rebuilt
+// from its current state. Deletes do not stamp change_seq, so they clear
+// their pairs directly (cleanupTradeMatches) and the sweep catches the rest.
+
+const (
+	tradeMatchBatchSize = 500
+	// tradeMatchCandidateCap bounds the candidates read for one instance;
+	// a species more popular than this is indexed partially.
+	tradeMatchCandidateCap = 5000
+)
+
+// Match reasons. An exact match pins gender and all moves on both sides;
+// a compatible one leaves some of them open on either side.
+const (
+	tradeMatchExact      = "exact"
+	tradeMatchCompatible = "compatible"
+)
+
+// TradeMatch mirrors "trade_matches".
+type TradeMatch struct {
+	TradeInstanceID  string `gorm:"column:trade_instance_id;primaryKey"`
+	TradeUserID      string `gorm:"column:trade_user_id"`
+	WantedInstanceID string `gorm:"column:wanted_instance_id;primaryKey"`
+	WantedUserID     string `gorm:"column:wanted_user_id"`
+	MatchReason      string `gorm:"column:match_reason"`
+	// MaxDistanceKM is the tighter max_distance_km of the two sides'
+	// match filters; search checks it against the trainers' locations.
+	MaxDistanceKM *float64 `gorm:"column:max_distance_km"`
+}
+
+func (TradeMatch) TableName() string {
+	return "trade_matches"
+}
+
+const createTradeMatchesTableSQL = `
+CREATE TABLE IF NOT EXISTS trade_matches (
+  trade_instance_id  VARCHAR(255) NOT NULL,
+  trade_user_id      VARCHAR(255) NOT NULL,
+  wanted_instance_id VARCHAR(255) NOT NULL,
+  wanted_user_id     VARCHAR(255) NOT NULL,
+  match_reason       VARCHAR(16) NOT NULL,
+  max_distance_km    DOUBLE NULL,
+  created_at         DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  PRIMARY KEY (trade_instance_id, wanted_instance_id),
+  KEY idx_trade_matches_wanted (wanted_instance_id),
+  KEY idx_trade_matches_trade_user (trade_user_id, wanted_user_id),
+  KEY idx_trade_matches_wanted_user (wanted_user_id, trade_user_id)
+)`
+
+// trade_match_state holds how far along the change feed the index is.
+const createTradeMatchStateTableSQL = `
+CREATE TABLE IF NOT EXISTS trade_match_state (
+  id              TINYINT UNSIGNED NOT NULL PRIMARY KEY,
+  last_change_seq BIGINT UNSIGNED NOT NULL
+)`
+
+// ensureTradeMatchesSchema creates the match tables and the index the
+// candidate lookups use. Needs ensureChangeSequenceSchema first.
+func ensureTradeMatchesSchema() error {
+	if err := DB.Exec(createTradeMatchesTableSQL).Error; err != nil {
+		return fmt.Errorf("create trade_matches: %w", err)
+	}
+	if err := DB.Exec(createTradeMatchStateTableSQL).Error; err != nil {
+		return fmt.Errorf("create trade_match_state: %w", err)
+	}
+	if err := DB.Exec("INSERT IGNORE INTO trade_match_state (id, last_change_seq) VALUES (1, 0)").Error; err != nil {
+		return fmt.Errorf("seed trade_match_state: %w", err)
+	}
+	// Pairs indexed before match filters existed are rebuilt from scratch.
+	hasDistance, err := columnExists("trade_matches", "max_distance_km")
+	if err != nil {
+		return fmt.Errorf("check trade_matches.max_distance_km: %w", err)
+	}
+	if !hasDistance {
+		if err := addMissingColumns("trade_matches", []addedColumn{
+			{Name: "max_distance_km", Definition: "DOUBLE NULL"},
+		}); err != nil {
+			return err
+		}
+		if err := DB.Exec("UPDATE trade_match_state SET last_change_seq = 0 WHERE id = 1").Error; err != nil {
+			return fmt.Errorf("reset trade_match_state: %w", err)
+		}
+	}
+	exists, err := indexExists("instances", "idx_instances_match")
+	if err != nil {
+		return fmt.Errorf("check index idx_instances_match: %w", err)
+	}
+	if !exists {
+		if err := DB.Exec("CREATE INDEX idx_instances_match ON instances (pokemon_id, shiny, shadow)").Error; err != nil {
+			return fmt.Errorf("create index idx_instances_match: %w", err)
+		}
+		logrus.Info("Added index idx_instances_match on instances")
+	}
+	return nil
+}
+
+// matchInstance is the part of an instance that matching reads.
+type matchInstance struct {
+	InstanceID     string  `gorm:"column:instance_id"`
+	UserID         string  `gorm:"column:user_id"`
+	PokemonID      int     `gorm:"column:pokemon_id"`
+	Shiny          bool    `gorm:"column:shiny"`
+	Shadow         bool    `gorm:"column:shadow"`
+	CostumeID      *int    `gorm:"column:costume_id"`
+	Gender         *string `gorm:"column:gender"`
+	LocationCard   *string `gorm:"column:location_card"`
+	Dynamax        bool    `gorm:"column:dynamax"`
+	Gigantamax     bool    `gorm:"column:gigantamax"`
+	FastMoveID     *int    `gorm:"column:fast_move_id"`
+	ChargedMove1ID *int    `gorm:"column:charged_move1_id"`
+	ChargedMove2ID *int    `gorm:"column:charged_move2_id"`
+	IsForTrade     bool    `gorm:"column:is_for_trade"`
+	IsWanted       bool    `gorm:"column:is_wanted"`
+	ChangeSeq      uint64  `gorm:"column:change_seq"`
+
+	// Read by match filters.
+	AttackIV        *int    `gorm:"column:attack_iv"`
+	DefenseIV       *int    `gorm:"column:defense_iv"`
+	StaminaIV       *int    `gorm:"column:stamina_iv"`
+	Lucky           bool    `gorm:"column:lucky"`
+	Purified        bool    `gorm:"column:purified"`
+	FriendshipLevel *int    `gorm:"column:friendship_level"`
+	TradeFilters    *string `gorm:"column:trade_filters"`
+	WantedFilters   *string `gorm:"column:wanted_filters"`
+
+	tradeFilter  matchFilter
+	wantedFilter matchFilter
+}
+
+const matchInstanceColumns = "instance_id, user_id, pokemon_id, shiny, shadow, costume_id, gender, location_card, " +
+	"dynamax, gigantamax, fast_move_id, charged_move1_id, charged_move2_id, is_for_trade, is_wanted, change_seq, " +
+	"attack_iv, defense_iv, stamina_iv, lucky, purified, friendship_level, trade_filters, wanted_filters"
+
+// parseMatchFilters fills in the parsed match filters of insts. A filter
+// that does not parse is ignored, so the instance matches as if unset.
+func parseMatchFilters(insts []matchInstance) {
+	for i := range insts {
+		inst := &insts[i]
+		var err error
+		if inst.tradeFilter, err = parseTradeMatchFilter(inst.TradeFilters); err != nil {
+			logrus.Warnf("Ignoring invalid trade_filters match of instance %s: %v", inst.InstanceID, err)
+		}
+		if inst.wantedFilter, err = parseMatchFilter(inst.WantedFilters); err != nil {
+			logrus.Warnf("Ignoring invalid wanted_filters match of instance %s: %v", inst.InstanceID, err)
+		}
+	}
+}
+
+// tradeMatchReason reports whether the instance offered for trade
+// satisfies the wanted one and why. Species, shiny, shadow, costume,
+// location card and dynamax/gigantamax must agree; gender and moves only
+// have to agree where both sides set them. Charged moves match in either
+// order. Each side's match filter must pass the other side. The result is
+// "" when they do not match.
+func tradeMatchReason(trade, wanted matchInstance) string {
+	if trade.PokemonID != wanted.PokemonID || trade.Shiny != wanted.Shiny || trade.Shadow != wanted.Shadow {
+		return ""
+	}
+	if !equalIntPtr(trade.CostumeID, wanted.CostumeID) {
+		return ""
+	}
+	// A wanted instance listing backgrounds accepts any of them instead.
+	if len(wanted.wantedFilter.Backgrounds) == 0 && !equalStringPtr(trade.LocationCard, wanted.LocationCard) {
+		return ""
+	}
+	if !wanted.wantedFilter.allows(trade) || !trade.tradeFilter.allows(wanted) {
+		return ""
+	}
+	if trade.Dynamax != wanted.Dynamax || trade.Gigantamax != wanted.Gigantamax {
+		return ""
+	}
+
+	exact := true
+	if trade.Gender == nil || wanted.Gender == nil {
+		exact = false
+	} else if *trade.Gender != *wanted.Gender {
+		return ""
+	}
+	if trade.FastMoveID == nil || wanted.FastMoveID == nil {
+		exact = false
+	} else if *trade.FastMoveID != *wanted.FastMoveID {
+		return ""
+	}
+	if trade.ChargedMove1ID == nil || trade.ChargedMove2ID == nil || wanted.ChargedMove1ID == nil || wanted.ChargedMove2ID == nil {
+		exact = false
+	} else {
+		a1, a2, b1, b2 := *trade.ChargedMove1ID, *trade.ChargedMove2ID, *wanted.ChargedMove1ID, *wanted.ChargedMove2ID
+		if !(a1 == b1 && a2 == b2) && !(a1 == b2 && a2 == b1) {
+			return ""
+		}
+	}
+
+	if exact {
+		return tradeMatchExact
+	}
+	return tradeMatchCompatible
+}
+
+func equalIntPtr(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+func equalStringPtr(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+// tradeMatchesFor pairs inst with the candidates it matches, on whichever
+// sides inst is listed. Candidates of the same trainer never match.
+func tradeMatchesFor(inst matchInstance, candidates []matchInstance) []TradeMatch {
+	var out []TradeMatch
+	for _, cand := range candidates {
+		if cand.UserID == inst.UserID || cand.InstanceID == inst.InstanceID {
+			continue
+		}
+		if inst.IsForTrade && cand.IsWanted {
+			if reason := tradeMatchReason(inst, cand); reason != "" {
+				out = append(out, TradeMatch{
+					TradeInstanceID: inst.InstanceID, TradeUserID: inst.UserID,
+					WantedInstanceID: cand.InstanceID, WantedUserID: cand.UserID,
+					MatchReason:   reason,
+					MaxDistanceKM: pairMaxDistanceKM(inst.tradeFilter, cand.wantedFilter),
+				})
+			}
+		}
+		if inst.IsWanted && cand.IsForTrade {
+			if reason := tradeMatchReason(cand, inst); reason != "" {
+				out = append(out, TradeMatch{
+					TradeInstanceID: cand.InstanceID, TradeUserID: cand.UserID,
+					WantedInstanceID: inst.InstanceID, WantedUserID: inst.UserID,
+					MatchReason:   reason,
+					MaxDistanceKM: pairMaxDistanceKM(cand.tradeFilter, inst.wantedFilter),
+				})
+			}
+		}
+	}
+	return out
+}
+
+var tradeMatchIndexMu sync.Mutex
+
+// IndexTradeMatches rebuilds the pairs of every instance changed since the
+// last run. Scheduled every few seconds; a run still in progress makes the
+// next one a no-op.
+func IndexTradeMatches() {
+	if !tradeMatchIndexMu.TryLock() {
+		return
+	}
+	defer tradeMatchIndexMu.Unlock()
+
+	var watermark uint64
+	if err := DB.Raw("SELECT last_change_seq FROM trade_match_state WHERE id = 1").Scan(&watermark).Error; err != nil {
+		logrus.Errorf("Failed to read trade match watermark: %v", err)
+		return
+	}
+
+	indexed := 0
+	for {
+		var changed []matchInstance
+		if err := DB.Table("instances").
+			Select(matchInstanceColumns).
+			Where("change_seq > ?", watermark).
+			Order("change_seq").
+			Limit(tradeMatchBatchSize).
+			Find(&changed).Error; err != nil {
+			logrus.Errorf("Failed to read changed instances for trade matches: %v", err)
+			return
+		}
+		if len(changed) == 0 {
+			break
+		}
+
+		parseMatchFilters(changed)
+		for _, inst := range changed {
+			if err := reindexTradeMatches(inst); err != nil {
+				// Leave the watermark before this instance so it is retried.
+				logrus.Errorf("Failed to index trade matches for instance %s: %v", inst.InstanceID, err)
+				saveTradeMatchWatermark(watermark)
+				return
+			}
+			watermark = inst.ChangeSeq
+			indexed++
+		}
+		saveTradeMatchWatermark(watermark)
+		if len(changed) < tradeMatchBatchSize {
+			break
+		}
+	}
+	if indexed > 0 {
+		logrus.Infof("Indexed trade matches for %d changed instances", indexed)
+	}
+}
+
+func saveTradeMatchWatermark(seq uint64) {
+	if err := DB.Exec("UPDATE trade_match_state SET last_change_seq = ? WHERE id = 1 AND last_change_seq < ?", seq, seq).Error; err != nil {
+		logrus.Errorf("Failed to save trade match watermark: %v", err)
+	}
+}
+
+// tradeMatchCandidateSides is the condition picking the instances inst
+// could pair with: wanted ones if it is for trade, and the other way round.
+func tradeMatchCandidateSides(inst matchInstance) string {
+	switch {
+	case inst.IsForTrade && inst.IsWanted:
+		return "(is_wanted = 1 OR is_for_trade = 1)"
+	case inst.IsForTrade:
+		return "is_wanted = 1"
+	default:
+		return "is_for_trade = 1"
+	}
+}
+
+// reindexTradeMatches replaces inst's pairs with those of its current state.
+func reindexTradeMatches(inst matchInstance) error {
+	var candidates []matchInstance
+	if inst.IsForTrade || inst.IsWanted {
+		if err := DB.Table("instances").
+			Select(matchInstanceColumns).
+			Where("pokemon_id = ? AND shiny = ? AND shadow = ? AND user_id <> ?", inst.PokemonID, inst.Shiny, inst.Shadow, inst.UserID).
+			Where(tradeMatchCandidateSides(inst)).
+			Limit(tradeMatchCandidateCap).
+			Find(&candidates).Error; err != nil {
+			return err
+		}
+		if len(candidates) == tradeMatchCandidateCap {
+			logrus.Warnf("Instance %s has at least %d match candidates; indexing the first %d", inst.InstanceID, tradeMatchCandidateCap, tradeMatchCandidateCap)
+		}
+	}
+	parseMatchFilters(candidates)
+	matches := tradeMatchesFor(inst, candidates)
+
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := cleanupTradeMatches(tx, inst.InstanceID); err != nil {
+			return err
+		}
+		if len(matches) == 0 {
+			return nil
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(matches, 500).Error
+	})
+}
+
+// cleanupTradeMatches drops every pair instanceID takes part in.
+func cleanupTradeMatches(tx *gorm.DB, instanceID string) error {
+	if err := tx.Where("trade_instance_id = ?", instanceID).Delete(&TradeMatch{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("wanted_instance_id = ?", instanceID).Delete(&TradeMatch{}).Error
+}
+
+// SweepTradeMatches drops pairs whose instances are gone without a delete
+// going through cleanupTradeMatches.
+func SweepTradeMatches() {
+	res := DB.Exec(`
+		DELETE m FROM trade_matches m
+		  LEFT JOIN instances t ON t.instance_id = m.trade_instance_id
+		  LEFT JOIN instances w ON w.instance_id = m.wanted_instance_id
+		 WHERE t.instance_id IS NULL OR w.instance_id IS NULL`)
+	if res.Error != nil {
+		logrus.Errorf("Failed to sweep trade matches: %v", res.Error)
+		return
+	}
+	if res.RowsAffected > 0 {
+		logrus.Infof("Swept %d orphaned trade matches", res.RowsAffected)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import "testing"
+
+func TestTradeMatchReason(t *testing.T) {
+	intp := func(v int) *int { return &v }
+	strp := func(v string) *string { return &v }
+
+	base := matchInstance{
+		PokemonID:      25,
+		Shiny:          true,
+		CostumeID:      intp(1),
+		Gender:         strp("Female"),
+		LocationCard:   strp("11"),
+		Dynamax:        true,
+		FastMoveID:     intp(10),
+		ChargedMove1ID: intp(20),
+		ChargedMove2ID: intp(30),
+	}
+
+	swapped := base
+	swapped.ChargedMove1ID, swapped.ChargedMove2ID = intp(30), intp(20)
+	if got := tradeMatchReason(base, swapped); got != tradeMatchExact {
+		t.Fatalf("expected an exact match with swapped charged moves, got %q", got)
+	}
+
+	open := base
+	open.Gender, open.FastMoveID = nil, nil
+	if got := tradeMatchReason(base, open); got != tradeMatchCompatible {
+		t.Fatalf("expected a compatible match with open gender and moves, got %q", got)
+	}
+
+	sameCard := base
+	sameCard.LocationCard = strp("11")
+	if got := tradeMatchReason(base, sameCard); got != tradeMatchExact {
+		t.Fatalf("expected equal location cards to match by value, got %q", got)
+	}
+
+	for name, mutate := range map[string]func(*matchInstance){
+		"species":       func(m *matchInstance) { m.PokemonID = 26 },
+		"costume":       func(m *matchInstance) { m.CostumeID = nil },
+		"location card": func(m *matchInstance) { m.LocationCard = strp("12") },
+		"gender":        func(m *matchInstance) { m.Gender = strp("Male") },
+		"fast move":     func(m *matchInstance) { m.FastMoveID = intp(11) },
+		"charged moves": func(m *matchInstance) { m.ChargedMove2ID = intp(31) },
+		"gigantamax":    func(m *matchInstance) { m.Gigantamax = true },
+	} {
+		other := base
+		mutate(&other)
+		if got := tradeMatchReason(base, other); got != "" {
+			t.Fatalf("expected a %s mismatch, got %q", name, got)
+		}
+	}
+}
+
+func TestTradeMatchesFor(t *testing.T) {
+	inst := matchInstance{InstanceID: "mine", UserID: "u1", PokemonID: 1, IsForTrade: true, IsWanted: true}
+	wanted := matchInstance{InstanceID: "w", UserID: "u2", PokemonID: 1, IsWanted: true}
+	offered := matchInstance{InstanceID: "t", UserID: "u3", PokemonID: 1, IsForTrade: true}
+	own := matchInstance{InstanceID: "own", UserID: "u1", PokemonID: 1, IsWanted: true}
+	other := matchInstance{InstanceID: "x", UserID: "u4", PokemonID: 2, IsWanted: true}
+
+	got := tradeMatchesFor(inst, []matchInstance{wanted, offered, own, other})
+	if len(got) != 2 {
+		t.Fatalf("expected 2 pairs, got %+v", got)
+	}
+	if got[0].TradeInstanceID != "mine" || got[0].WantedInstanceID != "w" || got[0].WantedUserID != "u2" {
+		t.Fatalf("unexpected trade-side pair: %+v", got[0])
+	}
+	if got[1].TradeInstanceID != "t" || got[1].TradeUserID != "u3" || got[1].WantedInstanceID != "mine" {
+		t.Fatalf("unexpected wanted-side pair: %+v", got[1])
+	}
+	if got[0].MatchReason != tradeMatchCompatible {
+		t.Fatalf("expected a compatible match, got %q", got[0].MatchReason)
+	}
+}
+
+func TestTradeMatchCandidateSides(t *testing.T) {
+	cases := []struct {
+		inst matchInstance
+		want string
+	}{
+		{matchInstance{IsForTrade: true}, "is_wanted = 1"},
+		{matchInstance{IsWanted: true}, "is_for_trade = 1"},
+		{matchInstance{IsForTrade: true, IsWanted: true}, "(is_wanted = 1 OR is_for_trade = 1)"},
+	}
+	for _, tc := range cases {
+		if got := tradeMatchCandidateSides(tc.inst); got != tc.want {
+			t.Fatalf("tradeMatchCandidateSides(%+v) = %q, want %q", tc.inst, got, tc.want)
+		}
+	}
+}
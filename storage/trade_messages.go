@@ -0,0 +1,532 @@
+// trade_messages.go
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	maxTradeMessageBody          = 1000
+	maxTradeMessageClientID      = 64
+	maxTradeMessageReportDetails = 500
+)
+
+// chatTradeStatuses are the trade statuses whose two sides may message each
+// other.
+var chatTradeStatuses = map[string]bool{
+	"proposed": true,
+	"pending":  true,
+}
+
+// tradeMessageReportReasons are the reasons a message can be reported for.
+var tradeMessageReportReasons = map[string]bool{
+	"spam":       true,
+	"harassment": true,
+	"scam":       true,
+	"other":      true,
+}
+
+var (
+	errMessageEmpty       = errors.New("message body is empty")
+	errMessageTradeClosed = errors.New("trade is not open for messages")
+	errMessageNotParty    = errors.New("sender is not a party to the trade")
+	errMessageRateLimited = errors.New("message rate limit reached")
+	errMessageDuplicate   = errors.New("message already stored")
+	errReportReason       = errors.New("unknown report reason")
+	errReportNotRecipient = errors.New("only the recipient can report a message")
+	errReportDuplicate    = errors.New("message already reported by this user")
+)
+
+// TradeMessage mirrors "trade_messages": one chat message between the two
+// sides of a trade. ClientMessageID makes redelivered batches idempotent.
+type TradeMessage struct {
+	MessageID       uint64     `gorm:"column:message_id;primaryKey;autoIncrement"`
+	TradeID         string     `gorm:"column:trade_id"`
+	ClientMessageID string     `gorm:"column:client_message_id"`
+	SenderUserID    string     `gorm:"column:sender_user_id"`
+	SenderUsername  string     `gorm:"column:sender_username"`
+	RecipientUserID string     `gorm:"column:recipient_user_id"`
+	Body            string     `gorm:"column:body"`
+	CreatedAt       time.Time  `gorm:"column:created_at"`
+	ReadAt          *time.Time `gorm:"column:read_at"`
+	// HiddenAt is set by moderation; hidden messages are no longer listed.
+	HiddenAt *time.Time `gorm:"column:hidden_at"`
+}
+
+func (TradeMessage) TableName() string {
+	return "trade_messages"
+}
+
+// TradeMessageReport mirrors "trade_message_reports".
+type TradeMessageReport struct {
+	MessageID      uint64    `gorm:"column:message_id;primaryKey"`
+	ReporterUserID string    `gorm:"column:reporter_user_id;primaryKey"`
+	Reason         string    `gorm:"column:reason"`
+	Details        *string   `gorm:"column:details"`
+	CreatedAt      time.Time `gorm:"column:created_at;autoCreateTime"`
+}
+
+func (TradeMessageReport) TableName() string {
+	return "trade_message_reports"
+}
+
+const createTradeMessagesTableSQL = `
+CREATE TABLE IF NOT EXISTS trade_messages (
+  message_id        BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  trade_id          VARCHAR(255) NOT NULL,
+  client_message_id VARCHAR(255) NOT NULL,
+  sender_user_id    VARCHAR(255) NOT NULL,
+  sender_username   VARCHAR(255) NOT NULL,
+  recipient_user_id VARCHAR(255) NOT NULL,
+  body              VARCHAR(1000) NOT NULL,
+  created_at        DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  read_at           DATETIME(6) NULL,
+  hidden_at         DATETIME(6) NULL,
+  UNIQUE KEY uq_trade_messages_client (sender_user_id, client_message_id),
+  KEY idx_trade_messages_trade (trade_id, message_id),
+  KEY idx_trade_messages_recipient (recipient_user_id, trade_id, read_at),
+  KEY idx_trade_messages_sender (sender_user_id, created_at)
+)`
+
+const createTradeMessageReportsTableSQL = `
+CREATE TABLE IF NOT EXISTS trade_message_reports (
+  message_id       BIGINT UNSIGNED NOT NULL,
+  reporter_user_id VARCHAR(255) NOT NULL,
+  reason           VARCHAR(32) NOT NULL,
+  details          VARCHAR(500) NULL,
+  created_at       DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  PRIMARY KEY (message_id, reporter_user_id)
+)`
+
+func ensureTradeMessagesSchema() error {
+	if err := DB.Exec(createTradeMessagesTableSQL).Error; err != nil {
+		return fmt.Errorf("create trade_messages: %w", err)
+	}
+	if err := DB.Exec(createTradeMessageReportsTableSQL).Error; err != nil {
+		return fmt.Errorf("create trade_message_reports: %w", err)
+	}
+	return nil
+}
+
+// Package var so tests can capture reports without Kafka.
+var publishMessageReportFn = publishMessageReport
+
+/* -------------------------------------------------------------------------- */
+/*  Parsing                                                                   */
+/* -------------------------------------------------------------------------- */
+
+// tradeMessageInput is one entry of the batched "tradeMessages" array. The
+// sender is always the authenticated sender of the batch.
+type tradeMessageInput struct {
+	TradeID         string
+	ClientMessageID string
+	Body            string
+}
+
+// parseTradeMessage reads one message. fallbackID identifies messages sent
+// without a client_message_id, and is stable across redeliveries.
+func parseTradeMessage(raw interface{}, fallbackID string) (tradeMessageInput, error) {
+	obj, ok := raw.(map[string]interface{})
+	if !ok {
+		return tradeMessageInput{}, errors.New("message is not an object")
+	}
+	tradeID := strings.TrimSpace(fmt.Sprintf("%v", obj["trade_id"]))
+	if !isPresentID(tradeID) {
+		return tradeMessageInput{}, errors.New("missing trade_id")
+	}
+	body := ""
+	if s, ok := obj["body"].(string); ok {
+		body = strings.TrimSpace(s)
+	}
+	if body == "" {
+		return tradeMessageInput{}, errMessageEmpty
+	}
+	if len([]rune(body)) > maxTradeMessageBody {
+		return tradeMessageInput{}, fmt.Errorf("message body longer than %d characters", maxTradeMessageBody)
+	}
+
+	clientID := fallbackID
+	if s, ok := obj["client_message_id"].(string); ok && strings.TrimSpace(s) != "" {
+		clientID = strings.TrimSpace(s)
+	}
+	if len(clientID) > maxTradeMessageClientID {
+		return tradeMessageInput{}, fmt.Errorf("client_message_id longer than %d characters", maxTradeMessageClientID)
+	}
+	return tradeMessageInput{TradeID: tradeID, ClientMessageID: clientID, Body: body}, nil
+}
+
+// tradeMessageReadInput marks the reader's messages in a trade read up to
+// and including UpToMessageID.
+type tradeMessageReadInput struct {
+	TradeID       string
+	UpToMessageID uint64
+}
+
+func parseTradeMessageRead(raw interface{}) (tradeMessageReadInput, error) {
+	obj, ok := raw.(map[string]interface{})
+	if !ok {
+		return tradeMessageReadInput{}, errors.New("read receipt is not an object")
+	}
+	tradeID := strings.TrimSpace(fmt.Sprintf("%v", obj["trade_id"]))
+	if !isPresentID(tradeID) {
+		return tradeMessageReadInput{}, errors.New("missing trade_id")
+	}
+	upTo, ok := parseMessageID(obj["up_to_message_id"])
+	if !ok {
+		return tradeMessageReadInput{}, errors.New("missing up_to_message_id")
+	}
+	return tradeMessageReadInput{TradeID: tradeID, UpToMessageID: upTo}, nil
+}
+
+type tradeMessageReportInput struct {
+	MessageID uint64
+	Reason    string
+	Details   *string
+}
+
+func parseTradeMessageReport(raw interface{}) (tradeMessageReportInput, error) {
+	obj, ok := raw.(map[string]interface{})
+	if !ok {
+		return tradeMessageReportInput{}, errors.New("report is not an object")
+	}
+	id, ok := parseMessageID(obj["message_id"])
+	if !ok {
+		return tradeMessageReportInput{}, errors.New("missing message_id")
+	}
+	reason, _ := obj["reason"].(string)
+	reason = strings.ToLower(strings.TrimSpace(reason))
+	if !tradeMessageReportReasons[reason] {
+		return tradeMessageReportInput{}, errReportReason
+	}
+	in := tradeMessageReportInput{MessageID: id, Reason: reason}
+	if s, ok := obj["details"].(string); ok {
+		text := strings.TrimSpace(s)
+		if len([]rune(text)) > maxTradeMessageReportDetails {
+			text = string([]rune(text)[:maxTradeMessageReportDetails])
+		}
+		if text != "" {
+			in.Details = &text
+		}
+	}
+	return in, nil
+}
+
+// parseMessageID accepts message ids as strings (how they are published) or
+// JSON numbers.
+func parseMessageID(v interface{}) (uint64, bool) {
+	switch t := v.(type) {
+	case string:
+		n, err := strconv.ParseUint(strings.TrimSpace(t), 10, 64)
+		return n, err == nil && n > 0
+	case float64:
+		if t >= 1 && t == math.Trunc(t) {
+			return uint64(t), true
+		}
+	}
+	return 0, false
+}
+
+// tradeCounterparty returns the user on the other side of trade from the
+// given user, falling back to usernames for trades stored without user ids.
+func tradeCounterparty(trade Trade, userID, username string) (string, error) {
+	switch {
+	case userID == trade.UserIDProposed || (trade.UserIDProposed == "" && username == trade.UsernameProposed):
+		if trade.UserIDAccepting != "" {
+			return trade.UserIDAccepting, nil
+		}
+		return getUserIdForUsername(trade.UsernameAccepting), nil
+	case userID == trade.UserIDAccepting || (trade.UserIDAccepting == "" && username == trade.UsernameAccepting):
+		if trade.UserIDProposed != "" {
+			return trade.UserIDProposed, nil
+		}
+		return getUserIdForUsername(trade.UsernameProposed), nil
+	}
+	return "", errMessageNotParty
+}
+
+// tradeMessageRateExceeded reports whether a sender who already sent
+// lastMinute messages in the last minute, and lastHourInTrade in this trade
+// in the last hour, must wait.
+func tradeMessageRateExceeded(lastMinute, lastHourInTrade int64, cfg ChatConfig) bool {
+	return lastMinute >= int64(cfg.MessagesPerMinute) || lastHourInTrade >= int64(cfg.MessagesPerTradePerHour)
+}
+
+/* -------------------------------------------------------------------------- */
+/*  Messages                                                                  */
+/* -------------------------------------------------------------------------- */
+
+// parseAndApplyTradeMessages stores the sender's messages and publishes
+// each one to both sides. Each message is its own transaction.
+func parseAndApplyTradeMessages(data map[string]interface{}, senderID, senderUsername, traceID string) (sent, rejected int) {
+	items, _ := data["tradeMessages"].([]interface{})
+	for i, raw := range items {
+		in, err := parseTradeMessage(raw, fmt.Sprintf("%s:%d", traceID, i))
+		if err != nil {
+			logrus.Warnf("Skipping trade message from %s: %v", senderUsername, err)
+			rejected++
+			continue
+		}
+
+		msg, err := storeTradeMessage(in, senderID, senderUsername, time.Now().UTC())
+		if errors.Is(err, errMessageDuplicate) {
+			continue
+		}
+		if err != nil {
+			logrus.Warnf("Rejected message on trade %s from %s: %v", in.TradeID, senderUsername, err)
+			rejected++
+			continue
+		}
+		sent++
+		publishTradeMessage(msg)
+	}
+	return sent, rejected
+}
+
+func storeTradeMessage(in tradeMessageInput, senderID, senderUsername string, now time.Time) (TradeMessage, error) {
+	var msg TradeMessage
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var trade Trade
+		if err := tx.Where("trade_id = ?", in.TradeID).First(&trade).Error; err != nil {
+			return err
+		}
+		if !chatTradeStatuses[trade.TradeStatus] {
+			return errMessageTradeClosed
+		}
+		recipientID, err := tradeCounterparty(trade, senderID, senderUsername)
+		if err != nil {
+			return err
+		}
+
+		var lastMinute, lastHourInTrade int64
+		if err := tx.Model(&TradeMessage{}).
+			Where("sender_user_id = ? AND created_at >= ?", senderID, now.Add(-time.Minute)).
+			Count(&lastMinute).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&TradeMessage{}).
+			Where("sender_user_id = ? AND trade_id = ? AND created_at >= ?", senderID, in.TradeID, now.Add(-time.Hour)).
+			Count(&lastHourInTrade).Error; err != nil {
+			return err
+		}
+		if tradeMessageRateExceeded(lastMinute, lastHourInTrade, AppConfig.Chat) {
+			return errMessageRateLimited
+		}
+
+		msg = TradeMessage{
+			TradeID:         in.TradeID,
+			ClientMessageID: in.ClientMessageID,
+			SenderUserID:    senderID,
+			SenderUsername:  senderUsername,
+			RecipientUserID: recipientID,
+			Body:            in.Body,
+			CreatedAt:       now,
+		}
+		res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&msg)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errMessageDuplicate
+		}
+		return nil
+	})
+	return msg, err
+}
+
+// publishTradeMessage delivers a stored message to both sides, the sender's
+// other devices included.
+func publishTradeMessage(msg TradeMessage) {
+	event := newStorageEvent(msg.SenderUserID, msg.SenderUsername, "trade_message")
+	event["tradeMessages"] = []interface{}{tradeMessagePayload(msg)}
+	if err := publishStorageEventFn(event); err != nil {
+		logrus.Warnf("Failed to publish message %d on trade %s: %v", msg.MessageID, msg.TradeID, err)
+	}
+}
+
+// tradeMessagePayload renders a message the way reader lists it, plus the
+// two user ids the events service routes by.
+func tradeMessagePayload(msg TradeMessage) map[string]interface{} {
+	return map[string]interface{}{
+		"message_id":        strconv.FormatUint(msg.MessageID, 10),
+		"trade_id":          msg.TradeID,
+		"client_message_id": msg.ClientMessageID,
+		"sender_username":   msg.SenderUsername,
+		"body":              msg.Body,
+		"created_at":        msg.CreatedAt.UTC(),
+		"read_at":           nil,
+		"sender_user_id":    msg.SenderUserID,
+		"recipient_user_id": msg.RecipientUserID,
+	}
+}
+
+/* -------------------------------------------------------------------------- */
+/*  Read receipts                                                             */
+/* -------------------------------------------------------------------------- */
+
+// parseAndApplyTradeMessageReads marks the reader's received messages read
+// and tells the other side.
+func parseAndApplyTradeMessageReads(data map[string]interface{}, readerID, readerUsername string) (applied, rejected int) {
+	items, _ := data["tradeMessageReads"].([]interface{})
+	for _, raw := range items {
+		in, err := parseTradeMessageRead(raw)
+		if err != nil {
+			logrus.Warnf("Skipping read receipt from %s: %v", readerUsername, err)
+			rejected++
+			continue
+		}
+
+		now := time.Now().UTC()
+		res := DB.Model(&TradeMessage{}).
+			Where("trade_id = ? AND recipient_user_id = ? AND message_id <= ? AND read_at IS NULL", in.TradeID, readerID, in.UpToMessageID).
+			Update("read_at", now)
+		if res.Error != nil {
+			logrus.Warnf("Failed to mark messages on trade %s read for %s: %v", in.TradeID, readerUsername, res.Error)
+			rejected++
+			continue
+		}
+		if res.RowsAffected == 0 {
+			continue
+		}
+		applied++
+
+		var trade Trade
+		if err := DB.Where("trade_id = ?", in.TradeID).First(&trade).Error; err != nil {
+			logrus.Warnf("Failed to load trade %s for read receipt: %v", in.TradeID, err)
+			continue
+		}
+		senderID, err := tradeCounterparty(trade, readerID, readerUsername)
+		if err != nil {
+			continue
+		}
+		publishTradeMessagesRead(in, readerID, readerUsername, senderID, now)
+	}
+	return applied, rejected
+}
+
+func publishTradeMessagesRead(in tradeMessageReadInput, readerID, readerUsername, senderID string, at time.Time) {
+	event := newStorageEvent(readerID, readerUsername, "trade_messages_read")
+	event["tradeMessageReads"] = []interface{}{map[string]interface{}{
+		"trade_id":         in.TradeID,
+		"reader_username":  readerUsername,
+		"up_to_message_id": strconv.FormatUint(in.UpToMessageID, 10),
+		"read_at":          at,
+		"reader_user_id":   readerID,
+		"sender_user_id":   senderID,
+	}}
+	if err := publishStorageEventFn(event); err != nil {
+		logrus.Warnf("Failed to publish read receipt on trade %s: %v", in.TradeID, err)
+	}
+}
+
+/* -------------------------------------------------------------------------- */
+/*  Reports                                                                   */
+/* -------------------------------------------------------------------------- */
+
+// parseAndApplyTradeMessageReports stores reports of received messages and
+// hands each new one to moderation.
+func parseAndApplyTradeMessageReports(data map[string]interface{}, reporterID, reporterUsername string) (applied, rejected int) {
+	items, _ := data["tradeMessageReports"].([]interface{})
+	for _, raw := range items {
+		in, err := parseTradeMessageReport(raw)
+		if err != nil {
+			logrus.Warnf("Skipping message report from %s: %v", reporterUsername, err)
+			rejected++
+			continue
+		}
+
+		msg, report, err := storeTradeMessageReport(in, reporterID)
+		if errors.Is(err, errReportDuplicate) {
+			continue
+		}
+		if err != nil {
+			logrus.Warnf("Rejected report of message %d by %s: %v", in.MessageID, reporterUsername, err)
+			rejected++
+			continue
+		}
+		applied++
+		logrus.Infof("Message %d on trade %s reported by %s (%s)", msg.MessageID, msg.TradeID, reporterUsername, report.Reason)
+		if err := publishMessageReportFn(messageReportPayload(msg, report, reporterUsername)); err != nil {
+			logrus.Warnf("Failed to publish report of message %d: %v", msg.MessageID, err)
+		}
+	}
+	return applied, rejected
+}
+
+func storeTradeMessageReport(in tradeMessageReportInput, reporterID string) (TradeMessage, TradeMessageReport, error) {
+	var msg TradeMessage
+	if err := DB.Where("message_id = ?", in.MessageID).First(&msg).Error; err != nil {
+		return msg, TradeMessageReport{}, err
+	}
+	if msg.RecipientUserID != reporterID {
+		return msg, TradeMessageReport{}, errReportNotRecipient
+	}
+	report := TradeMessageReport{
+		MessageID:      in.MessageID,
+		ReporterUserID: reporterID,
+		Reason:         in.Reason,
+		Details:        in.Details,
+	}
+	res := DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&report)
+	if res.Error != nil {
+		return msg, report, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return msg, report, errReportDuplicate
+	}
+	return msg, report, nil
+}
+
+// messageReportPayload is what moderation receives: the report and the
+// reported message as stored.
+func messageReportPayload(msg TradeMessage, report TradeMessageReport, reporterUsername string) map[string]interface{} {
+	return map[string]interface{}{
+		"message_id":        strconv.FormatUint(msg.MessageID, 10),
+		"trade_id":          msg.TradeID,
+		"body":              msg.Body,
+		"sent_at":           msg.CreatedAt.UTC(),
+		"reported_user_id":  msg.SenderUserID,
+		"reported_username": msg.SenderUsername,
+		"reporter_user_id":  report.ReporterUserID,
+		"reporter_username": reporterUsername,
+		"reason":            report.Reason,
+		"details":           report.Details,
+		"reported_at":       time.Now().UTC(),
+	}
+}
+
+// publishMessageReport writes a report, as plain JSON keyed by message id,
+// to the moderation topic. Without the topic configured, reports are only
+// kept in trade_message_reports.
+func publishMessageReport(payload map[string]interface{}) error {
+	storageWriterMu.RLock()
+	w := reportWriter
+	storageWriterMu.RUnlock()
+	if w == nil {
+		return nil
+	}
+
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal message report: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return w.WriteMessages(ctx, kafka.Message{
+		Key:   []byte(fmt.Sprintf("%v", payload["message_id"])),
+		Value: raw,
+		Time:  time.Now().UTC(),
+	})
+}
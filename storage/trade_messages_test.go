@@ -0,0 +1,119 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTradeMessage_Validation(t *testing.T) {
+	in, err := parseTradeMessage(map[string]interface{}{
+		"trade_id":          "t-1",
+		"client_message_id": "c-9",
+		"body":              "  see you at the gym  ",
+	}, "trace:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if in.TradeID != "t-1" || in.ClientMessageID != "c-9" || in.Body != "see you at the gym" {
+		t.Fatalf("unexpected message: %+v", in)
+	}
+
+	fallback, err := parseTradeMessage(map[string]interface{}{"trade_id": "t-1", "body": "hi"}, "trace:3")
+	if err != nil || fallback.ClientMessageID != "trace:3" {
+		t.Fatalf("expected the fallback client id, got %+v err=%v", fallback, err)
+	}
+
+	for _, raw := range []interface{}{
+		"not an object",
+		map[string]interface{}{"body": "hi"},
+		map[string]interface{}{"trade_id": "t-1", "body": "   "},
+		map[string]interface{}{"trade_id": "t-1", "body": strings.Repeat("a", maxTradeMessageBody+1)},
+		map[string]interface{}{"trade_id": "t-1", "body": "hi", "client_message_id": strings.Repeat("c", maxTradeMessageClientID+1)},
+	} {
+		if _, err := parseTradeMessage(raw, "trace:0"); err == nil {
+			t.Fatalf("expected %#v to be rejected", raw)
+		}
+	}
+}
+
+func TestParseTradeMessageReadAndReport(t *testing.T) {
+	read, err := parseTradeMessageRead(map[string]interface{}{"trade_id": "t-1", "up_to_message_id": "17"})
+	if err != nil || read.UpToMessageID != 17 {
+		t.Fatalf("unexpected read receipt: %+v err=%v", read, err)
+	}
+	for _, id := range []interface{}{nil, "0", "abc", float64(1.5), float64(-2)} {
+		if _, err := parseTradeMessageRead(map[string]interface{}{"trade_id": "t-1", "up_to_message_id": id}); err == nil {
+			t.Fatalf("expected up_to_message_id %v to be rejected", id)
+		}
+	}
+
+	report, err := parseTradeMessageReport(map[string]interface{}{
+		"message_id": float64(5),
+		"reason":     " Spam ",
+		"details":    strings.Repeat("d", maxTradeMessageReportDetails+10),
+	})
+	if err != nil || report.MessageID != 5 || report.Reason != "spam" || len(*report.Details) != maxTradeMessageReportDetails {
+		t.Fatalf("unexpected report: %+v err=%v", report, err)
+	}
+	if _, err := parseTradeMessageReport(map[string]interface{}{"message_id": "5", "reason": "rude"}); err != errReportReason {
+		t.Fatalf("expected unknown reason to be rejected, got %v", err)
+	}
+}
+
+func TestTradeCounterparty(t *testing.T) {
+	trade := Trade{UserIDProposed: "u-1", UsernameProposed: "alice", UserIDAccepting: "u-2", UsernameAccepting: "bob"}
+	if got, err := tradeCounterparty(trade, "u-1", "alice"); err != nil || got != "u-2" {
+		t.Fatalf("expected u-2, got %q err=%v", got, err)
+	}
+	if got, err := tradeCounterparty(trade, "u-2", "bob"); err != nil || got != "u-1" {
+		t.Fatalf("expected u-1, got %q err=%v", got, err)
+	}
+	if _, err := tradeCounterparty(trade, "u-3", "carol"); err != errMessageNotParty {
+		t.Fatalf("expected outsiders to be rejected, got %v", err)
+	}
+}
+
+func TestTradeMessageRateExceeded(t *testing.T) {
+	cfg := ChatConfig{MessagesPerMinute: 10, MessagesPerTradePerHour: 60}
+	if tradeMessageRateExceeded(9, 59, cfg) {
+		t.Fatalf("expected the message under both limits to pass")
+	}
+	if !tradeMessageRateExceeded(10, 0, cfg) || !tradeMessageRateExceeded(0, 60, cfg) {
+		t.Fatalf("expected either limit to stop the sender")
+	}
+}
+
+func TestPublishTradeMessage_RoutesBothSides(t *testing.T) {
+	prev := publishStorageEventFn
+	t.Cleanup(func() { publishStorageEventFn = prev })
+
+	var captured map[string]interface{}
+	publishStorageEventFn = func(payload map[string]interface{}) error {
+		captured = payload
+		return nil
+	}
+
+	publishTradeMessage(TradeMessage{
+		MessageID:       42,
+		TradeID:         "t-1",
+		ClientMessageID: "c-1",
+		SenderUserID:    "u-1",
+		SenderUsername:  "alice",
+		RecipientUserID: "u-2",
+		Body:            "hi",
+		CreatedAt:       time.Now(),
+	})
+
+	if captured["user_id"] != "u-1" || captured["event"] != "trade_message" {
+		t.Fatalf("expected an event from the sender, got %#v", captured)
+	}
+	items, ok := captured["tradeMessages"].([]interface{})
+	if !ok || len(items) != 1 {
+		t.Fatalf("expected one message, got %#v", captured["tradeMessages"])
+	}
+	item := items[0].(map[string]interface{})
+	if item["message_id"] != "42" || item["sender_user_id"] != "u-1" || item["recipient_user_id"] != "u-2" {
+		t.Fatalf("unexpected message payload: %#v", item)
+	}
+}
@@ -0,0 +1,271 @@
+// trade_ratings.go
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	minTradeRatingScore   = 1
+	maxTradeRatingScore   = 5
+	maxTradeRatingComment = 500
+)
+
+var (
+	errRatingNotAllowed  = errors.New("trade is not completed")
+	errRatingNotParty    = errors.New("rater is not a party to the trade")
+	errRatingDuplicate   = errors.New("trade already rated by this side")
+	errRatingScoreBounds = fmt.Errorf("score must be between %d and %d", minTradeRatingScore, maxTradeRatingScore)
+)
+
+// TradeRating mirrors the "trade_ratings" table: one row per side of a
+// completed trade.
+type TradeRating struct {
+	TradeID     string    `gorm:"column:trade_id;primaryKey"`
+	RaterUserID string    `gorm:"column:rater_user_id;primaryKey"`
+	RateeUserID string    `gorm:"column:ratee_user_id"`
+	Score       int       `gorm:"column:score"`
+	Comment     *string   `gorm:"column:comment"`
+	CreatedAt   time.Time `gorm:"column:created_at;autoCreateTime"`
+}
+
+func (TradeRating) TableName() string {
+	return "trade_ratings"
+}
+
+// TrainerReputation mirrors the "trainer_reputation" table, which storage
+// keeps current whenever a rating lands or a trade concludes.
+type TrainerReputation struct {
+	UserID           string    `gorm:"column:user_id;primaryKey"`
+	RatingAverage    *float64  `gorm:"column:rating_average"`
+	RatingCount      int64     `gorm:"column:rating_count"`
+	TradesCompleted  int64     `gorm:"column:trades_completed"`
+	TradesCancelled  int64     `gorm:"column:trades_cancelled"`
+	CompletionRate   *float64  `gorm:"column:completion_rate"`
+	CancellationRate *float64  `gorm:"column:cancellation_rate"`
+	UpdatedAt        time.Time `gorm:"column:updated_at;autoUpdateTime"`
+}
+
+func (TrainerReputation) TableName() string {
+	return "trainer_reputation"
+}
+
+// tradeRatingInput is one entry of the batched "tradeRatings" array. The
+// rater is always the authenticated sender of the batch.
+type tradeRatingInput struct {
+	TradeID string
+	Score   int
+	Comment *string
+}
+
+func parseTradeRating(raw interface{}) (tradeRatingInput, error) {
+	obj, ok := raw.(map[string]interface{})
+	if !ok {
+		return tradeRatingInput{}, errors.New("rating is not an object")
+	}
+	tradeID := strings.TrimSpace(fmt.Sprintf("%v", obj["trade_id"]))
+	if !isPresentID(tradeID) {
+		return tradeRatingInput{}, errors.New("missing trade_id")
+	}
+	score := parseNullableInt(obj["score"])
+	if score == nil || *score < minTradeRatingScore || *score > maxTradeRatingScore {
+		return tradeRatingInput{}, errRatingScoreBounds
+	}
+
+	in := tradeRatingInput{TradeID: tradeID, Score: *score}
+	if comment := parseNullableString(obj["comment"]); comment != nil {
+		text := strings.TrimSpace(*comment)
+		if len([]rune(text)) > maxTradeRatingComment {
+			text = string([]rune(text)[:maxTradeRatingComment])
+		}
+		if text != "" {
+			in.Comment = &text
+		}
+	}
+	return in, nil
+}
+
+// rateeForRater resolves which side of the trade raterID is on and returns
+// the other trainer along with the trades column the score is mirrored to.
+func rateeForRater(trade Trade, raterID, raterUsername string) (rateeID, column string, err error) {
+	switch {
+	case raterID == trade.UserIDProposed || (trade.UserIDProposed == "" && raterUsername == trade.UsernameProposed):
+		rateeID = trade.UserIDAccepting
+		if rateeID == "" {
+			rateeID = getUserIdForUsername(trade.UsernameAccepting)
+		}
+		return rateeID, "user_1_trade_satisfaction", nil
+	case raterID == trade.UserIDAccepting || (trade.UserIDAccepting == "" && raterUsername == trade.UsernameAccepting):
+		rateeID = trade.UserIDProposed
+		if rateeID == "" {
+			rateeID = getUserIdForUsername(trade.UsernameProposed)
+		}
+		return rateeID, "user_2_trade_satisfaction", nil
+	}
+	return "", "", errRatingNotParty
+}
+
+// parseAndApplyTradeRatings stores the sender's ratings. Each rating is its
+// own transaction so one rejected entry does not drop the rest of the batch.
+func parseAndApplyTradeRatings(data map[string]interface{}, raterID, raterUsername string) (applied, rejected int) {
+	items, _ := data["tradeRatings"].([]interface{})
+	for _, raw := range items {
+		in, err := parseTradeRating(raw)
+		if err != nil {
+			logrus.Warnf("Skipping trade rating from %s: %v", raterUsername, err)
+			rejected++
+			continue
+		}
+
+		trade, err := applyTradeRating(in, raterID, raterUsername)
+		if err != nil {
+			logrus.Warnf("Rejected rating of trade %s by %s: %v", in.TradeID, raterUsername, err)
+			rejected++
+			continue
+		}
+		applied++
+		publishTradeRated(trade)
+		recordNotifications(ratingNotifications(trade, raterID, raterUsername, in.Score))
+	}
+	return applied, rejected
+}
+
+func applyTradeRating(in tradeRatingInput, raterID, raterUsername string) (Trade, error) {
+	var trade Trade
+	var rateeID string
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("trade_id = ?", in.TradeID).First(&trade).Error; err != nil {
+			return err
+		}
+		if trade.TradeStatus != "completed" {
+			return errRatingNotAllowed
+		}
+
+		var column string
+		var err error
+		rateeID, column, err = rateeForRater(trade, raterID, raterUsername)
+		if err != nil {
+			return err
+		}
+
+		rating := TradeRating{
+			TradeID:     in.TradeID,
+			RaterUserID: raterID,
+			RateeUserID: rateeID,
+			Score:       in.Score,
+			Comment:     in.Comment,
+		}
+		res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&rating)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errRatingDuplicate
+		}
+
+		if err := tx.Model(&Trade{}).Where("trade_id = ?", in.TradeID).
+			Update(column, in.Score).Error; err != nil {
+			return err
+		}
+		score := in.Score
+		if column == "user_1_trade_satisfaction" {
+			trade.User1TradeSatisfaction = &score
+		} else {
+			trade.User2TradeSatisfaction = &score
+		}
+		return refreshTrainerReputation(tx, rateeID)
+	})
+	return trade, err
+}
+
+// publishTradeRated lets both sides see the new score without a refetch.
+func publishTradeRated(trade Trade) {
+	event := newStorageEvent(trade.UserIDProposed, trade.UsernameProposed, "trade_rated")
+	event["tradeUpdates"] = []interface{}{tradeUpdatePayload(trade)}
+	if err := publishStorageEventFn(event); err != nil {
+		logrus.Warnf("Failed to publish rated event for trade %s: %v", trade.TradeID, err)
+	}
+}
+
+// buildTrainerReputation turns raw counts into the stored rollup. Rates are
+// over concluded trades (completed or cancelled); cancellations only count
+// against a trainer when they cancelled themselves.
+func buildTrainerReputation(userID string, ratingCount, ratingSum, completed, concluded, cancelledByUser int64) TrainerReputation {
+	rep := TrainerReputation{
+		UserID:          userID,
+		RatingCount:     ratingCount,
+		TradesCompleted: completed,
+		TradesCancelled: cancelledByUser,
+	}
+	if ratingCount > 0 {
+		avg := float64(ratingSum) / float64(ratingCount)
+		rep.RatingAverage = &avg
+	}
+	if concluded > 0 {
+		completion := float64(completed) / float64(concluded)
+		cancellation := float64(cancelledByUser) / float64(concluded)
+		rep.CompletionRate = &completion
+		rep.CancellationRate = &cancellation
+	}
+	return rep
+}
+
+// refreshTrainerReputation recomputes one trainer's rollup from trade_ratings
+// and trades.
+func refreshTrainerReputation(tx *gorm.DB, userID string) error {
+	if strings.TrimSpace(userID) == "" {
+		return nil
+	}
+
+	var ratings struct {
+		Count int64
+		Sum   int64
+	}
+	if err := tx.Model(&TradeRating{}).
+		Select("COUNT(*) AS count, COALESCE(SUM(score), 0) AS sum").
+		Where("ratee_user_id = ?", userID).
+		Scan(&ratings).Error; err != nil {
+		return fmt.Errorf("load ratings: %w", err)
+	}
+
+	var username string
+	if err := tx.Model(&User{}).Select("username").Where("user_id = ?", userID).Scan(&username).Error; err != nil {
+		return fmt.Errorf("load username: %w", err)
+	}
+
+	var outcomes struct {
+		Completed       int64
+		Concluded       int64
+		CancelledByUser int64
+	}
+	if err := tx.Model(&Trade{}).
+		Select(`COALESCE(SUM(trade_status = 'completed'), 0) AS completed,
+			COALESCE(SUM(trade_status IN ('completed', 'cancelled')), 0) AS concluded,
+			COALESCE(SUM(trade_status = 'cancelled' AND trade_cancelled_by = ?), 0) AS cancelled_by_user`, username).
+		Where("user_id_proposed = ? OR user_id_accepting = ?", userID, userID).
+		Scan(&outcomes).Error; err != nil {
+		return fmt.Errorf("load trade outcomes: %w", err)
+	}
+
+	rep := buildTrainerReputation(userID, ratings.Count, ratings.Sum,
+		outcomes.Completed, outcomes.Concluded, outcomes.CancelledByUser)
+	return tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&rep).Error
+}
+
+// refreshReputationForTrade updates both trainers after a trade concludes.
+func refreshReputationForTrade(trade Trade) {
+	for _, userID := range []string{trade.UserIDProposed, trade.UserIDAccepting} {
+		if err := refreshTrainerReputation(DB, userID); err != nil {
+			logrus.Warnf("Failed to refresh reputation for user %s: %v", userID, err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseTradeRating_Validation(t *testing.T) {
+	in, err := parseTradeRating(map[string]interface{}{
+		"trade_id": "t-1",
+		"score":    float64(4),
+		"comment":  "  smooth trade  ",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if in.TradeID != "t-1" || in.Score != 4 || in.Comment == nil || *in.Comment != "smooth trade" {
+		t.Fatalf("unexpected rating: %+v", in)
+	}
+
+	for _, score := range []interface{}{float64(0), float64(6), nil, "five"} {
+		if _, err := parseTradeRating(map[string]interface{}{"trade_id": "t-1", "score": score}); err == nil {
+			t.Fatalf("expected score %v to be rejected", score)
+		}
+	}
+	if _, err := parseTradeRating(map[string]interface{}{"score": float64(3)}); err == nil {
+		t.Fatalf("expected missing trade_id to be rejected")
+	}
+
+	long, err := parseTradeRating(map[string]interface{}{
+		"trade_id": "t-1",
+		"score":    float64(5),
+		"comment":  strings.Repeat("a", maxTradeRatingComment+20),
+	})
+	if err != nil || len(*long.Comment) != maxTradeRatingComment {
+		t.Fatalf("expected comment to be truncated, got err=%v", err)
+	}
+}
+
+func TestRateeForRater_PicksOtherSide(t *testing.T) {
+	trade := Trade{
+		UserIDProposed:    "u-1",
+		UsernameProposed:  "alice",
+		UserIDAccepting:   "u-2",
+		UsernameAccepting: "bob",
+	}
+
+	ratee, column, err := rateeForRater(trade, "u-1", "alice")
+	if err != nil || ratee != "u-2" || column != "user_1_trade_satisfaction" {
+		t.Fatalf("proposer: got %q %q %v", ratee, column, err)
+	}
+	ratee, column, err = rateeForRater(trade, "u-2", "bob")
+	if err != nil || ratee != "u-1" || column != "user_2_trade_satisfaction" {
+		t.Fatalf("accepter: got %q %q %v", ratee, column, err)
+	}
+	if _, _, err := rateeForRater(trade, "u-3", "eve"); !errors.Is(err, errRatingNotParty) {
+		t.Fatalf("expected outsider to be rejected, got %v", err)
+	}
+}
+
+func TestBuildTrainerReputation_Rates(t *testing.T) {
+	rep := buildTrainerReputation("u-1", 4, 18, 6, 8, 1)
+	if rep.RatingAverage == nil || *rep.RatingAverage != 4.5 {
+		t.Fatalf("unexpected average: %v", rep.RatingAverage)
+	}
+	if rep.CompletionRate == nil || *rep.CompletionRate != 0.75 {
+		t.Fatalf("unexpected completion rate: %v", rep.CompletionRate)
+	}
+	if rep.CancellationRate == nil || *rep.CancellationRate != 0.125 {
+		t.Fatalf("unexpected cancellation rate: %v", rep.CancellationRate)
+	}
+
+	empty := buildTrainerReputation("u-2", 0, 0, 0, 0, 0)
+	if empty.RatingAverage != nil || empty.CompletionRate != nil || empty.CancellationRate != nil {
+		t.Fatalf("expected nil rates without data, got %+v", empty)
+	}
+}